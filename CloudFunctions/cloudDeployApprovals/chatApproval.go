@@ -0,0 +1,241 @@
+package example
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/shared/failure"
+	"example.com/shared/notify"
+	"google.golang.org/api/idtoken"
+)
+
+// Google Chat signs its calls to Chat apps as this account
+const chatIssuer = "chat@system.gserviceaccount.com"
+
+// Slack refuses requests older than this, to stop replays
+const slackMaxAge = 5 * time.Minute
+
+// chatApproval receives clicks on the Approve/Reject buttons requestApproval
+// posts, from Slack or Google Chat. It checks the platform signed the
+// request and that the clicker is an approver for the target, then casts
+// their vote, so the quorum decides as for every other vote.
+func chatApproval(w http.ResponseWriter, r *http.Request) {
+	loadConfig()
+	log.Printf("Chat approval function invoked")
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "error reading body", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("X-Slack-Signature") != "" {
+		slackApproval(w, r, body)
+		return
+	}
+	googleChatApproval(w, r, body)
+}
+
+// The parts of a Slack block_actions payload we need
+type slackAction struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+		Name     string `json:"name"`
+	} `json:"user"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+	ResponseURL string `json:"response_url"`
+}
+
+func slackApproval(w http.ResponseWriter, r *http.Request, body []byte) {
+	if !validSlackSignature(body, r.Header.Get("X-Slack-Request-Timestamp"), r.Header.Get("X-Slack-Signature"), time.Now()) {
+		log.Printf("Rejecting Slack request with invalid signature")
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	var p slackAction
+	if err := json.Unmarshal([]byte(form.Get("payload")), &p); err != nil || p.Type != "block_actions" || len(p.Actions) == 0 {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	action := p.Actions[0]
+	var v buttonValue
+	if err := json.Unmarshal([]byte(action.Value), &v); err != nil || v.Rollout == "" {
+		http.Error(w, "invalid button", http.StatusBadRequest)
+		return
+	}
+	name := p.User.Name
+	if name == "" {
+		name = p.User.Username
+	}
+	approver := Approver{ID: "slack:" + p.User.ID, Name: name}
+
+	ctx := r.Context()
+	text, decided, err := chatVote(ctx, v, approver, action.ActionID == approveAction)
+	if err != nil {
+		log.Printf("Error recording vote by %s on %s: %v", approver, v.Rollout, err)
+		http.Error(w, "error recording vote", http.StatusInternalServerError)
+		return
+	}
+	// Decisions replace the buttons for everyone, the rest only the
+	// clicker sees
+	reply := map[string]interface{}{"response_type": "ephemeral", "replace_original": false, "text": text}
+	if decided {
+		reply = map[string]interface{}{"replace_original": true, "text": text}
+	}
+	if err := notify.Post(ctx, p.ResponseURL, reply); err != nil {
+		log.Printf("Failed to answer %s in Slack: %v", approver, err)
+	}
+}
+
+// validSlackSignature checks Slack's "v0=<hex>" HMAC of "v0:<timestamp>:<body>"
+// with the app's signing secret, and that the request is recent.
+func validSlackSignature(body []byte, timestamp, header string, now time.Time) bool {
+	sig, ok := strings.CutPrefix(header, "v0=")
+	if !ok || c.SlackSigningSecret == "" {
+		return false
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > slackMaxAge || age < -slackMaxAge {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(c.SlackSigningSecret))
+	fmt.Fprintf(mac, "v0:%s:", timestamp)
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// The parts of a Google Chat CARD_CLICKED event we need
+type chatEvent struct {
+	Type string `json:"type"`
+	User struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+		Email       string `json:"email"`
+	} `json:"user"`
+	Common struct {
+		InvokedFunction string            `json:"invokedFunction"`
+		Parameters      map[string]string `json:"parameters"`
+	} `json:"common"`
+}
+
+func googleChatApproval(w http.ResponseWriter, r *http.Request, body []byte) {
+	ctx := r.Context()
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || c.GoogleChatAudience == "" {
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return
+	}
+	payload, err := idtoken.Validate(ctx, token, c.GoogleChatAudience)
+	if err != nil || payload.Claims["email"] != chatIssuer {
+		log.Printf("Rejecting Google Chat request with invalid token: %v", err)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	var e chatEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if e.Type != "CARD_CLICKED" {
+		// Chat apps also hear about being added to spaces and mentions
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+		return
+	}
+	v := buttonValue{Rollout: e.Common.Parameters["rollout"], Target: e.Common.Parameters["target"]}
+	if v.Rollout == "" || e.User.Email == "" {
+		http.Error(w, "invalid button", http.StatusBadRequest)
+		return
+	}
+	// Same identity as approvalVote, so both count as one approver
+	approver := Approver{ID: "google:" + e.User.Email, Name: e.User.DisplayName, Email: e.User.Email}
+	text, decided, err := chatVote(ctx, v, approver, e.Common.InvokedFunction == approveAction)
+	if err != nil {
+		log.Printf("Error recording vote by %s on %s: %v", approver, v.Rollout, err)
+		http.Error(w, "error recording vote", http.StatusInternalServerError)
+		return
+	}
+	response := "NEW_MESSAGE"
+	if decided {
+		response = "UPDATE_MESSAGE"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"actionResponse": map[string]string{"type": response},
+		"text":           text,
+	})
+}
+
+// chatVote casts approver's vote if they're an approver for the target. It
+// returns what to tell them and whether the rollout is now decided; errors
+// are only those worth retrying.
+func chatVote(ctx context.Context, v buttonValue, approver Approver, approve bool) (string, bool, error) {
+	quorum, err := currentQuorum(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	if !quorum.RuleFor(v.Target).Allows(approver) {
+		log.Printf("%s isn't an approver for %s", approver, v.Target)
+		return fmt.Sprintf("You're not an approver for %s.", v.Target), false, nil
+	}
+	t, err := castVote(ctx, Vote{
+		Rollout:  v.Rollout,
+		Approver: approver,
+		Approve:  approve,
+		Comment:  "Voted from chat.",
+		At:       time.Now().UTC(),
+	})
+	if failure.Classify(err) == failure.Permanent {
+		return err.Error(), false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	rollout := v.Rollout[strings.LastIndex(v.Rollout, "/")+1:]
+	if t.Decision != "" {
+		deciders := t.Approvers
+		verb := "approved"
+		if t.Decision == Reject {
+			deciders, verb = t.Rejecters, "rejected"
+		}
+		names := make([]string, len(deciders))
+		for i, a := range deciders {
+			names[i] = a.String()
+		}
+		if len(names) == 0 {
+			return fmt.Sprintf("Rollout %s to %s was already %s.", rollout, v.Target, verb), true, nil
+		}
+		return fmt.Sprintf("Rollout %s to %s was %s by %s.", rollout, v.Target, verb, strings.Join(names, ", ")), true, nil
+	}
+	text := fmt.Sprintf("Your vote on %s is in: %d of %d approvals.", rollout, len(t.Approvers), t.Needed)
+	for _, why := range t.Ignored {
+		text += "\nNot counted: " + why + "."
+	}
+	return text, false, nil
+}
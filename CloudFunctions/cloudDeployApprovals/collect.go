@@ -0,0 +1,238 @@
+package example
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/command"
+	"example.com/shared/configfile"
+	"example.com/shared/deployclient"
+	"example.com/shared/failure"
+	"example.com/shared/freeze"
+	"example.com/shared/history"
+	"example.com/shared/jira"
+	"example.com/shared/provenance"
+)
+
+// errNotPending is returned for votes on rollouts that aren't waiting for
+// approval, e.g. because they were decided already.
+var errNotPending = errors.New("not waiting for approval")
+
+var quorumCache = &configfile.Cache[*Quorum]{Name: "approval quorum", Parse: parseQuorum}
+
+// currentQuorum returns the quorum from APPROVAL_QUORUM (inline JSON) or
+// APPROVAL_QUORUM_URI. With neither, one vote decides.
+func currentQuorum(ctx context.Context) (*Quorum, error) {
+	if c.ApprovalQuorum != "" {
+		return parseQuorum([]byte(c.ApprovalQuorum))
+	}
+	if c.ApprovalQuorumURI == "" {
+		return &Quorum{}, nil
+	}
+	return quorumCache.Get(ctx, c.ApprovalQuorumURI, c.ApprovalPolicyRefresh)
+}
+
+func parseQuorum(data []byte) (*Quorum, error) {
+	var q Quorum
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&q); err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("error parsing approval quorum: %w", err))
+	}
+	if err := q.Validate(); err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("invalid approval quorum: %w", err))
+	}
+	return &q, nil
+}
+
+// castVote records a vote on a rollout waiting for approval and, once the
+// target's quorum is met, sends the decision to cloudDeployInteractions
+// naming the approvers. v.Target is filled in from the rollout.
+func castVote(ctx context.Context, v Vote) (Tally, error) {
+	deployClient, err := deployclient.New(ctx)
+	if err != nil {
+		return Tally{}, fmt.Errorf("error creating Cloud Deploy client: %w", err)
+	}
+	defer deployClient.Close()
+	rollout, err := deployClient.GetRollout(ctx, &deploypb.GetRolloutRequest{Name: v.Rollout})
+	if err != nil {
+		return Tally{}, fmt.Errorf("error getting rollout: %w", err)
+	}
+	if rollout.ApprovalState != deploypb.Rollout_NEEDS_APPROVAL {
+		return Tally{}, failure.NewPermanent(fmt.Errorf("rollout %s is %s, %w", v.Rollout, rollout.ApprovalState, errNotPending))
+	}
+	v.Target = rollout.TargetId
+	releaseName, _, _ := strings.Cut(v.Rollout, "/rollouts/")
+	release, err := deployClient.GetRelease(ctx, &deploypb.GetReleaseRequest{Name: releaseName})
+	if err != nil {
+		return Tally{}, fmt.Errorf("error getting release: %w", err)
+	}
+
+	quorum, err := currentQuorum(ctx)
+	if err != nil {
+		return Tally{}, err
+	}
+	rule := quorum.RuleFor(v.Target)
+	if v.Approve && rule.ForbidSelfApproval && v.Approver.Email == "" && !isPolicy(v.Approver) {
+		// Slack and Jira voters are checked against the author by email
+		email, err := voterEmail(ctx, v.Approver)
+		if err != nil {
+			return Tally{}, fmt.Errorf("error looking up the email of %s: %w", v.Approver, err)
+		}
+		if email == "" {
+			return Tally{}, failure.NewPermanent(fmt.Errorf("%s has no email to tell whether they authored the release, so can't approve it", v.Approver))
+		}
+		v.Approver.Email = email
+	}
+
+	store, err := newVoteStore(ctx)
+	if err != nil {
+		return Tally{}, fmt.Errorf("error creating vote store: %w", err)
+	}
+	defer store.Close()
+	ballot, err := store.Cast(ctx, v)
+	if err != nil {
+		return Tally{}, err
+	}
+	issueKey := release.Annotations[jira.IssueKeyAnnotation]
+	if ballot.Decision != "" {
+		log.Printf("Ignoring vote by %s, %s was already decided", v.Approver, v.Rollout)
+		t := Tally{Decision: ballot.Decision, Needed: max(rule.Approvals, 1)}
+		// The rollout still needs approval, so the attempt that decided it
+		// may have failed to send the decision. It's sent again, which
+		// cloudDeployInteractions drops if it isn't needed.
+		return t, sendDecision(ctx, rollout, issueKey, ballot.Decision, ballot.DecidedBy)
+	}
+	recordApproval(ctx, history.Vote, v.Rollout+"/"+v.Approver.ID+"/"+v.At.Format(time.RFC3339Nano), v.Rollout, v.Target, v.Approver.ID, voteDecision(v.Approve), v.Comment, v.Approver)
+	votes := make([]Vote, 0, len(ballot.Votes))
+	for _, vote := range ballot.Votes {
+		votes = append(votes, vote)
+	}
+	sort.Slice(votes, func(i, j int) bool { return votes[i].At.Before(votes[j].At) })
+	t := rule.Tally(votes, release.Annotations[provenance.Author])
+	log.Printf("%s voted approve=%t on %s: %d/%d approvals, %d rejection(s), %d ignored",
+		v.Approver, v.Approve, v.Rollout, len(t.Approvers), t.Needed, len(t.Rejecters), len(t.Ignored))
+
+	if t.Decision == "" {
+		if issueKey != "" {
+			commentOnIssue(ctx, issueKey, describeVote(v, rollout, t))
+		}
+		return t, nil
+	}
+
+	deciders := t.Approvers
+	if t.Decision == Reject {
+		deciders = t.Rejecters
+	}
+	// The decision is recorded before it's sent, so every decision sent has
+	// a record, and a failed send is retried by the next vote
+	first, err := store.Decide(ctx, v.Rollout, t)
+	if err != nil {
+		return t, err
+	}
+	if err := sendDecision(ctx, rollout, issueKey, t.Decision, deciders); err != nil {
+		return t, err
+	}
+	if first {
+		recordApproval(ctx, history.Approval, "quorum/"+v.Rollout, v.Rollout, v.Target, "quorum:"+approverIDs(deciders), t.Decision,
+			fmt.Sprintf("%d of %d approvals: %s", len(t.Approvers), t.Needed, approverNames(deciders)), deciders...)
+	}
+	if first && issueKey != "" {
+		commentOnIssue(ctx, issueKey, describeDecision(rollout, t, deciders))
+	}
+	return t, nil
+}
+
+// sendDecision sends a quorum's decision on the rollout to
+// cloudDeployInteractions, naming the deciders in the command. Approvals
+// onto a frozen target are deferred or turned into rejections, depending on
+// the freeze.
+func sendDecision(ctx context.Context, rollout *deploypb.Rollout, issueKey string, d Decision, deciders []Approver) error {
+	issuer := "quorum:" + approverIDs(deciders)
+	var frozen *freeze.Occurrence
+	if d == Approve {
+		var err error
+		frozen, err = freezes().Check(ctx, rollout.TargetId, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to check freezes: %w", err)
+		}
+	}
+	if frozen != nil && frozen.Freeze.ModeOrDefault() == freeze.Reject {
+		reason := describeFreeze(rollout.TargetId, frozen)
+		log.Printf("Rejecting %s, %s", rollout.Name, reason)
+		d, issuer = Reject, "freeze:"+frozen.Freeze.Name
+		recordApproval(ctx, history.Approval, "freeze/"+rollout.Name, rollout.Name, rollout.TargetId, issuer, d, reason)
+		if issueKey != "" {
+			commentOnIssue(ctx, issueKey, fmt.Sprintf("Rollout %s to %s was rejected: %s.", shortName(rollout.Name), rollout.TargetId, reason))
+		}
+		frozen = nil
+	}
+	cmd, err := command.New(command.ApproveRollout, issuer, &deploypb.ApproveRolloutRequest{
+		Name:     rollout.Name,
+		Approved: d == Approve,
+	})
+	if err != nil {
+		return failure.NewPermanent(fmt.Errorf("failed to build command: %w", err))
+	}
+	// Voters reaching the quorum at the same time send the same command
+	cmd.CorrelationID = "quorum/" + rollout.Name
+	if !strings.HasPrefix(issuer, "freeze:") {
+		for _, a := range deciders {
+			cmd.Approvers = append(cmd.Approvers, command.Approver(a))
+		}
+	}
+	if frozen != nil {
+		if err := deferApproval(ctx, rollout.Name, rollout.TargetId, issueKey, cmd, frozen); err != nil {
+			return fmt.Errorf("failed to defer approval: %w", err)
+		}
+		return nil
+	}
+	if _, err := command.NewPublisher(c.ProjectId, c.SendTopicID).Publish(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to send pubsub command: %w", err)
+	}
+	return nil
+}
+
+func describeVote(v Vote, rollout *deploypb.Rollout, t Tally) string {
+	verb := "approved"
+	if !v.Approve {
+		verb = "rejected"
+	}
+	text := fmt.Sprintf("%s %s rollout %s to %s (%d of %d approvals).", v.Approver, verb, shortName(rollout.Name), rollout.TargetId, len(t.Approvers), t.Needed)
+	if v.Comment != "" {
+		text += "\n" + v.Comment
+	}
+	for _, why := range t.Ignored {
+		text += "\nNot counted: " + why + "."
+	}
+	return text
+}
+
+func describeDecision(rollout *deploypb.Rollout, t Tally, deciders []Approver) string {
+	verb := "approved"
+	if t.Decision == Reject {
+		verb = "rejected"
+	}
+	return fmt.Sprintf("Rollout %s to %s was %s by %s.", shortName(rollout.Name), rollout.TargetId, verb, approverNames(deciders))
+}
+
+// shortName is the last segment of a resource name, e.g. the rollout ID.
+func shortName(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// commentOnIssue comments on the release's issue. Jira being unavailable
+// only gets logged, the votes stand either way.
+func commentOnIssue(ctx context.Context, key, text string) {
+	if err := jira.NewClient(c.JiraURL, c.JiraEmail, c.JiraToken).AddComment(ctx, key, text); err != nil {
+		log.Printf("Failed to comment on %s: %v", key, err)
+	}
+}
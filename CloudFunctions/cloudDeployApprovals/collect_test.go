@@ -0,0 +1,237 @@
+package example
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"example.com/shared/command"
+	"example.com/shared/deployclient"
+	"example.com/shared/deploytest"
+	"example.com/shared/freeze"
+	"example.com/shared/jira"
+	"example.com/shared/jira/jiratest"
+	"example.com/shared/provenance"
+)
+
+const testPipeline = "projects/p/locations/l/deliveryPipelines/app"
+
+// fakes are the services castVote talks to.
+type fakes struct {
+	pubsub *pstest.Server
+	n      int
+}
+
+// newFakes points the function at a fake Cloud Deploy with dev and prod
+// targets needing approval and a fake Pub/Sub, with in-memory stores.
+func newFakes(t *testing.T) *fakes {
+	t.Helper()
+	ds, err := deploytest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ds.Close)
+	ds.AddPipeline(&deploypb.DeliveryPipeline{
+		Name: testPipeline,
+		Pipeline: &deploypb.DeliveryPipeline_SerialPipeline{SerialPipeline: &deploypb.SerialPipeline{
+			Stages: []*deploypb.Stage{{TargetId: "dev"}, {TargetId: "prod"}},
+		}},
+	})
+	for _, target := range []string{"dev", "prod"} {
+		ds.AddTarget(&deploypb.Target{Name: "projects/p/locations/l/targets/" + target, TargetId: target, RequireApproval: true})
+	}
+	t.Setenv(deployclient.EmulatorHostEnv, ds.Addr)
+
+	ps := pstest.NewServer()
+	t.Cleanup(func() { ps.Close() })
+	t.Setenv("PUBSUB_EMULATOR_HOST", ps.Addr)
+	client, err := pubsub.NewClient(context.Background(), "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.CreateTopic(context.Background(), "commands"); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, savedVotes := c, memVotes
+	t.Cleanup(func() { c, memVotes = saved, savedVotes })
+	c.ProjectId, c.SendTopicID = "p", "commands"
+	c.VoteStore, c.FreezeStore, c.HistoryStore, c.OnceStore = "memory", "memory", "memory", "memory"
+	c.ApprovalPolicy, c.ApprovalQuorum, c.FreezeCalendar = "", "", ""
+	memVotes = NewMemoryVoteStore()
+	return &fakes{pubsub: ps}
+}
+
+// pendingRollout creates release and its rollout to target, which waits
+// for approval.
+func (f *fakes) pendingRollout(t *testing.T, target string, release *deploypb.Release) string {
+	t.Helper()
+	ctx := context.Background()
+	d, err := deployclient.New(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	f.n++
+	releaseID := fmt.Sprintf("r%d", f.n)
+	op, err := d.CreateRelease(ctx, &deploypb.CreateReleaseRequest{
+		Parent:    testPipeline,
+		ReleaseId: releaseID,
+		Release:   release,
+	})
+	if err == nil {
+		_, err = op.Wait(ctx)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	rop, err := d.CreateRollout(ctx, &deploypb.CreateRolloutRequest{
+		Parent:    testPipeline + "/releases/" + releaseID,
+		RolloutId: releaseID + "-to-" + target + "-0001",
+		Rollout:   &deploypb.Rollout{TargetId: target},
+	})
+	if err == nil {
+		_, err = rop.Wait(ctx)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testPipeline + "/releases/" + releaseID + "/rollouts/" + releaseID + "-to-" + target + "-0001"
+}
+
+// by is a release authored by author.
+func by(author string) *deploypb.Release {
+	return &deploypb.Release{Annotations: map[string]string{provenance.Author: author}}
+}
+
+// commands returns the commands published so far.
+func (f *fakes) commands(t *testing.T) []*command.Envelope {
+	t.Helper()
+	var cmds []*command.Envelope
+	for _, m := range f.pubsub.Messages() {
+		cmd, _, err := command.Parse(m.Data)
+		if err != nil {
+			t.Fatalf("published an invalid command: %v", err)
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+func vote(rollout, id string, approve bool) Vote {
+	return Vote{Rollout: rollout, Approver: Approver{ID: id}, Approve: approve, At: time.Now().UTC()}
+}
+
+func TestCastVote(t *testing.T) {
+	f := newFakes(t)
+	js := jiratest.NewServer()
+	t.Cleanup(js.Close)
+	c.JiraURL = js.URL + "/"
+	js.AddUser(jira.User{AccountID: "carol", DisplayName: "Carol", EmailAddress: "carol@example.com"})
+	c.ApprovalQuorum = `{"rules": [{"name": "prod", "targets": ["prod"], "approvals": 2, "forbidSelfApproval": true}]}`
+	ctx := context.Background()
+
+	rollout := f.pendingRollout(t, "prod", by("alice@example.com"))
+	for _, tt := range []struct {
+		vote     Vote
+		decision Decision
+	}{
+		{vote(rollout, "google:alice@example.com", true), ""},
+		{vote(rollout, "google:bob@example.com", true), ""},
+		{vote(rollout, "jira:carol", true), Approve},
+	} {
+		got, err := castVote(ctx, tt.vote)
+		if err != nil {
+			t.Fatalf("castVote by %s: %v", tt.vote.Approver, err)
+		}
+		if got.Decision != tt.decision {
+			t.Errorf("castVote by %s decided %q, want %q (%+v)", tt.vote.Approver, got.Decision, tt.decision, got)
+		}
+	}
+	cmds := f.commands(t)
+	if len(cmds) != 1 || cmds[0].Type != command.ApproveRollout || cmds[0].CorrelationID != "quorum/"+rollout {
+		t.Fatalf("commands = %+v, want one quorum ApproveRollout", cmds)
+	}
+	if got := cmds[0].Approvers; len(got) != 2 || got[0].ID != "google:bob@example.com" || got[1].ID != "jira:carol" {
+		t.Errorf("command approvers = %+v, want bob and carol", got)
+	}
+	b, _ := memVotes.Ballot(ctx, rollout)
+	if b.Decision != Approve || len(b.DecidedBy) != 2 {
+		t.Errorf("ballot = %+v, want approved by bob and carol", b)
+	}
+
+	// A vote after the decision sends it again, which interactions drops
+	if _, err := castVote(ctx, vote(rollout, "jira:dave", false)); err != nil {
+		t.Fatal(err)
+	}
+	if cmds := f.commands(t); len(cmds) != 2 || cmds[1].CorrelationID != cmds[0].CorrelationID {
+		t.Errorf("commands after a late vote = %d, want the decision again", len(cmds))
+	}
+
+	dev := f.pendingRollout(t, "dev", by("alice@example.com"))
+	got, err := castVote(ctx, vote(dev, "google:bob@example.com", false))
+	if err != nil || got.Decision != Reject {
+		t.Errorf("rejection on dev = %+v, %v, want rejected by one vote", got, err)
+	}
+}
+
+func TestCastVoteFrozen(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		mode         freeze.Mode
+		wantCommands int
+		wantDeferred bool
+	}{
+		{mode: freeze.Defer, wantDeferred: true},
+		{mode: freeze.Reject, wantCommands: 1},
+	} {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			f := newFakes(t)
+			name := "freeze-" + string(tt.mode)
+			c.FreezeCalendar = fmt.Sprintf(`{"freezes": [{"name": %q, "reason": "testing", "mode": %q, "targets": ["prod"],
+				"start": "2000-01-01T00:00:00Z", "end": "2999-01-01T00:00:00Z"}]}`, name, tt.mode)
+			rollout := f.pendingRollout(t, "prod", by(""))
+
+			got, err := castVote(ctx, vote(rollout, "jira:carol", true))
+			if err != nil || got.Decision != Approve {
+				t.Fatalf("castVote = %+v, %v, want the quorum approving", got, err)
+			}
+			cmds := f.commands(t)
+			if len(cmds) != tt.wantCommands {
+				t.Fatalf("published %d command(s), want %d", len(cmds), tt.wantCommands)
+			}
+			if len(cmds) > 0 {
+				_, payload, _ := command.Parse(f.pubsub.Messages()[0].Data)
+				if payload.(*deploypb.ApproveRolloutRequest).Approved || cmds[0].Issuer != "freeze:"+name || len(cmds[0].Approvers) != 0 {
+					t.Errorf("command = %+v, want a rejection by the freeze", cmds[0])
+				}
+			}
+			store, _ := freezes().OpenStore(ctx)
+			pending, _ := store.Pending(ctx)
+			deferred := false
+			for _, d := range pending {
+				if d.Freeze != name {
+					continue
+				}
+				deferred = true
+				// The approvers are sent once the freeze ends
+				var cmd command.Envelope
+				if err := json.Unmarshal(d.Command, &cmd); err != nil || len(cmd.Approvers) != 1 || cmd.Approvers[0].ID != "jira:carol" {
+					t.Errorf("deferred command = %s, want it approved by carol", d.Command)
+				}
+			}
+			if deferred != tt.wantDeferred {
+				t.Errorf("deferred = %t, want %t", deferred, tt.wantDeferred)
+			}
+			if b, _ := memVotes.Ballot(ctx, rollout); b.Decision != Approve {
+				t.Errorf("ballot decision = %q, want the quorum's approval recorded", b.Decision)
+			}
+		})
+	}
+}
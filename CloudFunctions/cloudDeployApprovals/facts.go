@@ -0,0 +1,63 @@
+package example
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/deployclient"
+	"example.com/shared/failure"
+	"example.com/shared/jira"
+	"google.golang.org/api/iterator"
+)
+
+// gatherFacts looks up what the approval policy needs to know about the
+// rollout, and the key of its release's Jira issue, if any.
+func gatherFacts(ctx context.Context, a ApprovalsData) (Facts, string, error) {
+	f := Facts{Target: a.TargetId, Now: time.Now()}
+	release, _, ok := strings.Cut(a.Rollout, "/rollouts/")
+	if !ok {
+		// Redelivering the message won't fix it
+		return f, "", failure.NewPermanent(fmt.Errorf("%q is not a rollout name", a.Rollout))
+	}
+
+	deployClient, err := deployclient.New(ctx)
+	if err != nil {
+		return f, "", fmt.Errorf("error creating Cloud Deploy client: %w", err)
+	}
+	defer deployClient.Close()
+	r, err := deployClient.GetRelease(ctx, &deploypb.GetReleaseRequest{Name: release})
+	if err != nil {
+		// NotFound and the like are permanent, see failure.Classify
+		return f, "", fmt.Errorf("error getting release: %w", err)
+	}
+	f.Labels = r.Labels
+	it := deployClient.ListRollouts(ctx, &deploypb.ListRolloutsRequest{Parent: release})
+	for {
+		rollout, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return f, "", fmt.Errorf("error listing rollouts: %w", err)
+		}
+		if rollout.State == deploypb.Rollout_SUCCEEDED {
+			f.SuccessfulRollouts++
+		}
+	}
+
+	key := r.Annotations[jira.IssueKeyAnnotation]
+	if key == "" {
+		return f, "", nil
+	}
+	issue, err := jira.NewClient(c.JiraURL, c.JiraEmail, c.JiraToken).GetIssue(ctx, key)
+	if err != nil {
+		return f, "", err
+	}
+	if status, ok := issue.Fields["status"].(map[string]interface{}); ok {
+		f.JiraStatus, _ = status["name"].(string)
+	}
+	return f, key, nil
+}
@@ -0,0 +1,85 @@
+package example
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/deployclient"
+	"example.com/shared/failure"
+	"example.com/shared/jira"
+	"example.com/shared/jira/jiratest"
+)
+
+func TestGatherFacts(t *testing.T) {
+	f := newFakes(t)
+	js := jiratest.NewServer()
+	t.Cleanup(js.Close)
+	c.JiraURL, c.JiraEmail, c.JiraToken = js.URL+"/", "bot@example.com", "token"
+	ctx := context.Background()
+	client := jira.NewClient(c.JiraURL, c.JiraEmail, c.JiraToken)
+	issue, err := client.CreateIssue(ctx, jira.IssueRequest{ProjectKey: "DEP", IssueType: "Task", Summary: "Release"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.TransitionTo(ctx, issue.Key, "Approved"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The release went through dev before waiting for approval on prod
+	dev := f.pendingRollout(t, "dev", &deploypb.Release{
+		Labels:      map[string]string{"branch": "main"},
+		Annotations: map[string]string{jira.IssueKeyAnnotation: issue.Key},
+	})
+	d, err := deployclient.New(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if _, err := d.ApproveRollout(ctx, &deploypb.ApproveRolloutRequest{Name: dev, Approved: true}); err != nil {
+		t.Fatal(err)
+	}
+	release, _, _ := strings.Cut(dev, "/rollouts/")
+	rop, err := d.CreateRollout(ctx, &deploypb.CreateRolloutRequest{
+		Parent:    release,
+		RolloutId: "r1-to-prod-0001",
+		Rollout:   &deploypb.Rollout{TargetId: "prod"},
+	})
+	if err == nil {
+		_, err = rop.Wait(ctx)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	facts, key, err := gatherFacts(ctx, ApprovalsData{Rollout: release + "/rollouts/r1-to-prod-0001", TargetId: "prod"})
+	if err != nil {
+		t.Fatalf("gatherFacts: %v", err)
+	}
+	if facts.Target != "prod" || facts.Labels["branch"] != "main" || facts.JiraStatus != "Approved" ||
+		facts.SuccessfulRollouts != 1 || facts.Now.IsZero() {
+		t.Errorf("facts = %+v, want prod, branch main, Approved and 1 successful rollout", facts)
+	}
+	if key != issue.Key {
+		t.Errorf("issue key = %q, want %s", key, issue.Key)
+	}
+
+	// Releases without an issue have no Jira status
+	prod := f.pendingRollout(t, "prod", &deploypb.Release{})
+	facts, key, err = gatherFacts(ctx, ApprovalsData{Rollout: prod, TargetId: "prod"})
+	if err != nil || key != "" || facts.JiraStatus != "" || facts.SuccessfulRollouts != 0 {
+		t.Errorf("facts without an issue = %+v, %q, %v", facts, key, err)
+	}
+
+	for _, rollout := range []string{"not-a-rollout", ""} {
+		if _, _, err := gatherFacts(ctx, ApprovalsData{Rollout: rollout}); failure.Classify(err) != failure.Permanent {
+			t.Errorf("gatherFacts of rollout %q = %v, want a permanent error", rollout, err)
+		}
+	}
+	// A release that's gone won't come back either
+	gone := "projects/p/locations/l/deliveryPipelines/app/releases/gone/rollouts/gone-to-prod-0001"
+	if _, _, err := gatherFacts(ctx, ApprovalsData{Rollout: gone, TargetId: "prod"}); err == nil || failure.Classify(err) != failure.Permanent {
+		t.Errorf("gatherFacts of a deleted release = %v, want a permanent error", err)
+	}
+}
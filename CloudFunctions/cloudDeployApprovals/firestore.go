@@ -0,0 +1,99 @@
+package example
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FirestoreVoteStore keeps one document per rollout's ballot.
+type FirestoreVoteStore struct {
+	client     *firestore.Client
+	collection string
+}
+
+func newFirestoreVoteStore(ctx context.Context, projectID, database, collection string) (*FirestoreVoteStore, error) {
+	client, err := firestore.NewClientWithDatabase(ctx, projectID, database)
+	if err != nil {
+		return nil, fmt.Errorf("firestore.NewClient: %w", err)
+	}
+	return &FirestoreVoteStore{client: client, collection: collection}, nil
+}
+
+// update reads the rollout's ballot and, if f changes it, writes it back in
+// one transaction, so concurrent votes don't overwrite each other.
+func (s *FirestoreVoteStore) update(ctx context.Context, rollout string, f func(b *Ballot, exists bool) bool) (*Ballot, error) {
+	ref := s.client.Collection(s.collection).Doc(ballotKey(rollout))
+	var b *Ballot
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		b = &Ballot{Rollout: rollout}
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if snap.Exists() {
+			if err := snap.DataTo(b); err != nil {
+				return err
+			}
+		}
+		if b.Votes == nil {
+			b.Votes = map[string]Vote{}
+		}
+		if !f(b, snap.Exists()) {
+			return nil
+		}
+		return tx.Set(ref, b)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("updating ballot of %s: %w", rollout, err)
+	}
+	return b, nil
+}
+
+func (s *FirestoreVoteStore) Cast(ctx context.Context, v Vote) (*Ballot, error) {
+	return s.update(ctx, v.Rollout, func(b *Ballot, _ bool) bool {
+		if b.Decision != "" {
+			return false
+		}
+		b.Target = v.Target
+		b.Votes[v.Approver.ID] = v
+		return true
+	})
+}
+
+func (s *FirestoreVoteStore) Decide(ctx context.Context, rollout string, t Tally) (bool, error) {
+	decided := false
+	_, err := s.update(ctx, rollout, func(b *Ballot, exists bool) bool {
+		decided = false
+		if !exists || b.Decision != "" {
+			return false
+		}
+		decide(b, t)
+		decided = true
+		return true
+	})
+	return decided, err
+}
+
+func (s *FirestoreVoteStore) Ballot(ctx context.Context, rollout string) (*Ballot, error) {
+	snap, err := s.client.Collection(s.collection).Doc(ballotKey(rollout)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting ballot of %s: %w", rollout, err)
+	}
+	var b Ballot
+	if err := snap.DataTo(&b); err != nil {
+		return nil, fmt.Errorf("reading ballot of %s: %w", rollout, err)
+	}
+	return &b, nil
+}
+
+// Close releases the Firestore client.
+func (s *FirestoreVoteStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,71 @@
+package example
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"example.com/shared/command"
+	"example.com/shared/freeze"
+	"example.com/shared/jira"
+)
+
+func freezes() freeze.Config {
+	return freeze.Config{
+		Inline:     c.FreezeCalendar,
+		URI:        c.FreezeCalendarURI,
+		Refresh:    c.FreezeCalendarRefresh,
+		Store:      c.FreezeStore,
+		ProjectID:  c.ProjectId,
+		Database:   c.FirestoreDatabase,
+		Collection: c.FreezeCollection,
+	}
+}
+
+// deferApproval parks an approval granted while the rollout's target is
+// frozen. cloudDeployOperations' resumeDeferred sends cmd once
+// the freeze ends or is lifted. The release's issue is told the first time
+// only, not on every redelivery.
+func deferApproval(ctx context.Context, rollout, target, issueKey string, cmd *command.Envelope, o *freeze.Occurrence) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return fmt.Errorf("error encoding command: %w", err)
+	}
+	store, err := freezes().OpenStore(ctx)
+	if err != nil {
+		return fmt.Errorf("error creating freeze store: %w", err)
+	}
+	defer store.Close()
+	first, err := store.Defer(ctx, freeze.DeferralKey(string(cmd.Type), cmd.CorrelationID), freeze.Deferral{
+		Freeze:   o.Freeze.Name,
+		Target:   target,
+		Action:   fmt.Sprintf("approval of rollout %s", shortName(rollout)),
+		Command:  data,
+		IssueKey: issueKey,
+		Until:    o.End,
+		Created:  time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	if !first {
+		log.Printf("Approval of %s was already deferred", rollout)
+		return nil
+	}
+	log.Printf("Deferred approval of %s until freeze %s ends at %s", rollout, o.Freeze.Name, o.End)
+	if issueKey != "" {
+		text := fmt.Sprintf("Approval of rollout %s to %s is deferred: %s. It will be approved once the freeze ends or is lifted.",
+			shortName(rollout), target, describeFreeze(target, o))
+		if err := jira.NewClient(c.JiraURL, c.JiraEmail, c.JiraToken).AddComment(ctx, issueKey, text); err != nil {
+			// The deferral stands either way
+			log.Printf("Failed to comment on %s: %v", issueKey, err)
+		}
+	}
+	return nil
+}
+
+func describeFreeze(target string, o *freeze.Occurrence) string {
+	return fmt.Sprintf("%s is frozen by %q until %s (%s)", target, o.Freeze.Name, o.End.UTC().Format(time.RFC3339), o.Freeze.Reason)
+}
@@ -0,0 +1,66 @@
+package example
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"example.com/shared/history"
+)
+
+func historyConfig() history.Config {
+	return history.Config{
+		Store:      c.HistoryStore,
+		ProjectID:  c.ProjectId,
+		Database:   c.FirestoreDatabase,
+		Collection: c.HistoryCollection,
+	}
+}
+
+// recordApproval appends a decision, or a vote, on a rollout to the
+// history, with whoever made it. id keeps redeliveries from recording it
+// twice. Failing to is only logged, the decision stands either way.
+func recordApproval(ctx context.Context, kind history.Kind, id, rollout, target, actor string, d Decision, detail string, approvers ...Approver) {
+	r := history.Record{
+		ID:       history.Key(string(kind), id),
+		Kind:     kind,
+		At:       time.Now().UTC(),
+		Target:   target,
+		Actor:    actor,
+		Decision: string(d),
+		Detail:   detail,
+	}
+	for _, a := range approvers {
+		r.Approvers = append(r.Approvers, a.ID)
+	}
+	r.SetResource(rollout)
+	if err := historyConfig().Append(ctx, r); err != nil {
+		log.Printf("Failed to record history: %v", err)
+	}
+}
+
+func voteDecision(approve bool) Decision {
+	if approve {
+		return Approve
+	}
+	return Reject
+}
+
+// approverIDs joins the approvers' IDs, as in quorum command issuers.
+func approverIDs(approvers []Approver) string {
+	ids := make([]string, len(approvers))
+	for i, a := range approvers {
+		ids[i] = a.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+// approverNames joins the approvers' names and IDs, as in issue comments.
+func approverNames(approvers []Approver) string {
+	names := make([]string, len(approvers))
+	for i, a := range approvers {
+		names[i] = a.String()
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,73 @@
+package example
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"example.com/shared/failure"
+	"example.com/shared/jira"
+)
+
+// voterEmail looks up the email of a voter whose vote didn't carry one:
+// Google identities are their email, Slack users are looked up with
+// users.info and Jira users with the user API, which leaves it empty when
+// their profile hides it. It's empty for anyone else, and for Slack users
+// when there's no bot token to ask with.
+func voterEmail(ctx context.Context, a Approver) (string, error) {
+	source, id, _ := strings.Cut(a.ID, ":")
+	switch source {
+	case "google":
+		return id, nil
+	case "slack":
+		return slackEmail(ctx, id)
+	case "jira":
+		u, err := jira.NewClient(c.JiraURL, c.JiraEmail, c.JiraToken).GetUser(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		return u.EmailAddress, nil
+	}
+	return "", nil
+}
+
+// slackEmail asks Slack for a user's email, which needs a bot token with
+// the users:read.email scope.
+func slackEmail(ctx context.Context, user string) (string, error) {
+	if c.SlackBotToken == "" {
+		return "", nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.SlackAPIURL+"/users.info?user="+url.QueryEscape(user), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.SlackBotToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Rate limits and outages, worth trying again
+		return "", fmt.Errorf("slack users.info returned %d", resp.StatusCode)
+	}
+	var info struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+		User  struct {
+			Profile struct {
+				Email string `json:"email"`
+			} `json:"profile"`
+		} `json:"user"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("error decoding slack users.info: %w", err)
+	}
+	if !info.OK {
+		return "", failure.NewPermanent(fmt.Errorf("slack users.info: %s", info.Error))
+	}
+	return info.User.Profile.Email, nil
+}
@@ -0,0 +1,93 @@
+package example
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/shared/failure"
+	"example.com/shared/jira"
+	"example.com/shared/jira/jiratest"
+)
+
+// newSlackAPI fakes Slack's users.info for the users in emails, keyed by
+// user ID, and points the function at it.
+func newSlackAPI(t *testing.T, emails map[string]string) {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users.info" || r.Header.Get("Authorization") != "Bearer xoxb-test" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		email, ok := emails[r.URL.Query().Get("user")]
+		if !ok {
+			json.NewEncoder(w).Encode(map[string]any{"ok": false, "error": "user_not_found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "user": map[string]any{"profile": map[string]any{"email": email}}})
+	}))
+	t.Cleanup(s.Close)
+	c.SlackBotToken, c.SlackAPIURL = "xoxb-test", s.URL
+}
+
+func TestCastVoteVoterEmails(t *testing.T) {
+	f := newFakes(t)
+	js := jiratest.NewServer()
+	t.Cleanup(js.Close)
+	c.JiraURL, c.JiraEmail, c.JiraToken = js.URL+"/", "bot@example.com", "token"
+	js.AddUser(jira.User{AccountID: "acc-alice", DisplayName: "Alice", EmailAddress: "alice@example.com"})
+	js.AddUser(jira.User{AccountID: "acc-bob", DisplayName: "Bob", EmailAddress: "bob@example.com"})
+	js.AddUser(jira.User{AccountID: "acc-hidden", DisplayName: "Hidden"})
+	newSlackAPI(t, map[string]string{"UALICE": "alice@example.com", "UBOB": "bob@example.com", "UNOEMAIL": ""})
+	c.ApprovalQuorum = `{"rules": [{"name": "prod", "targets": ["prod"], "approvals": 1, "forbidSelfApproval": true}]}`
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		name     string
+		voter    string
+		approve  bool
+		decision Decision
+		// the vote is refused
+		refused bool
+	}{
+		{name: "slack author", voter: "slack:UALICE", approve: true},
+		{name: "slack approver", voter: "slack:UBOB", approve: true, decision: Approve},
+		{name: "slack user without an email", voter: "slack:UNOEMAIL", approve: true, refused: true},
+		{name: "unknown slack user", voter: "slack:UNOBODY", approve: true, refused: true},
+		{name: "jira author", voter: "jira:acc-alice", approve: true},
+		{name: "jira approver", voter: "jira:acc-bob", approve: true, decision: Approve},
+		{name: "jira user hiding their email", voter: "jira:acc-hidden", approve: true, refused: true},
+		{name: "unknown jira user", voter: "jira:acc-nobody", approve: true, refused: true},
+		{name: "rejections need no email", voter: "jira:acc-hidden", decision: Reject},
+		{name: "policy needs no email", voter: "policy:prod", approve: true, decision: Approve},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rollout := f.pendingRollout(t, "prod", by("alice@example.com"))
+			got, err := castVote(ctx, vote(rollout, tt.voter, tt.approve))
+			if tt.refused {
+				if failure.Classify(err) != failure.Permanent {
+					t.Errorf("castVote = %+v, %v, want the vote refused", got, err)
+				}
+				if b, _ := memVotes.Ballot(ctx, rollout); b != nil {
+					t.Errorf("ballot = %+v, want no votes", b)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("castVote: %v", err)
+			}
+			if got.Decision != tt.decision {
+				t.Errorf("castVote decided %q, want %q (%+v)", got.Decision, tt.decision, got)
+			}
+		})
+	}
+
+	// Without a bot token Slack voters can't be told apart from the author
+	c.SlackBotToken = ""
+	rollout := f.pendingRollout(t, "prod", by("alice@example.com"))
+	if _, err := castVote(ctx, vote(rollout, "slack:UBOB", true)); failure.Classify(err) != failure.Permanent {
+		t.Errorf("castVote without a Slack bot token = %v, want the vote refused", err)
+	}
+}
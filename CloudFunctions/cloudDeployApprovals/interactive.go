@@ -0,0 +1,109 @@
+package example
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"example.com/shared/notify"
+	chat "google.golang.org/api/chat/v1"
+	"google.golang.org/api/option"
+)
+
+// Button IDs, and Google Chat functions, of the approval buttons
+const (
+	approveAction = "approve"
+	rejectAction  = "reject"
+)
+
+// buttonValue is what the approval buttons carry back to chatApproval.
+type buttonValue struct {
+	Rollout string `json:"rollout"`
+	Target  string `json:"target"`
+}
+
+func interactiveApprovals() bool {
+	return c.SlackApprovalWebhook != "" || c.GoogleChatApprovalSpace != ""
+}
+
+// requestApproval posts e with Approve and Reject buttons to the Slack app's
+// webhook and, as the Chat app, to the Google Chat space, whichever are
+// configured. Clicks come back to chatApproval.
+func requestApproval(ctx context.Context, e notify.Event, rollout string) error {
+	value, err := json.Marshal(buttonValue{Rollout: rollout, Target: e.Target})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if c.SlackApprovalWebhook != "" {
+		err := notify.Slack{WebhookURL: c.SlackApprovalWebhook, Buttons: []notify.Button{
+			{ID: approveAction, Text: "Approve", Value: string(value), Style: "primary"},
+			{ID: rejectAction, Text: "Reject", Value: string(value), Style: "danger"},
+		}}.Notify(ctx, e)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("slack approval request: %w", err))
+		}
+	}
+	if c.GoogleChatApprovalSpace != "" {
+		if err := postChatCard(ctx, e, buttonValue{Rollout: rollout, Target: e.Target}); err != nil {
+			errs = append(errs, fmt.Errorf("google chat approval request: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// postChatCard posts a card to the approval space as the Chat app. Cards
+// sent through incoming webhooks can't call the app back, hence the API.
+func postChatCard(ctx context.Context, e notify.Event, v buttonValue) error {
+	svc, err := chat.NewService(ctx, option.WithScopes(chat.ChatBotScope))
+	if err != nil {
+		return fmt.Errorf("error creating Chat client: %w", err)
+	}
+	var lines []string
+	if e.Detail != "" {
+		lines = append(lines, html.EscapeString(e.Detail))
+	}
+	var links []string
+	for _, l := range e.Links() {
+		if l.URL == "" {
+			links = append(links, html.EscapeString(l.Text))
+			continue
+		}
+		links = append(links, fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(l.URL), html.EscapeString(l.Text)))
+	}
+	if len(links) > 0 {
+		lines = append(lines, strings.Join(links, " · "))
+	}
+	params := []*chat.GoogleAppsCardV1ActionParameter{{Key: "rollout", Value: v.Rollout}, {Key: "target", Value: v.Target}}
+	button := func(text, function string) *chat.GoogleAppsCardV1Button {
+		return &chat.GoogleAppsCardV1Button{
+			Text:    text,
+			OnClick: &chat.GoogleAppsCardV1OnClick{Action: &chat.GoogleAppsCardV1Action{Function: function, Parameters: params}},
+		}
+	}
+	subtitle := e.Repo
+	if e.Branch != "" {
+		subtitle += "@" + e.Branch
+	}
+	msg := &chat.Message{
+		Text: notify.GoogleChatText(e),
+		CardsV2: []*chat.CardWithId{{
+			CardId: "approval",
+			Card: &chat.GoogleAppsCardV1Card{
+				Header: &chat.GoogleAppsCardV1CardHeader{Title: e.Title(), Subtitle: subtitle},
+				Sections: []*chat.GoogleAppsCardV1Section{{Widgets: []*chat.GoogleAppsCardV1Widget{
+					{TextParagraph: &chat.GoogleAppsCardV1TextParagraph{Text: strings.Join(lines, "<br>")}},
+					{ButtonList: &chat.GoogleAppsCardV1ButtonList{Buttons: []*chat.GoogleAppsCardV1Button{
+						button("Approve", approveAction),
+						button("Reject", rejectAction),
+					}}},
+				}}},
+			},
+		}},
+	}
+	_, err = svc.Spaces.Messages.Create(c.GoogleChatApprovalSpace, msg).Context(ctx).Do()
+	return err
+}
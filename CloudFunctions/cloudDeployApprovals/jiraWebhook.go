@@ -0,0 +1,208 @@
+package example
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/deployclient"
+	"example.com/shared/failure"
+	"example.com/shared/jira"
+	"google.golang.org/api/iterator"
+)
+
+// The parts of a Jira "jira:issue_updated" webhook we need
+type jiraWebhookPayload struct {
+	WebhookEvent string `json:"webhookEvent"`
+	User         struct {
+		AccountID    string `json:"accountId"`
+		DisplayName  string `json:"displayName"`
+		EmailAddress string `json:"emailAddress"`
+	} `json:"user"`
+	Issue struct {
+		Key string `json:"key"`
+	} `json:"issue"`
+	Changelog struct {
+		Items []struct {
+			Field      string `json:"field"`
+			FromString string `json:"fromString"`
+			ToString   string `json:"toString"`
+		} `json:"items"`
+	} `json:"changelog"`
+}
+
+// statusChange returns the status the issue moved to, or "" if this update
+// didn't touch the status
+func (p *jiraWebhookPayload) statusChange() string {
+	for _, item := range p.Changelog.Items {
+		if item.Field == "status" {
+			return item.ToString
+		}
+	}
+	return ""
+}
+
+// jiraWebhook votes to approve or reject the rollouts waiting on a release
+// when its Jira issue is transitioned into one of the configured statuses,
+// on behalf of whoever moved it.
+func jiraWebhook(w http.ResponseWriter, r *http.Request) {
+	loadConfig()
+	log.Printf("Jira webhook function invoked")
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "error reading body", http.StatusBadRequest)
+		return
+	}
+	if !validJiraSignature(body, r.Header.Get("X-Hub-Signature")) {
+		log.Printf("Rejecting webhook with invalid signature")
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var p jiraWebhookPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	status := p.statusChange()
+	if p.Issue.Key == "" || status == "" {
+		log.Printf("No status change in %s event, ignoring", p.WebhookEvent)
+		return
+	}
+
+	decisions, err := approvalDecisions(c.JiraApprovalStatuses)
+	if err != nil {
+		log.Printf("Bad JIRA_APPROVAL_STATUSES: %v", err)
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	approved, ok := decisions[strings.ToLower(status)]
+	if !ok {
+		log.Printf("Status %q on %s is not an approval decision, ignoring", status, p.Issue.Key)
+		return
+	}
+
+	ctx := r.Context()
+	var link jira.ReleaseLink
+	client := jira.NewClient(c.JiraURL, c.JiraEmail, c.JiraToken)
+	if err := client.GetProperty(ctx, p.Issue.Key, jira.ReleaseProperty, &link); err != nil {
+		if failure.Classify(err) == failure.Transient {
+			// Jira retries webhooks answered with 5xx, so the vote isn't lost
+			log.Printf("Error reading the release link of %s: %v", p.Issue.Key, err)
+			http.Error(w, "error reading issue", http.StatusServiceUnavailable)
+			return
+		}
+		// Not every issue in the project tracks a release
+		log.Printf("Issue %s is not linked to a release: %v", p.Issue.Key, err)
+		return
+	}
+
+	rollouts, err := rolloutsNeedingApproval(ctx, link.Release)
+	if err != nil {
+		log.Printf("Error listing rollouts for %s: %v", link.Release, err)
+		http.Error(w, "error listing rollouts", http.StatusInternalServerError)
+		return
+	}
+	if len(rollouts) == 0 {
+		log.Printf("No rollouts of %s are waiting for approval", link.Release)
+		return
+	}
+	recorded := 0
+	for _, rollout := range rollouts {
+		log.Printf("%s moved %s to %q, voting approve=%t on %s", p.User.DisplayName, p.Issue.Key, status, approved, rollout)
+		_, err := castVote(ctx, Vote{
+			Rollout: rollout,
+			Approver: Approver{
+				ID:    "jira:" + p.User.AccountID,
+				Name:  p.User.DisplayName,
+				Email: p.User.EmailAddress,
+			},
+			Approve: approved,
+			Comment: fmt.Sprintf("Moved %s to %q.", p.Issue.Key, status),
+			At:      time.Now().UTC(),
+		})
+		if failure.Classify(err) == failure.Permanent {
+			// Retrying won't make the vote count
+			log.Printf("Not recording vote on %s: %v", rollout, err)
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to record vote on %s: %v", rollout, err)
+			http.Error(w, "error recording vote", http.StatusInternalServerError)
+			return
+		}
+		recorded++
+	}
+	fmt.Fprintf(w, "recorded %d vote(s)\n", recorded)
+}
+
+// validJiraSignature checks the "sha256=<hex>" HMAC Jira sends in
+// X-Hub-Signature for webhooks registered with a secret.
+func validJiraSignature(body []byte, header string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok || c.JiraWebhookSecret == "" {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(c.JiraWebhookSecret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// approvalDecisions parses "Status=approve,Other Status=reject" into a map of
+// lowercased status name to whether the rollout should be approved.
+func approvalDecisions(s string) (map[string]bool, error) {
+	pairs, err := jira.ParseTransitions(s)
+	if err != nil {
+		return nil, err
+	}
+	decisions := map[string]bool{}
+	for status, decision := range pairs {
+		switch strings.ToLower(decision) {
+		case "approve":
+			decisions[strings.ToLower(status)] = true
+		case "reject":
+			decisions[strings.ToLower(status)] = false
+		default:
+			return nil, fmt.Errorf("decision for %q must be approve or reject, got %q", status, decision)
+		}
+	}
+	return decisions, nil
+}
+
+func rolloutsNeedingApproval(ctx context.Context, release string) ([]string, error) {
+	deployClient, err := deployclient.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Cloud Deploy client: %v", err)
+	}
+	defer deployClient.Close()
+
+	var names []string
+	it := deployClient.ListRollouts(ctx, &deploypb.ListRolloutsRequest{
+		Parent: release,
+		Filter: `approval_state="NEEDS_APPROVAL"`,
+	})
+	for {
+		rollout, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, rollout.Name)
+	}
+	return names, nil
+}
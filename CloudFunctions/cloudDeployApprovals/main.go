@@ -2,14 +2,18 @@ package example
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
-	"cloud.google.com/go/deploy/apiv1/deploypb"
-	"cloud.google.com/go/pubsub"
+	"example.com/shared/events"
+	"example.com/shared/failure"
+	"example.com/shared/history"
+	"example.com/shared/jira"
+	"example.com/shared/once"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/cloudevents/sdk-go/v2/event"
 	"github.com/codingconcepts/env"
@@ -20,29 +24,63 @@ type config struct {
 	ProjectId   string `env:"PROJECTID" required:"true"`
 	Location    string `env:"LOCATION" required:"true"`
 	SendTopicID string `env:"SENDTOPICID" required:"true"`
-}
+	// Events that can never be processed are parked here
+	DeadLetterTopicID string `env:"DEADLETTERTOPICID" required:"true"`
 
-type PubsubMessage struct {
-	// Data is the payload of the message.
-	Data []byte `json:"data"`
+	// Used by jiraWebhook to map issue transitions to approval decisions
+	JiraURL              string `env:"JIRA_URL" required:"true"`
+	JiraEmail            string `env:"JIRA_EMAIL" required:"true"`
+	JiraToken            string `env:"JIRA_API_TOKEN" required:"true"`
+	JiraWebhookSecret    string `env:"JIRA_WEBHOOK_SECRET" required:"true"`
+	JiraApprovalStatuses string `env:"JIRA_APPROVAL_STATUSES" default:"Approved=approve,Rejected=reject"`
 
-	// Attributes are optional attributes that can be used to. For example,
-	// to. For example, to provide metadata, or to. For example, to filter messages.
-	Attributes ApprovalsData `json:"attributes"`
+	// Approval policy as inline JSON, or where to load it from, see Policy
+	ApprovalPolicy        string        `env:"APPROVAL_POLICY"`
+	ApprovalPolicyURI     string        `env:"APPROVAL_POLICY_URI"`
+	ApprovalPolicyRefresh time.Duration `env:"APPROVAL_POLICY_REFRESH" default:"1m"`
+	// Votes needed per target, inline JSON or where to load it from (also
+	// refreshed every APPROVAL_POLICY_REFRESH), see Quorum
+	ApprovalQuorum    string `env:"APPROVAL_QUORUM"`
+	ApprovalQuorumURI string `env:"APPROVAL_QUORUM_URI"`
+	// Where votes are kept, "firestore" or "memory"
+	VoteStore      string `env:"VOTE_STORE" default:"firestore"`
+	VoteCollection string `env:"VOTE_COLLECTION" default:"deploy-approvals"`
 
-	// MessageId is the ID of the message generated by the server.
-	MessageID string `json:"messageId"`
+	// Freeze calendar as inline JSON, or where to load it from, see
+	// freeze.Calendar, and where lifts and deferred approvals are kept
+	FreezeCalendar        string        `env:"FREEZE_CALENDAR"`
+	FreezeCalendarURI     string        `env:"FREEZE_CALENDAR_URI"`
+	FreezeCalendarRefresh time.Duration `env:"FREEZE_CALENDAR_REFRESH" default:"1m"`
+	FreezeStore           string        `env:"FREEZE_STORE" default:"firestore"`
+	FirestoreDatabase     string        `env:"FIRESTORE_DATABASE" default:"(default)"`
+	FreezeCollection      string        `env:"FREEZE_COLLECTION" default:"deploy-freezes"`
 
-	// PublishTime is the time at which the message was published.
-	PublishTime time.Time `json:"publishTime"`
+	// Escalated rollouts get Approve/Reject buttons in Slack, posted to the
+	// incoming webhook of a Slack app whose interactivity URL is
+	// chatApproval, which checks clicks with the app's signing secret
+	SlackApprovalWebhook string `env:"SLACK_APPROVAL_WEBHOOK"`
+	SlackSigningSecret   string `env:"SLACK_SIGNING_SECRET"`
+	// ...and in this Google Chat space ("spaces/..."), posted as the Chat
+	// app whose HTTP endpoint is chatApproval. Chat's tokens are issued
+	// for GOOGLE_CHAT_AUDIENCE, the endpoint URL.
+	GoogleChatApprovalSpace string `env:"GOOGLE_CHAT_APPROVAL_SPACE"`
+	GoogleChatAudience      string `env:"GOOGLE_CHAT_AUDIENCE"`
+	// Slack clicks only say who the user is, so rules forbidding
+	// self-approval look their email up with the Slack app's bot token
+	SlackBotToken string `env:"SLACK_BOT_TOKEN"`
+	SlackAPIURL   string `env:"SLACK_API_URL" default:"https://slack.com/api"`
 
-	// OrderingKey is the ordering key of the message.
-	OrderingKey string `json:"orderingKey"`
-}
+	// Where decisions and votes are recorded for the deployment history,
+	// "firestore", "memory" or "file:<path>"
+	HistoryStore      string `env:"HISTORY_STORE" default:"firestore"`
+	HistoryCollection string `env:"HISTORY_COLLECTION" default:"deploy-history"`
 
-type Message struct {
-	Message PubsubMessage `json:"message"`
+	// Where chat announcements are claimed, so redeliveries don't repeat
+	// them, "firestore" or "memory"
+	OnceStore      string `env:"ONCE_STORE" default:"firestore"`
+	OnceCollection string `env:"ONCE_COLLECTION" default:"deploy-once"`
 }
+
 type ApprovalsData struct {
 	Action         string `json:"Action"`
 	Rollout        string `json:"Rollout"`
@@ -54,82 +92,130 @@ type ApprovalsData struct {
 	ManualApproval string `json:"manualApproval"`
 }
 
-type CommandMessage struct {
-	Commmand       string                         `json:"command"`
-	ApproveRollout deploypb.ApproveRolloutRequest `json:"approveRolloutRequest"`
-}
-
 var c config
 
+// sideEffects claims the chat announcements of a message, so redeliveries
+// don't post them again.
+func sideEffects() once.Config {
+	return once.Config{
+		Store:      c.OnceStore,
+		ProjectID:  c.ProjectId,
+		Database:   c.FirestoreDatabase,
+		Collection: c.OnceCollection,
+	}
+}
+
 func init() {
 	functions.CloudEvent("cloudDeployApprovals", cloudDeployApprovals)
+	functions.HTTP("jiraWebhook", jiraWebhook)
+	functions.HTTP("approvalVote", approvalVote)
+	functions.HTTP("chatApproval", chatApproval)
+}
+
+// loadConfig reads the environment on the first invocation rather than in
+// init, so tests can set it up in TestMain first.
+var loadConfig = sync.OnceFunc(func() {
 	//Load env variables using "github.com/codingconcepts/env"
 	if err := env.Set(&c); err != nil {
-		_ = fmt.Errorf("error getting env: %s", err)
+		log.Fatalf("error getting env: %s", err)
 	}
-}
+	if err := env.Set(&notifications); err != nil {
+		log.Fatalf("error getting env: %s", err)
+	}
+})
 
+// cloudDeployApprovals decides rollouts waiting for approval with the
+// approval policy. An approval or rejection by the policy is one vote,
+// cast as "policy:<rule>", so it's held to the target's quorum like anyone
+// else's; rollouts it escalates, or that need more votes, are left for
+// humans and announced in chat.
 func cloudDeployApprovals(ctx context.Context, e event.Event) error {
+	loadConfig()
 	log.Printf("Deploy Approvals function invoked")
-	var msg Message
-	err := json.Unmarshal(e.Data(), &msg)
+	failures := failure.NewHandler("cloudDeployApprovals", c.ProjectId, c.DeadLetterTopicID)
+	msg, err := events.Decode(e)
 	if err != nil {
-		// Don't return because it's probably a bad message and we need to ack
-		_ = fmt.Errorf("errored unmarshalling data: %v", err)
+		// It's a bad message, so it's dead-lettered and acked
+		return failures.Handle(ctx, e.Data(), failure.NewPermanent(err))
+	}
+	var a ApprovalsData
+	if err := msg.Message.DecodeAttributes(&a); err != nil {
+		return failures.Handle(ctx, msg.Message.Data, failure.NewPermanent(err), "messageId", msg.Message.MessageID)
+	}
+	if a.Action != "Required" || a.Rollout == "" || strings.ToLower(a.ManualApproval) != "true" {
+		// Return nil to ack pubsub message
 		return nil
 	}
-	log.Printf("Waiting 3 seconds to approve for demo")
-	time.Sleep(3 * time.Second)
-	var a = msg.Message.Attributes
-	log.Printf("A is: %v", a)
-	if a.Action == "Required" && a.Rollout != "" && strings.ToLower(a.ManualApproval) == "true" {
-		// Create the rollout
-		log.Printf("Creating Rollout and sending to pubsub")
-		var command = CommandMessage{
-			Commmand: "ApproveRollout",
-			ApproveRollout: deploypb.ApproveRolloutRequest{
-				Name:     a.Rollout,
-				Approved: true,
-			},
+	attrs := []any{"messageId", msg.Message.MessageID, "rollout", a.Rollout}
+
+	policy, err := currentPolicy(ctx)
+	if err != nil {
+		return failures.Handle(ctx, msg.Message.Data, err, attrs...)
+	}
+	facts, issueKey, err := gatherFacts(ctx, a)
+	if err != nil {
+		return failures.Handle(ctx, msg.Message.Data, err, attrs...)
+	}
+	v := policy.Evaluate(facts)
+	log.Printf("Policy decided %s for %s: rule %q, %s", v.Decision, a.Rollout, v.Rule, v.Reason)
+
+	issuer := "policy:" + v.Rule
+	if v.Rule == "" {
+		issuer = "policy:default"
+	}
+	if v.Decision != Escalate {
+		// Redeliveries cast the same vote at the same time, which replaces
+		// the first
+		at := msg.Message.PublishTime
+		if at.IsZero() {
+			at = facts.Now
+		}
+		t, err := castVote(ctx, Vote{
+			Rollout:  a.Rollout,
+			Approver: Approver{ID: issuer, Name: "approval policy"},
+			Approve:  v.Decision == Approve,
+			Comment:  v.Reason,
+			At:       at.UTC(),
+		})
+		if errors.Is(err, errNotPending) {
+			log.Printf("Not voting on %s: %v", a.Rollout, err)
+			return nil
 		}
-		err = sendCommandPubSub(ctx, &command)
 		if err != nil {
-			_ = fmt.Errorf("failed to send pubsub command: %v", err)
-			// Let's return nil to ack the bad message. Otherwise the function will rerun and fail.
+			return failures.Handle(ctx, msg.Message.Data, err, attrs...)
+		}
+		if t.Decision != "" {
+			// castVote told the issue and the history
 			return nil
 		}
-		log.Printf("Deployment triggered successfully")
+		v = Verdict{
+			Decision: Escalate,
+			Rule:     v.Rule,
+			Reason:   fmt.Sprintf("%s, voted %s (%d of %d approvals)", v.Reason, v.Decision, len(t.Approvers), t.Needed),
+		}
+	} else {
+		if issueKey != "" {
+			if err := commentVerdict(ctx, issueKey, a, v); err != nil {
+				log.Printf("Failed to comment on %s: %v", issueKey, err)
+			}
+		}
+		recordApproval(ctx, history.Approval, msg.Message.MessageID, a.Rollout, a.TargetId, issuer, v.Decision, v.Reason)
+	}
+	err = sideEffects().Do(ctx, "announce", a.Rollout+"/"+msg.Message.MessageID, func() error { return announceApproval(ctx, a, v) })
+	if err != nil {
+		log.Printf("Failed to notify: %v", err)
 	}
 	// Return nil to ack pubsub message
 	return nil
 }
 
-// This should be in a shared code folder
-func sendCommandPubSub(ctx context.Context, m *CommandMessage) error {
-	client, err := pubsub.NewClient(ctx, c.ProjectId)
-	if err != nil {
-		return fmt.Errorf("pubsub.NewClient: %v", err)
+// commentVerdict tells the release's issue the policy escalated a rollout,
+// so it shows up where humans approve it.
+func commentVerdict(ctx context.Context, key string, a ApprovalsData, v Verdict) error {
+	rule := "the default"
+	if v.Rule != "" {
+		rule = fmt.Sprintf("rule %q", v.Rule)
 	}
-	defer client.Close()
-	t := client.Topic(c.SendTopicID)
-	// Marshal the CommandMessage into a JSON byte slice
-	jsonData, err := json.Marshal(m)
-	if err != nil {
-		return fmt.Errorf("json.Marshal: %v", err)
-	}
-	log.Printf("Sending message to PubSub")
-	result := t.Publish(ctx, &pubsub.Message{
-		Data: jsonData, // Use the JSON byte slice here
-	})
-	// Block until the result is returned and a server-generated
-	// ID is returned for the published message.
-	id, err := result.Get(ctx)
-	log.Printf("ID: %s, err: %v", id, err)
-	if err != nil {
-		fmt.Printf("Get: %v", err)
-		return nil
-
-	}
-	log.Printf("Published a message; msg ID: %v\n", id)
-	return nil
+	text := fmt.Sprintf("Rollout %s to %s needs a human approval (approval policy %s: %s).", a.RolloutId, a.TargetId, rule, v.Reason)
+	return jira.NewClient(c.JiraURL, c.JiraEmail, c.JiraToken).AddComment(ctx, key, text)
 }
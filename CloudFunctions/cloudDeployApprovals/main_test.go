@@ -0,0 +1,163 @@
+package example
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/command"
+	"example.com/shared/deployclient"
+	"example.com/shared/events"
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// TestMain sets the required environment before the config is loaded;
+// tests set anything else they need on c.
+func TestMain(m *testing.M) {
+	for k, v := range map[string]string{
+		"PROJECTID":           "p",
+		"LOCATION":            "l",
+		"SENDTOPICID":         "commands",
+		"DEADLETTERTOPICID":   "dead-letter",
+		"JIRA_URL":            "http://jira.invalid/",
+		"JIRA_EMAIL":          "bot@example.com",
+		"JIRA_API_TOKEN":      "token",
+		"JIRA_WEBHOOK_SECRET": "secret",
+	} {
+		os.Setenv(k, v)
+	}
+	loadConfig()
+	os.Exit(m.Run())
+}
+
+// approvalEvent is the notification Cloud Deploy sends when rollout needs
+// approval.
+func approvalEvent(t *testing.T, id, rollout, target string) event.Event {
+	t.Helper()
+	e := event.New()
+	e.SetID(id)
+	e.SetType("google.cloud.pubsub.topic.v1.messagePublished")
+	e.SetSource("//pubsub.googleapis.com/projects/p/topics/clouddeploy-approvals")
+	err := e.SetData(event.ApplicationJSON, events.MessagePublishedData{Message: events.PubsubMessage{
+		MessageID:   id,
+		PublishTime: time.Now().UTC(),
+		Attributes: map[string]string{
+			"Action":         "Required",
+			"Rollout":        rollout,
+			"TargetId":       target,
+			"manualApproval": "true",
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestCloudDeployApprovals(t *testing.T) {
+	f := newFakes(t)
+	c.ApprovalPolicy = `{"rules": [{"name": "main", "match": {"labels": {"branch": "main"}}, "decision": "approve"},
+		{"name": "experiments", "match": {"labels": {"branch": "exp-*"}}, "decision": "reject"}]}`
+	c.ApprovalQuorum = `{"rules": [{"name": "prod", "targets": ["prod"], "approvals": 2}]}`
+	ctx := context.Background()
+	branch := func(name string) *deploypb.Release {
+		return &deploypb.Release{Labels: map[string]string{"branch": name}}
+	}
+
+	for _, tt := range []struct {
+		name    string
+		target  string
+		release *deploypb.Release
+		// issuer of the ApproveRollout sent, none when empty
+		issuer   string
+		approved bool
+		// votes on the rollout's ballot
+		votes int
+	}{
+		{name: "policy approval decides when one vote will do", target: "dev", release: branch("main"), issuer: "quorum:policy:main", approved: true, votes: 1},
+		{name: "policy approval is one of the votes prod needs", target: "prod", release: branch("main"), votes: 1},
+		{name: "policy rejection", target: "prod", release: branch("exp-1"), issuer: "quorum:policy:experiments", votes: 1},
+		{name: "escalated", target: "prod", release: branch("feature")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f.pubsub.ClearMessages()
+			rollout := f.pendingRollout(t, tt.target, tt.release)
+			if err := cloudDeployApprovals(ctx, approvalEvent(t, "m-"+rollout, rollout, tt.target)); err != nil {
+				t.Fatalf("cloudDeployApprovals: %v", err)
+			}
+			cmds := f.commands(t)
+			switch {
+			case tt.issuer == "" && len(cmds) != 0:
+				t.Errorf("sent %+v, want nothing", cmds)
+			case tt.issuer != "" && (len(cmds) != 1 || cmds[0].Issuer != tt.issuer):
+				t.Errorf("sent %+v, want one ApproveRollout by %s", cmds, tt.issuer)
+			case tt.issuer != "":
+				_, req, _ := command.Parse(f.pubsub.Messages()[0].Data)
+				if got := req.(*deploypb.ApproveRolloutRequest).Approved; got != tt.approved {
+					t.Errorf("ApproveRollout approved = %t, want %t", got, tt.approved)
+				}
+			}
+			votes := 0
+			if b, _ := memVotes.Ballot(ctx, rollout); b != nil {
+				votes = len(b.Votes)
+			}
+			if votes != tt.votes {
+				t.Errorf("ballot has %d vote(s), want %d", votes, tt.votes)
+			}
+		})
+	}
+
+	// Redeliveries once the rollout was decided are acked
+	rollout := f.pendingRollout(t, "dev", branch("main"))
+	d, err := deployclient.New(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if _, err := d.ApproveRollout(ctx, &deploypb.ApproveRolloutRequest{Name: rollout, Approved: true}); err != nil {
+		t.Fatal(err)
+	}
+	f.pubsub.ClearMessages()
+	if err := cloudDeployApprovals(ctx, approvalEvent(t, "late", rollout, "dev")); err != nil {
+		t.Errorf("cloudDeployApprovals of a decided rollout = %v, want it acked", err)
+	}
+	if cmds := f.commands(t); len(cmds) != 0 {
+		t.Errorf("sent %+v for a decided rollout", cmds)
+	}
+}
+
+func TestCloudDeployApprovalsAnnouncesOnce(t *testing.T) {
+	f := newFakes(t)
+	var posts atomic.Int32
+	chat := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+	}))
+	t.Cleanup(chat.Close)
+	saved := notifications
+	t.Cleanup(func() { notifications = saved })
+	notifications.Inline = `{"routes": [{"name": "approvals", "events": ["approval-required"], "slack": "` + chat.URL + `"}]}`
+	ctx := context.Background()
+
+	rollout := f.pendingRollout(t, "prod", &deploypb.Release{})
+	for range 2 {
+		if err := cloudDeployApprovals(ctx, approvalEvent(t, "m-announce", rollout, "prod")); err != nil {
+			t.Fatalf("cloudDeployApprovals: %v", err)
+		}
+	}
+	if got := posts.Load(); got != 1 {
+		t.Errorf("posted %d announcement(s) for a redelivered message, want 1", got)
+	}
+
+	// Another notification for the rollout is announced again
+	if err := cloudDeployApprovals(ctx, approvalEvent(t, "m-announce-again", rollout, "prod")); err != nil {
+		t.Fatalf("cloudDeployApprovals: %v", err)
+	}
+	if got := posts.Load(); got != 2 {
+		t.Errorf("posted %d announcement(s) after a new message, want 2", got)
+	}
+}
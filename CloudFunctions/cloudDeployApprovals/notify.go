@@ -0,0 +1,77 @@
+package example
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/deployclient"
+	"example.com/shared/jira"
+	"example.com/shared/notify"
+)
+
+// notifications says where the notification routes are.
+var notifications notify.Config
+
+// announceApproval tells chat a rollout the policy escalated is waiting
+// for votes, and how many it needs: the routed channels get a notification
+// and, when they're set up, the Slack app and Google Chat space get one
+// with Approve/Reject buttons.
+func announceApproval(ctx context.Context, a ApprovalsData, v Verdict) error {
+	routes, err := notifications.Routes(ctx)
+	if err != nil {
+		return err
+	}
+	if len(routes.Routes) == 0 && !interactiveApprovals() {
+		return nil
+	}
+	releaseName, _, _ := strings.Cut(a.Rollout, "/rollouts/")
+	deployClient, err := deployclient.New(ctx)
+	if err != nil {
+		return fmt.Errorf("error creating Cloud Deploy client: %w", err)
+	}
+	defer deployClient.Close()
+	release, err := deployClient.GetRelease(ctx, &deploypb.GetReleaseRequest{Name: releaseName})
+	if err != nil {
+		return fmt.Errorf("error getting release: %w", err)
+	}
+
+	needed := 1
+	if q, err := currentQuorum(ctx); err == nil {
+		needed = max(q.RuleFor(a.TargetId).Approvals, 1)
+	}
+	rule := "the default"
+	if v.Rule != "" {
+		rule = fmt.Sprintf("rule %q", v.Rule)
+	}
+	e := notify.Event{
+		Kind:     notify.ApprovalRequired,
+		Project:  c.ProjectId,
+		Location: a.Location,
+		Pipeline: resourceID(releaseName, "deliveryPipelines"),
+		Release:  a.ReleaseId,
+		Target:   a.TargetId,
+		Rollout:  a.RolloutId,
+		IssueKey: release.Annotations[jira.IssueKeyAnnotation],
+		Detail:   fmt.Sprintf("Needs %d approval(s), approval policy %s: %s.", needed, rule, v.Reason),
+	}
+	e.AddProvenance(release.Annotations)
+	if e.IssueKey != "" {
+		e.IssueURL = jira.IssueURL(c.JiraURL, e.IssueKey)
+	}
+	return errors.Join(notifications.Send(ctx, e), requestApproval(ctx, e, a.Rollout))
+}
+
+// resourceID returns the ID following collection in a resource name, e.g.
+// the pipeline of projects/p/locations/l/deliveryPipelines/d/releases/r.
+func resourceID(name, collection string) string {
+	parts := strings.Split(name, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == collection {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,278 @@
+package example
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"example.com/shared/notify"
+	// Function runtimes don't promise a zoneinfo database
+	_ "time/tzdata"
+)
+
+// Decision is what the approval policy does with a rollout.
+type Decision string
+
+const (
+	Approve Decision = "approve"
+	Reject  Decision = "reject"
+	// Escalate leaves the rollout for a human, e.g. through the Jira issue
+	Escalate Decision = "escalate"
+)
+
+// Policy decides rollout approvals. Rules are tried in order and the first
+// whose Match selects the rollout decides it, e.g.
+//
+//	{
+//	  "rules": [
+//	    {"name": "dev", "match": {"targets": ["dev"]}, "decision": "approve"},
+//	    {"name": "prod in hours",
+//	     "match": {"targets": ["prod"]},
+//	     "conditions": {
+//	       "windows": [{"days": ["Mon", "Tue", "Wed", "Thu"], "start": "09:00", "end": "16:00", "timeZone": "Europe/London"}],
+//	       "jiraStatuses": ["Approved"],
+//	       "labels": {"branch": "main"},
+//	       "minSuccessfulRollouts": 1
+//	     },
+//	     "decision": "approve", "otherwise": "escalate"}
+//	  ],
+//	  "default": "escalate"
+//	}
+type Policy struct {
+	Rules []Rule `json:"rules"`
+	// Default applies when no rule matches, escalate when empty
+	Default Decision `json:"default,omitempty"`
+}
+
+// Rule applies Decision to the rollouts it matches when all its conditions
+// hold, and Otherwise (escalate when empty) when any doesn't.
+type Rule struct {
+	Name       string     `json:"name"`
+	Match      Match      `json:"match"`
+	Conditions Conditions `json:"conditions"`
+	Decision   Decision   `json:"decision"`
+	Otherwise  Decision   `json:"otherwise,omitempty"`
+}
+
+// Match selects rollouts by target and release labels. Values are
+// path.Match patterns and empty fields match everything.
+type Match struct {
+	Targets []string          `json:"targets,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// Conditions that must all hold for a rule's Decision. Unset ones always hold.
+type Conditions struct {
+	// Windows the rollout may be approved in, any of them will do
+	Windows []Window `json:"windows,omitempty"`
+	// JiraStatuses the release's issue must be in, case-insensitively
+	JiraStatuses []string `json:"jiraStatuses,omitempty"`
+	// Labels the release must have, values are path.Match patterns
+	Labels map[string]string `json:"labels,omitempty"`
+	// MinSuccessfulRollouts the release must already have, e.g. 1 to
+	// require it to have gone through an earlier stage
+	MinSuccessfulRollouts int `json:"minSuccessfulRollouts,omitempty"`
+}
+
+// Window is a daily time range, on some days of the week. End before Start
+// wraps past midnight.
+type Window struct {
+	// Days as Mon, Tue..., every day when empty
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	// TimeZone is an IANA name, UTC when empty
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// Facts describe the rollout waiting for approval.
+type Facts struct {
+	Target string
+	// Labels of the release
+	Labels map[string]string
+	// JiraStatus of the release's issue, empty when it has none
+	JiraStatus string
+	// SuccessfulRollouts the release has had so far
+	SuccessfulRollouts int
+	Now                time.Time
+}
+
+// Verdict is a Decision and why it was made.
+type Verdict struct {
+	Decision Decision
+	// Rule that decided, empty for the policy default
+	Rule   string
+	Reason string
+}
+
+// Evaluate decides what to do with the rollout described by f. It doesn't
+// do any I/O, so policies can be checked against made up Facts.
+func (p *Policy) Evaluate(f Facts) Verdict {
+	for _, r := range p.Rules {
+		if !r.Match.matches(f) {
+			continue
+		}
+		failed := r.Conditions.failed(f)
+		if len(failed) == 0 {
+			return Verdict{Decision: r.Decision, Rule: r.Name, Reason: "all conditions hold"}
+		}
+		return Verdict{Decision: orEscalate(r.Otherwise), Rule: r.Name, Reason: strings.Join(failed, "; ")}
+	}
+	return Verdict{Decision: orEscalate(p.Default), Reason: "no rule matches"}
+}
+
+// Validate checks the policy can be evaluated, so a bad file is refused
+// when it's loaded rather than when a rollout needs it.
+func (p *Policy) Validate() error {
+	if err := validDecision(orEscalate(p.Default)); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for i, r := range p.Rules {
+		if err := validDecision(r.Decision); err != nil {
+			return fmt.Errorf("rule %d (%s): %w", i, r.Name, err)
+		}
+		if err := validDecision(orEscalate(r.Otherwise)); err != nil {
+			return fmt.Errorf("rule %d (%s) otherwise: %w", i, r.Name, err)
+		}
+		for _, w := range r.Conditions.Windows {
+			if _, err := w.contains(time.Time{}); err != nil {
+				return fmt.Errorf("rule %d (%s): %w", i, r.Name, err)
+			}
+		}
+		patterns := append([]string{}, r.Match.Targets...)
+		for _, m := range []map[string]string{r.Match.Labels, r.Conditions.Labels} {
+			for _, v := range m {
+				patterns = append(patterns, v)
+			}
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %d (%s): bad pattern %q: %w", i, r.Name, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validDecision(d Decision) error {
+	switch d {
+	case Approve, Reject, Escalate:
+		return nil
+	}
+	return fmt.Errorf("decision must be approve, reject or escalate, got %q", d)
+}
+
+func orEscalate(d Decision) Decision {
+	if d == "" {
+		return Escalate
+	}
+	return d
+}
+
+func (m *Match) matches(f Facts) bool {
+	if len(m.Targets) > 0 && !notify.MatchAny(m.Targets, f.Target) {
+		return false
+	}
+	return labelsMatch(m.Labels, f.Labels)
+}
+
+// failed describes every condition that doesn't hold.
+func (c *Conditions) failed(f Facts) []string {
+	var failed []string
+	if len(c.Windows) > 0 {
+		in := false
+		for _, w := range c.Windows {
+			if ok, _ := w.contains(f.Now); ok {
+				in = true
+				break
+			}
+		}
+		if !in {
+			failed = append(failed, fmt.Sprintf("%s is outside the approval windows", f.Now.Format(time.RFC3339)))
+		}
+	}
+	if len(c.JiraStatuses) > 0 {
+		ok := false
+		for _, s := range c.JiraStatuses {
+			if f.JiraStatus != "" && strings.EqualFold(s, f.JiraStatus) {
+				ok = true
+			}
+		}
+		if !ok {
+			failed = append(failed, fmt.Sprintf("Jira status %q is not one of %v", f.JiraStatus, c.JiraStatuses))
+		}
+	}
+	if !labelsMatch(c.Labels, f.Labels) {
+		failed = append(failed, fmt.Sprintf("release labels %v don't match %v", f.Labels, c.Labels))
+	}
+	if f.SuccessfulRollouts < c.MinSuccessfulRollouts {
+		failed = append(failed, fmt.Sprintf("%d successful rollout(s), %d required", f.SuccessfulRollouts, c.MinSuccessfulRollouts))
+	}
+	return failed
+}
+
+func labelsMatch(want, have map[string]string) bool {
+	for k, pattern := range want {
+		v, ok := have[k]
+		if !ok || !notify.MatchAny([]string{pattern}, v) {
+			return false
+		}
+	}
+	return true
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// contains reports whether t falls in the window. A window that wraps past
+// midnight belongs to the day it starts on.
+func (w *Window) contains(t time.Time) (bool, error) {
+	loc := time.UTC
+	if w.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(w.TimeZone); err != nil {
+			return false, fmt.Errorf("window time zone: %w", err)
+		}
+	}
+	start, err := minuteOfDay(w.Start)
+	if err != nil {
+		return false, err
+	}
+	end, err := minuteOfDay(w.End)
+	if err != nil {
+		return false, err
+	}
+	days := map[time.Weekday]bool{}
+	for _, d := range w.Days {
+		wd, ok := weekdays[strings.ToLower(d)[:min(3, len(d))]]
+		if !ok {
+			return false, fmt.Errorf("unknown day %q", d)
+		}
+		days[wd] = true
+	}
+
+	t = t.In(loc)
+	now := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if end <= start && now < end {
+		// In the part of yesterday's window after midnight
+		day = (day + 6) % 7
+	} else if end > start && (now < start || now >= end) {
+		return false, nil
+	} else if end <= start && now < start {
+		return false, nil
+	}
+	return len(days) == 0 || days[day], nil
+}
+
+// minuteOfDay parses "15:04".
+func minuteOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("window time %q must be HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,97 @@
+package example
+
+import (
+	"testing"
+	"time"
+
+	"example.com/shared/failure"
+)
+
+func TestEvaluate(t *testing.T) {
+	policy, err := parsePolicy([]byte(`{
+		"rules": [
+			{"name": "dev", "match": {"targets": ["dev*"]}, "decision": "approve"},
+			{"name": "hotfix", "match": {"targets": ["prod"], "labels": {"branch": "hotfix-*"}}, "decision": "reject"},
+			{"name": "prod in hours", "match": {"targets": ["prod"]},
+			 "conditions": {
+			   "windows": [{"days": ["Mon", "Tue", "Wed", "Thu"], "start": "09:00", "end": "16:00", "timeZone": "Europe/London"}],
+			   "jiraStatuses": ["Approved"],
+			   "labels": {"branch": "main"},
+			   "minSuccessfulRollouts": 1
+			 },
+			 "decision": "approve"},
+			{"name": "night", "match": {"targets": ["staging"]},
+			 "conditions": {"windows": [{"days": ["Fri"], "start": "22:00", "end": "02:00"}]},
+			 "decision": "approve", "otherwise": "reject"}
+		],
+		"default": "escalate"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A Tuesday, 10:00 in London
+	tuesday := time.Date(2026, 6, 2, 9, 0, 0, 0, time.UTC)
+	prod := Facts{Target: "prod", Labels: map[string]string{"branch": "main"}, JiraStatus: "approved", SuccessfulRollouts: 1, Now: tuesday}
+	with := func(change func(*Facts)) Facts {
+		f := prod
+		change(&f)
+		return f
+	}
+
+	for _, tt := range []struct {
+		name     string
+		facts    Facts
+		decision Decision
+		rule     string
+	}{
+		{"target pattern", Facts{Target: "dev-eu", Now: tuesday}, Approve, "dev"},
+		{"match on labels", with(func(f *Facts) { f.Labels = map[string]string{"branch": "hotfix-1"} }), Reject, "hotfix"},
+		{"all conditions hold", prod, Approve, "prod in hours"},
+		{"outside the window", with(func(f *Facts) { f.Now = tuesday.Add(8 * time.Hour) }), Escalate, "prod in hours"},
+		{"wrong day", with(func(f *Facts) { f.Now = tuesday.AddDate(0, 0, 4) }), Escalate, "prod in hours"},
+		{"wrong Jira status", with(func(f *Facts) { f.JiraStatus = "In Progress" }), Escalate, "prod in hours"},
+		{"no issue", with(func(f *Facts) { f.JiraStatus = "" }), Escalate, "prod in hours"},
+		{"label missing", with(func(f *Facts) { f.Labels = nil }), Escalate, "prod in hours"},
+		{"not through dev", with(func(f *Facts) { f.SuccessfulRollouts = 0 }), Escalate, "prod in hours"},
+		{"window starting Friday, on Saturday night", Facts{Target: "staging", Now: time.Date(2026, 6, 6, 1, 0, 0, 0, time.UTC)}, Approve, "night"},
+		{"window starting Friday, on Sunday night", Facts{Target: "staging", Now: time.Date(2026, 6, 7, 1, 0, 0, 0, time.UTC)}, Reject, "night"},
+		{"window starting Friday, on Friday night", Facts{Target: "staging", Now: time.Date(2026, 6, 5, 23, 0, 0, 0, time.UTC)}, Approve, "night"},
+		{"no rule", Facts{Target: "qa", Now: tuesday}, Escalate, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			v := policy.Evaluate(tt.facts)
+			if v.Decision != tt.decision || v.Rule != tt.rule {
+				t.Errorf("Evaluate = %+v, want %s by rule %q", v, tt.decision, tt.rule)
+			}
+		})
+	}
+
+	if v := (&Policy{}).Evaluate(prod); v.Decision != Escalate {
+		t.Errorf("empty policy decided %s, want escalate", v.Decision)
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	for name, policy := range map[string]string{
+		"not JSON":          `{"rules": [`,
+		"misspelt field":    `{"rules": [{"name": "dev", "condition": {}, "decision": "approve"}]}`,
+		"bad decision":      `{"rules": [{"name": "dev", "decision": "yes"}]}`,
+		"bad otherwise":     `{"rules": [{"name": "dev", "decision": "approve", "otherwise": "maybe"}]}`,
+		"bad default":       `{"default": "approve-all"}`,
+		"bad window time":   `{"rules": [{"name": "dev", "conditions": {"windows": [{"start": "9am", "end": "17:00"}]}, "decision": "approve"}]}`,
+		"bad day":           `{"rules": [{"name": "dev", "conditions": {"windows": [{"days": ["Funday"], "start": "09:00", "end": "17:00"}]}, "decision": "approve"}]}`,
+		"bad time zone":     `{"rules": [{"name": "dev", "conditions": {"windows": [{"start": "09:00", "end": "17:00", "timeZone": "Mars/Olympus"}]}, "decision": "approve"}]}`,
+		"bad target":        `{"rules": [{"name": "dev", "match": {"targets": ["["]}, "decision": "approve"}]}`,
+		"bad label pattern": `{"rules": [{"name": "dev", "conditions": {"labels": {"branch": "["}}, "decision": "approve"}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parsePolicy([]byte(policy))
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if failure.Classify(err) != failure.Permanent {
+				t.Errorf("%v is transient, want permanent", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package example
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"example.com/shared/configfile"
+	"example.com/shared/failure"
+)
+
+// policyCache keeps the loaded policy across invocations of a warm
+// instance, checking APPROVAL_POLICY_URI for a new version at most every
+// APPROVAL_POLICY_REFRESH so policy changes apply without a redeploy.
+var policyCache = &configfile.Cache[*Policy]{Name: "approval policy", Parse: parsePolicy}
+
+// currentPolicy returns the approval policy from APPROVAL_POLICY (inline
+// JSON) or APPROVAL_POLICY_URI (gs://bucket/object or a local file). With
+// neither, every rollout is escalated.
+func currentPolicy(ctx context.Context) (*Policy, error) {
+	if c.ApprovalPolicy != "" {
+		return parsePolicy([]byte(c.ApprovalPolicy))
+	}
+	if c.ApprovalPolicyURI == "" {
+		return &Policy{Default: Escalate}, nil
+	}
+	return policyCache.Get(ctx, c.ApprovalPolicyURI, c.ApprovalPolicyRefresh)
+}
+
+func parsePolicy(data []byte) (*Policy, error) {
+	var p Policy
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// A misspelt condition would otherwise silently always hold
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("error parsing approval policy: %w", err))
+	}
+	if err := p.Validate(); err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("invalid approval policy: %w", err))
+	}
+	return &p, nil
+}
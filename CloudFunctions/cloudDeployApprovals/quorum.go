@@ -0,0 +1,177 @@
+package example
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"example.com/shared/notify"
+)
+
+// Approver identifies whoever cast a vote. ID says where they voted from,
+// e.g. "jira:5b10ac8d82e05b22cc7d4ef5" or "google:alice@example.com".
+type Approver struct {
+	ID    string `firestore:"id" json:"id"`
+	Name  string `firestore:"name,omitempty" json:"name,omitempty"`
+	Email string `firestore:"email,omitempty" json:"email,omitempty"`
+}
+
+func (a Approver) String() string {
+	if a.Name != "" {
+		return fmt.Sprintf("%s (%s)", a.Name, a.ID)
+	}
+	return a.ID
+}
+
+// Vote is one approver's decision on a rollout waiting for approval.
+type Vote struct {
+	Rollout  string    `firestore:"rollout" json:"rollout"`
+	Target   string    `firestore:"target" json:"target"`
+	Approver Approver  `firestore:"approver" json:"approver"`
+	Approve  bool      `firestore:"approve" json:"approve"`
+	Comment  string    `firestore:"comment,omitempty" json:"comment,omitempty"`
+	At       time.Time `firestore:"at" json:"at"`
+}
+
+// Quorum says how many votes decide a rollout. The first rule matching the
+// rollout's target applies, Default when none does, e.g.
+//
+//	{
+//	  "rules": [
+//	    {"name": "prod", "targets": ["prod*"], "approvals": 2,
+//	     "forbidSelfApproval": true, "approvers": ["jira:*", "*@example.com"]}
+//	  ],
+//	  "default": {"approvals": 1}
+//	}
+type Quorum struct {
+	Rules   []QuorumRule `json:"rules"`
+	Default QuorumRule   `json:"default"`
+}
+
+// QuorumRule is the quorum for some targets.
+type QuorumRule struct {
+	Name string `json:"name,omitempty"`
+	// Targets as path.Match patterns
+	Targets []string `json:"targets,omitempty"`
+	// Approvals needed to approve, 1 when unset
+	Approvals int `json:"approvals,omitempty"`
+	// Rejections that reject, 1 when unset
+	Rejections int `json:"rejections,omitempty"`
+	// ForbidSelfApproval ignores approvals by the release's author, and by
+	// people whose email can't be found to tell
+	ForbidSelfApproval bool `json:"forbidSelfApproval,omitempty"`
+	// Approvers whose votes count, path.Match patterns over their ID or
+	// email; anyone's when empty
+	Approvers []string `json:"approvers,omitempty"`
+}
+
+// Tally is where the votes on a rollout stand.
+type Tally struct {
+	// Decision is Approve or Reject once the quorum is met, empty before
+	Decision  Decision
+	Approvers []Approver
+	Rejecters []Approver
+	// Needed approvals
+	Needed int
+	// Ignored votes and why
+	Ignored []string
+}
+
+// RuleFor returns the rule applying to target.
+func (q *Quorum) RuleFor(target string) QuorumRule {
+	for _, r := range q.Rules {
+		if notify.MatchAny(r.Targets, target) {
+			return r
+		}
+	}
+	return q.Default
+}
+
+// Validate checks the quorum can be evaluated, so a bad file is refused
+// when it's loaded rather than when someone votes.
+func (q *Quorum) Validate() error {
+	for i, r := range append([]QuorumRule{q.Default}, q.Rules...) {
+		name := "default"
+		if i > 0 {
+			name = fmt.Sprintf("rule %d (%s)", i-1, r.Name)
+			if len(r.Targets) == 0 {
+				return fmt.Errorf("%s has no targets", name)
+			}
+		}
+		if r.Approvals < 0 || r.Rejections < 0 {
+			return fmt.Errorf("%s: approvals and rejections can't be negative", name)
+		}
+		for _, pattern := range append(append([]string{}, r.Targets...), r.Approvers...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("%s: bad pattern %q: %w", name, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Tally counts votes, the latest per approver, against the rule. author is
+// the release's author, empty if unknown. It doesn't do any I/O.
+func (r QuorumRule) Tally(votes []Vote, author string) Tally {
+	t := Tally{Needed: max(r.Approvals, 1)}
+	latest := map[string]Vote{}
+	for _, v := range votes {
+		if prev, ok := latest[v.Approver.ID]; !ok || !v.At.Before(prev.At) {
+			latest[v.Approver.ID] = v
+		}
+	}
+	ids := make([]string, 0, len(latest))
+	for id := range latest {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		v := latest[id]
+		switch {
+		case !r.Allows(v.Approver):
+			t.Ignored = append(t.Ignored, fmt.Sprintf("%s is not an approver for %s", v.Approver, v.Target))
+		case v.Approve && r.ForbidSelfApproval && isAuthor(v.Approver, author):
+			t.Ignored = append(t.Ignored, fmt.Sprintf("%s authored the release and can't approve it", v.Approver))
+		case v.Approve && r.ForbidSelfApproval && v.Approver.Email == "" && !isPolicy(v.Approver):
+			t.Ignored = append(t.Ignored, fmt.Sprintf("%s has no email to tell whether they authored the release", v.Approver))
+		case v.Approve:
+			t.Approvers = append(t.Approvers, v.Approver)
+		default:
+			t.Rejecters = append(t.Rejecters, v.Approver)
+		}
+	}
+	switch {
+	case len(t.Rejecters) >= max(r.Rejections, 1):
+		t.Decision = Reject
+	case len(t.Approvers) >= t.Needed:
+		t.Decision = Approve
+	}
+	return t
+}
+
+// Allows says whether a's votes count under the rule.
+func (r QuorumRule) Allows(a Approver) bool {
+	return len(r.Approvers) == 0 || notify.MatchAny(r.Approvers, a.ID) || (a.Email != "" && notify.MatchAny(r.Approvers, a.Email))
+}
+
+// isPolicy says whether a is the approval policy rather than a person.
+func isPolicy(a Approver) bool {
+	return strings.HasPrefix(a.ID, "policy:")
+}
+
+// isAuthor matches an approver against the author annotation, which may be
+// an approver ID, an email or the ID without its source prefix.
+func isAuthor(a Approver, author string) bool {
+	if author == "" {
+		return false
+	}
+	_, bare, _ := strings.Cut(a.ID, ":")
+	for _, id := range []string{a.ID, a.Email, bare} {
+		if id != "" && strings.EqualFold(id, author) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package example
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"example.com/shared/failure"
+)
+
+func TestRuleFor(t *testing.T) {
+	q, err := parseQuorum([]byte(`{
+		"rules": [{"name": "prod", "targets": ["prod*"], "approvals": 2}],
+		"default": {"approvals": 1}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := q.RuleFor("prod-eu"); r.Name != "prod" {
+		t.Errorf("RuleFor(prod-eu) = %+v, want the prod rule", r)
+	}
+	if r := q.RuleFor("dev"); r.Name != "" || r.Approvals != 1 {
+		t.Errorf("RuleFor(dev) = %+v, want the default", r)
+	}
+}
+
+func TestTally(t *testing.T) {
+	at := time.Date(2026, 6, 2, 9, 0, 0, 0, time.UTC)
+	v := func(id, email string, approve bool, minutes int) Vote {
+		return Vote{Target: "prod", Approver: Approver{ID: id, Email: email}, Approve: approve, At: at.Add(time.Duration(minutes) * time.Minute)}
+	}
+	ids := func(approvers []Approver) []string {
+		var out []string
+		for _, a := range approvers {
+			out = append(out, a.ID)
+		}
+		return out
+	}
+
+	for _, tt := range []struct {
+		name      string
+		rule      QuorumRule
+		votes     []Vote
+		author    string
+		decision  Decision
+		approvers []string
+		rejecters []string
+		ignored   int
+	}{
+		{
+			name:      "one vote decides by default",
+			votes:     []Vote{v("jira:bob", "", true, 0)},
+			decision:  Approve,
+			approvers: []string{"jira:bob"},
+		},
+		{
+			name:      "short of the quorum",
+			rule:      QuorumRule{Approvals: 2},
+			votes:     []Vote{v("jira:bob", "", true, 0)},
+			approvers: []string{"jira:bob"},
+		},
+		{
+			name:      "latest vote of each approver counts",
+			rule:      QuorumRule{Approvals: 2},
+			votes:     []Vote{v("jira:bob", "", true, 0), v("jira:carol", "", false, 1), v("jira:carol", "", true, 2)},
+			decision:  Approve,
+			approvers: []string{"jira:bob", "jira:carol"},
+		},
+		{
+			name:      "changing to a rejection",
+			rule:      QuorumRule{Approvals: 2},
+			votes:     []Vote{v("jira:bob", "", true, 0), v("jira:bob", "", false, 1)},
+			decision:  Reject,
+			rejecters: []string{"jira:bob"},
+		},
+		{
+			name:      "rejections needed",
+			rule:      QuorumRule{Approvals: 3, Rejections: 2},
+			votes:     []Vote{v("jira:bob", "", false, 0), v("jira:carol", "", true, 1)},
+			approvers: []string{"jira:carol"},
+			rejecters: []string{"jira:bob"},
+		},
+		{
+			name:    "author can't approve",
+			rule:    QuorumRule{ForbidSelfApproval: true},
+			votes:   []Vote{v("google:alice@example.com", "alice@example.com", true, 0)},
+			author:  "alice@example.com",
+			ignored: 1,
+		},
+		{
+			name:    "author matched without the source prefix",
+			rule:    QuorumRule{ForbidSelfApproval: true},
+			votes:   []Vote{v("slack:UALICE", "", true, 0)},
+			author:  "ualice",
+			ignored: 1,
+		},
+		{
+			name:      "author can reject",
+			rule:      QuorumRule{ForbidSelfApproval: true},
+			votes:     []Vote{v("google:alice@example.com", "alice@example.com", false, 0)},
+			author:    "alice@example.com",
+			decision:  Reject,
+			rejecters: []string{"google:alice@example.com"},
+		},
+		{
+			name:    "approver without an email can't approve",
+			rule:    QuorumRule{ForbidSelfApproval: true},
+			votes:   []Vote{v("jira:bob", "", true, 0)},
+			author:  "alice@example.com",
+			ignored: 1,
+		},
+		{
+			name:    "slack author matched by email",
+			rule:    QuorumRule{ForbidSelfApproval: true},
+			votes:   []Vote{v("slack:UALICE", "alice@example.com", true, 0)},
+			author:  "alice@example.com",
+			ignored: 1,
+		},
+		{
+			name:      "jira approver with an email counts",
+			rule:      QuorumRule{ForbidSelfApproval: true},
+			votes:     []Vote{v("jira:bob", "bob@example.com", true, 0)},
+			author:    "alice@example.com",
+			decision:  Approve,
+			approvers: []string{"jira:bob"},
+		},
+		{
+			name:      "policy approves without an email",
+			rule:      QuorumRule{ForbidSelfApproval: true},
+			votes:     []Vote{v("policy:prod", "", true, 0)},
+			author:    "alice@example.com",
+			decision:  Approve,
+			approvers: []string{"policy:prod"},
+		},
+		{
+			name:      "only approvers count",
+			rule:      QuorumRule{Approvers: []string{"jira:*", "*@example.com"}},
+			votes:     []Vote{v("slack:UMALLORY", "", false, 0), v("google:bob@example.com", "bob@example.com", true, 1)},
+			decision:  Approve,
+			approvers: []string{"google:bob@example.com"},
+			ignored:   1,
+		},
+		{
+			name:      "policy vote counts as one",
+			rule:      QuorumRule{Approvals: 2},
+			votes:     []Vote{v("policy:prod", "", true, 0)},
+			approvers: []string{"policy:prod"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.rule.Tally(tt.votes, tt.author)
+			if got.Decision != tt.decision ||
+				!reflect.DeepEqual(ids(got.Approvers), tt.approvers) ||
+				!reflect.DeepEqual(ids(got.Rejecters), tt.rejecters) ||
+				len(got.Ignored) != tt.ignored {
+				t.Errorf("Tally = %+v, want decision %q, approvers %v, rejecters %v and %d ignored",
+					got, tt.decision, tt.approvers, tt.rejecters, tt.ignored)
+			}
+			if want := max(tt.rule.Approvals, 1); got.Needed != want {
+				t.Errorf("Needed = %d, want %d", got.Needed, want)
+			}
+		})
+	}
+}
+
+func TestParseQuorumErrors(t *testing.T) {
+	for name, quorum := range map[string]string{
+		"not JSON":            `{"rules": [`,
+		"misspelt field":      `{"default": {"approval": 2}}`,
+		"rule without target": `{"rules": [{"name": "prod", "approvals": 2}]}`,
+		"negative approvals":  `{"default": {"approvals": -1}}`,
+		"bad approver":        `{"rules": [{"name": "prod", "targets": ["prod"], "approvers": ["["]}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseQuorum([]byte(quorum))
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if failure.Classify(err) != failure.Permanent {
+				t.Errorf("%v is transient, want permanent", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package example
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/shared/failure"
+)
+
+// voteRequest is the body approvalVote accepts.
+type voteRequest struct {
+	Rollout string `json:"rollout"`
+	Approve bool   `json:"approve"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// voteResponse is what approvalVote returns: the votes counted so far and
+// the decision, once there is one.
+type voteResponse struct {
+	Decision  Decision   `json:"decision,omitempty"`
+	Approvals int        `json:"approvals"`
+	Needed    int        `json:"needed"`
+	Rejecters []Approver `json:"rejecters,omitempty"`
+	Ignored   []string   `json:"ignored,omitempty"`
+}
+
+// approvalVote lets people vote on rollouts over HTTP. POST records a vote,
+// GET ?rollout=<name> returns the rollout's ballot. The function only
+// accepts callers with the Cloud Run invoker role, whose identity token the
+// platform has already verified, so the voter is the token's email.
+func approvalVote(w http.ResponseWriter, r *http.Request) {
+	loadConfig()
+	log.Printf("Approval vote function invoked")
+	email := callerEmail(r)
+	if email == "" {
+		http.Error(w, "no caller identity", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	if r.Method == http.MethodGet {
+		store, err := newVoteStore(ctx)
+		if err != nil {
+			log.Printf("Error creating vote store: %v", err)
+			http.Error(w, "error reading ballot", http.StatusInternalServerError)
+			return
+		}
+		defer store.Close()
+		b, err := store.Ballot(ctx, r.URL.Query().Get("rollout"))
+		if err != nil {
+			log.Printf("Error reading ballot: %v", err)
+			http.Error(w, "error reading ballot", http.StatusInternalServerError)
+			return
+		}
+		if b == nil {
+			http.Error(w, "no votes on that rollout", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(b)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req voteRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil || req.Rollout == "" {
+		http.Error(w, "body must be {\"rollout\": ..., \"approve\": ...}", http.StatusBadRequest)
+		return
+	}
+	t, err := castVote(ctx, Vote{
+		Rollout:  req.Rollout,
+		Approver: Approver{ID: "google:" + email, Email: email},
+		Approve:  req.Approve,
+		Comment:  req.Comment,
+		At:       time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Error recording vote by %s on %s: %v", email, req.Rollout, err)
+		if failure.Classify(err) == failure.Permanent {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "error recording vote", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(voteResponse{
+		Decision:  t.Decision,
+		Approvals: len(t.Approvers),
+		Needed:    t.Needed,
+		Rejecters: t.Rejecters,
+		Ignored:   t.Ignored,
+	})
+}
+
+// callerEmail reads the email claim of the caller's identity token. It
+// doesn't verify the token: Cloud Run did before the request got here.
+func callerEmail(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || !claims.EmailVerified {
+		return ""
+	}
+	return claims.Email
+}
@@ -0,0 +1,105 @@
+package example
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// functionEnv returns the environment functions.tf gives the function
+// resource named name.
+func functionEnv(t *testing.T, name string) map[string]string {
+	t.Helper()
+	data, err := os.ReadFile("../../functions.tf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf := string(data)
+	start := strings.Index(tf, `resource "google_cloudfunctions2_function" "`+name+`"`)
+	if start < 0 {
+		t.Fatalf("functions.tf has no function %s", name)
+	}
+	block := tf[start:]
+	if end := strings.Index(block[1:], "\nresource "); end >= 0 {
+		block = block[:end+1]
+	}
+	env := map[string]string{}
+	for _, m := range regexp.MustCompile(`(?m)^\s+([A-Z_]+) = (.+)$`).FindAllStringSubmatch(block, -1) {
+		env[m[1]] = m[2]
+	}
+	return env
+}
+
+// idToken is an unsigned identity token for email, as Cloud Run passes on
+// once it has verified it.
+func idToken(email string) string {
+	claims, _ := json.Marshal(map[string]any{"email": email, "email_verified": true})
+	return "Bearer e30." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+}
+
+func TestApprovalFunctionsFreezeCalendar(t *testing.T) {
+	want := functionEnv(t, "cloudDeployApprovals")
+	for _, key := range []string{"FREEZE_CALENDAR_URI", "FREEZE_CALENDAR_REFRESH", "FREEZE_STORE"} {
+		if want[key] == "" {
+			t.Fatalf("cloudDeployApprovals has no %s", key)
+		}
+		// Each of these can cast the vote that decides a rollout
+		for _, name := range []string{"jiraWebhook", "approvalVote", "chatApproval"} {
+			if got := functionEnv(t, name)[key]; got != want[key] {
+				t.Errorf("%s has %s = %s, want cloudDeployApprovals' %s", name, key, got, want[key])
+			}
+		}
+	}
+
+	// A quorum decided by an approvalVote with that config is deferred
+	f := newFakes(t)
+	calendar := filepath.Join(t.TempDir(), "freeze-calendar.json")
+	err := os.WriteFile(calendar, []byte(`{"freezes": [{"name": "launch", "reason": "testing", "mode": "defer", "targets": ["prod"],
+		"start": "2000-01-01T00:00:00Z", "end": "2999-01-01T00:00:00Z"}]}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh, err := time.ParseDuration(strings.Trim(want["FREEZE_CALENDAR_REFRESH"], `"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.FreezeCalendarURI, c.FreezeCalendarRefresh = calendar, refresh
+	c.ApprovalQuorum = `{"rules": [{"name": "prod", "targets": ["prod"], "approvals": 1}]}`
+	// The freeze store outlives the fakes, so don't reuse a rollout name
+	// other tests deferred
+	f.n = 100
+	rollout := f.pendingRollout(t, "prod", by(""))
+
+	body, _ := json.Marshal(voteRequest{Rollout: rollout, Approve: true})
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	r.Header.Set("Authorization", idToken("bob@example.com"))
+	w := httptest.NewRecorder()
+	approvalVote(w, r)
+	var resp voteResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Decision != Approve {
+		t.Fatalf("approvalVote = %d %s, want the quorum approving", w.Code, w.Body)
+	}
+	if cmds := f.commands(t); len(cmds) != 0 {
+		t.Errorf("sent %+v during a freeze, want the approval deferred", cmds)
+	}
+	store, _ := freezes().OpenStore(context.Background())
+	pending, _ := store.Pending(context.Background())
+	deferred := 0
+	for _, d := range pending {
+		if d.Freeze == "launch" {
+			deferred++
+		}
+	}
+	if deferred != 1 {
+		t.Errorf("%d approval(s) deferred by launch, want 1", deferred)
+	}
+}
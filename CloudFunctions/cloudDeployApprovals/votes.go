@@ -0,0 +1,131 @@
+package example
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Ballot is everything recorded about the votes on one rollout.
+type Ballot struct {
+	Rollout string `firestore:"rollout" json:"rollout"`
+	Target  string `firestore:"target" json:"target"`
+	// Votes by approver ID, the latest of each
+	Votes map[string]Vote `firestore:"votes" json:"votes"`
+	// Decision and who made it, once the quorum was met
+	Decision  Decision   `firestore:"decision,omitempty" json:"decision,omitempty"`
+	DecidedBy []Approver `firestore:"decidedBy,omitempty" json:"decidedBy,omitempty"`
+	DecidedAt time.Time  `firestore:"decidedAt,omitempty" json:"decidedAt,omitempty"`
+}
+
+// VoteStore keeps the votes on rollouts, which come in through different
+// requests and function instances.
+type VoteStore interface {
+	// Cast records v on its rollout's ballot, replacing the approver's
+	// earlier vote, and returns the ballot. Votes on a decided ballot aren't
+	// recorded.
+	Cast(ctx context.Context, v Vote) (*Ballot, error)
+	// Decide records the quorum's decision, reporting false if the ballot
+	// was already decided.
+	Decide(ctx context.Context, rollout string, t Tally) (bool, error)
+	// Ballot returns the rollout's ballot, nil if nobody voted on it.
+	Ballot(ctx context.Context, rollout string) (*Ballot, error)
+	Close() error
+}
+
+// ballotKey is the store key of a rollout's ballot. It's hashed because
+// rollout names contain slashes.
+func ballotKey(rollout string) string {
+	sum := sha256.Sum256([]byte(rollout))
+	return hex.EncodeToString(sum[:])
+}
+
+// newVoteStore returns the VoteStore selected by VOTE_STORE.
+func newVoteStore(ctx context.Context) (VoteStore, error) {
+	switch c.VoteStore {
+	case "firestore":
+		return newFirestoreVoteStore(ctx, c.ProjectId, c.FirestoreDatabase, c.VoteCollection)
+	case "memory":
+		return memVotes, nil
+	default:
+		return nil, fmt.Errorf("unknown VOTE_STORE %q", c.VoteStore)
+	}
+}
+
+// memVotes lives as long as the function instance, which is enough for
+// tests and local runs but not for production.
+var memVotes = NewMemoryVoteStore()
+
+// MemoryVoteStore is an in-process VoteStore.
+type MemoryVoteStore struct {
+	mu      sync.Mutex
+	ballots map[string]*Ballot
+}
+
+func NewMemoryVoteStore() *MemoryVoteStore {
+	return &MemoryVoteStore{ballots: map[string]*Ballot{}}
+}
+
+func (s *MemoryVoteStore) Cast(ctx context.Context, v Vote) (*Ballot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.ballots[v.Rollout]
+	if !ok {
+		b = &Ballot{Rollout: v.Rollout, Target: v.Target, Votes: map[string]Vote{}}
+		s.ballots[v.Rollout] = b
+	}
+	if b.Decision == "" {
+		b.Votes[v.Approver.ID] = v
+	}
+	return copyBallot(b), nil
+}
+
+func (s *MemoryVoteStore) Decide(ctx context.Context, rollout string, t Tally) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.ballots[rollout]
+	if !ok {
+		return false, fmt.Errorf("no ballot for %s", rollout)
+	}
+	if b.Decision != "" {
+		return false, nil
+	}
+	decide(b, t)
+	return true, nil
+}
+
+func (s *MemoryVoteStore) Ballot(ctx context.Context, rollout string) (*Ballot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.ballots[rollout]
+	if !ok {
+		return nil, nil
+	}
+	return copyBallot(b), nil
+}
+
+func (s *MemoryVoteStore) Close() error {
+	return nil
+}
+
+func copyBallot(b *Ballot) *Ballot {
+	cp := *b
+	cp.Votes = make(map[string]Vote, len(b.Votes))
+	for k, v := range b.Votes {
+		cp.Votes[k] = v
+	}
+	return &cp
+}
+
+// decide records the tally's decision and the approvers who made it.
+func decide(b *Ballot, t Tally) {
+	b.Decision = t.Decision
+	b.DecidedBy = t.Approvers
+	if t.Decision == Reject {
+		b.DecidedBy = t.Rejecters
+	}
+	b.DecidedAt = time.Now().UTC()
+}
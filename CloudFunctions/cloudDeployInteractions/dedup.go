@@ -0,0 +1,129 @@
+package example
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"example.com/shared/command"
+)
+
+// Outcome is the state of a command in the deduplication Store.
+type Outcome string
+
+const (
+	InProgress Outcome = "in_progress"
+	Succeeded  Outcome = "succeeded"
+	Failed     Outcome = "failed"
+	// Retrying commands failed transiently and may be claimed again
+	Retrying Outcome = "retrying"
+)
+
+// claimTimeout is how long an in-progress claim is honoured. It's well past
+// the function timeout, so a claim this old belongs to a crashed attempt.
+const claimTimeout = 10 * time.Minute
+
+// Record is what the Store keeps about a command.
+type Record struct {
+	CorrelationID string    `firestore:"correlationId"`
+	Type          string    `firestore:"type"`
+	Issuer        string    `firestore:"issuer"`
+	MessageID     string    `firestore:"messageId"`
+	Outcome       Outcome   `firestore:"outcome"`
+	Error         string    `firestore:"error,omitempty"`
+	Started       time.Time `firestore:"started"`
+	Finished      time.Time `firestore:"finished,omitempty"`
+}
+
+// Store remembers which commands have been processed so redelivered Pub/Sub
+// messages, and producers publishing the same command twice, only reach
+// Cloud Deploy once.
+type Store interface {
+	// Claim records rec as in progress under key. If key was already claimed
+	// by an attempt that hasn't timed out, it returns that Record and false.
+	Claim(ctx context.Context, key string, rec Record) (*Record, bool, error)
+	// Finish records the outcome of a claimed command.
+	Finish(ctx context.Context, key string, outcome Outcome, cmdErr error) error
+	Close() error
+}
+
+// dedupKey identifies a command across redeliveries. The correlation ID is
+// stable across both Pub/Sub redeliveries (same message ID) and producer
+// retries (new message ID), so it's what we key on. It's hashed because
+// correlation IDs may contain characters store keys can't.
+func dedupKey(cmd *command.Envelope) string {
+	sum := sha256.Sum256([]byte(string(cmd.Type) + "/" + cmd.CorrelationID))
+	return hex.EncodeToString(sum[:])
+}
+
+// newStore returns the Store selected by DEDUP_STORE.
+func newStore(ctx context.Context) (Store, error) {
+	switch c.DedupStore {
+	case "firestore":
+		return newFirestoreStore(ctx, c.ProjectId, c.FirestoreDatabase, c.DedupCollection)
+	case "memory":
+		return memStore, nil
+	default:
+		return nil, fmt.Errorf("unknown DEDUP_STORE %q", c.DedupStore)
+	}
+}
+
+// memStore lives as long as the function instance, which is enough for
+// tests and local runs but not for production.
+var memStore = NewMemoryStore()
+
+// MemoryStore is an in-process Store.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]Record{}}
+}
+
+func (s *MemoryStore) Claim(ctx context.Context, key string, rec Record) (*Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.records[key]; ok && !claimable(existing) {
+		return &existing, false, nil
+	}
+	rec.Outcome = InProgress
+	s.records[key] = rec
+	return &rec, true, nil
+}
+
+func (s *MemoryStore) Finish(ctx context.Context, key string, outcome Outcome, cmdErr error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.records[key]
+	if !ok {
+		return fmt.Errorf("no claim for %s", key)
+	}
+	finish(&rec, outcome, cmdErr)
+	s.records[key] = rec
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// claimable says whether a new attempt may take over an existing record.
+func claimable(rec Record) bool {
+	if rec.Outcome == Retrying {
+		return true
+	}
+	return rec.Outcome == InProgress && time.Since(rec.Started) > claimTimeout
+}
+
+func finish(rec *Record, outcome Outcome, cmdErr error) {
+	rec.Outcome = outcome
+	rec.Finished = time.Now().UTC()
+	if cmdErr != nil {
+		rec.Error = cmdErr.Error()
+	}
+}
@@ -0,0 +1,74 @@
+package example
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FirestoreStore keeps one document per command in a collection.
+type FirestoreStore struct {
+	client     *firestore.Client
+	collection string
+}
+
+func newFirestoreStore(ctx context.Context, projectID, database, collection string) (*FirestoreStore, error) {
+	client, err := firestore.NewClientWithDatabase(ctx, projectID, database)
+	if err != nil {
+		return nil, fmt.Errorf("firestore.NewClient: %w", err)
+	}
+	return &FirestoreStore{client: client, collection: collection}, nil
+}
+
+func (s *FirestoreStore) Claim(ctx context.Context, key string, rec Record) (*Record, bool, error) {
+	ref := s.client.Collection(s.collection).Doc(key)
+	var existing *Record
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		existing = nil
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if snap.Exists() {
+			var r Record
+			if err := snap.DataTo(&r); err != nil {
+				return err
+			}
+			if !claimable(r) {
+				existing = &r
+				return nil
+			}
+		}
+		rec.Outcome = InProgress
+		return tx.Set(ref, rec)
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("claiming %s: %w", key, err)
+	}
+	if existing != nil {
+		return existing, false, nil
+	}
+	return &rec, true, nil
+}
+
+func (s *FirestoreStore) Finish(ctx context.Context, key string, outcome Outcome, cmdErr error) error {
+	var rec Record
+	finish(&rec, outcome, cmdErr)
+	_, err := s.client.Collection(s.collection).Doc(key).Set(ctx, map[string]interface{}{
+		"outcome":  rec.Outcome,
+		"error":    rec.Error,
+		"finished": rec.Finished,
+	}, firestore.MergeAll)
+	if err != nil {
+		return fmt.Errorf("recording outcome of %s: %w", key, err)
+	}
+	return nil
+}
+
+// Close releases the Firestore client.
+func (s *FirestoreStore) Close() error {
+	return s.client.Close()
+}
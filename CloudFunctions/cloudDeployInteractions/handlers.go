@@ -0,0 +1,351 @@
+package example
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	deploy "cloud.google.com/go/deploy/apiv1"
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/command"
+	"example.com/shared/failure"
+	"example.com/shared/freeze"
+	"example.com/shared/provenance"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// handler executes one command against Cloud Deploy. req is the payload
+// command.Parse decoded for the command's type. It returns the request as
+// executed, which differs from req when the handler filled in what the
+// command left open, so the history names what was actually done.
+type handler func(ctx context.Context, d deploy.CloudDeployClient, req command.Payload) (command.Payload, error)
+
+// handlers maps every command type this function executes to its handler.
+var handlers = map[command.Type]handler{
+	command.CreateRelease:   typed(cdCreateRelease),
+	command.CreateRollout:   typed(cdCreateRollout),
+	command.ApproveRollout:  typed(cdApproveRollout),
+	command.PromoteRelease:  resolving(cdPromoteRelease),
+	command.AdvanceRollout:  typed(cdAdvanceRollout),
+	command.CancelRollout:   typed(cdCancelRollout),
+	command.RetryJob:        typed(cdRetryJob),
+	command.RollbackTarget:  typed(cdRollbackTarget),
+	command.AbandonRelease:  typed(cdAbandonRelease),
+	command.IgnoreJob:       typed(cdIgnoreJob),
+	command.TerminateJobRun: typed(cdTerminateJobRun),
+	command.LiftFreeze:      typed(liftFreeze),
+}
+
+// issuerKey carries the issuer of the command being handled in its context.
+type issuerKey struct{}
+
+// correlationKey carries the correlation ID of the command being handled in
+// its context.
+type correlationKey struct{}
+
+// errRolloutTaken means a rollout ID is used by a rollout some other
+// command created.
+var errRolloutTaken = errors.New("rollout ID is taken by another command")
+
+// typed adapts a handler taking a concrete request type, which it executes
+// as is.
+func typed[T command.Payload](f func(context.Context, deploy.CloudDeployClient, T) error) handler {
+	return resolving(func(ctx context.Context, d deploy.CloudDeployClient, r T) (T, error) {
+		return r, f(ctx, d, r)
+	})
+}
+
+// resolving adapts a handler taking a concrete request type and returning
+// it as executed.
+func resolving[T command.Payload](f func(context.Context, deploy.CloudDeployClient, T) (T, error)) handler {
+	return func(ctx context.Context, d deploy.CloudDeployClient, req command.Payload) (command.Payload, error) {
+		r, ok := req.(T)
+		if !ok {
+			return req, failure.NewPermanent(fmt.Errorf("unexpected payload %T", req))
+		}
+		return f(ctx, d, r)
+	}
+}
+
+func cdCreateRelease(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.CreateReleaseRequest) error {
+	releaseOp, err := d.CreateRelease(ctx, c)
+	if status.Code(err) == codes.AlreadyExists {
+		// An earlier attempt got there first
+		log.Printf("Release %s already exists", c.ReleaseId)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error creating release request: %w", err)
+	}
+	log.Printf("Created release operation: %s", releaseOp.Name())
+
+	_, err = releaseOp.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("error on release operation: %w", err)
+	}
+	log.Printf("Create Release Operation Completed")
+	return nil
+}
+
+// cdCreateRollout creates a rollout carrying its release's provenance.
+func cdCreateRollout(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.CreateRolloutRequest) error {
+	release, err := d.GetRelease(ctx, &deploypb.GetReleaseRequest{Name: c.Parent})
+	if err != nil {
+		return fmt.Errorf("error getting release: %w", err)
+	}
+	err = createRollout(ctx, d, c, release)
+	if errors.Is(err, errRolloutTaken) {
+		return failure.NewPermanent(err)
+	}
+	return err
+}
+
+// createRollout creates the rollout annotated with the command's
+// correlation ID. A rollout of the same ID counts as created only when an
+// earlier attempt of this command created it; otherwise errRolloutTaken is
+// returned.
+func createRollout(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.CreateRolloutRequest, release *deploypb.Release) error {
+	c = proto.Clone(c).(*deploypb.CreateRolloutRequest)
+	if c.Rollout == nil {
+		c.Rollout = &deploypb.Rollout{}
+	}
+	provenance.Inherit(c.Rollout, release)
+	correlationID, _ := ctx.Value(correlationKey{}).(string)
+	if correlationID != "" {
+		if c.Rollout.Annotations == nil {
+			c.Rollout.Annotations = map[string]string{}
+		}
+		c.Rollout.Annotations[command.CorrelationAnnotation] = correlationID
+	}
+	rollout, err := d.CreateRollout(ctx, c)
+	if status.Code(err) == codes.AlreadyExists {
+		existing, err := d.GetRollout(ctx, &deploypb.GetRolloutRequest{Name: c.Parent + "/rollouts/" + c.RolloutId})
+		if err != nil {
+			return fmt.Errorf("error getting existing rollout: %w", err)
+		}
+		if correlationID == "" || existing.Annotations[command.CorrelationAnnotation] != correlationID {
+			return fmt.Errorf("%w: %s", errRolloutTaken, existing.Name)
+		}
+		// An earlier attempt got there first
+		log.Printf("Rollout %s already exists", c.RolloutId)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error creating rollout request: %w", err)
+	}
+	log.Printf("Created Rollout Request: %v", rollout.Name())
+	_, err = rollout.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("error on rollout operation: %w", err)
+	}
+	log.Printf("Create Rollout Operation Completed")
+	return nil
+}
+
+func cdApproveRollout(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.ApproveRolloutRequest) error {
+	_, err := d.ApproveRollout(ctx, c)
+	if err != nil {
+		return fmt.Errorf("error approving rollout request operation: %w", err)
+	}
+	log.Printf("Approved Rollout")
+	return nil
+}
+
+// cdPromoteRelease creates a rollout of the release in c.Parent. Without a
+// target it picks the stage after the furthest one the release has
+// successfully rolled out to. It returns c with the target and rollout ID
+// it used, once it got that far.
+func cdPromoteRelease(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.CreateRolloutRequest) (*deploypb.CreateRolloutRequest, error) {
+	req := proto.Clone(c).(*deploypb.CreateRolloutRequest)
+	if req.Rollout == nil {
+		req.Rollout = &deploypb.Rollout{}
+	}
+	release, err := d.GetRelease(ctx, &deploypb.GetReleaseRequest{Name: req.Parent})
+	if err != nil {
+		return c, fmt.Errorf("error getting release: %w", err)
+	}
+	rollouts, err := listRollouts(ctx, d, release.Name)
+	if err != nil {
+		return c, err
+	}
+	if req.Rollout.TargetId == "" {
+		target, err := nextTarget(release, rollouts)
+		if err != nil {
+			return c, err
+		}
+		req.Rollout.TargetId = target
+	}
+	generated := req.RolloutId == ""
+	if generated {
+		releaseID := req.Parent[strings.LastIndex(req.Parent, "/")+1:]
+		correlationID, _ := ctx.Value(correlationKey{}).(string)
+		req.RolloutId = rolloutID(releaseID, req.Rollout.TargetId, rollouts, correlationID)
+	}
+	log.Printf("Promoting %s to %s as %s", req.Parent, req.Rollout.TargetId, req.RolloutId)
+	err = createRollout(ctx, d, req, release)
+	if errors.Is(err, errRolloutTaken) && !generated {
+		return req, failure.NewPermanent(err)
+	}
+	// A generated ID taken in the meantime is retried with the next one
+	return req, err
+}
+
+// listRollouts returns the rollouts of the release.
+func listRollouts(ctx context.Context, d deploy.CloudDeployClient, release string) ([]*deploypb.Rollout, error) {
+	var rollouts []*deploypb.Rollout
+	it := d.ListRollouts(ctx, &deploypb.ListRolloutsRequest{Parent: release})
+	for {
+		rollout, err := it.Next()
+		if err == iterator.Done {
+			return rollouts, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing rollouts: %w", err)
+		}
+		rollouts = append(rollouts, rollout)
+	}
+}
+
+// nextTarget returns the target of the serial pipeline stage following the
+// last one release has a succeeded rollout in.
+func nextTarget(release *deploypb.Release, rollouts []*deploypb.Rollout) (string, error) {
+	stages := release.GetDeliveryPipelineSnapshot().GetSerialPipeline().GetStages()
+	if len(stages) == 0 {
+		return "", failure.NewPermanent(fmt.Errorf("release %s has no serial pipeline stages", release.Name))
+	}
+
+	deployed := map[string]bool{}
+	for _, rollout := range rollouts {
+		if rollout.State == deploypb.Rollout_SUCCEEDED {
+			deployed[rollout.TargetId] = true
+		}
+	}
+
+	next := 0
+	for i, stage := range stages {
+		if deployed[stage.TargetId] {
+			next = i + 1
+		}
+	}
+	if next == len(stages) {
+		return "", failure.NewPermanent(fmt.Errorf("release %s is already on the last stage", release.Name))
+	}
+	return stages[next].TargetId, nil
+}
+
+// rolloutID follows Cloud Deploy's own <release>-to-<target>-0001 naming,
+// trimmed to the 63 characters a resource ID may have. The number is one
+// past the highest among the release's rollouts, so the release can be
+// rolled out to a target again, unless an earlier attempt of the command
+// with correlationID already created one.
+func rolloutID(releaseID, targetID string, rollouts []*deploypb.Rollout, correlationID string) string {
+	prefix := fmt.Sprintf("%s-to-%s", releaseID, targetID)
+	if len(prefix) > 58 {
+		prefix = strings.TrimRight(prefix[:58], "-")
+	}
+	last := 0
+	for _, r := range rollouts {
+		id := r.Name[strings.LastIndex(r.Name, "/")+1:]
+		if correlationID != "" && r.Annotations[command.CorrelationAnnotation] == correlationID {
+			return id
+		}
+		if n, ok := strings.CutPrefix(id, prefix+"-"); ok {
+			if i, err := strconv.Atoi(n); err == nil && i > last {
+				last = i
+			}
+		}
+	}
+	return fmt.Sprintf("%s-%04d", prefix, last+1)
+}
+
+func cdAdvanceRollout(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.AdvanceRolloutRequest) error {
+	_, err := d.AdvanceRollout(ctx, c)
+	if err != nil {
+		return fmt.Errorf("error advancing rollout: %w", err)
+	}
+	log.Printf("Advanced Rollout %s to phase %s", c.Name, c.PhaseId)
+	return nil
+}
+
+func cdCancelRollout(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.CancelRolloutRequest) error {
+	_, err := d.CancelRollout(ctx, c)
+	if err != nil {
+		return fmt.Errorf("error cancelling rollout: %w", err)
+	}
+	log.Printf("Cancelled Rollout %s", c.Name)
+	return nil
+}
+
+func cdRetryJob(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.RetryJobRequest) error {
+	_, err := d.RetryJob(ctx, c)
+	if err != nil {
+		return fmt.Errorf("error retrying job: %w", err)
+	}
+	log.Printf("Retried job %s/%s of %s", c.PhaseId, c.JobId, c.Rollout)
+	return nil
+}
+
+func cdRollbackTarget(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.RollbackTargetRequest) error {
+	resp, err := d.RollbackTarget(ctx, c)
+	if err != nil {
+		return fmt.Errorf("error rolling back target: %w", err)
+	}
+	log.Printf("Rolling back %s with rollout %s", c.TargetId, resp.GetRollbackConfig().GetRollout().GetName())
+	return nil
+}
+
+func cdAbandonRelease(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.AbandonReleaseRequest) error {
+	_, err := d.AbandonRelease(ctx, c)
+	if err != nil {
+		return fmt.Errorf("error abandoning release: %w", err)
+	}
+	log.Printf("Abandoned Release %s", c.Name)
+	return nil
+}
+
+func cdIgnoreJob(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.IgnoreJobRequest) error {
+	_, err := d.IgnoreJob(ctx, c)
+	if err != nil {
+		return fmt.Errorf("error ignoring job: %w", err)
+	}
+	log.Printf("Ignored job %s/%s of %s", c.PhaseId, c.JobId, c.Rollout)
+	return nil
+}
+
+func cdTerminateJobRun(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.TerminateJobRunRequest) error {
+	_, err := d.TerminateJobRun(ctx, c)
+	if err != nil {
+		return fmt.Errorf("error terminating job run: %w", err)
+	}
+	log.Printf("Terminated JobRun %s", c.Name)
+	return nil
+}
+
+// liftFreeze ends the occurrence of a freeze in force now. Actions it
+// deferred are resumed by cloudDeployOperations' next resumeDeferred run.
+func liftFreeze(ctx context.Context, _ deploy.CloudDeployClient, req *command.LiftFreezeRequest) error {
+	store, err := freeze.NewStore(ctx, c.FreezeStore, c.ProjectId, c.FirestoreDatabase, c.FreezeCollection)
+	if err != nil {
+		return fmt.Errorf("error creating freeze store: %w", err)
+	}
+	defer store.Close()
+	issuer, _ := ctx.Value(issuerKey{}).(string)
+	l := freeze.Lift{
+		Freeze: req.Freeze,
+		Reason: req.Reason,
+		By:     issuer,
+		At:     time.Now().UTC(),
+	}
+	if err := store.Lift(ctx, l); err != nil {
+		return err
+	}
+	log.Printf("Lifted freeze %s for %s: %s", l.Freeze, l.By, l.Reason)
+	return nil
+}
@@ -0,0 +1,149 @@
+package example
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	deploy "cloud.google.com/go/deploy/apiv1"
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/command"
+	"example.com/shared/deployclient"
+	"example.com/shared/deploytest"
+	"example.com/shared/freeze"
+)
+
+const testPipeline = "projects/p/locations/l/deliveryPipelines/app"
+
+// newDeploy starts a fake Cloud Deploy with a dev then prod pipeline,
+// where prod needs approval, and returns a client of it.
+func newDeploy(t *testing.T) (*deploytest.Server, *deploy.CloudDeployClient) {
+	t.Helper()
+	ds, err := deploytest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ds.Close)
+	ds.AddPipeline(&deploypb.DeliveryPipeline{
+		Name: testPipeline,
+		Pipeline: &deploypb.DeliveryPipeline_SerialPipeline{SerialPipeline: &deploypb.SerialPipeline{
+			Stages: []*deploypb.Stage{{TargetId: "dev"}, {TargetId: "prod"}},
+		}},
+	})
+	ds.AddTarget(&deploypb.Target{Name: "projects/p/locations/l/targets/dev", TargetId: "dev"})
+	ds.AddTarget(&deploypb.Target{Name: "projects/p/locations/l/targets/prod", TargetId: "prod", RequireApproval: true})
+	t.Setenv(deployclient.EmulatorHostEnv, ds.Addr)
+	d, err := deployclient.New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return ds, d
+}
+
+// createRelease creates release id of the test pipeline.
+func createRelease(t *testing.T, d *deploy.CloudDeployClient, id string) string {
+	t.Helper()
+	ctx := context.Background()
+	op, err := d.CreateRelease(ctx, &deploypb.CreateReleaseRequest{Parent: testPipeline, ReleaseId: id, Release: &deploypb.Release{}})
+	if err == nil {
+		_, err = op.Wait(ctx)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testPipeline + "/releases/" + id
+}
+
+func TestRolloutID(t *testing.T) {
+	const release = "projects/p/locations/l/deliveryPipelines/d/releases/r1"
+	rollout := func(id, correlationID string) *deploypb.Rollout {
+		r := &deploypb.Rollout{Name: release + "/rollouts/" + id}
+		if correlationID != "" {
+			r.Annotations = map[string]string{command.CorrelationAnnotation: correlationID}
+		}
+		return r
+	}
+	for _, tt := range []struct {
+		name     string
+		target   string
+		rollouts []*deploypb.Rollout
+		want     string
+	}{
+		{
+			name:   "first rollout",
+			target: "prod",
+			want:   "r1-to-prod-0001",
+		},
+		{
+			name:     "after a failed one",
+			target:   "prod",
+			rollouts: []*deploypb.Rollout{rollout("r1-to-dev-0001", "a"), rollout("r1-to-prod-0001", "b")},
+			want:     "r1-to-prod-0002",
+		},
+		{
+			name:   "after the highest, whatever the order",
+			target: "prod",
+			rollouts: []*deploypb.Rollout{
+				rollout("r1-to-prod-0003", "b"), rollout("r1-to-prod-0001", "c"), rollout("r1-to-prod-manual", ""),
+			},
+			want: "r1-to-prod-0004",
+		},
+		{
+			name:     "other targets and rollbacks don't count",
+			target:   "prod",
+			rollouts: []*deploypb.Rollout{rollout("r1-to-dev-0007", "b"), rollout("rollback-r1-to-prod-0009", "")},
+			want:     "r1-to-prod-0001",
+		},
+		{
+			name:     "retry of the same command",
+			target:   "prod",
+			rollouts: []*deploypb.Rollout{rollout("r1-to-prod-0001", "b"), rollout("r1-to-prod-0002", "retried")},
+			want:     "r1-to-prod-0002",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rolloutID("r1", tt.target, tt.rollouts, "retried"); got != tt.want {
+				t.Errorf("rolloutID = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	long := strings.Repeat("a", 50)
+	if got := rolloutID(long, "production", nil, ""); len(got) > 63 || !strings.HasSuffix(got, "-0001") {
+		t.Errorf("rolloutID of a long release = %q, want at most 63 characters ending -0001", got)
+	}
+}
+
+func TestLiftFreeze(t *testing.T) {
+	c.FreezeStore = "memory"
+	cmd, err := command.New(command.LiftFreeze, "google:alice@example.com", &command.LiftFreezeRequest{Freeze: "christmas", Reason: "hotfix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, req, err := command.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), issuerKey{}, cmd.Issuer)
+	if _, err := handlers[command.LiftFreeze](ctx, deploy.CloudDeployClient{}, req); err != nil {
+		t.Fatalf("liftFreeze: %v", err)
+	}
+
+	store, err := freeze.NewStore(ctx, "memory", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lifts, err := store.Lifts(ctx, "christmas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lifts) != 1 || lifts[0].Reason != "hotfix" || lifts[0].By != cmd.Issuer || lifts[0].At.IsZero() {
+		t.Errorf("lifts = %+v, want one by %s for the hotfix", lifts, cmd.Issuer)
+	}
+}
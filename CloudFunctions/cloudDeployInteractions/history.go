@@ -0,0 +1,99 @@
+package example
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/command"
+	"example.com/shared/history"
+	"example.com/shared/provenance"
+)
+
+func historyConfig() history.Config {
+	return history.Config{
+		Store:      c.HistoryStore,
+		ProjectID:  c.ProjectId,
+		Database:   c.FirestoreDatabase,
+		Collection: c.HistoryCollection,
+	}
+}
+
+// historyRecords describes the outcome of a command: the command itself,
+// with whoever approved it, and, for a release that was created, the
+// release. req is the request as the handler executed it. key is the
+// command's dedup key, so each outcome is only recorded once.
+func historyRecords(key string, cmd *command.Envelope, req command.Payload, cmdErr error) []history.Record {
+	name, target := commandResource(req)
+	r := history.Record{
+		ID:      history.Key("command", key),
+		Kind:    history.Command,
+		At:      time.Now().UTC(),
+		Actor:   cmd.Issuer,
+		Command: string(cmd.Type),
+		Detail:  cmd.CorrelationID,
+	}
+	r.SetResource(name)
+	if target != "" {
+		r.Target = target
+	}
+	for _, a := range cmd.Approvers {
+		r.Approvers = append(r.Approvers, a.ID)
+	}
+	if cmdErr != nil {
+		r.Error = cmdErr.Error()
+		return []history.Record{r}
+	}
+	records := []history.Record{r}
+	if create, ok := req.(*deploypb.CreateReleaseRequest); ok {
+		created := history.Record{
+			ID:     history.Key("release", name),
+			Kind:   history.ReleaseCreated,
+			At:     r.At,
+			Actor:  cmd.Issuer,
+			Commit: create.GetRelease().GetAnnotations()[provenance.CommitSha],
+		}
+		created.SetResource(name)
+		records = append(records, created)
+	}
+	return records
+}
+
+// commandResource returns the resource a command acts on and, when the
+// name doesn't say, its target.
+func commandResource(req command.Payload) (name, target string) {
+	switch r := req.(type) {
+	case *deploypb.CreateReleaseRequest:
+		return r.Parent + "/releases/" + r.ReleaseId, ""
+	case *deploypb.CreateRolloutRequest:
+		return r.Parent + "/rollouts/" + r.RolloutId, r.GetRollout().GetTargetId()
+	case *deploypb.ApproveRolloutRequest:
+		return r.Name, ""
+	case *deploypb.AdvanceRolloutRequest:
+		return r.Name, ""
+	case *deploypb.CancelRolloutRequest:
+		return r.Name, ""
+	case *deploypb.RetryJobRequest:
+		return r.Rollout, ""
+	case *deploypb.RollbackTargetRequest:
+		return r.Name, r.TargetId
+	case *deploypb.AbandonReleaseRequest:
+		return r.Name, ""
+	case *deploypb.IgnoreJobRequest:
+		return r.Rollout, ""
+	case *deploypb.TerminateJobRunRequest:
+		return r.Name, ""
+	}
+	return "", ""
+}
+
+// recordCommand appends the command's outcome to the history. It's only
+// logged when that fails, the command has run either way.
+func recordCommand(ctx context.Context, key string, cmd *command.Envelope, req command.Payload, cmdErr error) error {
+	for _, r := range historyRecords(key, cmd, req, cmdErr) {
+		if err := historyConfig().Append(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
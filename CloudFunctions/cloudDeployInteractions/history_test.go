@@ -0,0 +1,64 @@
+package example
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/command"
+	"example.com/shared/history"
+)
+
+func TestHistoryRecords(t *testing.T) {
+	const rollout = "projects/p/locations/l/deliveryPipelines/d/releases/r1/rollouts/r1-to-prod-0001"
+	req := &deploypb.ApproveRolloutRequest{Name: rollout, Approved: true}
+	cmd, err := command.New(command.ApproveRollout, "quorum:google:bob@example.com,jira:carol", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Approvers = []command.Approver{{ID: "google:bob@example.com", Email: "bob@example.com"}, {ID: "jira:carol", Name: "Carol"}}
+
+	records := historyRecords("key", cmd, req, nil)
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	r := records[0]
+	if r.Kind != history.Command || r.Command != "ApproveRollout" || r.Actor != cmd.Issuer ||
+		r.Pipeline != "d" || r.Release != "r1" || r.Rollout != "r1-to-prod-0001" || r.Error != "" {
+		t.Errorf("record = %+v, want the approval of r1-to-prod-0001 by the quorum", r)
+	}
+	if want := []string{"google:bob@example.com", "jira:carol"}; !reflect.DeepEqual(r.Approvers, want) {
+		t.Errorf("approvers = %v, want %v", r.Approvers, want)
+	}
+
+	records = historyRecords("key", cmd, req, errors.New("rollout doesn't need approval"))
+	if len(records) != 1 || records[0].Error == "" {
+		t.Errorf("records of a failed command = %+v, want one with the error", records)
+	}
+}
+
+func TestHistoryRecordsPromoteRelease(t *testing.T) {
+	ds, d := newDeploy(t)
+	release := createRelease(t, d, "r1")
+	req := &deploypb.CreateRolloutRequest{Parent: release}
+	cmd, err := command.New(command.PromoteRelease, "google:alice@example.com", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), correlationKey{}, cmd.CorrelationID)
+	done, err := handlers[command.PromoteRelease](ctx, *d, req)
+	if err != nil {
+		t.Fatalf("PromoteRelease: %v", err)
+	}
+	if rollouts := ds.Rollouts(); len(rollouts) != 1 || rollouts[0].Name != release+"/rollouts/r1-to-dev-0001" {
+		t.Fatalf("rollouts = %v, want r1-to-dev-0001", rollouts)
+	}
+
+	// The command left the rollout to the handler, the history names it
+	records := historyRecords("key", cmd, done, nil)
+	if len(records) != 1 || records[0].Rollout != "r1-to-dev-0001" || records[0].Target != "dev" || records[0].Release != "r1" {
+		t.Errorf("records = %+v, want the promotion of r1 to dev as r1-to-dev-0001", records)
+	}
+}
@@ -2,113 +2,130 @@ package example
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
-	deploy "cloud.google.com/go/deploy/apiv1"
-	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/command"
+	"example.com/shared/deployclient"
+	"example.com/shared/events"
+	"example.com/shared/failure"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/codingconcepts/env"
 )
 
-func init() {
-	functions.CloudEvent("cloudDeployInteractions", cloudDeployInteractions)
-}
+type config struct {
+	ProjectId string `env:"PROJECTID" required:"true"`
+	Location  string `env:"LOCATION" required:"true"`
+	// Commands that fail validation are forwarded here with the reason
+	DeadLetterTopicID string `env:"DEADLETTERTOPICID" required:"true"`
 
-type PubSubMessage struct {
-	Data []byte `json:"data"`
+	// Where processed commands are recorded, "firestore" or "memory"
+	DedupStore        string `env:"DEDUP_STORE" default:"firestore"`
+	FirestoreDatabase string `env:"FIRESTORE_DATABASE" default:"(default)"`
+	DedupCollection   string `env:"DEDUP_COLLECTION" default:"deploy-commands"`
+	// Where LiftFreeze records lifts for the functions checking freezes
+	FreezeStore      string `env:"FREEZE_STORE" default:"firestore"`
+	FreezeCollection string `env:"FREEZE_COLLECTION" default:"deploy-freezes"`
+	// Where command outcomes are recorded for the deployment history,
+	// "firestore", "memory" or "file:<path>"
+	HistoryStore      string `env:"HISTORY_STORE" default:"firestore"`
+	HistoryCollection string `env:"HISTORY_COLLECTION" default:"deploy-history"`
 }
 
-type MessagePublishedData struct {
-	Message PubSubMessage
-}
+var c config
 
-type DeployCommand struct {
-	Commmand       string                         `json:"command"`
-	CreateRelease  deploypb.CreateReleaseRequest  `json:"createReleaseRequest"`
-	CreateRollout  deploypb.CreateRolloutRequest  `json:"createRolloutRequest"`
-	ApproveRollout deploypb.ApproveRolloutRequest `json:"approveRolloutRequest"`
+func init() {
+	functions.CloudEvent("cloudDeployInteractions", cloudDeployInteractions)
 }
 
+// loadConfig reads the environment on the first invocation rather than in
+// init, so tests can set it up in TestMain first.
+var loadConfig = sync.OnceFunc(func() {
+	//Load env variables using "github.com/codingconcepts/env"
+	if err := env.Set(&c); err != nil {
+		log.Fatalf("error getting env: %s", err)
+	}
+})
+
 func cloudDeployInteractions(ctx context.Context, e event.Event) error {
+	loadConfig()
 	log.Printf("Deploy trigger function invoked")
+	failures := failure.NewHandler("cloudDeployInteractions", c.ProjectId, c.DeadLetterTopicID)
 	// Parse the Pub/Sub message data
-	var msg MessagePublishedData
-	if err := e.DataAs(&msg); err != nil {
-		return fmt.Errorf("event.DataAs: %w", err)
+	msg, err := events.Decode(e)
+	if err != nil {
+		return failures.Handle(ctx, e.Data(), failure.NewPermanent(err))
 	}
-	// Unmarshal the Command Data
+	// Unmarshal and validate the Command Data
 	log.Printf("Converting Byte to Struct Object")
-	var c DeployCommand
-	if err := json.Unmarshal(msg.Message.Data, &c); err != nil {
-		log.Printf("Failed to unmarshal to command, assuming bad command")
-		return nil
-	}
-
-	// Create a new Cloud Deploy client
-	deployClient, err := deploy.NewCloudDeployClient(ctx)
+	cmd, req, err := command.Parse(msg.Message.Data)
 	if err != nil {
-		return fmt.Errorf("error creating Cloud Deploy client: %v", err)
+		// Always a *command.ValidationError, so it's dead-lettered
+		return failures.Handle(ctx, msg.Message.Data, err, "messageId", msg.Message.MessageID)
 	}
-	defer deployClient.Close()
+	log.Printf("Received %s command %s from %s", cmd.Type, cmd.CorrelationID, cmd.Issuer)
+	attrs := []any{"type", cmd.Type, "correlationId", cmd.CorrelationID, "issuer", cmd.Issuer, "messageId", msg.Message.MessageID}
 
-	// Depending on how big this list get's we should probably
-	// make a dictionary style object with this mapping. But for now here we are
-	switch c.Commmand {
-	case "CreateRelease":
-		if err := cdCreateRelease(ctx, *deployClient, &c.CreateRelease); err != nil {
-			_ = fmt.Errorf("create release failed: %v", err)
-			return nil
-		}
-	case "CreateRollout":
-		if err := cdCreateRollout(ctx, *deployClient, &c.CreateRollout); err != nil {
-			_ = fmt.Errorf("create rollout failed: %v", err)
-			return nil
-		}
-	case "ApproveRollout":
-		if err := cdApproveRollout(ctx, *deployClient, &c.ApproveRollout); err != nil {
-			_ = fmt.Errorf("approve rollout failed: %v", err)
-			return nil
-		}
+	h, ok := handlers[cmd.Type]
+	if !ok {
+		// Parse knows the type but this build of the function doesn't
+		err := failure.NewPermanent(fmt.Errorf("no handler for command type %q", cmd.Type))
+		return failures.Handle(ctx, msg.Message.Data, err, attrs...)
 	}
-	return nil
-}
 
-func cdCreateRelease(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.CreateReleaseRequest) error {
-	releaseOp, err := d.CreateRelease(ctx, c)
+	// Create a new Cloud Deploy client
+	deployClient, err := deployclient.New(ctx)
 	if err != nil {
-		return fmt.Errorf("error creating release request: %v", err)
+		return failures.Handle(ctx, msg.Message.Data, fmt.Errorf("error creating Cloud Deploy client: %w", err), attrs...)
 	}
-	log.Printf("Created release operation: %s", releaseOp.Name())
+	defer deployClient.Close()
 
-	_, err = releaseOp.Wait(ctx)
+	// Make sure each command only reaches Cloud Deploy once
+	store, err := newStore(ctx)
 	if err != nil {
-		return fmt.Errorf("error on release operation: %v", err)
+		return failures.Handle(ctx, msg.Message.Data, fmt.Errorf("error creating dedup store: %w", err), attrs...)
 	}
-	log.Printf("Create Release Operation Completed")
-	return nil
-}
-
-func cdCreateRollout(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.CreateRolloutRequest) error {
-	rollout, err := d.CreateRollout(ctx, c)
+	defer store.Close()
+	key := dedupKey(cmd)
+	rec, claimed, err := store.Claim(ctx, key, Record{
+		CorrelationID: cmd.CorrelationID,
+		Type:          string(cmd.Type),
+		Issuer:        cmd.Issuer,
+		MessageID:     msg.Message.MessageID,
+		Started:       time.Now().UTC(),
+	})
 	if err != nil {
-		return fmt.Errorf("error creating rollout request: %v", err)
+		return failures.Handle(ctx, msg.Message.Data, err, attrs...)
 	}
-	log.Printf("Created Rollout Request: %v", rollout.Name())
-	_, err = rollout.Wait(ctx)
-	if err != nil {
-		return fmt.Errorf("error on rollout operation: %v", err)
+	if !claimed {
+		log.Printf("Skipping %s command %s, already %s by message %s", cmd.Type, cmd.CorrelationID, rec.Outcome, rec.MessageID)
+		return nil
 	}
-	log.Printf("Create Rollout Operation Completed")
-	return nil
-}
 
-func cdApproveRollout(ctx context.Context, d deploy.CloudDeployClient, c *deploypb.ApproveRolloutRequest) error {
-	_, err := d.ApproveRollout(ctx, c)
-	if err != nil {
-		return fmt.Errorf("error approving rollout request operation: %v", err)
+	hctx := context.WithValue(context.WithValue(ctx, issuerKey{}, cmd.Issuer), correlationKey{}, cmd.CorrelationID)
+	done, cmdErr := h(hctx, *deployClient, req)
+	outcome := Succeeded
+	if cmdErr != nil {
+		outcome = Failed
+		if failure.Classify(cmdErr) == failure.Transient {
+			// Let the redelivery claim the command again
+			outcome = Retrying
+		}
+	}
+	if err := store.Finish(ctx, key, outcome, cmdErr); err != nil {
+		log.Printf("Failed to record outcome: %v", err)
+	}
+	if outcome != Retrying {
+		if err := recordCommand(ctx, key, cmd, done, cmdErr); err != nil {
+			log.Printf("Failed to record history: %v", err)
+		}
+	}
+	if cmdErr != nil {
+		return failures.Handle(ctx, msg.Message.Data, fmt.Errorf("%s command failed: %w", cmd.Type, cmdErr), attrs...)
 	}
-	log.Printf("Approved Rollout")
+	log.Printf("%s command %s completed", cmd.Type, cmd.CorrelationID)
 	return nil
 }
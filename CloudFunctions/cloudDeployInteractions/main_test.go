@@ -0,0 +1,244 @@
+package example
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	deploy "cloud.google.com/go/deploy/apiv1"
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"example.com/shared/command"
+	"example.com/shared/deployclient"
+	"example.com/shared/events"
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// TestMain sets the required environment before the config is loaded;
+// tests set anything else they need on c.
+func TestMain(m *testing.M) {
+	for k, v := range map[string]string{
+		"PROJECTID":         "p",
+		"LOCATION":          "l",
+		"DEADLETTERTOPICID": "dead-letter",
+	} {
+		os.Setenv(k, v)
+	}
+	loadConfig()
+	os.Exit(m.Run())
+}
+
+// newDeadLetters fakes Pub/Sub with the dead-letter topic and returns it,
+// with in-memory stores for everything else.
+func newDeadLetters(t *testing.T) *pstest.Server {
+	t.Helper()
+	ps := pstest.NewServer()
+	t.Cleanup(func() { ps.Close() })
+	t.Setenv("PUBSUB_EMULATOR_HOST", ps.Addr)
+	client, err := pubsub.NewClient(context.Background(), "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.CreateTopic(context.Background(), "dead-letter"); err != nil {
+		t.Fatal(err)
+	}
+	saved := c
+	t.Cleanup(func() { c = saved })
+	c.DedupStore, c.FreezeStore, c.HistoryStore = "memory", "memory", "memory"
+	return ps
+}
+
+// commandEvent is the Pub/Sub message carrying a command of type typ.
+func commandEvent(t *testing.T, typ command.Type, req command.Payload) event.Event {
+	t.Helper()
+	cmd, err := command.New(typ, "test", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := event.New()
+	e.SetID(cmd.CorrelationID)
+	e.SetType("google.cloud.pubsub.topic.v1.messagePublished")
+	e.SetSource("//pubsub.googleapis.com/projects/p/topics/deploy-commands")
+	err = e.SetData(event.ApplicationJSON, events.MessagePublishedData{Message: events.PubsubMessage{
+		MessageID:   cmd.CorrelationID,
+		PublishTime: time.Now().UTC(),
+		Data:        data,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// deployed promotes release through dev, which leaves its prod rollout
+// waiting for approval, and returns the two rollouts.
+func deployed(t *testing.T, d *deploy.CloudDeployClient, release string) (dev, prod string) {
+	t.Helper()
+	ctx := context.Background()
+	for _, target := range []string{"dev", "prod"} {
+		if _, err := cdPromoteRelease(ctx, *d, &deploypb.CreateRolloutRequest{Parent: release}); err != nil {
+			t.Fatalf("promoting %s to %s: %v", release, target, err)
+		}
+	}
+	return release + "/rollouts/" + releaseID(release) + "-to-dev-0001", release + "/rollouts/" + releaseID(release) + "-to-prod-0001"
+}
+
+func releaseID(release string) string {
+	return release[len(testPipeline+"/releases/"):]
+}
+
+type outcome string
+
+const (
+	acked        outcome = "acked"
+	retried      outcome = "retried"
+	deadLettered outcome = "dead-lettered"
+)
+
+func TestCloudDeployInteractions(t *testing.T) {
+	const release = testPipeline + "/releases/r1"
+	const dev, prod = release + "/rollouts/r1-to-dev-0001", release + "/rollouts/r1-to-prod-0001"
+	for _, tt := range []struct {
+		name string
+		typ  command.Type
+		req  command.Payload
+		// setup runs after r1 went to dev and waits for approval on prod
+		setup func(t *testing.T, d *deploy.CloudDeployClient)
+		want  outcome
+	}{
+		{name: "create release", typ: command.CreateRelease, want: acked,
+			req: &deploypb.CreateReleaseRequest{Parent: testPipeline, ReleaseId: "r2", Release: &deploypb.Release{}}},
+		{name: "create an existing release", typ: command.CreateRelease, want: acked,
+			req: &deploypb.CreateReleaseRequest{Parent: testPipeline, ReleaseId: "r1", Release: &deploypb.Release{}}},
+		{name: "create release of a missing pipeline", typ: command.CreateRelease, want: deadLettered,
+			req: &deploypb.CreateReleaseRequest{Parent: testPipeline + "-gone", ReleaseId: "r2", Release: &deploypb.Release{}}},
+		{name: "create rollout", typ: command.CreateRollout, want: acked,
+			req: &deploypb.CreateRolloutRequest{Parent: release, RolloutId: "r1-to-dev-again", Rollout: &deploypb.Rollout{TargetId: "dev"}}},
+		{name: "create rollout with a taken ID", typ: command.CreateRollout, want: deadLettered,
+			req: &deploypb.CreateRolloutRequest{Parent: release, RolloutId: "r1-to-dev-0001", Rollout: &deploypb.Rollout{TargetId: "dev"}}},
+		{name: "create rollout of a missing release", typ: command.CreateRollout, want: deadLettered,
+			req: &deploypb.CreateRolloutRequest{Parent: testPipeline + "/releases/gone", RolloutId: "gone-to-dev", Rollout: &deploypb.Rollout{TargetId: "dev"}}},
+		{name: "create rollout to a target outside the pipeline", typ: command.CreateRollout, want: deadLettered,
+			req: &deploypb.CreateRolloutRequest{Parent: release, RolloutId: "r1-to-staging", Rollout: &deploypb.Rollout{TargetId: "staging"}}},
+		{name: "promote release", typ: command.PromoteRelease, want: acked,
+			req: &deploypb.CreateRolloutRequest{Parent: release, Rollout: &deploypb.Rollout{TargetId: "dev"}}},
+		{name: "promote release past the last stage", typ: command.PromoteRelease, want: deadLettered,
+			req: &deploypb.CreateRolloutRequest{Parent: release},
+			setup: func(t *testing.T, d *deploy.CloudDeployClient) {
+				if _, err := d.ApproveRollout(context.Background(), &deploypb.ApproveRolloutRequest{Name: prod, Approved: true}); err != nil {
+					t.Fatal(err)
+				}
+			}},
+		{name: "promote release with a taken rollout ID", typ: command.PromoteRelease, want: deadLettered,
+			req: &deploypb.CreateRolloutRequest{Parent: release, RolloutId: "r1-to-dev-0001", Rollout: &deploypb.Rollout{TargetId: "dev"}}},
+		{name: "approve rollout", typ: command.ApproveRollout, want: acked,
+			req: &deploypb.ApproveRolloutRequest{Name: prod, Approved: true}},
+		{name: "approve rollout not waiting for approval", typ: command.ApproveRollout, want: deadLettered,
+			req: &deploypb.ApproveRolloutRequest{Name: dev, Approved: true}},
+		{name: "approve missing rollout", typ: command.ApproveRollout, want: deadLettered,
+			req: &deploypb.ApproveRolloutRequest{Name: release + "/rollouts/gone", Approved: true}},
+		{name: "cancel rollout", typ: command.CancelRollout, want: acked,
+			req: &deploypb.CancelRolloutRequest{Name: prod}},
+		{name: "cancel missing rollout", typ: command.CancelRollout, want: deadLettered,
+			req: &deploypb.CancelRolloutRequest{Name: release + "/rollouts/gone"}},
+		{name: "roll back", typ: command.RollbackTarget, want: acked,
+			req: &deploypb.RollbackTargetRequest{Name: testPipeline, TargetId: "dev", RolloutId: "rollback-1"},
+			setup: func(t *testing.T, d *deploy.CloudDeployClient) {
+				deployed(t, d, createRelease(t, d, "r2"))
+			}},
+		{name: "roll back with nothing to go back to", typ: command.RollbackTarget, want: deadLettered,
+			req: &deploypb.RollbackTargetRequest{Name: testPipeline, TargetId: "dev", RolloutId: "rollback-1"}},
+		{name: "abandon release", typ: command.AbandonRelease, want: acked,
+			req: &deploypb.AbandonReleaseRequest{Name: release}},
+		{name: "abandon missing release", typ: command.AbandonRelease, want: deadLettered,
+			req: &deploypb.AbandonReleaseRequest{Name: testPipeline + "/releases/gone"}},
+		{name: "lift freeze", typ: command.LiftFreeze, want: acked,
+			req: &command.LiftFreezeRequest{Freeze: "christmas", Reason: "hotfix"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newDeadLetters(t)
+			ds, d := newDeploy(t)
+			deployed(t, d, createRelease(t, d, "r1"))
+			if tt.setup != nil {
+				tt.setup(t, d)
+			}
+			before := len(ds.Calls())
+
+			err := cloudDeployInteractions(context.Background(), commandEvent(t, tt.typ, tt.req))
+			got := acked
+			switch {
+			case err != nil:
+				got = retried
+			case len(ps.Messages()) > 0:
+				got = deadLettered
+			}
+			if got != tt.want {
+				t.Errorf("cloudDeployInteractions = %v with %d dead letter(s): %s, want %s", err, len(ps.Messages()), got, tt.want)
+			}
+			if len(ds.Calls()) == before && tt.typ != command.LiftFreeze {
+				t.Errorf("the command didn't reach Cloud Deploy")
+			}
+		})
+	}
+}
+
+func TestCloudDeployInteractionsRetries(t *testing.T) {
+	ps := newDeadLetters(t)
+	ds, d := newDeploy(t)
+	_, prod := deployed(t, d, createRelease(t, d, "r1"))
+	e := commandEvent(t, command.ApproveRollout, &deploypb.ApproveRolloutRequest{Name: prod, Approved: true})
+
+	// Cloud Deploy being unreachable is worth retrying
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis.Close()
+	t.Setenv(deployclient.EmulatorHostEnv, lis.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := cloudDeployInteractions(ctx, e); err == nil {
+		t.Fatal("cloudDeployInteractions with Cloud Deploy down succeeded, want it retried")
+	}
+	if n := len(ps.Messages()); n != 0 {
+		t.Errorf("dead-lettered %d message(s), want the command retried", n)
+	}
+
+	// ...and the redelivery isn't taken for a duplicate
+	t.Setenv(deployclient.EmulatorHostEnv, ds.Addr)
+	if err := cloudDeployInteractions(context.Background(), e); err != nil {
+		t.Fatalf("redelivery: %v", err)
+	}
+	r, err := d.GetRollout(context.Background(), &deploypb.GetRolloutRequest{Name: prod})
+	if err != nil || r.ApprovalState != deploypb.Rollout_APPROVED {
+		t.Errorf("rollout after the redelivery = %v, %v, want it approved", r, err)
+	}
+	// A second redelivery is one
+	before := len(ds.Calls())
+	if err := cloudDeployInteractions(context.Background(), e); err != nil || len(ds.Calls()) != before {
+		t.Errorf("second redelivery = %v after %d call(s), want it skipped", err, len(ds.Calls())-before)
+	}
+}
+
+func TestCloudDeployInteractionsInvalid(t *testing.T) {
+	ps := newDeadLetters(t)
+	e := event.New()
+	e.SetID("bad")
+	e.SetType("google.cloud.pubsub.topic.v1.messagePublished")
+	e.SetSource("//pubsub.googleapis.com/projects/p/topics/deploy-commands")
+	if err := e.SetData(event.ApplicationJSON, events.MessagePublishedData{Message: events.PubsubMessage{MessageID: "bad", Data: []byte(`{"schemaVersion": "v0"}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cloudDeployInteractions(context.Background(), e); err != nil || len(ps.Messages()) != 1 {
+		t.Errorf("cloudDeployInteractions of an invalid command = %v with %d dead letter(s), want it dead-lettered", err, len(ps.Messages()))
+	}
+}
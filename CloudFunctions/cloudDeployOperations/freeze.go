@@ -0,0 +1,149 @@
+package example
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/command"
+	"example.com/shared/freeze"
+	"example.com/shared/jira"
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+func freezes() freeze.Config {
+	return freeze.Config{
+		Inline:     c.FreezeCalendar,
+		URI:        c.FreezeCalendarURI,
+		Refresh:    c.FreezeCalendarRefresh,
+		Store:      c.FreezeStore,
+		ProjectID:  c.ProjectId,
+		Database:   c.FirestoreDatabase,
+		Collection: c.FreezeCollection,
+	}
+}
+
+// holdPromotion handles a promotion onto a frozen stage. A deferring
+// freeze parks the PromoteRelease command for resumeDeferred to send once
+// the freeze ends or is lifted; a rejecting one drops it, leaving the
+// release to be promoted by hand. Either way the release's issue is told,
+// once.
+func holdPromotion(ctx context.Context, a OperationsData, messageID string, stage *deploypb.Stage, o *freeze.Occurrence) error {
+	why := describeFreeze(stage.TargetId, o)
+	if o.Freeze.ModeOrDefault() == freeze.Reject {
+		log.Printf("Not promoting release %s, %s", a.ReleaseId, why)
+		commentOnRelease(ctx, a, fmt.Sprintf("Release %s was not promoted to %s: %s. Promote it by hand once the freeze is over.",
+			a.ReleaseId, stage.TargetId, why))
+		return nil
+	}
+
+	cmd, err := promoteCommand(a, messageID, stage)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return fmt.Errorf("error encoding command: %w", err)
+	}
+	issueKey, err := issueKeyForRelease(ctx, a)
+	if err != nil {
+		return err
+	}
+	store, err := freezes().OpenStore(ctx)
+	if err != nil {
+		return fmt.Errorf("error creating freeze store: %w", err)
+	}
+	defer store.Close()
+	first, err := store.Defer(ctx, freeze.DeferralKey(string(cmd.Type), cmd.CorrelationID), freeze.Deferral{
+		Freeze:   o.Freeze.Name,
+		Target:   stage.TargetId,
+		Action:   fmt.Sprintf("promotion of release %s to %s", a.ReleaseId, stage.TargetId),
+		Command:  data,
+		IssueKey: issueKey,
+		Until:    o.End,
+		Created:  time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	if !first {
+		log.Printf("Promotion of release %s to %s was already deferred", a.ReleaseId, stage.TargetId)
+		return nil
+	}
+	log.Printf("Deferred promotion of release %s, %s", a.ReleaseId, why)
+	commentOnRelease(ctx, a, fmt.Sprintf("Promotion of release %s to %s is deferred: %s. It will go ahead once the freeze ends or is lifted.",
+		a.ReleaseId, stage.TargetId, why))
+	return nil
+}
+
+// resumeDeferred runs on a schedule, sending every deferred command whose
+// target isn't frozen any more. Failures are left for the next run.
+func resumeDeferred(ctx context.Context, e event.Event) error {
+	loadConfig()
+	log.Printf("Resume deferred function invoked")
+	cfg := freezes()
+	store, err := cfg.OpenStore(ctx)
+	if err != nil {
+		return fmt.Errorf("error creating freeze store: %w", err)
+	}
+	defer store.Close()
+	pending, err := store.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for key, d := range pending {
+		o, err := cfg.Check(ctx, d.Target, now)
+		if err != nil {
+			log.Printf("Failed to check freezes on %s: %v", d.Target, err)
+			continue
+		}
+		if o != nil {
+			continue
+		}
+		var cmd command.Envelope
+		if err := json.Unmarshal(d.Command, &cmd); err != nil {
+			// It will never decode, so don't try again
+			log.Printf("Dropping %s, its command doesn't decode: %v", d.Action, err)
+			if err := store.Resumed(ctx, key); err != nil {
+				log.Printf("Failed to record %s as resumed: %v", d.Action, err)
+			}
+			continue
+		}
+		// The command keeps its correlation ID, so sending it twice is harmless
+		if _, err := command.NewPublisher(c.ProjectId, c.SendTopicID).Publish(ctx, &cmd); err != nil {
+			log.Printf("Failed to resume %s: %v", d.Action, err)
+			continue
+		}
+		if err := store.Resumed(ctx, key); err != nil {
+			log.Printf("Failed to record %s as resumed: %v", d.Action, err)
+		}
+		log.Printf("Resumed %s after freeze %s", d.Action, d.Freeze)
+		if d.IssueKey != "" {
+			text := fmt.Sprintf("Freeze %q on %s is over, going ahead with the %s.", d.Freeze, d.Target, d.Action)
+			if err := jira.NewClient(c.JiraURL, c.JiraEmail, c.JiraToken).AddComment(ctx, d.IssueKey, text); err != nil {
+				log.Printf("Failed to comment on %s: %v", d.IssueKey, err)
+			}
+		}
+	}
+	return nil
+}
+
+// commentOnRelease comments on the release's issue, if it has one. Jira
+// being unavailable only gets logged.
+func commentOnRelease(ctx context.Context, a OperationsData, text string) {
+	key, err := issueKeyForRelease(ctx, a)
+	if err == nil && key != "" {
+		err = jira.NewClient(c.JiraURL, c.JiraEmail, c.JiraToken).AddComment(ctx, key, text)
+	}
+	if err != nil {
+		log.Printf("Failed to comment on the issue of release %s: %v", a.ReleaseId, err)
+	}
+}
+
+func describeFreeze(target string, o *freeze.Occurrence) string {
+	return fmt.Sprintf("%s is frozen by %q until %s (%s)", target, o.Freeze.Name, o.End.UTC().Format(time.RFC3339), o.Freeze.Reason)
+}
@@ -0,0 +1,145 @@
+package example
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/deployclient"
+	"example.com/shared/history"
+	"example.com/shared/provenance"
+)
+
+func historyConfig() history.Config {
+	return history.Config{
+		Store:      c.HistoryStore,
+		ProjectID:  c.ProjectId,
+		Database:   c.FirestoreDatabase,
+		Collection: c.HistoryCollection,
+	}
+}
+
+// Rollout actions and the state they leave the rollout in
+var rolloutStates = map[string]string{
+	"Start":   history.InProgress,
+	"Succeed": history.Succeeded,
+	"Failure": history.Failed,
+	"Cancel":  history.Cancelled,
+}
+
+// recordRollout appends a rollout's state change to the history, with the
+// commit it deploys, when that was committed and what it rolls back, for
+// the DORA metrics. messageID keeps redeliveries from recording it twice.
+func recordRollout(ctx context.Context, a OperationsData, messageID string) error {
+	state, ok := rolloutStates[a.Action]
+	if a.ResourceType != "Rollout" || !ok {
+		return nil
+	}
+	r := history.Record{
+		ID:       history.Key("operations", messageID),
+		Kind:     history.RolloutState,
+		At:       time.Now().UTC(),
+		Pipeline: a.DeliveryPipelineId,
+		Release:  a.ReleaseId,
+		Target:   a.TargetId,
+		Rollout:  a.RolloutId,
+		State:    state,
+	}
+	deployClient, err := deployclient.New(ctx)
+	if err != nil {
+		return fmt.Errorf("error creating Cloud Deploy client: %w", err)
+	}
+	defer deployClient.Close()
+	release, err := deployClient.GetRelease(ctx, &deploypb.GetReleaseRequest{Name: releaseName(a)})
+	if err != nil {
+		// Still worth recording without the commit
+		log.Printf("Failed to get release %s for its commit: %v", a.ReleaseId, err)
+	} else {
+		r.Commit = release.Annotations[provenance.CommitSha]
+		if t, err := time.Parse(time.RFC3339, release.Annotations[provenance.CommitTime]); err == nil {
+			r.CommitTime = t
+		}
+	}
+	rollout, err := deployClient.GetRollout(ctx, &deploypb.GetRolloutRequest{Name: releaseName(a) + "/rollouts/" + a.RolloutId})
+	if err != nil {
+		log.Printf("Failed to get rollout %s to tell if it's a rollback: %v", a.RolloutId, err)
+	} else if rollout.RollbackOfRollout != "" {
+		r.RollbackOf = rollout.RollbackOfRollout[strings.LastIndex(rollout.RollbackOfRollout, "/")+1:]
+	}
+	return historyConfig().Append(ctx, r)
+}
+
+// deployHistory answers queries on the deployment history:
+//
+//	GET /?pipeline=&target=&release=&kind=&state=&limit=  matching records, newest first
+//	GET /current?target=X[&pipeline=]                    the rollout running on target X
+func deployHistory(w http.ResponseWriter, r *http.Request) {
+	loadConfig()
+	log.Printf("Deploy history function invoked")
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	ctx := r.Context()
+	store, err := historyConfig().Open(ctx)
+	if err != nil {
+		log.Printf("Error creating history store: %v", err)
+		http.Error(w, "error reading history", http.StatusInternalServerError)
+		return
+	}
+	defer store.Close()
+
+	if strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/current") {
+		if q.Get("target") == "" {
+			http.Error(w, "target is required", http.StatusBadRequest)
+			return
+		}
+		current, err := history.Current(ctx, store, q.Get("pipeline"), q.Get("target"))
+		if err != nil {
+			log.Printf("Error reading history: %v", err)
+			http.Error(w, "error reading history", http.StatusInternalServerError)
+			return
+		}
+		if current == nil {
+			http.Error(w, "nothing has been deployed to that target", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, current)
+		return
+	}
+
+	f := history.Filter{
+		Pipeline: q.Get("pipeline"),
+		Release:  q.Get("release"),
+		Target:   q.Get("target"),
+		Kind:     history.Kind(q.Get("kind")),
+		State:    q.Get("state"),
+	}
+	if l := q.Get("limit"); l != "" {
+		if f.Limit, err = strconv.Atoi(l); err != nil || f.Limit < 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	records, err := store.Query(ctx, f)
+	if err != nil {
+		log.Printf("Error reading history: %v", err)
+		http.Error(w, "error reading history", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string][]history.Record{"records": records})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
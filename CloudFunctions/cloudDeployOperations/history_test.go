@@ -0,0 +1,77 @@
+package example
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"example.com/shared/history"
+)
+
+func TestDeployHistory(t *testing.T) {
+	c.HistoryStore = "memory"
+	ctx := context.Background()
+	at := time.Date(2026, 6, 2, 9, 0, 0, 0, time.UTC)
+	for i, r := range []history.Record{
+		{Kind: history.RolloutState, Pipeline: "web", Release: "r1", Target: "dev", State: history.Succeeded},
+		{Kind: history.RolloutState, Pipeline: "web", Release: "r1", Target: "prod", State: history.Succeeded},
+		{Kind: history.RolloutState, Pipeline: "web", Release: "r2", Target: "prod", State: history.InProgress},
+		{Kind: history.Approval, Pipeline: "web", Release: "r2", Target: "prod", Decision: "approve"},
+		{Kind: history.RolloutState, Pipeline: "api", Release: "r1", Target: "prod", State: history.Succeeded},
+	} {
+		r.At = at.Add(time.Duration(i) * time.Minute)
+		if err := historyConfig().Append(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		query string
+		// releases of the records returned, newest first
+		want []string
+	}{
+		{query: "?pipeline=web&target=prod", want: []string{"r2", "r2", "r1"}},
+		{query: "?release=r1&target=prod", want: []string{"r1", "r1"}},
+		{query: "?pipeline=web&release=r1&target=prod&kind=rollout&state=SUCCEEDED", want: []string{"r1"}},
+		{query: "?kind=approval", want: []string{"r2"}},
+		{query: "?pipeline=web&limit=2", want: []string{"r2", "r2"}},
+	} {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			deployHistory(w, httptest.NewRequest(http.MethodGet, "/"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			var resp struct{ Records []history.Record }
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range resp.Records {
+				got = append(got, r.Release)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("releases %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	deployHistory(w, httptest.NewRequest(http.MethodGet, "/current?pipeline=web&target=prod", nil))
+	var current history.Record
+	if err := json.Unmarshal(w.Body.Bytes(), &current); err != nil || current.Release != "r1" || current.Pipeline != "web" {
+		t.Errorf("current = %s, want web's r1", w.Body)
+	}
+
+	for _, query := range []string{"/?limit=-1", "/current"} {
+		w := httptest.NewRecorder()
+		deployHistory(w, httptest.NewRequest(http.MethodGet, query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
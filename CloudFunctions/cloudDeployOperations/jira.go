@@ -0,0 +1,111 @@
+package example
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/deployclient"
+	"example.com/shared/failure"
+	"example.com/shared/jira"
+)
+
+// Resource types and actions we mirror onto the release's Jira issue
+var (
+	trackedResources = map[string]bool{"Release": true, "Rollout": true, "JobRun": true}
+	trackedActions   = map[string]bool{"Start": true, "Succeed": true, "Failure": true, "Cancel": true}
+)
+
+// updateJiraIssue comments on the Jira issue linked to the event's release
+// and, if JIRA_TRANSITIONS maps "<ResourceType>.<Action>" to a status,
+// moves the issue there.
+func updateJiraIssue(ctx context.Context, a OperationsData) error {
+	if !trackedResources[a.ResourceType] || !trackedActions[a.Action] {
+		return nil
+	}
+	key, err := issueKeyForRelease(ctx, a)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		log.Printf("Release %s has no Jira issue, skipping", a.ReleaseId)
+		return nil
+	}
+
+	client := jira.NewClient(c.JiraURL, c.JiraEmail, c.JiraToken)
+	if err := client.AddComment(ctx, key, describeEvent(a)); err != nil {
+		return err
+	}
+	log.Printf("Commented on %s", key)
+
+	transitions, err := jira.ParseTransitions(c.JiraTransitions)
+	if err != nil {
+		return failure.NewPermanent(fmt.Errorf("error parsing JIRA_TRANSITIONS: %w", err))
+	}
+	status, ok := transitions[a.ResourceType+"."+a.Action]
+	if !ok {
+		return nil
+	}
+	err = client.TransitionTo(ctx, key, status)
+	if errors.Is(err, jira.ErrNoTransition) {
+		// Usually the issue is already there (or past it), so don't fail the event
+		log.Printf("Not moving %s: %v", key, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Moved %s to %q", key, status)
+	return nil
+}
+
+// issueKeyForRelease reads the Jira issue key createRelease annotated the
+// release with. Empty means the release isn't tracked in Jira.
+func issueKeyForRelease(ctx context.Context, a OperationsData) (string, error) {
+	deployClient, err := deployclient.New(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error creating Cloud Deploy client: %w", err)
+	}
+	defer deployClient.Close()
+
+	release, err := deployClient.GetRelease(ctx, &deploypb.GetReleaseRequest{
+		Name: releaseName(a),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error getting release: %w", err)
+	}
+	return release.Annotations[jira.IssueKeyAnnotation], nil
+}
+
+func releaseName(a OperationsData) string {
+	return fmt.Sprintf("%s/releases/%s", pipelineName(a), a.ReleaseId)
+}
+
+func describeEvent(a OperationsData) string {
+	var subject string
+	switch a.ResourceType {
+	case "Release":
+		subject = fmt.Sprintf("Release %s", a.ReleaseId)
+	case "Rollout":
+		subject = fmt.Sprintf("Rollout %s", a.RolloutId)
+	case "JobRun":
+		subject = fmt.Sprintf("Job run %s of rollout %s", a.JobRunId, a.RolloutId)
+	}
+	if a.TargetId != "" {
+		subject += fmt.Sprintf(" to %s", a.TargetId)
+	}
+	var outcome string
+	switch a.Action {
+	case "Start":
+		outcome = "started"
+	case "Succeed":
+		outcome = "succeeded"
+	case "Failure":
+		outcome = "failed"
+	case "Cancel":
+		outcome = "was cancelled"
+	}
+	return fmt.Sprintf("%s %s in pipeline %s.\n%s", subject, outcome, a.DeliveryPipelineId, a.Resource)
+}
@@ -2,13 +2,14 @@ package example
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
-	"cloud.google.com/go/deploy/apiv1/deploypb"
-	"cloud.google.com/go/pubsub"
+	"example.com/shared/events"
+	"example.com/shared/failure"
+	"example.com/shared/once"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/cloudevents/sdk-go/v2/event"
 	"github.com/codingconcepts/env"
@@ -19,28 +20,51 @@ type config struct {
 	ProjectId   string `env:"PROJECTID" required:"true"`
 	Location    string `env:"LOCATION" required:"true"`
 	SendTopicID string `env:"SENDTOPICID" required:"true"`
-}
-
-type PubsubMessage struct {
-	// Data is the payload of the message.
-	Data []byte `json:"data"`
-
-	// Attributes are optional attributes that can be used to. For example,
-	// to. For example, to provide metadata, or to. For example, to filter messages.
-	Attributes OperationsData `json:"attributes"`
-
-	// MessageId is the ID of the message generated by the server.
-	MessageID string `json:"messageId"`
-
-	// PublishTime is the time at which the message was published.
-	PublishTime time.Time `json:"publishTime"`
-
-	// OrderingKey is the ordering key of the message.
-	OrderingKey string `json:"orderingKey"`
-}
-
-type Message struct {
-	Message PubsubMessage `json:"message"`
+	// Events that can never be processed are parked here
+	DeadLetterTopicID string `env:"DEADLETTERTOPICID" required:"true"`
+
+	// Jira issue updates for release/rollout/job run events
+	JiraURL   string `env:"JIRA_URL" required:"true"`
+	JiraEmail string `env:"JIRA_EMAIL" required:"true"`
+	JiraToken string `env:"JIRA_API_TOKEN" required:"true"`
+	// Comma separated ResourceType.Action=Status pairs
+	JiraTransitions string `env:"JIRA_TRANSITIONS" default:"Rollout.Start=In Progress,Rollout.Succeed=Deployed,Rollout.Failure=Failed"`
+
+	// Releases are promoted through the pipeline's stages automatically,
+	// except onto stages whose target is listed here (comma separated) or
+	// that use the manual Skaffold profile
+	ManualStages  string `env:"MANUAL_STAGES"`
+	ManualProfile string `env:"MANUAL_PROFILE" default:"manual"`
+
+	// Freeze calendar as inline JSON, or where to load it from, see
+	// freeze.Calendar, and where lifts and deferred promotions are kept
+	FreezeCalendar        string        `env:"FREEZE_CALENDAR"`
+	FreezeCalendarURI     string        `env:"FREEZE_CALENDAR_URI"`
+	FreezeCalendarRefresh time.Duration `env:"FREEZE_CALENDAR_REFRESH" default:"1m"`
+	FreezeStore           string        `env:"FREEZE_STORE" default:"firestore"`
+	FirestoreDatabase     string        `env:"FIRESTORE_DATABASE" default:"(default)"`
+	FreezeCollection      string        `env:"FREEZE_COLLECTION" default:"deploy-freezes"`
+
+	// Where rollout state changes are recorded, and deployHistory reads
+	// from, "firestore", "memory" or "file:<path>"
+	HistoryStore      string `env:"HISTORY_STORE" default:"firestore"`
+	HistoryCollection string `env:"HISTORY_COLLECTION" default:"deploy-history"`
+
+	// Automatic rollback policy as inline JSON, or where to load it from,
+	// see RollbackPolicy
+	RollbackPolicy        string        `env:"ROLLBACK_POLICY"`
+	RollbackPolicyURI     string        `env:"ROLLBACK_POLICY_URI"`
+	RollbackPolicyRefresh time.Duration `env:"ROLLBACK_POLICY_REFRESH" default:"1m"`
+
+	// Where the side effects already done for an event are claimed, so
+	// redeliveries don't repeat them, "firestore" or "memory"
+	OnceStore      string `env:"ONCE_STORE" default:"firestore"`
+	OnceCollection string `env:"ONCE_COLLECTION" default:"deploy-once"`
+
+	// Rolling windows doraMetrics reports on by default, and how many
+	// rollout records it reads at most
+	DoraWindows    string `env:"DORA_WINDOWS" default:"1d,7d,30d"`
+	DoraMaxRecords int    `env:"DORA_MAX_RECORDS" default:"10000"`
 }
 
 type OperationsData struct {
@@ -52,85 +76,97 @@ type OperationsData struct {
 	ProjectNumber      string `json:"ProjectNumber"`
 	ReleaseId          string `json:"ReleaseId"`
 	RolloutId          string `json:"RolloutId"`
-}
-
-type CommandMessage struct {
-	Commmand      string                        `json:"command"`
-	CreateRollout deploypb.CreateRolloutRequest `json:"createRolloutRequest"`
+	TargetId           string `json:"TargetId"`
+	JobRunId           string `json:"JobRunId"`
 }
 
 var c config
 
+// sideEffects claims the Jira updates and chat posts of a message, so
+// redeliveries after a later step fails don't repeat them.
+func sideEffects() once.Config {
+	return once.Config{
+		Store:      c.OnceStore,
+		ProjectID:  c.ProjectId,
+		Database:   c.FirestoreDatabase,
+		Collection: c.OnceCollection,
+	}
+}
+
 func init() {
 	functions.CloudEvent("cloudDeployOperations", cloudDeployOperations)
+	functions.CloudEvent("resumeDeferred", resumeDeferred)
+	functions.HTTP("deployHistory", deployHistory)
+	functions.HTTP("doraMetrics", doraMetrics)
+}
+
+// loadConfig reads the environment on the first invocation rather than in
+// init, so tests can set it up in TestMain first.
+var loadConfig = sync.OnceFunc(func() {
 	//Load env variables using "github.com/codingconcepts/env"
 	if err := env.Set(&c); err != nil {
-		_ = fmt.Errorf("error getting env: %s", err)
+		log.Fatalf("error getting env: %s", err)
 	}
-}
+	if err := env.Set(&notifications); err != nil {
+		log.Fatalf("error getting env: %s", err)
+	}
+})
 
 func cloudDeployOperations(ctx context.Context, e event.Event) error {
+	loadConfig()
 	log.Printf("Deploy Operations function invoked")
-	var msg Message
-	err := json.Unmarshal(e.Data(), &msg)
+	failures := failure.NewHandler("cloudDeployOperations", c.ProjectId, c.DeadLetterTopicID)
+	msg, err := events.Decode(e)
 	if err != nil {
-		// Don't return because it's probably a bad message and we need to ack
-		_ = fmt.Errorf("errored unmarshalling data: %v", err)
-		return nil
+		// It's a bad message, so it's dead-lettered and acked
+		return failures.Handle(ctx, e.Data(), failure.NewPermanent(err))
 	}
-	var a = msg.Message.Attributes
-
-	if a.ResourceType == "Release" && a.Action == "Succeed" {
-		// Create the rollout
-		log.Printf("Creating Rollout and sending to pubsub")
-		var command = CommandMessage{
-			Commmand: "CreateRollout",
-			CreateRollout: deploypb.CreateRolloutRequest{
-				Parent:    a.Resource,
-				RolloutId: a.ReleaseId,
-				Rollout: &deploypb.Rollout{
-					// TODO(GHaun): Update so this comes from the pubsub message
-					TargetId: "random-date-service",
-				},
-			},
-		}
-		err = sendCommandPubSub(ctx, &command)
-		if err != nil {
-			_ = fmt.Errorf("failed to send pubsub command: %v", err)
-			// Let's return nil to ack the bad message. Otherwise the function will rerun and fail.
-			return nil
-		}
-		log.Printf("Deployment triggered successfully")
+	var a OperationsData
+	if err := msg.Message.DecodeAttributes(&a); err != nil {
+		return failures.Handle(ctx, msg.Message.Data, failure.NewPermanent(err), "messageId", msg.Message.MessageID)
 	}
-	return nil
-}
+	attrs := []any{"messageId", msg.Message.MessageID, "resource", a.Resource, "action", a.Action}
 
-// This should be in a shared code folder
-func sendCommandPubSub(ctx context.Context, m *CommandMessage) error {
-	client, err := pubsub.NewClient(ctx, c.ProjectId)
+	// Redeliveries after a failure below mustn't repeat these
+	err = sideEffects().Do(ctx, "jira", msg.Message.MessageID, func() error { return updateJiraIssue(ctx, a) })
 	if err != nil {
-		return fmt.Errorf("pubsub.NewClient: %v", err)
+		// Jira being unavailable shouldn't hold up the deployment itself
+		log.Printf("Failed to update Jira issue: %v", err)
 	}
-	defer client.Close()
-	t := client.Topic(c.SendTopicID)
-	// Marshal the CommandMessage into a JSON byte slice
-	jsonData, err := json.Marshal(m)
+	err = sideEffects().Do(ctx, "notify", msg.Message.MessageID, func() error { return notifyRollout(ctx, a) })
 	if err != nil {
-		return fmt.Errorf("json.Marshal: %v", err)
+		// Nor should chat
+		log.Printf("Failed to notify: %v", err)
 	}
-	log.Printf("Sending message to PubSub")
-	result := t.Publish(ctx, &pubsub.Message{
-		Data: jsonData, // Use the JSON byte slice here
-	})
-	// Block until the result is returned and a server-generated
-	// ID is returned for the published message.
-	id, err := result.Get(ctx)
-	log.Printf("ID: %s, err: %v", id, err)
+	if err := recordRollout(ctx, a, msg.Message.MessageID); err != nil {
+		// Nor the history
+		log.Printf("Failed to record history: %v", err)
+	}
+
+	if err := rollBack(ctx, a); err != nil {
+		return failures.Handle(ctx, msg.Message.Data, fmt.Errorf("failed to roll back: %w", err), attrs...)
+	}
+
+	stage, err := nextStage(ctx, a)
 	if err != nil {
-		fmt.Printf("Get: %v", err)
+		return failures.Handle(ctx, msg.Message.Data, fmt.Errorf("failed to work out next stage: %w", err), attrs...)
+	}
+	if stage == nil {
 		return nil
-
 	}
-	log.Printf("Published a message; msg ID: %v\n", id)
+	frozen, err := freezes().Check(ctx, stage.TargetId, time.Now())
+	if err != nil {
+		return failures.Handle(ctx, msg.Message.Data, fmt.Errorf("failed to check freezes: %w", err), attrs...)
+	}
+	if frozen != nil {
+		if err := holdPromotion(ctx, a, msg.Message.MessageID, stage, frozen); err != nil {
+			return failures.Handle(ctx, msg.Message.Data, fmt.Errorf("failed to hold promotion: %w", err), attrs...)
+		}
+		return nil
+	}
+	if err := promote(ctx, a, msg.Message.MessageID, stage); err != nil {
+		return failures.Handle(ctx, msg.Message.Data, fmt.Errorf("failed to promote release: %w", err), attrs...)
+	}
+	log.Printf("Deployment triggered successfully")
 	return nil
 }
@@ -0,0 +1,24 @@
+package example
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain sets the required environment before the config is loaded;
+// tests set anything else they need on c.
+func TestMain(m *testing.M) {
+	for k, v := range map[string]string{
+		"PROJECTID":         "p",
+		"LOCATION":          "l",
+		"SENDTOPICID":       "commands",
+		"DEADLETTERTOPICID": "dead-letter",
+		"JIRA_URL":          "http://jira.invalid/",
+		"JIRA_EMAIL":        "bot@example.com",
+		"JIRA_API_TOKEN":    "token",
+	} {
+		os.Setenv(k, v)
+	}
+	loadConfig()
+	os.Exit(m.Run())
+}
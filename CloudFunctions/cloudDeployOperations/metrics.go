@@ -0,0 +1,82 @@
+package example
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/shared/dora"
+	"example.com/shared/history"
+)
+
+// doraMetrics serves the DORA metrics of every pipeline and target,
+// computed from the rollouts in the deployment history:
+//
+//	GET /?pipeline=&target=&windows=7d,30d  as JSON
+//	GET /metrics                            as OpenMetrics, for scrapers
+//
+// OpenMetrics is also served for ?format=openmetrics or when the Accept
+// header asks for it. windows defaults to DORA_WINDOWS.
+func doraMetrics(w http.ResponseWriter, r *http.Request) {
+	loadConfig()
+	log.Printf("DORA metrics function invoked")
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	windowList := q.Get("windows")
+	if windowList == "" {
+		windowList = c.DoraWindows
+	}
+	windows, err := dora.ParseWindows(windowList)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	store, err := historyConfig().Open(ctx)
+	if err != nil {
+		log.Printf("Error creating history store: %v", err)
+		http.Error(w, "error reading history", http.StatusInternalServerError)
+		return
+	}
+	defer store.Close()
+	now := time.Now().UTC()
+	records, err := store.Query(ctx, history.Filter{
+		Pipeline: q.Get("pipeline"),
+		Target:   q.Get("target"),
+		Kind:     history.RolloutState,
+		Since:    now.Add(-dora.Longest(windows)),
+		Limit:    c.DoraMaxRecords,
+	})
+	if err != nil {
+		log.Printf("Error reading history: %v", err)
+		http.Error(w, "error reading history", http.StatusInternalServerError)
+		return
+	}
+	if len(records) == c.DoraMaxRecords {
+		log.Printf("Read the maximum of %d records, metrics only cover the newest", c.DoraMaxRecords)
+	}
+	metrics := dora.Compute(records, windows, now)
+
+	if wantsOpenMetrics(r) {
+		w.Header().Set("Content-Type", dora.ContentType)
+		if err := dora.WriteOpenMetrics(w, metrics); err != nil {
+			log.Printf("Error writing response: %v", err)
+		}
+		return
+	}
+	if metrics == nil {
+		metrics = []dora.Metrics{}
+	}
+	writeJSON(w, map[string][]dora.Metrics{"metrics": metrics})
+}
+
+func wantsOpenMetrics(r *http.Request) bool {
+	return strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/metrics") ||
+		r.URL.Query().Get("format") == "openmetrics" ||
+		strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+}
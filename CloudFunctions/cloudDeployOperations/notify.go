@@ -0,0 +1,72 @@
+package example
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/deployclient"
+	"example.com/shared/jira"
+	"example.com/shared/notify"
+)
+
+// Rollout actions chat channels are told about
+var rolloutKinds = map[string]notify.Kind{
+	"Start":   notify.RolloutStarted,
+	"Succeed": notify.RolloutSucceeded,
+	"Failure": notify.RolloutFailed,
+}
+
+// notifications says where the notification routes are.
+var notifications notify.Config
+
+// notifyRollout tells the routed chat channels a rollout started, succeeded
+// or failed, with the commit and build it came from and why it failed.
+func notifyRollout(ctx context.Context, a OperationsData) error {
+	kind, ok := rolloutKinds[a.Action]
+	if a.ResourceType != "Rollout" || !ok {
+		return nil
+	}
+	routes, err := notifications.Routes(ctx)
+	if err != nil {
+		return err
+	}
+	if len(routes.Routes) == 0 {
+		return nil
+	}
+
+	deployClient, err := deployclient.New(ctx)
+	if err != nil {
+		return fmt.Errorf("error creating Cloud Deploy client: %w", err)
+	}
+	defer deployClient.Close()
+	release, err := deployClient.GetRelease(ctx, &deploypb.GetReleaseRequest{Name: releaseName(a)})
+	if err != nil {
+		return fmt.Errorf("error getting release: %w", err)
+	}
+
+	e := notify.Event{
+		Kind:     kind,
+		Project:  c.ProjectId,
+		Location: a.Location,
+		Pipeline: a.DeliveryPipelineId,
+		Release:  a.ReleaseId,
+		Target:   a.TargetId,
+		Rollout:  a.RolloutId,
+		IssueKey: release.Annotations[jira.IssueKeyAnnotation],
+	}
+	e.AddProvenance(release.Annotations)
+	if e.IssueKey != "" {
+		e.IssueURL = jira.IssueURL(c.JiraURL, e.IssueKey)
+	}
+	if kind == notify.RolloutFailed {
+		rollout, err := deployClient.GetRollout(ctx, &deploypb.GetRolloutRequest{Name: releaseName(a) + "/rollouts/" + a.RolloutId})
+		if err != nil {
+			log.Printf("Failed to get rollout %s for its failure reason: %v", a.RolloutId, err)
+		} else if rollout.FailureReason != "" {
+			e.Detail = rollout.FailureReason
+		}
+	}
+	return notifications.Send(ctx, e)
+}
@@ -0,0 +1,131 @@
+package example
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/command"
+	"example.com/shared/deployclient"
+	"example.com/shared/failure"
+)
+
+// nextStage works out where the release in a should go after the event: the
+// first stage once the release has rendered, or the stage after the rollout's
+// target once it succeeded. A nil stage means there's nothing to promote to,
+// either because the event isn't one we promote on, the rollout was a
+// rollback, the target was the last stage, or the next stage has to be
+// promoted by hand.
+func nextStage(ctx context.Context, a OperationsData) (*deploypb.Stage, error) {
+	if a.Action != "Succeed" || (a.ResourceType != "Release" && a.ResourceType != "Rollout") {
+		return nil, nil
+	}
+
+	deployClient, err := deployclient.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Cloud Deploy client: %w", err)
+	}
+	defer deployClient.Close()
+
+	if a.ResourceType == "Rollout" {
+		rollout, err := deployClient.GetRollout(ctx, &deploypb.GetRolloutRequest{Name: releaseName(a) + "/rollouts/" + a.RolloutId})
+		if err != nil {
+			return nil, fmt.Errorf("error getting rollout: %w", err)
+		}
+		if rollout.RollbackOfRollout != "" {
+			log.Printf("Rollout %s was a rollback, not promoting release %s", a.RolloutId, a.ReleaseId)
+			return nil, nil
+		}
+	}
+
+	pipeline, err := deployClient.GetDeliveryPipeline(ctx, &deploypb.GetDeliveryPipelineRequest{
+		Name: pipelineName(a),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting delivery pipeline: %w", err)
+	}
+	stages := pipeline.GetSerialPipeline().GetStages()
+
+	next := 0
+	if a.ResourceType == "Rollout" {
+		next = -1
+		for i, stage := range stages {
+			if stage.TargetId == a.TargetId {
+				next = i + 1
+				break
+			}
+		}
+		if next == -1 {
+			return nil, failure.NewPermanent(fmt.Errorf("target %s is not a stage of pipeline %s", a.TargetId, pipeline.Name))
+		}
+	}
+	if next >= len(stages) {
+		log.Printf("Release %s reached the last stage of %s", a.ReleaseId, a.DeliveryPipelineId)
+		return nil, nil
+	}
+	stage := stages[next]
+	if isManual(stage) {
+		log.Printf("Stage %s is manual, not promoting release %s", stage.TargetId, a.ReleaseId)
+		return nil, nil
+	}
+	return stage, nil
+}
+
+// isManual says whether a stage is listed in MANUAL_STAGES or deploys with
+// the MANUAL_PROFILE Skaffold profile.
+func isManual(stage *deploypb.Stage) bool {
+	for _, target := range strings.Split(c.ManualStages, ",") {
+		if strings.TrimSpace(target) == stage.TargetId {
+			return true
+		}
+	}
+	for _, profile := range stage.Profiles {
+		if c.ManualProfile != "" && profile == c.ManualProfile {
+			return true
+		}
+	}
+	return false
+}
+
+// promote asks cloudDeployInteractions to roll the event's release out to
+// stage.
+func promote(ctx context.Context, a OperationsData, messageID string, stage *deploypb.Stage) error {
+	log.Printf("Promoting release %s to %s (profiles %v)", a.ReleaseId, stage.TargetId, stage.Profiles)
+	cmd, err := promoteCommand(a, messageID, stage)
+	if err != nil {
+		return err
+	}
+	if _, err := command.NewPublisher(c.ProjectId, c.SendTopicID).Publish(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to send pubsub command: %w", err)
+	}
+	return nil
+}
+
+// promoteCommand builds the PromoteRelease command for stage. The stage's
+// profiles were already applied when the release was rendered for its
+// target, so only the target needs to be named. The rollout ID is left to
+// cloudDeployInteractions, which numbers it after the release's earlier
+// rollouts to the target, so a release can be promoted there again after
+// a failure or rollback.
+func promoteCommand(a OperationsData, messageID string, stage *deploypb.Stage) (*command.Envelope, error) {
+	cmd, err := command.New(command.PromoteRelease, "cloudDeployOperations", &deploypb.CreateRolloutRequest{
+		Parent: releaseName(a),
+		Rollout: &deploypb.Rollout{
+			TargetId: stage.TargetId,
+		},
+	})
+	if err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("failed to build command: %w", err))
+	}
+	// Redeliveries of this event carry the same correlation ID, which is
+	// how a redelivered promotion finds the rollout it already created
+	cmd.CorrelationID = messageID
+	return cmd, nil
+}
+
+func pipelineName(a OperationsData) string {
+	return fmt.Sprintf("projects/%s/locations/%s/deliveryPipelines/%s",
+		a.ProjectNumber, a.Location, a.DeliveryPipelineId)
+}
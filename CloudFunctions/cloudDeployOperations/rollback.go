@@ -0,0 +1,373 @@
+package example
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/command"
+	"example.com/shared/configfile"
+	"example.com/shared/deployclient"
+	"example.com/shared/failure"
+	"example.com/shared/history"
+	"example.com/shared/jira"
+	"example.com/shared/notify"
+)
+
+// RollbackPolicy says which targets are rolled back automatically when a
+// rollout to them fails. The first rule matching the target applies;
+// targets no rule matches are left alone, e.g.
+//
+//	{
+//	  "rules": [
+//	    {"name": "production", "targets": ["prod*"], "jobs": ["deploy", "verify"],
+//	     "cooldown": "30m", "maxAttempts": 2, "window": "24h"}
+//	  ]
+//	}
+type RollbackPolicy struct {
+	Rules []RollbackRule `json:"rules"`
+}
+
+// RollbackRule is the rollback policy of some targets.
+type RollbackRule struct {
+	Name string `json:"name,omitempty"`
+	// Targets as path.Match patterns
+	Targets []string `json:"targets"`
+	// Jobs whose failure triggers a rollback: "predeploy", "deploy",
+	// "verify" or "postdeploy". Any failure does when empty.
+	Jobs []string `json:"jobs,omitempty"`
+	// Cooldown is the least time between automatic rollbacks of a target,
+	// 15m when unset
+	Cooldown string `json:"cooldown,omitempty"`
+	// MaxAttempts is how many times a target is rolled back automatically
+	// within Window before failures are left to people, 3 in 24h when unset
+	MaxAttempts int    `json:"maxAttempts,omitempty"`
+	Window      string `json:"window,omitempty"`
+}
+
+var rollbackJobs = map[string]bool{"predeploy": true, "deploy": true, "verify": true, "postdeploy": true}
+
+// RuleFor returns the rule applying to target, or nil.
+func (p *RollbackPolicy) RuleFor(target string) *RollbackRule {
+	for i, r := range p.Rules {
+		for _, pattern := range r.Targets {
+			if ok, _ := path.Match(pattern, target); ok {
+				return &p.Rules[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks the policy can be applied, so a bad file is refused when
+// it's loaded rather than when a rollout fails.
+func (p *RollbackPolicy) Validate() error {
+	for i, r := range p.Rules {
+		name := fmt.Sprintf("rule %d (%s)", i, r.Name)
+		if len(r.Targets) == 0 {
+			return fmt.Errorf("%s has no targets", name)
+		}
+		for _, pattern := range r.Targets {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("%s: invalid target pattern %q: %w", name, pattern, err)
+			}
+		}
+		for _, job := range r.Jobs {
+			if !rollbackJobs[job] {
+				return fmt.Errorf("%s: unknown job %q", name, job)
+			}
+		}
+		for field, d := range map[string]string{"cooldown": r.Cooldown, "window": r.Window} {
+			if d == "" {
+				continue
+			}
+			if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+				return fmt.Errorf("%s: %s must be a positive duration, got %q", name, field, d)
+			}
+		}
+		if r.MaxAttempts < 0 {
+			return fmt.Errorf("%s: maxAttempts can't be negative", name)
+		}
+	}
+	return nil
+}
+
+func (r *RollbackRule) cooldown() time.Duration {
+	return durationOr(r.Cooldown, 15*time.Minute)
+}
+
+func (r *RollbackRule) window() time.Duration {
+	return durationOr(r.Window, 24*time.Hour)
+}
+
+func (r *RollbackRule) maxAttempts() int {
+	if r.MaxAttempts == 0 {
+		return 3
+	}
+	return r.MaxAttempts
+}
+
+// covers says whether the rule rolls back a rollout whose failed jobs are
+// failed.
+func (r *RollbackRule) covers(failed []string) bool {
+	if len(r.Jobs) == 0 {
+		return true
+	}
+	for _, job := range r.Jobs {
+		for _, f := range failed {
+			if job == f {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// durationOr parses d, already validated, or returns def when it's unset.
+func durationOr(d string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(d); err == nil {
+		return v
+	}
+	return def
+}
+
+var rollbackCache = &configfile.Cache[*RollbackPolicy]{Name: "rollback policy", Parse: parseRollbackPolicy}
+
+// currentRollbackPolicy returns the policy from ROLLBACK_POLICY (inline
+// JSON) or ROLLBACK_POLICY_URI. With neither, nothing is rolled back.
+func currentRollbackPolicy(ctx context.Context) (*RollbackPolicy, error) {
+	if c.RollbackPolicy != "" {
+		return parseRollbackPolicy([]byte(c.RollbackPolicy))
+	}
+	if c.RollbackPolicyURI == "" {
+		return &RollbackPolicy{}, nil
+	}
+	return rollbackCache.Get(ctx, c.RollbackPolicyURI, c.RollbackPolicyRefresh)
+}
+
+func parseRollbackPolicy(data []byte) (*RollbackPolicy, error) {
+	var p RollbackPolicy
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("error parsing rollback policy: %w", err))
+	}
+	if err := p.Validate(); err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("invalid rollback policy: %w", err))
+	}
+	return &p, nil
+}
+
+// rollBack answers a failed rollout by rolling its target back to the last
+// release that succeeded there, when the target's rule says so. Rollbacks
+// that fail aren't rolled back in turn, and a target isn't rolled back
+// twice within the cool-down nor more than the rule's attempts within its
+// window, so a broken target can't loop. The automatic rollbacks are
+// counted in the history.
+func rollBack(ctx context.Context, a OperationsData) error {
+	if a.ResourceType != "Rollout" || a.Action != "Failure" {
+		return nil
+	}
+	policy, err := currentRollbackPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	rule := policy.RuleFor(a.TargetId)
+	if rule == nil {
+		return nil
+	}
+
+	deployClient, err := deployclient.New(ctx)
+	if err != nil {
+		return fmt.Errorf("error creating Cloud Deploy client: %w", err)
+	}
+	defer deployClient.Close()
+	rolloutName := releaseName(a) + "/rollouts/" + a.RolloutId
+	rollout, err := deployClient.GetRollout(ctx, &deploypb.GetRolloutRequest{Name: rolloutName})
+	if err != nil {
+		return fmt.Errorf("error getting rollout: %w", err)
+	}
+	if rollout.RollbackOfRollout != "" {
+		skipRollback(ctx, a, "it was itself a rollback, roll the target back by hand")
+		return nil
+	}
+	failed := failedJobs(rollout)
+	if !rule.covers(failed) {
+		log.Printf("Not rolling back %s, rule %s doesn't cover failed jobs %v", a.RolloutId, rule.Name, failed)
+		return nil
+	}
+
+	store, err := historyConfig().Open(ctx)
+	if err != nil {
+		return fmt.Errorf("error creating history store: %w", err)
+	}
+	defer store.Close()
+	now := time.Now().UTC()
+	past, err := store.Query(ctx, history.Filter{
+		Pipeline: a.DeliveryPipelineId,
+		Target:   a.TargetId,
+		Kind:     history.Rollback,
+		Since:    now.Add(-max(rule.window(), rule.cooldown())),
+	})
+	if err != nil {
+		return fmt.Errorf("error reading past rollbacks: %w", err)
+	}
+	attempts := 0
+	for _, r := range past {
+		if r.Rollout == a.RolloutId {
+			log.Printf("Rollout %s was already rolled back", a.RolloutId)
+			return nil
+		}
+		if r.At.After(now.Add(-rule.window())) {
+			attempts++
+		}
+	}
+	if len(past) > 0 && past[0].At.After(now.Add(-rule.cooldown())) {
+		skipRollback(ctx, a, fmt.Sprintf("%s was rolled back automatically at %s, within the %s cool-down",
+			a.TargetId, past[0].At.Format(time.RFC3339), rule.cooldown()))
+		return nil
+	}
+	if attempts >= rule.maxAttempts() {
+		skipRollback(ctx, a, fmt.Sprintf("%s was rolled back automatically %d times in the last %s already",
+			a.TargetId, attempts, rule.window()))
+		return nil
+	}
+
+	// Cloud Deploy picks the last successful release itself when the
+	// history doesn't know it
+	var to string
+	current, err := history.Current(ctx, store, a.DeliveryPipelineId, a.TargetId)
+	if err != nil {
+		log.Printf("Failed to look up the release running on %s: %v", a.TargetId, err)
+	} else if current != nil && current.Release != a.ReleaseId {
+		to = current.Release
+	}
+	cmd, err := command.New(command.RollbackTarget, "cloudDeployOperations", &deploypb.RollbackTargetRequest{
+		Name:              pipelineName(a),
+		TargetId:          a.TargetId,
+		RolloutId:         rollbackID(a.RolloutId),
+		ReleaseId:         to,
+		RolloutToRollBack: rolloutName,
+	})
+	if err != nil {
+		return failure.NewPermanent(fmt.Errorf("failed to build command: %w", err))
+	}
+	cmd.CorrelationID = rollbackCorrelation(a)
+	if _, err := command.NewPublisher(c.ProjectId, c.SendTopicID).Publish(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to send pubsub command: %w", err)
+	}
+
+	why := "the rollout failed"
+	if len(failed) > 0 {
+		why = fmt.Sprintf("the rollout's %s job failed", strings.Join(failed, " and "))
+	}
+	if to == "" {
+		to = "the last successful release"
+	}
+	log.Printf("Rolling %s back to %s after rollout %s failed (rule %s)", a.TargetId, to, a.RolloutId, rule.Name)
+	err = store.Append(ctx, history.Record{
+		ID:       history.Key("rollback", rolloutName),
+		Kind:     history.Rollback,
+		At:       now,
+		Pipeline: a.DeliveryPipelineId,
+		Release:  a.ReleaseId,
+		Target:   a.TargetId,
+		Rollout:  a.RolloutId,
+		Actor:    "rollback:" + rule.Name,
+		Detail:   why,
+	})
+	if err != nil {
+		// The rollback is on its way, only the guards are weakened
+		log.Printf("Failed to record rollback of %s: %v", a.RolloutId, err)
+	}
+	tell(ctx, a, notify.RollbackStarted, fmt.Sprintf("Rolling %s back to %s: %s.", a.TargetId, to, why))
+	return nil
+}
+
+// skipRollback tells people a failed rollout is left for them to deal with.
+func skipRollback(ctx context.Context, a OperationsData, why string) {
+	log.Printf("Not rolling back %s: %s", a.RolloutId, why)
+	tell(ctx, a, notify.RollbackSkipped, fmt.Sprintf("Rollout %s failed and was not rolled back: %s.", a.RolloutId, why))
+}
+
+// rollbackCorrelation ties the rollback of a failed rollout, and what
+// people are told about it, to the rollout.
+func rollbackCorrelation(a OperationsData) string {
+	return "rollback/" + releaseName(a) + "/rollouts/" + a.RolloutId
+}
+
+// tell comments text on the release's issue and sends it to the routed
+// chat channels. Each kind of notice is claimed under the rollback's
+// correlation, so redeliveries of the failure don't repeat it. Failures
+// only get logged.
+func tell(ctx context.Context, a OperationsData, kind notify.Kind, text string) {
+	err := sideEffects().Do(ctx, string(kind), rollbackCorrelation(a), func() error {
+		commentOnRelease(ctx, a, text)
+		e := notify.Event{
+			Kind:     kind,
+			Project:  c.ProjectId,
+			Location: a.Location,
+			Pipeline: a.DeliveryPipelineId,
+			Release:  a.ReleaseId,
+			Target:   a.TargetId,
+			Rollout:  a.RolloutId,
+			Detail:   text,
+		}
+		deployClient, err := deployclient.New(ctx)
+		if err == nil {
+			defer deployClient.Close()
+			var release *deploypb.Release
+			if release, err = deployClient.GetRelease(ctx, &deploypb.GetReleaseRequest{Name: releaseName(a)}); err == nil {
+				e.AddProvenance(release.Annotations)
+				if e.IssueKey = release.Annotations[jira.IssueKeyAnnotation]; e.IssueKey != "" {
+					e.IssueURL = jira.IssueURL(c.JiraURL, e.IssueKey)
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to get release %s for its provenance: %v", a.ReleaseId, err)
+		}
+		return notifications.Send(ctx, e)
+	})
+	if err != nil {
+		log.Printf("Failed to notify: %v", err)
+	}
+}
+
+// failedJobs returns the kinds of job that failed in the rollout's phases.
+func failedJobs(r *deploypb.Rollout) []string {
+	var failed []string
+	for _, phase := range r.Phases {
+		jobs := phase.GetDeploymentJobs()
+		for _, j := range []struct {
+			name string
+			job  *deploypb.Job
+		}{
+			{"predeploy", jobs.GetPredeployJob()},
+			{"deploy", jobs.GetDeployJob()},
+			{"verify", jobs.GetVerifyJob()},
+			{"postdeploy", jobs.GetPostdeployJob()},
+		} {
+			if j.job.GetState() == deploypb.Job_FAILED {
+				failed = append(failed, j.name)
+			}
+		}
+	}
+	return failed
+}
+
+// rollbackID names the rollback rollout after the failed one, within
+// Cloud Deploy's 63 character limit.
+func rollbackID(rolloutID string) string {
+	id := "rollback-" + rolloutID
+	if len(id) > 63 {
+		id = id[:63]
+	}
+	return strings.TrimRight(id, "-")
+}
@@ -0,0 +1,344 @@
+package example
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	deploy "cloud.google.com/go/deploy/apiv1"
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"example.com/shared/command"
+	"example.com/shared/deployclient"
+	"example.com/shared/deploytest"
+	"example.com/shared/history"
+)
+
+// rollbackFakes fakes what rollBack talks to: Cloud Deploy, the command
+// topic, the chat webhook and in-memory stores.
+type rollbackFakes struct {
+	deploy *deploytest.Server
+	client *deploy.CloudDeployClient
+	pubsub *pstest.Server
+
+	mu    sync.Mutex
+	posts []string
+}
+
+func newRollbackFakes(t *testing.T) *rollbackFakes {
+	t.Helper()
+	f := &rollbackFakes{}
+	var err error
+	if f.deploy, err = deploytest.NewServer(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(f.deploy.Close)
+	// Releases named bad-* fail their verification, and so do the
+	// rollbacks rollBack doesn't send
+	f.deploy.FailRollout = func(r *deploypb.Rollout) bool {
+		return strings.Contains(r.Name, "/releases/bad-") || strings.Contains(r.Name, "/rollouts/manual-")
+	}
+	f.deploy.AddTarget(&deploypb.Target{Name: "projects/p/locations/l/targets/prod", TargetId: "prod"})
+	t.Setenv(deployclient.EmulatorHostEnv, f.deploy.Addr)
+	if f.client, err = deployclient.New(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.client.Close() })
+
+	f.pubsub = pstest.NewServer()
+	t.Cleanup(func() { f.pubsub.Close() })
+	t.Setenv("PUBSUB_EMULATOR_HOST", f.pubsub.Addr)
+	ps, err := pubsub.NewClient(context.Background(), "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ps.Close() })
+	if _, err := ps.CreateTopic(context.Background(), "commands"); err != nil {
+		t.Fatal(err)
+	}
+
+	chat := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.posts = append(f.posts, string(body))
+	}))
+	t.Cleanup(chat.Close)
+
+	saved, savedRoutes := c, notifications.Inline
+	t.Cleanup(func() { c, notifications.Inline = saved, savedRoutes })
+	c.HistoryStore, c.OnceStore = "memory", "memory"
+	notifications.Inline = `{"routes": [{"name": "rollbacks", "events": ["rollback-started", "rollback-skipped"], "googleChat": "` + chat.URL + `"}]}`
+	return f
+}
+
+// failedRollout deploys a good release of pipeline to prod, then a bad one
+// whose rollout fails, and returns the failure's event.
+func (f *rollbackFakes) failedRollout(t *testing.T, pipeline string) OperationsData {
+	t.Helper()
+	name := "projects/p/locations/l/deliveryPipelines/" + pipeline
+	f.deploy.AddPipeline(&deploypb.DeliveryPipeline{
+		Name: name,
+		Pipeline: &deploypb.DeliveryPipeline_SerialPipeline{SerialPipeline: &deploypb.SerialPipeline{
+			Stages: []*deploypb.Stage{{TargetId: "prod"}},
+		}},
+	})
+	for _, release := range []string{"good-1", "bad-1"} {
+		f.rollout(t, name+"/releases/"+release, release+"-to-prod-0001")
+	}
+	return OperationsData{
+		Action:             "Failure",
+		ResourceType:       "Rollout",
+		Location:           "l",
+		ProjectNumber:      "p",
+		DeliveryPipelineId: pipeline,
+		ReleaseId:          "bad-1",
+		RolloutId:          "bad-1-to-prod-0001",
+		TargetId:           "prod",
+	}
+}
+
+// rollout creates release, unless it exists, and rolls it out to prod.
+func (f *rollbackFakes) rollout(t *testing.T, release, id string) {
+	t.Helper()
+	ctx := context.Background()
+	if f.deploy.Release(release) == nil {
+		pipeline, releaseID, _ := strings.Cut(release, "/releases/")
+		op, err := f.client.CreateRelease(ctx, &deploypb.CreateReleaseRequest{Parent: pipeline, ReleaseId: releaseID, Release: &deploypb.Release{}})
+		if err == nil {
+			_, err = op.Wait(ctx)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	op, err := f.client.CreateRollout(ctx, &deploypb.CreateRolloutRequest{
+		Parent:    release,
+		RolloutId: id,
+		Rollout:   &deploypb.Rollout{TargetId: "prod"},
+	})
+	if err == nil {
+		_, err = op.Wait(ctx)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rollbacks returns the RollbackTarget commands sent so far.
+func (f *rollbackFakes) rollbacks(t *testing.T) []*command.Envelope {
+	t.Helper()
+	var cmds []*command.Envelope
+	for _, m := range f.pubsub.Messages() {
+		cmd, _, err := command.Parse(m.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd.Type == command.RollbackTarget {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+func (f *rollbackFakes) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.posts...)
+}
+
+func TestRollBack(t *testing.T) {
+	f := newRollbackFakes(t)
+	ctx := context.Background()
+
+	for i, tt := range []struct {
+		name   string
+		policy string
+		// automatic rollbacks of prod already in the history, this long ago
+		past []time.Duration
+		// whether the failed rollout is itself a rollback
+		ofRollback bool
+		// whether a rollback is sent, and what people are told: "*Rolling
+		// back", "*Not rolling back" or nothing
+		rollback bool
+		notice   string
+	}{
+		{
+			name:     "covered job failed",
+			policy:   `{"rules": [{"name": "prod", "targets": ["prod"], "jobs": ["verify"]}]}`,
+			rollback: true,
+			notice:   "*Rolling back",
+		},
+		{
+			name:     "any failure when the rule names no jobs",
+			policy:   `{"rules": [{"name": "prod", "targets": ["prod"]}]}`,
+			rollback: true,
+			notice:   "*Rolling back",
+		},
+		{
+			name:   "uncovered job failed",
+			policy: `{"rules": [{"name": "prod", "targets": ["prod"], "jobs": ["deploy", "predeploy"]}]}`,
+		},
+		{
+			name:   "no rule for the target",
+			policy: `{"rules": [{"name": "staging", "targets": ["staging*"]}]}`,
+		},
+		{
+			name:       "rollback of a rollback",
+			policy:     `{"rules": [{"name": "prod", "targets": ["prod"]}]}`,
+			ofRollback: true,
+			notice:     "*Not rolling back",
+		},
+		{
+			name:   "within the cool-down",
+			policy: `{"rules": [{"name": "prod", "targets": ["prod"], "cooldown": "30m"}]}`,
+			past:   []time.Duration{20 * time.Minute},
+			notice: "*Not rolling back",
+		},
+		{
+			name:     "past the cool-down",
+			policy:   `{"rules": [{"name": "prod", "targets": ["prod"], "cooldown": "30m"}]}`,
+			past:     []time.Duration{40 * time.Minute},
+			rollback: true,
+			notice:   "*Rolling back",
+		},
+		{
+			name:   "default cool-down",
+			policy: `{"rules": [{"name": "prod", "targets": ["prod"]}]}`,
+			past:   []time.Duration{10 * time.Minute},
+			notice: "*Not rolling back",
+		},
+		{
+			name:   "max attempts within the window",
+			policy: `{"rules": [{"name": "prod", "targets": ["prod"], "cooldown": "5m", "maxAttempts": 2, "window": "6h"}]}`,
+			past:   []time.Duration{time.Hour, 5 * time.Hour},
+			notice: "*Not rolling back",
+		},
+		{
+			name:     "attempts before the window don't count",
+			policy:   `{"rules": [{"name": "prod", "targets": ["prod"], "cooldown": "5m", "maxAttempts": 2, "window": "6h"}]}`,
+			past:     []time.Duration{time.Hour, 7 * time.Hour},
+			rollback: true,
+			notice:   "*Rolling back",
+		},
+		{
+			name:   "default max attempts",
+			policy: `{"rules": [{"name": "prod", "targets": ["prod"]}]}`,
+			past:   []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour},
+			notice: "*Not rolling back",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f.pubsub.ClearMessages()
+			posts := len(f.sent())
+			c.RollbackPolicy = tt.policy
+			// The in-memory stores outlive each case, so each gets its own
+			// pipeline
+			pipeline := fmt.Sprintf("rollback-%d", i)
+			a := f.failedRollout(t, pipeline)
+			if tt.ofRollback {
+				// Someone rolled the failure back by hand, and that failed too
+				_, err := f.client.RollbackTarget(ctx, &deploypb.RollbackTargetRequest{
+					Name:              "projects/p/locations/l/deliveryPipelines/" + pipeline,
+					TargetId:          "prod",
+					RolloutId:         "manual-1",
+					RolloutToRollBack: releaseName(a) + "/rollouts/" + a.RolloutId,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				a.ReleaseId, a.RolloutId = "good-1", "manual-1"
+			}
+			for j, ago := range tt.past {
+				err := historyConfig().Append(ctx, history.Record{
+					ID:       history.Key("rollback", pipeline, fmt.Sprint(j)),
+					Kind:     history.Rollback,
+					At:       time.Now().UTC().Add(-ago),
+					Pipeline: pipeline,
+					Release:  "bad-0",
+					Target:   "prod",
+					Rollout:  fmt.Sprintf("bad-0-to-prod-%04d", j+1),
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Redeliveries of the failure neither roll back again nor repeat
+			// the notice
+			for range 2 {
+				if err := rollBack(ctx, a); err != nil {
+					t.Fatalf("rollBack: %v", err)
+				}
+			}
+
+			cmds := f.rollbacks(t)
+			switch {
+			case !tt.rollback && len(cmds) != 0:
+				t.Errorf("sent %+v, want no rollback", cmds)
+			case tt.rollback && len(cmds) != 1:
+				t.Errorf("sent %d rollback(s), want 1", len(cmds))
+			case tt.rollback:
+				if want := "rollback/" + releaseName(a) + "/rollouts/" + a.RolloutId; cmds[0].CorrelationID != want {
+					t.Errorf("correlation ID = %q, want %q", cmds[0].CorrelationID, want)
+				}
+				store, err := historyConfig().Open(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer store.Close()
+				recorded, err := store.Query(ctx, history.Filter{Pipeline: pipeline, Kind: history.Rollback})
+				if err != nil || len(recorded) != len(tt.past)+1 || recorded[0].Rollout != a.RolloutId {
+					t.Errorf("rollbacks recorded = %+v, %v, want this one on top of %d", recorded, err, len(tt.past))
+				}
+			}
+
+			sent := f.sent()[posts:]
+			switch {
+			case tt.notice == "" && len(sent) != 0:
+				t.Errorf("posted %q, want nothing", sent)
+			case tt.notice != "" && (len(sent) != 1 || !strings.Contains(sent[0], tt.notice)):
+				t.Errorf("posted %q, want one %q", sent, tt.notice)
+			}
+		})
+	}
+}
+
+func TestRollbackSends(t *testing.T) {
+	f := newRollbackFakes(t)
+	ctx := context.Background()
+	c.RollbackPolicy = `{"rules": [{"name": "prod", "targets": ["prod"], "jobs": ["verify"]}]}`
+	a := f.failedRollout(t, "rollback-sends")
+	if err := rollBack(ctx, a); err != nil {
+		t.Fatalf("rollBack: %v", err)
+	}
+	msgs := f.pubsub.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d message(s), want 1", len(msgs))
+	}
+	_, req, err := command.Parse(msgs[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Which is what the interactions function runs against Cloud Deploy
+	if _, err := f.client.RollbackTarget(ctx, req.(*deploypb.RollbackTargetRequest)); err != nil {
+		t.Fatalf("RollbackTarget: %v", err)
+	}
+	var rollback *deploypb.Rollout
+	for _, r := range f.deploy.Rollouts() {
+		if r.RollbackOfRollout != "" {
+			rollback = r
+		}
+	}
+	if want := releaseName(a) + "/rollouts/" + a.RolloutId; rollback == nil || rollback.RollbackOfRollout != want ||
+		!strings.Contains(rollback.Name, "/releases/good-1/rollouts/rollback-bad-1-to-prod-0001") {
+		t.Errorf("rollback = %v, want good-1 rolled out again in place of %s", rollback, want)
+	}
+}
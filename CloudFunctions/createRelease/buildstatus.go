@@ -0,0 +1,153 @@
+package example
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"example.com/shared/events"
+	"example.com/shared/failure"
+	"example.com/shared/jira"
+)
+
+// Cloud Build statuses we follow. STATUS_UNKNOWN and PENDING say nothing
+// about the build yet, so they're ignored.
+var trackedStatuses = map[string]bool{
+	"QUEUED":         true,
+	"WORKING":        true,
+	"SUCCESS":        true,
+	"FAILURE":        true,
+	"INTERNAL_ERROR": true,
+	"TIMEOUT":        true,
+	"CANCELLED":      true,
+	"EXPIRED":        true,
+}
+
+// trackBuild mirrors the build's status onto its Jira issue, opening it on
+// the first notification, and publishes it to BUILDEVENTSTOPICID. Failed
+// builds get a comment with their log so the issue explains itself, once
+// per build status however often the notification is redelivered.
+func trackBuild(ctx context.Context, b *BuildMessage, r *Route) (*jira.Issue, error) {
+	issue, err := buildIssue(ctx, b)
+	if err != nil {
+		return nil, fmt.Errorf("error getting Jira issue: %w", err)
+	}
+	event := buildEvent(b, r, issue.Key)
+
+	client := jiraClient()
+	if event.Failed() {
+		err := sideEffects().Do(ctx, "build-comment", b.ID+"/"+b.Status, func() error {
+			return commentFailedBuild(ctx, client, issue.Key, b)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := transitionBuildIssue(ctx, client, issue.Key, b.Status); err != nil {
+		return nil, err
+	}
+
+	if c.BuildEventsTopicID != "" {
+		if err := events.PublishBuildEvent(ctx, c.ProjectId, c.BuildEventsTopicID, event); err != nil {
+			return nil, err
+		}
+	}
+	return issue, nil
+}
+
+// commentFailedBuild explains on the issue why build b failed.
+func commentFailedBuild(ctx context.Context, client *jira.Client, key string, b *BuildMessage) error {
+	text := fmt.Sprintf("Build %s finished with %s, nothing was released.", b.ID, b.Status)
+	if f := b.FailureInfo; f != nil && f.Detail != "" {
+		text += fmt.Sprintf("\n%s: %s", f.Type, f.Detail)
+	}
+	if step := failedStep(b); step != nil {
+		text += fmt.Sprintf("\nFailed step: %s (%s)", stepName(step), step.Status)
+	}
+	text += "\nBuild log: " + b.LogUrl
+	if err := client.AddComment(ctx, key, text); err != nil {
+		return err
+	}
+	log.Printf("Commented on %s", key)
+	return nil
+}
+
+func buildEvent(b *BuildMessage, r *Route, issueKey string) *events.BuildEvent {
+	s := b.Substitutions
+	return &events.BuildEvent{
+		BuildID:   b.ID,
+		Status:    b.Status,
+		Trigger:   s.TriggerName(),
+		Repo:      s.RepoName(),
+		Branch:    s.BranchName(),
+		Tag:       s.TagName(),
+		CommitSha: s.CommitSha(),
+		LogURL:    b.LogUrl,
+		Pipeline:  r.Pipeline,
+		IssueKey:  issueKey,
+		Time:      statusTime(b),
+	}
+}
+
+// statusTime is when the build reached its status, going by the build's
+// own timestamps.
+func statusTime(b *BuildMessage) time.Time {
+	t := b.FinishTime
+	switch b.Status {
+	case "QUEUED":
+		t = b.CreateTime
+	case "WORKING":
+		t = b.StartTime
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UTC()
+}
+
+// failedStep returns the first step that failed, timed out or was
+// cancelled, or nil.
+func failedStep(b *BuildMessage) *Step {
+	for i := range b.Steps {
+		switch b.Steps[i].Status {
+		case "FAILURE", "INTERNAL_ERROR", "TIMEOUT", "CANCELLED":
+			if !b.Steps[i].AllowFailure {
+				return &b.Steps[i]
+			}
+		}
+	}
+	return nil
+}
+
+func stepName(s *Step) string {
+	if s.ID != "" {
+		return s.ID
+	}
+	return s.Name
+}
+
+// transitionBuildIssue moves the issue to the status JIRA_BUILD_TRANSITIONS
+// maps the build status to, if any.
+func transitionBuildIssue(ctx context.Context, client *jira.Client, key, buildStatus string) error {
+	transitions, err := jira.ParseTransitions(c.JiraBuildTransitions)
+	if err != nil {
+		return failure.NewPermanent(fmt.Errorf("error parsing JIRA_BUILD_TRANSITIONS: %w", err))
+	}
+	status, ok := transitions[buildStatus]
+	if !ok {
+		return nil
+	}
+	err = client.TransitionTo(ctx, key, status)
+	if errors.Is(err, jira.ErrNoTransition) {
+		// Usually a redelivery, with the issue already there
+		log.Printf("Not moving %s: %v", key, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Moved %s to %q", key, status)
+	return nil
+}
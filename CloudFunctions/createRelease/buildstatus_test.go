@@ -0,0 +1,52 @@
+package example
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"example.com/shared/jira/jiratest"
+)
+
+func TestTrackBuildCommentsOnce(t *testing.T) {
+	s := jiratest.NewServer()
+	t.Cleanup(s.Close)
+	ps := pstest.NewServer()
+	t.Cleanup(func() { ps.Close() })
+	t.Setenv("PUBSUB_EMULATOR_HOST", ps.Addr)
+	saved, savedIssues := c, memIssues
+	t.Cleanup(func() { c, memIssues = saved, savedIssues })
+	c.ProjectId = "p"
+	c.JiraURL, c.JiraEmail, c.JiraToken = s.URL+"/", "bot@example.com", "token"
+	c.JiraProject, c.JiraIssueType = "DEP", "Task"
+	c.JiraSummary, c.JiraDescription, c.JiraFields = "Release {{.ID}}", "Build {{.ID}}", ""
+	c.IssueStore, c.OnceStore = "memory", "memory"
+	c.JiraBuildTransitions = ""
+	// The topic doesn't exist yet, so publishing the build event fails
+	c.BuildEventsTopicID = "build-events"
+	memIssues = NewMemoryIssueStore()
+	ctx := context.Background()
+
+	b := &BuildMessage{ID: "build-comment-once", Status: "FAILURE", LogUrl: "https://example.com/log"}
+	for i := 0; i < 2; i++ {
+		if _, err := trackBuild(ctx, b, &Route{Pipeline: "app"}); err == nil {
+			t.Fatal("trackBuild succeeded without a build events topic")
+		}
+	}
+	client, err := pubsub.NewClient(ctx, c.ProjectId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.CreateTopic(ctx, c.BuildEventsTopicID); err != nil {
+		t.Fatal(err)
+	}
+	issue, err := trackBuild(ctx, b, &Route{Pipeline: "app"})
+	if err != nil {
+		t.Fatalf("trackBuild: %v", err)
+	}
+	if comments := s.Issue(issue.Key).Comments; len(comments) != 1 {
+		t.Errorf("issue has %d comments after three deliveries, want 1: %q", len(comments), comments)
+	}
+}
@@ -1,70 +1,305 @@
 package example
 
-// Define the struct to match the JSON structure
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BuildMessage is the Cloud Build Build resource, as published to the
+// cloud-builds topic on every status change. It follows the REST
+// representation (https://cloud.google.com/build/docs/api/reference/rest/v1/projects.builds)
+// field for field. Fields added to the API later are ignored by the
+// decoder.
+type BuildMessage struct {
+	Name           string        `json:"name"`
+	ID             string        `json:"id"`
+	ProjectID      string        `json:"projectId"`
+	Status         string        `json:"status"`
+	StatusDetail   string        `json:"statusDetail"`
+	Source         Source        `json:"source"`
+	Steps          []Step        `json:"steps"`
+	Results        Results       `json:"results"`
+	CreateTime     time.Time     `json:"createTime"`
+	StartTime      time.Time     `json:"startTime"`
+	FinishTime     time.Time     `json:"finishTime"`
+	Timeout        Duration      `json:"timeout"`
+	Images         []string      `json:"images"`
+	QueueTtl       Duration      `json:"queueTtl"`
+	Artifacts      Artifacts     `json:"artifacts"`
+	LogsBucket     string        `json:"logsBucket"`
+	BuildTriggerID string        `json:"buildTriggerId"`
+	Options        Options       `json:"options"`
+	LogUrl         string        `json:"logUrl"`
+	Substitutions  Substitutions `json:"substitutions"`
+	Tags           []string      `json:"tags"`
+	// Timing of the build's phases, keyed by BUILD, PUSH, FETCHSOURCE...
+	Timing           map[string]TimeSpan `json:"timing"`
+	Approval         *Approval           `json:"approval,omitempty"`
+	ServiceAccount   string              `json:"serviceAccount"`
+	SourceProvenance SourceProvenance    `json:"sourceProvenance"`
+	Warnings         []Warning           `json:"warnings"`
+	FailureInfo      *FailureInfo        `json:"failureInfo,omitempty"`
+}
+
+// parseBuild decodes a build notification.
+func parseBuild(data []byte) (*BuildMessage, error) {
+	var b BuildMessage
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+type Source struct {
+	StorageSource         *StorageSource         `json:"storageSource,omitempty"`
+	RepoSource            *RepoSource            `json:"repoSource,omitempty"`
+	GitSource             *GitSource             `json:"gitSource,omitempty"`
+	StorageSourceManifest *StorageSourceManifest `json:"storageSourceManifest,omitempty"`
+	ConnectedRepository   *ConnectedRepository   `json:"connectedRepository,omitempty"`
+	DeveloperConnect      *DeveloperConnect      `json:"developerConnectConfig,omitempty"`
+}
+
+type StorageSource struct {
+	Bucket     string `json:"bucket"`
+	Object     string `json:"object"`
+	Generation Int64  `json:"generation"`
+}
+
+type RepoSource struct {
+	ProjectID     string            `json:"projectId"`
+	RepoName      string            `json:"repoName"`
+	Dir           string            `json:"dir"`
+	InvertRegex   bool              `json:"invertRegex"`
+	Substitutions map[string]string `json:"substitutions"`
+	BranchName    string            `json:"branchName"`
+	TagName       string            `json:"tagName"`
+	CommitSha     string            `json:"commitSha"`
+}
+
 type GitSource struct {
 	URL      string `json:"url"`
+	Dir      string `json:"dir"`
 	Revision string `json:"revision"`
 }
 
-type Source struct {
-	GitSource GitSource `json:"gitSource"`
+type StorageSourceManifest struct {
+	Bucket     string `json:"bucket"`
+	Object     string `json:"object"`
+	Generation Int64  `json:"generation"`
 }
 
+type ConnectedRepository struct {
+	Repository string `json:"repository"`
+	Dir        string `json:"dir"`
+	Revision   string `json:"revision"`
+}
+
+type DeveloperConnect struct {
+	GitRepositoryLink string `json:"gitRepositoryLink"`
+	Dir               string `json:"dir"`
+	Revision          string `json:"revision"`
+}
+
+// Step is one build step with, once it ran, its status and timings.
 type Step struct {
-	Name string   `json:"name"`
-	Args []string `json:"args"`
-	Dir  string   `json:"dir"`
+	Name                 string   `json:"name"`
+	ID                   string   `json:"id"`
+	Env                  []string `json:"env"`
+	Args                 []string `json:"args"`
+	Dir                  string   `json:"dir"`
+	WaitFor              []string `json:"waitFor"`
+	Entrypoint           string   `json:"entrypoint"`
+	SecretEnv            []string `json:"secretEnv"`
+	Volumes              []Volume `json:"volumes"`
+	Timing               TimeSpan `json:"timing"`
+	PullTiming           TimeSpan `json:"pullTiming"`
+	Timeout              Duration `json:"timeout"`
+	Status               string   `json:"status"`
+	AllowFailure         bool     `json:"allowFailure"`
+	ExitCode             int      `json:"exitCode"`
+	AllowExitCodes       []int    `json:"allowExitCodes"`
+	Script               string   `json:"script"`
+	AutomapSubstitutions bool     `json:"automapSubstitutions"`
 }
 
-type SourceProvenance struct {
-	ResolvedGitSource GitSource `json:"resolvedGitSource"`
+type Volume struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
 }
 
-type Pool struct {
-	// Pool is an empty struct in the JSON, so no fields are needed here
+// Results are what the build produced. Images carries the digests of the
+// images pushed.
+type Results struct {
+	Images           []BuiltImage       `json:"images"`
+	BuildStepImages  []string           `json:"buildStepImages"`
+	ArtifactManifest string             `json:"artifactManifest"`
+	NumArtifacts     Int64              `json:"numArtifacts"`
+	BuildStepOutputs [][]byte           `json:"buildStepOutputs"`
+	ArtifactTiming   TimeSpan           `json:"artifactTiming"`
+	PythonPackages   []UploadedArtifact `json:"pythonPackages"`
+	MavenArtifacts   []UploadedArtifact `json:"mavenArtifacts"`
+	NpmPackages      []UploadedArtifact `json:"npmPackages"`
+	GoModules        []UploadedArtifact `json:"goModules"`
 }
 
-type Options struct {
-	SubstitutionOption   string `json:"substitutionOption"`
-	Logging              string `json:"logging"`
-	DynamicSubstitutions bool   `json:"dynamicSubstitutions"`
-	Pool                 Pool   `json:"pool"`
+type BuiltImage struct {
+	Name       string   `json:"name"`
+	Digest     string   `json:"digest"`
+	PushTiming TimeSpan `json:"pushTiming"`
 }
 
-type Substitutions struct {
-	TriggerBuildConfigPath string `json:"TRIGGER_BUILD_CONFIG_PATH"`
-	TriggerName            string `json:"TRIGGER_NAME"`
-	RefName                string `json:"REF_NAME"`
-	BranchName             string `json:"BRANCH_NAME"`
-	RepoFullName           string `json:"REPO_FULL_NAME"`
-	CommitSha              string `json:"COMMIT_SHA"`
-	ShortSha               string `json:"SHORT_SHA"`
-	RevisionID             string `json:"REVISION_ID"`
-	RepoName               string `json:"REPO_NAME"`
-	DeployGCS              string `json:"_DEPLOY_GCS"`
+// UploadedArtifact is any of the language packages in Results.
+type UploadedArtifact struct {
+	URI        string     `json:"uri"`
+	FileHashes FileHashes `json:"fileHashes"`
+	PushTiming TimeSpan   `json:"pushTiming"`
 }
 
 type Artifacts struct {
-	Images []string `json:"images"`
+	Images         []string          `json:"images"`
+	Objects        *ArtifactObjects  `json:"objects,omitempty"`
+	MavenArtifacts []json.RawMessage `json:"mavenArtifacts"`
+	PythonPackages []json.RawMessage `json:"pythonPackages"`
+	NpmPackages    []json.RawMessage `json:"npmPackages"`
+	GoModules      []json.RawMessage `json:"goModules"`
 }
 
-type BuildMessage struct {
-	ID               string           `json:"id"`
-	Status           string           `json:"status"`
-	Source           Source           `json:"source"`
-	CreateTime       string           `json:"createTime"`
-	Steps            []Step           `json:"steps"`
-	Timeout          string           `json:"timeout"`
-	Images           []string         `json:"images"`
-	ProjectID        string           `json:"projectId"`
-	SourceProvenance SourceProvenance `json:"sourceProvenance"`
-	BuildTriggerID   string           `json:"buildTriggerId"`
-	Options          Options          `json:"options"`
-	LogUrl           string           `json:"logUrl"`
-	Substitutions    Substitutions    `json:"substitutions"`
-	Tags             []string         `json:"tags"`
-	Artifacts        Artifacts        `json:"artifacts"`
-	QueueTtl         string           `json:"queueTtl"`
-	ServiceAccount   string           `json:"serviceAccount"`
-	Name             string           `json:"name"`
+type ArtifactObjects struct {
+	Location string   `json:"location"`
+	Paths    []string `json:"paths"`
+	Timing   TimeSpan `json:"timing"`
+}
+
+type TimeSpan struct {
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+}
+
+// Duration returns how long the span took, zero if it hasn't ended.
+func (t TimeSpan) Duration() time.Duration {
+	if t.StartTime.IsZero() || t.EndTime.IsZero() {
+		return 0
+	}
+	return t.EndTime.Sub(t.StartTime)
+}
+
+type Options struct {
+	SourceProvenanceHash      []string `json:"sourceProvenanceHash"`
+	RequestedVerifyOption     string   `json:"requestedVerifyOption"`
+	MachineType               string   `json:"machineType"`
+	DiskSizeGb                Int64    `json:"diskSizeGb"`
+	SubstitutionOption        string   `json:"substitutionOption"`
+	DynamicSubstitutions      bool     `json:"dynamicSubstitutions"`
+	AutomapSubstitutions      bool     `json:"automapSubstitutions"`
+	LogStreamingOption        string   `json:"logStreamingOption"`
+	WorkerPool                string   `json:"workerPool"`
+	Pool                      Pool     `json:"pool"`
+	Logging                   string   `json:"logging"`
+	Env                       []string `json:"env"`
+	SecretEnv                 []string `json:"secretEnv"`
+	Volumes                   []Volume `json:"volumes"`
+	DefaultLogsBucketBehavior string   `json:"defaultLogsBucketBehavior"`
+}
+
+type Pool struct {
+	Name string `json:"name"`
+}
+
+type Approval struct {
+	State  string          `json:"state"`
+	Config json.RawMessage `json:"config"`
+	Result json.RawMessage `json:"result"`
+}
+
+type SourceProvenance struct {
+	ResolvedStorageSource         *StorageSource         `json:"resolvedStorageSource,omitempty"`
+	ResolvedRepoSource            *RepoSource            `json:"resolvedRepoSource,omitempty"`
+	ResolvedStorageSourceManifest *StorageSourceManifest `json:"resolvedStorageSourceManifest,omitempty"`
+	ResolvedConnectedRepository   *ConnectedRepository   `json:"resolvedConnectedRepository,omitempty"`
+	ResolvedGitSource             *GitSource             `json:"resolvedGitSource,omitempty"`
+	// Hashes of the source files, keyed by file path
+	FileHashes map[string]FileHashes `json:"fileHashes"`
+}
+
+type FileHashes struct {
+	FileHash []Hash `json:"fileHash"`
+}
+
+type Hash struct {
+	Type string `json:"type"`
+	// Value is base64 in the JSON
+	Value []byte `json:"value"`
+}
+
+type Warning struct {
+	Text     string `json:"text"`
+	Priority string `json:"priority"`
+}
+
+// FailureInfo says why a build failed, e.g. USER_BUILD_STEP and the step.
+type FailureInfo struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+// Substitutions holds every substitution of the build, built-in and user
+// defined. The methods name the ones this function uses, so templates can
+// keep writing {{.Substitutions.CommitSha}} while any user substitution is
+// reachable as {{index .Substitutions "_MY_VAR"}}.
+type Substitutions map[string]string
+
+func (s Substitutions) TriggerBuildConfigPath() string { return s["TRIGGER_BUILD_CONFIG_PATH"] }
+func (s Substitutions) TriggerName() string            { return s["TRIGGER_NAME"] }
+func (s Substitutions) RefName() string                { return s["REF_NAME"] }
+func (s Substitutions) BranchName() string             { return s["BRANCH_NAME"] }
+func (s Substitutions) TagName() string                { return s["TAG_NAME"] }
+func (s Substitutions) RepoFullName() string           { return s["REPO_FULL_NAME"] }
+func (s Substitutions) CommitSha() string              { return s["COMMIT_SHA"] }
+func (s Substitutions) ShortSha() string               { return s["SHORT_SHA"] }
+func (s Substitutions) RevisionID() string             { return s["REVISION_ID"] }
+func (s Substitutions) RepoName() string               { return s["REPO_NAME"] }
+func (s Substitutions) DeployGCS() string              { return s["_DEPLOY_GCS"] }
+func (s Substitutions) ImageMapping() string           { return s["_IMAGE_MAPPING"] }
+
+// Duration is a protobuf JSON duration such as "600s" or "3.5s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration: %w", err)
+	}
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("duration: %w", err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatFloat(time.Duration(d).Seconds(), 'f', -1, 64) + "s")
+}
+
+// Int64 decodes int64 fields, which protobuf JSON sends as strings, but
+// accepts plain numbers too.
+type Int64 int64
+
+func (i *Int64) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	n, err := strconv.ParseInt(strings.Trim(string(b), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("int64: %w", err)
+	}
+	*i = Int64(n)
+	return nil
 }
@@ -0,0 +1,135 @@
+package example
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the .golden files in testdata")
+
+// TestParseBuildGolden decodes the Cloud Build notifications in testdata
+// and compares the model, encoded again, with its .golden file. Run with
+// -update after changing the model on purpose.
+func TestParseBuildGolden(t *testing.T) {
+	payloads, err := filepath.Glob("testdata/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payloads) == 0 {
+		t.Fatal("no payloads in testdata")
+	}
+	for _, payload := range payloads {
+		name := strings.TrimSuffix(filepath.Base(payload), ".json")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := parseBuild(data)
+			if err != nil {
+				t.Fatalf("parseBuild: %v", err)
+			}
+			got, err := json.MarshalIndent(b, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+			golden := strings.TrimSuffix(payload, ".json") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run with -update to create it", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("decoded %s differs from %s:\n%s", payload, golden, got)
+			}
+		})
+	}
+}
+
+// TestParseBuild spot checks the fields this function relies on and the
+// protobuf JSON encodings the model has to undo.
+func TestParseBuild(t *testing.T) {
+	parse := func(t *testing.T, name string) *BuildMessage {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join("testdata", name+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := parseBuild(data)
+		if err != nil {
+			t.Fatalf("parseBuild: %v", err)
+		}
+		return b
+	}
+
+	t.Run("success", func(t *testing.T) {
+		b := parse(t, "success-github")
+		if b.Status != "SUCCESS" || b.BuildTriggerID != "5d2c9f7a-8b4e-4f3a-9c1d-7e6b5a4c3d2e" {
+			t.Errorf("status %s, trigger %s", b.Status, b.BuildTriggerID)
+		}
+		if len(b.Results.Images) != 1 || !strings.HasPrefix(b.Results.Images[0].Digest, "sha256:9c1b") {
+			t.Errorf("results.images = %+v, want the pushed digest", b.Results.Images)
+		}
+		if b.Results.NumArtifacts != 1 || time.Duration(b.Timeout) != 10*time.Minute || time.Duration(b.QueueTtl) != time.Hour {
+			t.Errorf("numArtifacts %d, timeout %v, queueTtl %v", b.Results.NumArtifacts, time.Duration(b.Timeout), time.Duration(b.QueueTtl))
+		}
+		if b.Substitutions.ShortSha() != "0123456" || b.Substitutions.BranchName() != "main" || b.Substitutions.ImageMapping() != "pizza=pizza" {
+			t.Errorf("substitutions = %v", b.Substitutions)
+		}
+		if b.Substitutions["_AUTHOR"] != "alice@example.com" {
+			t.Errorf("user substitution _AUTHOR = %q", b.Substitutions["_AUTHOR"])
+		}
+		if got := b.Timing["BUILD"].Duration(); got != 100388*time.Millisecond {
+			t.Errorf("BUILD took %v", got)
+		}
+		if b.SourceProvenance.ResolvedGitSource == nil || b.Source.GitSource == nil {
+			t.Errorf("git source wasn't decoded: %+v", b.Source)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		b := parse(t, "failure-step")
+		if b.FailureInfo == nil || b.FailureInfo.Type != "USER_BUILD_STEP" {
+			t.Errorf("failureInfo = %+v", b.FailureInfo)
+		}
+		if s := b.Steps[1]; s.Status != "FAILURE" || s.ExitCode != 1 || time.Duration(s.Timeout) != 5*time.Minute {
+			t.Errorf("failed step = %+v", s)
+		}
+		if time.Duration(b.Timeout) != 3500*time.Millisecond || b.Options.DiskSizeGb != 100 {
+			t.Errorf("timeout %v, diskSizeGb %d", time.Duration(b.Timeout), b.Options.DiskSizeGb)
+		}
+		if b.Source.RepoSource == nil || b.Source.RepoSource.BranchName != "feature/crust" {
+			t.Errorf("repo source = %+v", b.Source.RepoSource)
+		}
+	})
+
+	t.Run("working", func(t *testing.T) {
+		b := parse(t, "working-storage")
+		if b.Source.StorageSource == nil || b.Source.StorageSource.Generation != 1760695200654321 {
+			t.Errorf("storage source = %+v", b.Source.StorageSource)
+		}
+		if b.Steps[0].Timing.Duration() != 0 || !b.FinishTime.IsZero() || b.QueueTtl != 0 {
+			t.Errorf("unfinished build has timings: step %v, finish %v, queueTtl %v", b.Steps[0].Timing, b.FinishTime, b.QueueTtl)
+		}
+		hashes := b.SourceProvenance.FileHashes["gs://deploy-demo_cloudbuild/source/1760695200.123456-3f2e1d0c.tgz#1760695200654321"]
+		if len(hashes.FileHash) != 1 || len(hashes.FileHash[0].Value) != 8 {
+			t.Errorf("file hashes = %+v", hashes)
+		}
+	})
+
+	if _, err := parseBuild([]byte(`{"id": "b", "timeout": "ten minutes"}`)); err == nil {
+		t.Error("parseBuild accepted a malformed duration")
+	}
+}
@@ -0,0 +1,21 @@
+// Command main serves the function locally with the Functions Framework.
+// FUNCTION_TARGET picks the function and PORT the port (default 8080).
+package main
+
+import (
+	"log"
+	"os"
+
+	_ "example.com/example"
+	"github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
+)
+
+func main() {
+	port := "8080"
+	if envPort := os.Getenv("PORT"); envPort != "" {
+		port = envPort
+	}
+	if err := funcframework.Start(port); err != nil {
+		log.Fatalf("funcframework.Start: %v\n", err)
+	}
+}
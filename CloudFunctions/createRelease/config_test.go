@@ -0,0 +1,76 @@
+package example
+
+import (
+	"context"
+	"testing"
+
+	"example.com/shared/failure"
+)
+
+// TestConfigErrorsArePermanent checks bad configuration is dead-lettered
+// rather than redelivered forever.
+func TestConfigErrorsArePermanent(t *testing.T) {
+	saved := c
+	t.Cleanup(func() { c = saved })
+	b := &BuildMessage{ID: "build-1", BuildTriggerID: "trigger"}
+
+	for _, tt := range []struct {
+		name string
+		set  func()
+		run  func() error
+	}{
+		{
+			name: "summary template doesn't parse",
+			set:  func() { c.JiraSummary = "Release {{.Substitutions.ShortSha" },
+			run:  func() error { _, err := render("summary", c.JiraSummary, b); return err },
+		},
+		{
+			name: "summary template doesn't render",
+			set:  func() { c.JiraSummary = "Release {{.NoSuchField}}" },
+			run:  func() error { _, err := render("summary", c.JiraSummary, b); return err },
+		},
+		{
+			name: "JIRA_FIELDS isn't JSON",
+			set:  func() { c.JiraFields = "labels=deploy" },
+			run:  func() error { _, err := issueFields(b); return err },
+		},
+		{
+			name: "JIRA_BUILD_TRANSITIONS isn't pairs",
+			set:  func() { c.JiraBuildTransitions = "WORKING" },
+			run:  func() error { return transitionBuildIssue(context.Background(), nil, "DEP-1", "WORKING") },
+		},
+		{
+			name: "ROUTES isn't JSON",
+			set:  func() { c.Routes = "[{" },
+			run:  func() error { _, err := loadRoutes(); return err },
+		},
+		{
+			name: "route matches every build",
+			set:  func() { c.Routes = `[{"pipeline": "p"}]` },
+			run:  func() error { _, err := loadRoutes(); return err },
+		},
+		{
+			name: "route pattern is invalid",
+			run:  func() error { _, err := route([]Route{{TriggerID: "[", Pipeline: "p"}}, b); return err },
+		},
+		{
+			name: "no routes",
+			set:  func() { c.Routes, c.RoutesFile, c.TriggerID, c.Pipeline = "", "", "", "" },
+			run:  func() error { _, err := loadRoutes(); return err },
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c = saved
+			if tt.set != nil {
+				tt.set()
+			}
+			err := tt.run()
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if got := failure.Classify(err); got != failure.Permanent {
+				t.Errorf("%v is %s, want permanent", err, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package example
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"example.com/shared/failure"
+	artifactregistry "google.golang.org/api/artifactregistry/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// pinImage returns image pinned to the digest it was pushed with, e.g.
+// us-docker.pkg.dev/p/repo/app@sha256:... for us-docker.pkg.dev/p/repo/app:latest.
+// The digest comes from the build's results, or failing that from the tag
+// in Artifact Registry. An image without a digest is never released, as its
+// tag could point at different content by the time it's deployed.
+func pinImage(ctx context.Context, b *BuildMessage, image string) (string, error) {
+	if strings.Contains(image, "@sha256:") {
+		return image, nil
+	}
+	for _, built := range b.Results.Images {
+		if built.Name == image && built.Digest != "" {
+			return repository(image) + "@" + built.Digest, nil
+		}
+	}
+	log.Printf("Build %s has no digest for %s, asking Artifact Registry", b.ID, image)
+	digest, err := resolveTag(ctx, image)
+	if err != nil {
+		return "", fmt.Errorf("no digest for %s: %w", image, err)
+	}
+	return repository(image) + "@" + digest, nil
+}
+
+// resolveTag looks up the version an Artifact Registry Docker tag points
+// at. ARTIFACT_REGISTRY_ENDPOINT points it at a stand-in without
+// authentication.
+func resolveTag(ctx context.Context, image string) (string, error) {
+	name, err := tagName(image)
+	if err != nil {
+		return "", failure.NewPermanent(err)
+	}
+	var opts []option.ClientOption
+	if c.ArtifactRegistryEndpoint != "" {
+		opts = append(opts, option.WithEndpoint(c.ArtifactRegistryEndpoint), option.WithoutAuthentication())
+	}
+	svc, err := artifactregistry.NewService(ctx, opts...)
+	if err != nil {
+		return "", fmt.Errorf("error creating Artifact Registry client: %w", err)
+	}
+	tag, err := svc.Projects.Locations.Repositories.Packages.Tags.Get(name).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return "", failure.NewPermanent(fmt.Errorf("tag %s not found: %w", name, err))
+	}
+	if err != nil {
+		return "", fmt.Errorf("error getting tag %s: %w", name, err)
+	}
+	_, digest, ok := strings.Cut(tag.Version, "/versions/")
+	if !ok || !strings.HasPrefix(digest, "sha256:") {
+		return "", failure.NewPermanent(fmt.Errorf("tag %s points at %q, not a digest", name, tag.Version))
+	}
+	return digest, nil
+}
+
+// tagName maps LOCATION-docker.pkg.dev/PROJECT/REPOSITORY/IMAGE:TAG to its
+// Artifact Registry tag resource name. Other registries can't be resolved.
+func tagName(image string) (string, error) {
+	repo := repository(image)
+	tag := strings.TrimPrefix(image[len(repo):], ":")
+	if tag == "" {
+		tag = "latest"
+	}
+	parts := strings.SplitN(repo, "/", 4)
+	if len(parts) < 4 || !strings.HasSuffix(parts[0], "-docker.pkg.dev") {
+		return "", fmt.Errorf("%s isn't an Artifact Registry image", image)
+	}
+	location := strings.TrimSuffix(parts[0], "-docker.pkg.dev")
+	// Slashes in the package are escaped in the resource name
+	return fmt.Sprintf("projects/%s/locations/%s/repositories/%s/packages/%s/tags/%s",
+		parts[1], location, parts[2], url.PathEscape(parts[3]), url.PathEscape(tag)), nil
+}
@@ -0,0 +1,174 @@
+package example
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/configfile"
+	"example.com/shared/failure"
+	"google.golang.org/api/googleapi"
+)
+
+// buildArtifacts maps every image the build pushed to the Skaffold image
+// name it replaces in the manifests (e.g. "pizza" in CloudRun/run.yaml).
+//
+// The mapping comes from IMAGE_MAPPING, overridden by the route's
+// imageMapping, then by IMAGE_MAPPING_FILE in the build's Skaffold config
+// tarball and last by the _IMAGE_MAPPING substitution. All are
+// "repository=name" pairs, where repository is either the full image path
+// without tag or digest, or just its last segment. Unmapped images keep
+// their last segment as the name, which is Skaffold's own convention.
+// Every image is pinned to its digest, see pinImage.
+func buildArtifacts(ctx context.Context, b *BuildMessage, routeMapping string) ([]*deploypb.BuildArtifact, error) {
+	mapping, err := parseImageMapping(c.ImageMapping)
+	if err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("error parsing IMAGE_MAPPING: %w", err))
+	}
+	routeOverrides, err := parseImageMapping(routeMapping)
+	if err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("error parsing route imageMapping: %w", err))
+	}
+	fileOverrides, err := tarballMapping(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	buildOverrides, err := parseImageMapping(b.Substitutions.ImageMapping())
+	if err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("error parsing _IMAGE_MAPPING: %w", err))
+	}
+	for _, overrides := range []map[string]string{routeOverrides, fileOverrides, buildOverrides} {
+		for k, v := range overrides {
+			mapping[k] = v
+		}
+	}
+
+	var artifacts []*deploypb.BuildArtifact
+	for _, image := range b.Artifacts.Images {
+		repo := repository(image)
+		short := repo[strings.LastIndex(repo, "/")+1:]
+		name, ok := mapping[repo]
+		if !ok {
+			name, ok = mapping[short]
+		}
+		if !ok {
+			name = short
+		}
+		pinned, err := pinImage(ctx, b, image)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Mapping image %s to %s", pinned, name)
+		artifacts = append(artifacts, &deploypb.BuildArtifact{
+			// Tag == Container Image
+			Tag: pinned,
+			// Image == The template substitution variable in the manifests
+			Image: name,
+		})
+	}
+	return artifacts, nil
+}
+
+// skaffoldConfigURI is where the build uploaded the tarball with its
+// Skaffold config and manifests.
+func skaffoldConfigURI(b *BuildMessage) string {
+	return fmt.Sprintf("%s/%s.tar.gz", b.Substitutions.DeployGCS(), b.Substitutions.CommitSha())
+}
+
+// tarballMapping reads the IMAGE_MAPPING_FILE pairs out of the build's
+// Skaffold config tarball, one or more per line with # starting a comment.
+// A tarball or file that isn't there maps nothing.
+func tarballMapping(ctx context.Context, b *BuildMessage) (map[string]string, error) {
+	if c.ImageMappingFile == "" || b.Substitutions.DeployGCS() == "" {
+		return nil, nil
+	}
+	uri := skaffoldConfigURI(b)
+	_, data, err := configfile.Read(ctx, uri, "")
+	var apiErr *googleapi.Error
+	if errors.Is(err, fs.ErrNotExist) || (errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound) {
+		log.Printf("No Skaffold config tarball at %s to read %s from", uri, c.ImageMappingFile)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	text, err := readFromTarball(data, c.ImageMappingFile)
+	if err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("error reading %s: %w", uri, err))
+	}
+	if text == nil {
+		log.Printf("%s has no %s", uri, c.ImageMappingFile)
+		return nil, nil
+	}
+	var pairs []string
+	for _, line := range strings.Split(string(text), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		pairs = append(pairs, line)
+	}
+	mapping, err := parseImageMapping(strings.Join(pairs, ","))
+	if err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("error parsing %s in %s: %w", c.ImageMappingFile, uri, err))
+	}
+	return mapping, nil
+}
+
+// readFromTarball returns the contents of the file called name in a
+// gzipped tarball, or nil if it has none.
+func readFromTarball(data []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && path.Clean(hdr.Name) == path.Clean(name) {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// repository strips the tag or digest off an image reference, leaving a
+// registry port alone.
+func repository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// parseImageMapping parses "app=pizza,worker=worker-image".
+func parseImageMapping(s string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		repo, name, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(repo) == "" || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("expected repository=name, got %q", pair)
+		}
+		mapping[strings.TrimSpace(repo)] = strings.TrimSpace(name)
+	}
+	return mapping, nil
+}
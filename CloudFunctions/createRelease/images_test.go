@@ -0,0 +1,130 @@
+package example
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"example.com/shared/failure"
+)
+
+// writeTarball writes the files as <dir>/<sha>.tar.gz, the way the build
+// uploads its Skaffold config.
+func writeTarball(t *testing.T, dir, sha string, files map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, body := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, sha+".tar.gz"), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildArtifacts(t *testing.T) {
+	saved := c
+	t.Cleanup(func() { c = saved })
+	const (
+		app    = "us-docker.pkg.dev/p/apps/app@sha256:0a"
+		worker = "us-docker.pkg.dev/p/apps/worker@sha256:0b"
+		cron   = "us-docker.pkg.dev/p/apps/cron@sha256:0c"
+	)
+	dir := t.TempDir()
+	writeTarball(t, dir, "with-file", map[string]string{
+		"skaffold.yaml": "apiVersion: skaffold/v4beta7\n",
+		"./image-mapping.txt": "# Images of this commit\n" +
+			"worker=worker-image\n" +
+			"cron=cron-image, app=from-file\n",
+	})
+	writeTarball(t, dir, "without-file", map[string]string{"skaffold.yaml": ""})
+	writeTarball(t, dir, "bad-file", map[string]string{"image-mapping.txt": "worker\n"})
+
+	for _, tt := range []struct {
+		name          string
+		sha           string
+		route         string
+		substitution  string
+		want          map[string]string
+		wantPermanent bool
+	}{
+		{
+			name: "env and route only",
+			sha:  "none",
+			// IMAGE_MAPPING is app=pizza
+			route: "worker=from-route",
+			want:  map[string]string{app: "pizza", worker: "from-route", cron: "cron"},
+		},
+		{
+			name:  "file overrides env and route",
+			sha:   "with-file",
+			route: "worker=from-route",
+			want:  map[string]string{app: "from-file", worker: "worker-image", cron: "cron-image"},
+		},
+		{
+			name:         "substitution overrides the file",
+			sha:          "with-file",
+			substitution: "us-docker.pkg.dev/p/apps/cron=from-substitution",
+			want:         map[string]string{app: "from-file", worker: "worker-image", cron: "from-substitution"},
+		},
+		{
+			name: "tarball without the file",
+			sha:  "without-file",
+			want: map[string]string{app: "pizza", worker: "worker", cron: "cron"},
+		},
+		{
+			name:          "file that doesn't parse",
+			sha:           "bad-file",
+			wantPermanent: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c = saved
+			c.ImageMapping = "app=pizza"
+			c.ImageMappingFile = "image-mapping.txt"
+			b := &BuildMessage{
+				ID:            "build-1",
+				Artifacts:     Artifacts{Images: []string{app, worker, cron}},
+				Substitutions: Substitutions{"_DEPLOY_GCS": dir, "COMMIT_SHA": tt.sha, "_IMAGE_MAPPING": tt.substitution},
+			}
+			artifacts, err := buildArtifacts(context.Background(), b, tt.route)
+			if tt.wantPermanent {
+				if err == nil || failure.Classify(err) != failure.Permanent {
+					t.Fatalf("buildArtifacts = %v, want a permanent error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildArtifacts: %v", err)
+			}
+			got := map[string]string{}
+			for _, a := range artifacts {
+				got[a.Tag] = a.Image
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			for image, name := range tt.want {
+				if got[image] != name {
+					t.Errorf("%s is mapped to %q, want %q", image, got[image], name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package example
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// issueClaimTimeout is how long a claim without an issue is honoured. It's
+// well past the function timeout, so a claim this old belongs to a crashed
+// attempt.
+const issueClaimTimeout = 10 * time.Minute
+
+// issueRetention is how long claims are kept, long after the build's last
+// notification; the Firestore TTL policy on expireAt deletes them after.
+const issueRetention = 30 * 24 * time.Hour
+
+// IssueClaim is what the IssueStore keeps about a build.
+type IssueClaim struct {
+	// Key is the build's issue, empty while it's being created
+	Key      string    `firestore:"key"`
+	Claimed  time.Time `firestore:"claimed"`
+	ExpireAt time.Time `firestore:"expireAt"`
+}
+
+// IssueStore makes sure a build gets a single Jira issue when its QUEUED,
+// WORKING and SUCCESS notifications arrive together. Jira's search only
+// sees new issues after a while, so it can't tell on its own.
+type IssueStore interface {
+	// Claim makes the caller the one opening the build's issue. If another
+	// attempt opened it, or is opening it and hasn't timed out, it returns
+	// that claim and false.
+	Claim(ctx context.Context, buildID string) (*IssueClaim, bool, error)
+	// Finish records the issue opened for a claimed build. An empty key
+	// drops the claim so a redelivery can try again.
+	Finish(ctx context.Context, buildID, key string) error
+	Close() error
+}
+
+// newIssueStore returns the IssueStore selected by ISSUE_STORE.
+func newIssueStore(ctx context.Context) (IssueStore, error) {
+	switch c.IssueStore {
+	case "firestore":
+		client, err := firestore.NewClientWithDatabase(ctx, c.ProjectId, c.FirestoreDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("firestore.NewClient: %w", err)
+		}
+		return &FirestoreIssueStore{client: client, collection: c.IssueCollection}, nil
+	case "memory":
+		return memIssues, nil
+	default:
+		return nil, fmt.Errorf("unknown ISSUE_STORE %q", c.IssueStore)
+	}
+}
+
+// claimable says whether a new attempt may take over an existing claim.
+func (claim IssueClaim) claimable() bool {
+	return claim.Key == "" && time.Since(claim.Claimed) > issueClaimTimeout
+}
+
+func newIssueClaim() IssueClaim {
+	now := time.Now().UTC()
+	return IssueClaim{Claimed: now, ExpireAt: now.Add(issueRetention)}
+}
+
+// memIssues lives as long as the function instance, which is enough for
+// tests and local runs but not for production.
+var memIssues = NewMemoryIssueStore()
+
+// MemoryIssueStore is an in-process IssueStore.
+type MemoryIssueStore struct {
+	mu     sync.Mutex
+	claims map[string]IssueClaim
+}
+
+func NewMemoryIssueStore() *MemoryIssueStore {
+	return &MemoryIssueStore{claims: map[string]IssueClaim{}}
+}
+
+func (s *MemoryIssueStore) Claim(ctx context.Context, buildID string) (*IssueClaim, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.claims[buildID]; ok && !existing.claimable() {
+		return &existing, false, nil
+	}
+	claim := newIssueClaim()
+	s.claims[buildID] = claim
+	return &claim, true, nil
+}
+
+func (s *MemoryIssueStore) Finish(ctx context.Context, buildID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	claim, ok := s.claims[buildID]
+	if !ok {
+		return fmt.Errorf("no claim for build %s", buildID)
+	}
+	if key == "" {
+		delete(s.claims, buildID)
+		return nil
+	}
+	claim.Key = key
+	s.claims[buildID] = claim
+	return nil
+}
+
+func (s *MemoryIssueStore) Close() error {
+	return nil
+}
+
+// FirestoreIssueStore keeps one document per build.
+type FirestoreIssueStore struct {
+	client     *firestore.Client
+	collection string
+}
+
+func (s *FirestoreIssueStore) Claim(ctx context.Context, buildID string) (*IssueClaim, bool, error) {
+	ref := s.client.Collection(s.collection).Doc(buildID)
+	var existing *IssueClaim
+	claim := newIssueClaim()
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		existing = nil
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if snap.Exists() {
+			var current IssueClaim
+			if err := snap.DataTo(&current); err != nil {
+				return err
+			}
+			if !current.claimable() {
+				existing = &current
+				return nil
+			}
+		}
+		return tx.Set(ref, claim)
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("claiming the issue of build %s: %w", buildID, err)
+	}
+	if existing != nil {
+		return existing, false, nil
+	}
+	return &claim, true, nil
+}
+
+func (s *FirestoreIssueStore) Finish(ctx context.Context, buildID, key string) error {
+	ref := s.client.Collection(s.collection).Doc(buildID)
+	var err error
+	if key == "" {
+		_, err = ref.Delete(ctx)
+	} else {
+		_, err = ref.Update(ctx, []firestore.Update{{Path: "key", Value: key}})
+	}
+	if err != nil {
+		return fmt.Errorf("recording the issue of build %s: %w", buildID, err)
+	}
+	return nil
+}
+
+// Close releases the Firestore client.
+func (s *FirestoreIssueStore) Close() error {
+	return s.client.Close()
+}
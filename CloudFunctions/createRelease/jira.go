@@ -0,0 +1,142 @@
+package example
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"text/template"
+
+	"example.com/shared/failure"
+	"example.com/shared/jira"
+)
+
+// createReleaseIssue opens the Jira issue that tracks a release. Summary,
+// description and any extra field values are text/templates rendered against
+// the build notification so they can be tuned per project through env.
+func createReleaseIssue(ctx context.Context, b *BuildMessage) (*jira.Issue, error) {
+	summary, err := render("summary", c.JiraSummary, b)
+	if err != nil {
+		return nil, err
+	}
+	description, err := render("description", c.JiraDescription, b)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := issueFields(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return jiraClient().CreateIssue(ctx, jira.IssueRequest{
+		ProjectKey:  c.JiraProject,
+		IssueType:   c.JiraIssueType,
+		Summary:     summary,
+		Description: description,
+		Fields:      fields,
+	})
+}
+
+// buildIssue returns the issue tracking the build, or opens it if this is
+// the build's first notification. The build's notifications often arrive
+// together and Jira's search lags behind new issues, so the build is
+// claimed in the IssueStore before an issue is opened.
+func buildIssue(ctx context.Context, b *BuildMessage) (*jira.Issue, error) {
+	store, err := newIssueStore(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating issue store: %w", err)
+	}
+	defer store.Close()
+	claim, claimed, err := store.Claim(ctx, b.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		if claim.Key == "" {
+			// Redelivered once the other attempt has opened it or given up
+			return nil, fmt.Errorf("issue of build %s is being opened by another notification", b.ID)
+		}
+		return jiraClient().GetIssue(ctx, claim.Key)
+	}
+
+	// Builds from before the store may already have an issue
+	issues, err := jiraClient().SearchIssues(ctx, jira.BuildLabelJQL(b.ID))
+	if err != nil {
+		finishIssue(ctx, store, b.ID, "")
+		return nil, err
+	}
+	if len(issues) > 0 {
+		finishIssue(ctx, store, b.ID, issues[0].Key)
+		return &issues[0], nil
+	}
+	issue, err := createReleaseIssue(ctx, b)
+	if err != nil {
+		finishIssue(ctx, store, b.ID, "")
+		return nil, err
+	}
+	log.Printf("Created Jira issue %s for build %s", issue.Key, b.ID)
+	finishIssue(ctx, store, b.ID, issue.Key)
+	return issue, nil
+}
+
+// finishIssue records the build's issue, or drops its claim when key is
+// empty. Failing only costs a wait for the claim to time out, so it's
+// logged rather than returned.
+func finishIssue(ctx context.Context, store IssueStore, buildID, key string) {
+	if err := store.Finish(ctx, buildID, key); err != nil {
+		log.Printf("error finishing the issue claim of build %s: %v", buildID, err)
+	}
+}
+
+// linkIssueToRelease records the release name on the issue so Jira webhooks,
+// which only carry the issue, can be mapped back to the release's rollouts.
+func linkIssueToRelease(ctx context.Context, key, release string) error {
+	return jiraClient().SetProperty(ctx, key, jira.ReleaseProperty, jira.ReleaseLink{Release: release})
+}
+
+func jiraClient() *jira.Client {
+	return jira.NewClient(c.JiraURL, c.JiraEmail, c.JiraToken)
+}
+
+// issueFields decodes the JIRA_FIELDS mapping (Jira field ID -> value).
+// String values are treated as templates, anything else is sent as is, e.g.
+// {"labels": ["deploy"], "customfield_10042": "{{.Substitutions.CommitSha}}"}
+// The build label is always added to labels.
+func issueFields(b *BuildMessage) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if c.JiraFields != "" {
+		if err := json.Unmarshal([]byte(c.JiraFields), &fields); err != nil {
+			return nil, failure.NewPermanent(fmt.Errorf("error parsing JIRA_FIELDS: %w", err))
+		}
+	}
+	if fields == nil {
+		fields = map[string]interface{}{}
+	}
+	labels, _ := fields["labels"].([]interface{})
+	fields["labels"] = append(labels, jira.BuildLabel(b.ID))
+	for k, v := range fields {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		rendered, err := render(k, s, b)
+		if err != nil {
+			return nil, err
+		}
+		fields[k] = rendered
+	}
+	return fields, nil
+}
+
+func render(name, text string, b *BuildMessage) (string, error) {
+	t, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", failure.NewPermanent(fmt.Errorf("error parsing %s template: %w", name, err))
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, b); err != nil {
+		return "", failure.NewPermanent(fmt.Errorf("error rendering %s template: %w", name, err))
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,70 @@
+package example
+
+import (
+	"context"
+	"testing"
+
+	"example.com/shared/failure"
+	"example.com/shared/jira/jiratest"
+)
+
+func TestBuildIssue(t *testing.T) {
+	s := jiratest.NewServer()
+	t.Cleanup(s.Close)
+	saved, savedIssues := c, memIssues
+	t.Cleanup(func() { c, memIssues = saved, savedIssues })
+	c.JiraURL, c.JiraEmail, c.JiraToken = s.URL+"/", "bot@example.com", "token"
+	c.JiraProject, c.JiraIssueType = "DEP", "Task"
+	c.JiraSummary, c.JiraDescription, c.JiraFields = "Release {{.ID}}", "Build {{.ID}}", ""
+	c.IssueStore = "memory"
+	memIssues = NewMemoryIssueStore()
+	ctx := context.Background()
+
+	b := &BuildMessage{ID: "build-1"}
+	first, err := buildIssue(ctx, b)
+	if err != nil {
+		t.Fatalf("buildIssue: %v", err)
+	}
+	again, err := buildIssue(ctx, b)
+	if err != nil {
+		t.Fatalf("buildIssue again: %v", err)
+	}
+	if first.Key != "DEP-1" || again.Key != first.Key {
+		t.Errorf("buildIssue = %s then %s, want DEP-1 both times", first.Key, again.Key)
+	}
+
+	// Another notification of the build is opening its issue
+	if _, _, err := memIssues.Claim(ctx, "build-2"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = buildIssue(ctx, &BuildMessage{ID: "build-2"})
+	if err == nil || failure.Classify(err) != failure.Transient {
+		t.Errorf("buildIssue while claimed = %v, want a transient error", err)
+	}
+	if s.Issue("DEP-2") != nil {
+		t.Error("opened a second issue while the build was claimed")
+	}
+	if err := memIssues.Finish(ctx, "build-2", "DEP-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := buildIssue(ctx, &BuildMessage{ID: "build-2"}); err != nil || got.Key != "DEP-1" {
+		t.Errorf("buildIssue once finished = %v, %v, want DEP-1", got, err)
+	}
+}
+
+func TestIssueClaimDroppedOnFailure(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryIssueStore()
+	if _, claimed, _ := store.Claim(ctx, "build-1"); !claimed {
+		t.Fatal("first Claim didn't claim")
+	}
+	if _, claimed, _ := store.Claim(ctx, "build-1"); claimed {
+		t.Fatal("second Claim claimed too")
+	}
+	if err := store.Finish(ctx, "build-1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed, _ := store.Claim(ctx, "build-1"); !claimed {
+		t.Error("Claim after a dropped claim didn't claim")
+	}
+}
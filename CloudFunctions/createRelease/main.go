@@ -2,15 +2,18 @@ package example
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 
-	deploy "cloud.google.com/go/deploy/apiv1"
 	"cloud.google.com/go/deploy/apiv1/deploypb"
-	"cloud.google.com/go/pubsub"
+	"example.com/shared/command"
+	"example.com/shared/deployclient"
+	"example.com/shared/events"
+	"example.com/shared/failure"
+	"example.com/shared/jira"
+	"example.com/shared/once"
+	"example.com/shared/provenance"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/cloudevents/sdk-go/v2/event"
 	"github.com/codingconcepts/env"
@@ -18,157 +21,222 @@ import (
 
 type config struct {
 	// Truthfully project ID and location might be able to be gathered by the function instead of env
-	ProjectId   string `env:"PROJECTID" required:"true"`
-	Location    string `env:"LOCATION" required:"true"`
-	Pipeline    string `env:"PIPELINE" required:"true"`
-	TriggerID   string `env:"TRIGGER" required:"true"`
-	SendTopicID string `env:"SENDTOPICID" required:"true"`
+	ProjectId string `env:"PROJECTID" required:"true"`
+	Location  string `env:"LOCATION" required:"true"`
+	// A single trigger -> pipeline route, used when no routing table is set
+	Pipeline  string `env:"PIPELINE"`
+	TriggerID string `env:"TRIGGER"`
+	// Routing table as JSON or YAML, inline or in a file, see Route
+	Routes     string `env:"ROUTES"`
+	RoutesFile string `env:"ROUTES_FILE"`
+	// text/template for release IDs, see ReleaseNameData. Releases are
+	// named after their Jira issue by default.
+	ReleaseIDTemplate string `env:"RELEASE_ID_TEMPLATE" default:"{{.JiraKey}}"`
+	SendTopicID       string `env:"SENDTOPICID" required:"true"`
+	// Every status change of a routed build is published here when set
+	BuildEventsTopicID string `env:"BUILDEVENTSTOPICID"`
+	// Notifications that can never be processed are parked here
+	DeadLetterTopicID string `env:"DEADLETTERTOPICID" required:"true"`
+
+	// Jira site and credentials used to open an issue per release
+	JiraURL     string `env:"JIRA_URL" required:"true"`
+	JiraEmail   string `env:"JIRA_EMAIL" required:"true"`
+	JiraToken   string `env:"JIRA_API_TOKEN" required:"true"`
+	JiraProject string `env:"JIRA_PROJECT" required:"true"`
+	// Issue type, summary, description and extra fields are all configurable.
+	// Summary/description/string field values are templates over BuildMessage.
+	JiraIssueType   string `env:"JIRA_ISSUE_TYPE" default:"Task"`
+	JiraSummary     string `env:"JIRA_SUMMARY" default:"Release {{.Substitutions.ShortSha}} of {{.Substitutions.RepoName}}"`
+	JiraDescription string `env:"JIRA_DESCRIPTION" default:"Commit: {{.Substitutions.CommitSha}}\nBranch: {{.Substitutions.BranchName}}\nBuild log: {{.LogUrl}}"`
+	JiraFields      string `env:"JIRA_FIELDS"`
+	// Comma separated BUILD_STATUS=Jira status pairs the build's issue is
+	// moved to
+	JiraBuildTransitions string `env:"JIRA_BUILD_TRANSITIONS" default:"WORKING=In Progress,FAILURE=Failed,INTERNAL_ERROR=Failed,TIMEOUT=Failed,CANCELLED=Failed,EXPIRED=Failed"`
+
+	// Comma separated repository=name pairs mapping pushed images to the
+	// Skaffold image names in the manifests, see buildArtifacts
+	ImageMapping string `env:"IMAGE_MAPPING" default:"app=pizza"`
+	// File in the Skaffold config tarball the build uploads to _DEPLOY_GCS
+	// with more pairs, e.g. image-mapping.txt; not looked for when empty
+	ImageMappingFile string `env:"IMAGE_MAPPING_FILE"`
+	// Overrides the Artifact Registry API endpoint used to resolve tags
+	// the build didn't report a digest for
+	ArtifactRegistryEndpoint string `env:"ARTIFACT_REGISTRY_ENDPOINT"`
+	// Substitution naming the release's author, e.g. set by the trigger
+	// from the pusher's email, used to stop self-approval
+	AuthorSubstitution string `env:"AUTHOR_SUBSTITUTION" default:"_AUTHOR"`
+	// Substitution with the commit's time, for lead time metrics
+	CommitTimeSubstitution string `env:"COMMIT_TIME_SUBSTITUTION" default:"_COMMIT_TIME"`
+
+	// Where builds claim their Jira issue, "firestore" or "memory"
+	IssueStore        string `env:"ISSUE_STORE" default:"firestore"`
+	FirestoreDatabase string `env:"FIRESTORE_DATABASE" default:"(default)"`
+	IssueCollection   string `env:"ISSUE_COLLECTION" default:"deploy-build-issues"`
+
+	// Where the comments already posted for a build status are claimed, so
+	// redeliveries don't repeat them, "firestore" or "memory"
+	OnceStore      string `env:"ONCE_STORE" default:"firestore"`
+	OnceCollection string `env:"ONCE_COLLECTION" default:"deploy-once"`
 }
 
 var c config
 
-func init() {
-	functions.CloudEvent("deployTrigger", deployTrigger)
-	//Load env variables using "github.com/codingconcepts/env"
-	if err := env.Set(&c); err != nil {
-		_ = fmt.Errorf("error getting env: %s", err)
+// sideEffects claims the Jira comments of a build status, so redeliveries
+// after a later step fails don't repeat them.
+func sideEffects() once.Config {
+	return once.Config{
+		Store:      c.OnceStore,
+		ProjectID:  c.ProjectId,
+		Database:   c.FirestoreDatabase,
+		Collection: c.OnceCollection,
 	}
 }
 
-type PubSubMessage struct {
-	Data []byte `json:"data"`
-}
-
-type MessagePublishedData struct {
-	Message PubSubMessage
+func init() {
+	functions.CloudEvent("deployTrigger", deployTrigger)
 }
 
-type CommandMessage struct {
-	Commmand      string                        `json:"command"`
-	CreateRelease deploypb.CreateReleaseRequest `json:"createReleaseRequest"`
-}
+// loadConfig reads the environment on the first invocation rather than in
+// init, so tests can set it up in TestMain first.
+var loadConfig = sync.OnceFunc(func() {
+	//Load env variables using "github.com/codingconcepts/env"
+	if err := env.Set(&c); err != nil {
+		log.Fatalf("error getting env: %s", err)
+	}
+	if err := env.Set(&notifications); err != nil {
+		log.Fatalf("error getting env: %s", err)
+	}
+})
 
 func deployTrigger(ctx context.Context, e event.Event) error {
+	loadConfig()
 	log.Printf("Deploy trigger function invoked")
 
+	failures := failure.NewHandler("createRelease", c.ProjectId, c.DeadLetterTopicID)
+
 	// Parse the Pub/Sub message data
-	var msg MessagePublishedData
-	if err := e.DataAs(&msg); err != nil {
-		return fmt.Errorf("event.DataAs: %w", err)
+	msg, err := events.Decode(e)
+	if err != nil {
+		return failures.Handle(ctx, e.Data(), failure.NewPermanent(err))
+	}
+	if err := handleBuild(ctx, msg); err != nil {
+		return failures.Handle(ctx, msg.Message.Data, err, "messageId", msg.Message.MessageID)
 	}
+	return nil
+}
 
+// handleBuild follows every status change of a routed build and creates a
+// release once it succeeds.
+func handleBuild(ctx context.Context, msg *events.MessagePublishedData) error {
 	// Unmarshal the CloudBuild data
 	log.Printf("Converting Byte to Struct Object")
-	var buildNotification BuildMessage
-	if err := json.Unmarshal(msg.Message.Data, &buildNotification); err != nil {
-		return fmt.Errorf("error parsing JIRA notification: %v", err)
+	buildNotification, err := parseBuild(msg.Message.Data)
+	if err != nil {
+		return failure.NewPermanent(fmt.Errorf("error parsing build notification: %w", err))
+	}
+	routes, err := loadRoutes()
+	if err != nil {
+		return err
+	}
+	r, err := route(routes, buildNotification)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		log.Printf("No route matches build %s of trigger %s, returning early", buildNotification.ID, buildNotification.BuildTriggerID)
+		return nil
 	}
 	log.Printf("Checking if proper build")
-	if buildNotification.BuildTriggerID != c.TriggerID || buildNotification.Status != "SUCCESS" {
-		log.Printf("Build trigger ID or status does not match, returning early")
+	if !trackedStatuses[buildNotification.Status] {
+		log.Printf("Build status is %s, returning early", buildNotification.Status)
 		// Acknowledge the event, depending on how the event system is expecting it
 		return nil // Return nil to indicate successful processing of the event, even if we don't process further
 	}
-	log.Printf("Pulling relavent image")
-	// Extract relevant information from the JIRA notification
-	image := buildNotification.Artifacts.Images[0]
-	// ... extract other necessary details
+	log.Printf("Routing %s build %s to pipeline %s", buildNotification.Status, buildNotification.ID, r.Pipeline)
+	issue, err := trackBuild(ctx, buildNotification, r)
+	if err != nil {
+		return err
+	}
+	if buildNotification.Status != "SUCCESS" {
+		return nil
+	}
 
-	log.Printf("Received Image from Cloud Build: %s", image)
+	log.Printf("Pulling relavent images")
+	if len(buildNotification.Artifacts.Images) == 0 {
+		log.Printf("Build %s pushed no images, nothing to release", buildNotification.ID)
+		return nil
+	}
+	artifacts, err := buildArtifacts(ctx, buildNotification, r.ImageMapping)
+	if err != nil {
+		return err
+	}
+	log.Printf("Received %d image(s) from Cloud Build", len(artifacts))
 
 	// Create a new Cloud Deploy client
-	deployClient, err := deploy.NewCloudDeployClient(ctx)
+	deployClient, err := deployclient.New(ctx)
 	if err != nil {
-		return fmt.Errorf("error creating Cloud Deploy client: %v", err)
+		return fmt.Errorf("error creating Cloud Deploy client: %w", err)
 	}
 	defer deployClient.Close()
 
 	// Get the delivery pipeline
-	pipelineName := fmt.Sprintf("projects/%s/locations/%s/deliveryPipelines/%s", c.ProjectId, c.Location, c.Pipeline)
+	pipelineName := fmt.Sprintf("projects/%s/locations/%s/deliveryPipelines/%s", c.ProjectId, c.Location, r.Pipeline)
 	pipeline, err := deployClient.GetDeliveryPipeline(ctx, &deploypb.GetDeliveryPipelineRequest{
 		Name: pipelineName,
 	})
 	if err != nil {
-		return fmt.Errorf("error getting delivery pipeline: %v", err)
+		return fmt.Errorf("error getting delivery pipeline: %w", err)
 	}
 
-	randomID, err := generateRandomID(6) // Generate a random ID of 6 bytes (12 hex characters)
+	// Name the release
+	nameData := releaseNameData(buildNotification, r.Pipeline)
+	nameData.JiraKey = issue.Key
+	baseID, err := releaseID(nameData)
 	if err != nil {
-		log.Fatalf("Error generating random ID: %v", err)
-	}
-
-	// Use the random ID as the release ID
-	releaseID := fmt.Sprintf("release-%s", randomID)
-
-	// Create a new release request
-	var command = CommandMessage{
-		Commmand: "CreateRelease",
-		CreateRelease: deploypb.CreateReleaseRequest{
-			Parent:    pipeline.Name,
-			ReleaseId: releaseID, // Use the JIRA issue key as the release ID
-			Release: &deploypb.Release{
-				// Configure the release (e.g., Skaffold configuration)
-				BuildArtifacts: []*deploypb.BuildArtifact{
-					{
-						// Tag == Container Image
-						Tag: image,
-						// Image == The template substitution variable in run.yaml
-						Image: "pizza",
-					},
-				},
-				SkaffoldConfigUri: fmt.Sprintf("%s/%s.tar.gz",
-					buildNotification.Substitutions.DeployGCS,
-					buildNotification.Substitutions.CommitSha,
-				), // This is needed as we upload to GCS from Cloud Build
-				SkaffoldConfigPath: "skaffold.yaml", // Replace with your Skaffold config path
-			},
-		},
+		return failure.NewPermanent(err)
 	}
-	err = sendCommandPubSub(ctx, &command)
+	releaseID, exists, err := resolveReleaseID(ctx, deployClient, pipeline.Name, baseID, buildNotification.ID)
 	if err != nil {
-		return fmt.Errorf("failed to send pubsub command: %v", err)
+		return err
 	}
-	log.Printf("Deployment triggered successfully")
-	return nil
-}
-
-func generateRandomID(length int) (string, error) {
-	// Create a byte slice of the desired length
-	bytes := make([]byte, length)
-	// Fill the byte slice with random data
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
+	if exists {
+		log.Printf("Build %s was already released as %s, returning early", buildNotification.ID, releaseID)
+		return nil
 	}
-	// Return the hexadecimal string representation of the byte slice
-	return hex.EncodeToString(bytes), nil
-}
 
-// This should be in a shared code folder
-func sendCommandPubSub(ctx context.Context, m *CommandMessage) error {
-	client, err := pubsub.NewClient(ctx, c.ProjectId)
-	if err != nil {
-		return fmt.Errorf("pubsub.NewClient: %v", err)
+	releaseName := fmt.Sprintf("%s/releases/%s", pipeline.Name, releaseID)
+	if err := linkIssueToRelease(ctx, issue.Key, releaseName); err != nil {
+		return fmt.Errorf("error linking Jira issue to release: %w", err)
 	}
-	defer client.Close()
-	t := client.Topic(c.SendTopicID)
-	// Marshal the CommandMessage into a JSON byte slice
-	jsonData, err := json.Marshal(m)
+
+	// Carry the build's provenance onto the release
+	annotations := provenanceAnnotations(buildNotification)
+	labels := provenance.Labels(annotations)
+	annotations[jira.IssueKeyAnnotation] = issue.Key
+
+	// Create a new release request
+	cmd, err := command.New(command.CreateRelease, "createRelease", &deploypb.CreateReleaseRequest{
+		Parent:    pipeline.Name,
+		ReleaseId: releaseID,
+		Release: &deploypb.Release{
+			Labels:      labels,
+			Annotations: annotations,
+			// Configure the release (e.g., Skaffold configuration)
+			BuildArtifacts:     artifacts,
+			SkaffoldConfigUri:  skaffoldConfigURI(buildNotification), // This is needed as we upload to GCS from Cloud Build
+			SkaffoldConfigPath: r.skaffoldPath(),
+		},
+	})
 	if err != nil {
-		return fmt.Errorf("json.Marshal: %v", err)
+		return failure.NewPermanent(fmt.Errorf("error building command: %w", err))
 	}
-	log.Printf("Sending message to PubSub")
-	result := t.Publish(ctx, &pubsub.Message{
-		Data: jsonData, // Use the JSON byte slice here
-	})
-	// Block until the result is returned and a server-generated
-	// ID is returned for the published message.
-	id, err := result.Get(ctx)
-	log.Printf("ID: %s, err: %v", id, err)
+	// Redeliveries of this build notification carry the same correlation ID
+	cmd.CorrelationID = buildNotification.ID
+	_, err = command.NewPublisher(c.ProjectId, c.SendTopicID).Publish(ctx, cmd)
 	if err != nil {
-		fmt.Printf("Get: %v", err)
-		return nil
-
+		return fmt.Errorf("failed to send pubsub command: %w", err)
 	}
-	log.Printf("Published a message; msg ID: %v\n", id)
+	log.Printf("Deployment triggered successfully")
+	notifyReleaseCreated(ctx, pipeline.Name, releaseID, annotations)
 	return nil
 }
@@ -0,0 +1,146 @@
+package example
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	deploy "cloud.google.com/go/deploy/apiv1"
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/failure"
+	"example.com/shared/provenance"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Cloud Deploy resource IDs: lowercase letters, digits and hyphens,
+	// starting with a letter, at most 63 characters
+	maxReleaseIDLength = 63
+	// How many -2, -3... suffixes are tried before giving up
+	maxReleaseIDAttempts = 20
+)
+
+// ReleaseNameData is what RELEASE_ID_TEMPLATE is rendered against, e.g.
+// "{{.Repo}}-{{.Branch}}-{{.ShortSha}}" or "{{.JiraKey}}-{{.Date}}".
+type ReleaseNameData struct {
+	CommitSha    string
+	ShortSha     string
+	Branch       string
+	Tag          string
+	Repo         string
+	Trigger      string
+	BuildID      string
+	ShortBuildID string
+	// Date the build was created, as 20060102
+	Date     string
+	Pipeline string
+	// JiraKey of the issue tracking the build
+	JiraKey string
+}
+
+func releaseNameData(b *BuildMessage, pipeline string) ReleaseNameData {
+	s := b.Substitutions
+	d := ReleaseNameData{
+		CommitSha:    s.CommitSha(),
+		ShortSha:     s.ShortSha(),
+		Branch:       s.BranchName(),
+		Tag:          s.TagName(),
+		Repo:         s.RepoName(),
+		Trigger:      s.TriggerName(),
+		BuildID:      b.ID,
+		ShortBuildID: b.ID,
+		Pipeline:     pipeline,
+	}
+	if d.ShortSha == "" && len(d.CommitSha) >= 7 {
+		d.ShortSha = d.CommitSha[:7]
+	}
+	if len(d.ShortBuildID) > 8 {
+		d.ShortBuildID = d.ShortBuildID[:8]
+	}
+	created := b.CreateTime
+	if created.IsZero() {
+		created = time.Now()
+	}
+	d.Date = created.UTC().Format("20060102")
+	return d
+}
+
+// releaseID renders RELEASE_ID_TEMPLATE and makes the result a valid
+// release ID.
+func releaseID(d ReleaseNameData) (string, error) {
+	t, err := template.New("release ID").Option("missingkey=error").Parse(c.ReleaseIDTemplate)
+	if err != nil {
+		return "", fmt.Errorf("error parsing RELEASE_ID_TEMPLATE: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, d); err != nil {
+		return "", fmt.Errorf("error rendering RELEASE_ID_TEMPLATE: %w", err)
+	}
+	id := sanitizeReleaseID(buf.String())
+	if id == "" {
+		return "", fmt.Errorf("RELEASE_ID_TEMPLATE rendered %q, which has nothing usable as a release ID", buf.String())
+	}
+	return id, nil
+}
+
+var invalidIDChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// sanitizeReleaseID lowercases s, collapses anything else than letters and
+// digits into single hyphens and trims it to maxReleaseIDLength, so
+// "Feature/ABC_12" becomes "feature-abc-12".
+func sanitizeReleaseID(s string) string {
+	id := strings.Trim(invalidIDChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if id == "" {
+		return ""
+	}
+	if id[0] < 'a' || id[0] > 'z' {
+		id = "r-" + id
+	}
+	return truncateID(id, maxReleaseIDLength)
+}
+
+func truncateID(id string, n int) string {
+	if len(id) > n {
+		id = id[:n]
+	}
+	return strings.TrimRight(id, "-")
+}
+
+// withSuffix returns the nth candidate for base: base itself, then base-2,
+// base-3 and so on, shortening base so the suffix always fits.
+func withSuffix(base string, n int) string {
+	if n == 1 {
+		return base
+	}
+	suffix := fmt.Sprintf("-%d", n)
+	return truncateID(base, maxReleaseIDLength-len(suffix)) + suffix
+}
+
+// resolveReleaseID picks the first candidate for base that is free in the
+// pipeline, or already belongs to this build going by its build ID
+// annotation. Candidates are tried in the
+// same order every time, so the same build always resolves to the same ID.
+func resolveReleaseID(ctx context.Context, d *deploy.CloudDeployClient, pipeline, base, buildID string) (string, bool, error) {
+	for n := 1; n <= maxReleaseIDAttempts; n++ {
+		id := withSuffix(base, n)
+		release, err := d.GetRelease(ctx, &deploypb.GetReleaseRequest{Name: fmt.Sprintf("%s/releases/%s", pipeline, id)})
+		if status.Code(err) == codes.NotFound {
+			return id, false, nil
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("error checking release %s: %w", id, err)
+		}
+		if release.Annotations[provenance.BuildID] == buildID {
+			return id, true, nil
+		}
+		log.Printf("Release %s belongs to build %s, trying the next ID", id, release.Annotations[provenance.BuildID])
+	}
+	// Retrying won't free any of them
+	return "", false, failure.NewPermanent(fmt.Errorf("no free release ID for %s after %d attempts", base, maxReleaseIDAttempts))
+}
@@ -0,0 +1,103 @@
+package example
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/deployclient"
+	"example.com/shared/deploytest"
+	"example.com/shared/failure"
+	"example.com/shared/provenance"
+	"github.com/codingconcepts/env"
+)
+
+func TestReleaseID(t *testing.T) {
+	var defaults config
+	if err := env.Set(&defaults); err != nil {
+		t.Fatal(err)
+	}
+	if defaults.ReleaseIDTemplate != "{{.JiraKey}}" {
+		t.Errorf("RELEASE_ID_TEMPLATE defaults to %q, want releases named after their Jira issue", defaults.ReleaseIDTemplate)
+	}
+
+	d := ReleaseNameData{Repo: "cloud_deploy_jira", ShortSha: "0123456", Branch: "feature/login", JiraKey: "DEP-42", Date: "20260602"}
+	for _, tt := range []struct {
+		template string
+		want     string
+	}{
+		{"{{.JiraKey}}", "dep-42"},
+		{"{{.Repo}}-{{.ShortSha}}", "cloud-deploy-jira-0123456"},
+		{"{{.Branch}}-{{.Date}}", "feature-login-20260602"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			c.ReleaseIDTemplate = tt.template
+			if got, err := releaseID(d); err != nil || got != tt.want {
+				t.Errorf("releaseID = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+	c.ReleaseIDTemplate = "{{.Tag}}"
+	if _, err := releaseID(d); err == nil {
+		t.Error("releaseID rendering nothing succeeded")
+	}
+}
+
+func TestResolveReleaseID(t *testing.T) {
+	const pipeline = "projects/p/locations/l/deliveryPipelines/app"
+	fake, err := deploytest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(fake.Close)
+	fake.AddPipeline(&deploypb.DeliveryPipeline{Name: pipeline})
+	t.Setenv(deployclient.EmulatorHostEnv, fake.Addr)
+	ctx := context.Background()
+	d, err := deployclient.New(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	release := func(id, buildID string) {
+		t.Helper()
+		op, err := d.CreateRelease(ctx, &deploypb.CreateReleaseRequest{
+			Parent:    pipeline,
+			ReleaseId: id,
+			Release:   &deploypb.Release{Annotations: map[string]string{provenance.BuildID: buildID}},
+		})
+		if err == nil {
+			_, err = op.Wait(ctx)
+		}
+		if err != nil {
+			t.Fatalf("creating release %s: %v", id, err)
+		}
+	}
+
+	id, exists, err := resolveReleaseID(ctx, d, pipeline, "app-0123456", "build-1")
+	if id != "app-0123456" || exists || err != nil {
+		t.Errorf("free ID = %q, %v, %v, want app-0123456", id, exists, err)
+	}
+
+	// The same commit built again gets the next ID, and each build keeps
+	// its own
+	release("app-0123456", "build-1")
+	id, exists, err = resolveReleaseID(ctx, d, pipeline, "app-0123456", "build-2")
+	if id != "app-0123456-2" || exists || err != nil {
+		t.Errorf("ID for a second build = %q, %v, %v, want app-0123456-2", id, exists, err)
+	}
+	id, exists, err = resolveReleaseID(ctx, d, pipeline, "app-0123456", "build-1")
+	if id != "app-0123456" || !exists || err != nil {
+		t.Errorf("ID for a redelivered build = %q, %v, %v, want existing app-0123456", id, exists, err)
+	}
+
+	// Running out of IDs won't get better on a retry
+	release("app-abcdef0", "other-1")
+	for n := 2; n <= maxReleaseIDAttempts; n++ {
+		release(withSuffix("app-abcdef0", n), fmt.Sprintf("other-%d", n))
+	}
+	_, _, err = resolveReleaseID(ctx, d, pipeline, "app-abcdef0", "build-3")
+	if err == nil || failure.Classify(err) != failure.Permanent {
+		t.Errorf("resolveReleaseID with every ID taken = %v, want a permanent error", err)
+	}
+}
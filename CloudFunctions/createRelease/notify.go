@@ -0,0 +1,34 @@
+package example
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"example.com/shared/jira"
+	"example.com/shared/notify"
+)
+
+// notifications says where the notification routes are.
+var notifications notify.Config
+
+// notifyReleaseCreated tells the chat channels routed release-created
+// about a release once its command is on its way. Chat being unavailable
+// only gets logged.
+func notifyReleaseCreated(ctx context.Context, pipelineName, releaseID string, annotations map[string]string) {
+	e := notify.Event{
+		Kind:     notify.ReleaseCreated,
+		Project:  c.ProjectId,
+		Location: c.Location,
+		Pipeline: pipelineName[strings.LastIndex(pipelineName, "/")+1:],
+		Release:  releaseID,
+		IssueKey: annotations[jira.IssueKeyAnnotation],
+	}
+	e.AddProvenance(annotations)
+	if e.IssueKey != "" {
+		e.IssueURL = jira.IssueURL(c.JiraURL, e.IssueKey)
+	}
+	if err := notifications.Send(ctx, e); err != nil {
+		log.Printf("Failed to notify: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package example
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"example.com/shared/provenance"
+)
+
+// provenanceAnnotations describes where the build came from, so any release
+// or rollout can be traced back to its commit and build log.
+func provenanceAnnotations(b *BuildMessage) map[string]string {
+	s := b.Substitutions
+	repo := s.RepoFullName()
+	if repo == "" {
+		repo = s.RepoName()
+	}
+	var source string
+	if g := b.SourceProvenance.ResolvedGitSource; g != nil {
+		source = g.URL
+	} else if g := b.Source.GitSource; g != nil {
+		source = g.URL
+	}
+	annotations := map[string]string{}
+	for k, v := range map[string]string{
+		provenance.BuildID:    b.ID,
+		provenance.CommitSha:  s.CommitSha(),
+		provenance.Branch:     s.BranchName(),
+		provenance.Tag:        s.TagName(),
+		provenance.Repo:       repo,
+		provenance.Trigger:    s.TriggerName(),
+		provenance.LogURL:     b.LogUrl,
+		provenance.SourceURL:  source,
+		provenance.Author:     s[c.AuthorSubstitution],
+		provenance.CommitTime: commitTime(b),
+	} {
+		if v != "" {
+			annotations[k] = v
+		}
+	}
+	return annotations
+}
+
+// commitTime reads the commit time from the substitution in
+// COMMIT_TIME_SUBSTITUTION, as RFC 3339 or Unix seconds. Build
+// notifications don't carry it, so without one the build's creation time
+// stands in, which leaves out the time spent waiting for the trigger.
+func commitTime(b *BuildMessage) string {
+	if v := b.Substitutions[c.CommitTimeSubstitution]; v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+		if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(secs, 0).UTC().Format(time.RFC3339)
+		}
+		log.Printf("Ignoring commit time %q of build %s, it's neither RFC 3339 nor Unix seconds", v, b.ID)
+	}
+	if b.CreateTime.IsZero() {
+		return ""
+	}
+	return b.CreateTime.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,108 @@
+package example
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"example.com/shared/failure"
+	"sigs.k8s.io/yaml"
+)
+
+// Route sends matching builds to a delivery pipeline. Every match field
+// that is set must match; they are path.Match patterns, so "release/*"
+// matches any release branch.
+type Route struct {
+	TriggerID string `json:"triggerId,omitempty"`
+	RepoName  string `json:"repoName,omitempty"`
+	Branch    string `json:"branch,omitempty"`
+	Tag       string `json:"tag,omitempty"`
+
+	// Pipeline is the delivery pipeline ID in PROJECTID/LOCATION
+	Pipeline string `json:"pipeline"`
+	// SkaffoldPath is the Skaffold config inside the build's tarball,
+	// skaffold.yaml when empty
+	SkaffoldPath string `json:"skaffoldPath,omitempty"`
+	// ImageMapping overrides IMAGE_MAPPING for this route, see buildArtifacts
+	ImageMapping string `json:"imageMapping,omitempty"`
+}
+
+// loadRoutes reads the routing table from ROUTES or ROUTES_FILE, as JSON
+// or YAML with the same field names. Without either, TRIGGER and PIPELINE
+// make a single route so existing single-app deployments keep working.
+func loadRoutes() ([]Route, error) {
+	data := []byte(c.Routes)
+	if len(data) == 0 && c.RoutesFile != "" {
+		var err error
+		if data, err = os.ReadFile(c.RoutesFile); err != nil {
+			return nil, fmt.Errorf("error reading ROUTES_FILE: %w", err)
+		}
+	}
+	if len(data) == 0 {
+		if c.TriggerID == "" || c.Pipeline == "" {
+			return nil, failure.NewPermanent(fmt.Errorf("one of ROUTES, ROUTES_FILE or TRIGGER and PIPELINE must be set"))
+		}
+		return []Route{{TriggerID: c.TriggerID, Pipeline: c.Pipeline}}, nil
+	}
+
+	var routes []Route
+	if err := yaml.Unmarshal(data, &routes); err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("error parsing routes: %w", err))
+	}
+	for i, r := range routes {
+		if r.Pipeline == "" {
+			return nil, failure.NewPermanent(fmt.Errorf("route %d has no pipeline", i))
+		}
+		if r.TriggerID == "" && r.RepoName == "" && r.Branch == "" && r.Tag == "" {
+			return nil, failure.NewPermanent(fmt.Errorf("route %d to %s matches every build", i, r.Pipeline))
+		}
+	}
+	return routes, nil
+}
+
+// route returns the first route matching the build, or nil.
+func route(routes []Route, b *BuildMessage) (*Route, error) {
+	for i := range routes {
+		r := &routes[i]
+		ok, err := matchAll(
+			r.TriggerID, b.BuildTriggerID,
+			r.RepoName, b.Substitutions.RepoName(),
+			r.Branch, b.Substitutions.BranchName(),
+			r.Tag, b.Substitutions.TagName(),
+		)
+		if err != nil {
+			// A bad pattern never matches, whatever the build
+			return nil, failure.NewPermanent(fmt.Errorf("route %d: %w", i, err))
+		}
+		if ok {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+// matchAll takes pattern, value pairs. Empty patterns match anything, but
+// a set pattern never matches an empty value.
+func matchAll(pairs ...string) (bool, error) {
+	for i := 0; i < len(pairs); i += 2 {
+		pattern, value := pairs[i], pairs[i+1]
+		if pattern == "" {
+			continue
+		}
+		ok, err := path.Match(pattern, value)
+		if err != nil {
+			return false, fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+		if !ok || value == "" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (r *Route) skaffoldPath() string {
+	if r.SkaffoldPath == "" {
+		return "skaffold.yaml"
+	}
+	return r.SkaffoldPath
+}
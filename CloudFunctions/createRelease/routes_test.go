@@ -0,0 +1,59 @@
+package example
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadRoutes(t *testing.T) {
+	saved := c
+	t.Cleanup(func() { c = saved })
+	want := []Route{
+		{TriggerID: "pizza-main", Pipeline: "pizza", ImageMapping: "app=pizza"},
+		{RepoName: "worker", Branch: "release/*", Pipeline: "worker", SkaffoldPath: "deploy/skaffold.yaml"},
+	}
+	const asJSON = `[
+		{"triggerId": "pizza-main", "pipeline": "pizza", "imageMapping": "app=pizza"},
+		{"repoName": "worker", "branch": "release/*", "pipeline": "worker", "skaffoldPath": "deploy/skaffold.yaml"}
+	]`
+	const asYAML = `
+# One instance serves every service
+- triggerId: pizza-main
+  pipeline: pizza
+  imageMapping: app=pizza
+- repoName: worker
+  branch: release/*
+  pipeline: worker
+  skaffoldPath: deploy/skaffold.yaml
+`
+	file := filepath.Join(t.TempDir(), "routes.yaml")
+	if err := os.WriteFile(file, []byte(asYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		set  func()
+		want []Route
+	}{
+		{"inline JSON", func() { c.Routes = asJSON }, want},
+		{"inline YAML", func() { c.Routes = asYAML }, want},
+		{"YAML file", func() { c.RoutesFile = file }, want},
+		{"single trigger", func() { c.TriggerID, c.Pipeline = "t", "p" }, []Route{{TriggerID: "t", Pipeline: "p"}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c = saved
+			c.Routes, c.RoutesFile, c.TriggerID, c.Pipeline = "", "", "", ""
+			tt.set()
+			got, err := loadRoutes()
+			if err != nil {
+				t.Fatalf("loadRoutes: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadRoutes = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,1029 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"example.com/shared/command"
+	"example.com/shared/deployclient"
+	"example.com/shared/deploytest"
+	"example.com/shared/jira"
+	"example.com/shared/jira/jiratest"
+	"example.com/shared/provenance"
+)
+
+const (
+	project  = "e2e-project"
+	location = "us-central1"
+	pipeline = "e2e-pipeline"
+	trigger  = "e2e-trigger"
+)
+
+var (
+	root = flag.String("root", "..", "CloudFunctions directory holding the function modules")
+	wait = flag.Duration("wait", 2*time.Minute, "how long to wait for a release to reach a stage")
+)
+
+// TestE2E drives the deploy functions end to end without touching GCP. It
+// starts an in-process Pub/Sub fake, a fake Cloud Deploy API and a fake
+// Jira site, runs every function locally with the Functions Framework, then
+// publishes Cloud Build notifications and follows the releases through a
+// two stage pipeline: deployTrigger -> cloudDeployInteractions ->
+// cloudDeployOperations / cloudDeployApprovals and back.
+//
+//	cd CloudFunctions/e2e && go test -v .
+//
+// Each step carries on from where the last one left the fakes, so the rest
+// are skipped once one fails.
+func TestE2E(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs every function")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	h := newHarness(ctx, t)
+
+	for _, step := range []struct {
+		name string
+		run  func(*harness, context.Context) error
+	}{
+		{"issue opened for a started build", (*harness).buildStarted},
+		{"release reaches prod", (*harness).release},
+		{"redelivered command runs once", (*harness).redelivery},
+		{"malformed command dead-lettered", (*harness).deadLetter},
+		{"failed build fails its issue", (*harness).failedBuild},
+		{"quorum of votes approves prod", (*harness).quorum},
+		{"Slack buttons approve prod", (*harness).slackApproval},
+		{"notifications routed to chat", (*harness).notifications},
+		{"history", (*harness).history},
+		{"DORA metrics", (*harness).metrics},
+		{"failed verification rolled back", (*harness).rollback},
+	} {
+		ok := t.Run(step.name, func(t *testing.T) {
+			if err := step.run(h, ctx); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if !ok {
+			t.FailNow()
+		}
+	}
+}
+
+// topics the functions publish to or are triggered by
+var topics = []string{"cloud-builds", "deploy-commands", "clouddeploy-operations", "clouddeploy-approvals", "deploy-dead-letter", "build-events"}
+
+// harness holds the fakes and the running functions the steps drive.
+type harness struct {
+	cd     *deploytest.Server
+	js     *jiratest.Server
+	chat   *chatServer
+	topics map[string]*pubsub.Topic
+
+	// voter and approver take votes from people and Slack clicks,
+	// historyAPI and doraMetrics answer queries
+	voter, approver, historyAPI, doraMetrics *function
+
+	// commands, deadLetters and builds record what goes over the command,
+	// dead-letter and build event topics
+	commands, deadLetters, builds *tap
+}
+
+func newHarness(ctx context.Context, t *testing.T) *harness {
+	t.Helper()
+	binDir := t.TempDir()
+	h := &harness{topics: map[string]*pubsub.Topic{}, commands: &tap{}, deadLetters: &tap{}, builds: &tap{}}
+
+	// Fakes
+	ps := pstest.NewServer()
+	t.Cleanup(func() { ps.Close() })
+	t.Setenv("PUBSUB_EMULATOR_HOST", ps.Addr)
+
+	var err error
+	if h.cd, err = deploytest.NewServer(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.cd.Close)
+	pipelineName := fmt.Sprintf("projects/%s/locations/%s/deliveryPipelines/%s", project, location, pipeline)
+	h.cd.AddPipeline(&deploypb.DeliveryPipeline{
+		Name: pipelineName,
+		Pipeline: &deploypb.DeliveryPipeline_SerialPipeline{SerialPipeline: &deploypb.SerialPipeline{
+			Stages: []*deploypb.Stage{{TargetId: "dev"}, {TargetId: "prod"}},
+		}},
+	})
+	h.cd.AddTarget(&deploypb.Target{
+		Name:            fmt.Sprintf("projects/%s/locations/%s/targets/prod", project, location),
+		RequireApproval: true,
+	})
+
+	h.js = jiratest.NewServer()
+	t.Cleanup(h.js.Close)
+	ar := newRegistry()
+	t.Cleanup(ar.Close)
+	h.chat = newChatServer()
+	t.Cleanup(h.chat.Close)
+
+	client, err := pubsub.NewClient(ctx, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	for _, id := range topics {
+		topic, err := client.CreateTopic(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.topics[id] = topic
+		t.Cleanup(topic.Stop)
+	}
+
+	n := &notifier{ctx: ctx, operations: h.topics["clouddeploy-operations"], approvals: h.topics["clouddeploy-approvals"]}
+	h.cd.OnRelease = n.release
+	h.cd.OnRollout = n.rollout
+	h.cd.FailRollout = failing
+
+	// Functions
+	env := []string{
+		deployclient.EmulatorHostEnv + "=" + h.cd.Addr,
+		"PUBSUB_EMULATOR_HOST=" + ps.Addr,
+		"PROJECTID=" + project,
+		"LOCATION=" + location,
+		"SENDTOPICID=deploy-commands",
+		"DEADLETTERTOPICID=deploy-dead-letter",
+		"JIRA_URL=" + h.js.URL,
+		"JIRA_EMAIL=e2e@example.com",
+		"JIRA_API_TOKEN=token",
+		"NOTIFY_ROUTES=" + h.chat.routes(),
+		"HISTORY_STORE=file:" + filepath.Join(binDir, "history.jsonl"),
+		"ONCE_STORE=memory",
+	}
+	// Shared by the event and HTTP functions of cloudDeployApprovals
+	approvalsEnv := map[string]string{
+		"JIRA_WEBHOOK_SECRET": "secret",
+		"APPROVAL_POLICY": `{"rules": [{"name": "prod after dev", "match": {"targets": ["prod"]},
+			"conditions": {"labels": {"branch": "main"}, "minSuccessfulRollouts": 1}, "decision": "approve"}]}`,
+		"APPROVAL_QUORUM": `{"rules": [{"name": "prod", "targets": ["prod"], "approvals": 2, "forbidSelfApproval": true,
+			"approvers": ["policy:*", "google:*", "slack:UAPPROVER*"]}]}`,
+		"VOTE_STORE":             "memory",
+		"SLACK_APPROVAL_WEBHOOK": h.chat.URL + "/slack-app",
+		"SLACK_SIGNING_SECRET":   slackSecret,
+		// forbidSelfApproval checks Slack voters by email
+		"SLACK_BOT_TOKEN": "xoxb-e2e",
+		"SLACK_API_URL":   h.chat.URL + "/slack-api",
+	}
+	// Its HTTP functions share the process, and so the votes, see below
+	approvals := &function{Dir: "cloudDeployApprovals", Target: "cloudDeployApprovals", Env: approvalsEnv, All: true}
+	var wg sync.WaitGroup
+	t.Cleanup(wg.Wait)
+	deliverCtx, stopDelivery := context.WithCancel(ctx)
+	t.Cleanup(stopDelivery)
+	for _, f := range []struct {
+		fn    *function
+		topic string
+	}{
+		{&function{Dir: "createRelease", Target: "deployTrigger", Env: map[string]string{
+			"PIPELINE": pipeline, "TRIGGER": trigger, "JIRA_PROJECT": "E2E",
+			"BUILDEVENTSTOPICID":         "build-events",
+			"ARTIFACT_REGISTRY_ENDPOINT": ar.URL + "/",
+			"ISSUE_STORE":                "memory",
+			// The steps look releases up by commit rather than issue
+			"RELEASE_ID_TEMPLATE": "{{.Repo}}-{{.ShortSha}}",
+		}}, "cloud-builds"},
+		{&function{Dir: "cloudDeployInteractions", Target: "cloudDeployInteractions", Env: map[string]string{
+			"DEDUP_STORE": "memory",
+		}}, "deploy-commands"},
+		{&function{Dir: "cloudDeployOperations", Target: "cloudDeployOperations", Env: map[string]string{
+			"ROLLBACK_POLICY": `{"rules": [{"name": "dev", "targets": ["dev"], "jobs": ["verify"], "cooldown": "1h"}]}`,
+		}}, "clouddeploy-operations"},
+		{approvals, "clouddeploy-approvals"},
+	} {
+		f.fn.Dir = filepath.Join(*root, f.fn.Dir)
+		if err := f.fn.start(ctx, binDir, env); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(f.fn.stop)
+		sub, err := client.CreateSubscription(ctx, f.fn.Target, pubsub.SubscriptionConfig{Topic: h.topics[f.topic]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.fn.deliver(deliverCtx, sub, h.topics[f.topic].String())
+		}()
+	}
+
+	// Votes counted together with the policy's
+	h.voter = approvals.sibling("approvalVote")
+	h.approver = approvals.sibling("chatApproval")
+	h.historyAPI = &function{Dir: filepath.Join(*root, "cloudDeployOperations"), Target: "deployHistory"}
+	h.doraMetrics = &function{Dir: filepath.Join(*root, "cloudDeployOperations"), Target: "doraMetrics"}
+	for _, f := range []*function{h.historyAPI, h.doraMetrics} {
+		if err := f.start(ctx, binDir, env); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(f.stop)
+	}
+
+	for topic, tp := range map[string]*tap{"deploy-commands": h.commands, "deploy-dead-letter": h.deadLetters, "build-events": h.builds} {
+		sub, err := client.CreateSubscription(ctx, topic+"-tap", pubsub.SubscriptionConfig{Topic: h.topics[topic]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tp.receive(deliverCtx, sub)
+		}()
+	}
+	return h
+}
+
+// buildStarted checks the issue is opened when the build starts; it's
+// found again on success.
+func (h *harness) buildStarted(ctx context.Context) error {
+	if err := publishBuild(ctx, h.topics["cloud-builds"], "build-1", "WORKING", nil); err != nil {
+		return err
+	}
+	err := waitFor(*wait, func() bool {
+		issue := h.js.Issue("E2E-1")
+		return issue != nil && issue.Status == "In Progress"
+	})
+	if err != nil {
+		return fmt.Errorf("issue E2E-1 wasn't opened for the WORKING build: %w", err)
+	}
+	return nil
+}
+
+// release finishes build-1 and votes on its prod rollout, the policy's
+// approval being one of the two prod needs, then asserts on the Cloud
+// Deploy calls, the commands sent and the Jira issue once the release is on
+// the last stage.
+func (h *harness) release(ctx context.Context) error {
+	if err := publishBuild(ctx, h.topics["cloud-builds"], "build-1", "SUCCESS", nil); err != nil {
+		return err
+	}
+	prod, err := h.waitRollout("prod rollout of build-1 waiting for approval", func(r *deploypb.Rollout) bool {
+		return r.TargetId == "prod" && r.ApprovalState == deploypb.Rollout_NEEDS_APPROVAL
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := h.vote(ctx, "bob@example.com", prod.Name, true); err != nil {
+		return err
+	}
+	err = waitFor(*wait, func() bool {
+		return countRollouts(h.cd, deploypb.Rollout_SUCCEEDED) == 2
+	})
+	if err != nil {
+		return fmt.Errorf("release didn't reach prod: %w\ncalls: %s", err, methods(h.cd.Calls()))
+	}
+
+	counts := map[string]int{}
+	for _, c := range h.cd.Calls() {
+		counts[c.Method]++
+		if c.Method == "ApproveRollout" && !c.Request.(*deploypb.ApproveRolloutRequest).Approved {
+			return fmt.Errorf("rollout was rejected: %v", c.Request)
+		}
+	}
+	want := map[string]int{"CreateRelease": 1, "CreateRollout": 2, "ApproveRollout": 1}
+	for method, n := range want {
+		if counts[method] != n {
+			return fmt.Errorf("got %d %s calls, want %d (calls: %s)", counts[method], method, n, methods(h.cd.Calls()))
+		}
+	}
+
+	types := map[command.Type]int{}
+	for _, data := range h.commands.all() {
+		e, _, err := command.Parse(data)
+		if err != nil {
+			return fmt.Errorf("functions sent an invalid command: %w", err)
+		}
+		types[e.Type]++
+	}
+	wantTypes := map[command.Type]int{command.CreateRelease: 1, command.PromoteRelease: 2, command.ApproveRollout: 1}
+	for typ, n := range wantTypes {
+		if types[typ] != n {
+			return fmt.Errorf("got %d %s commands, want %d", types[typ], typ, n)
+		}
+	}
+
+	// Named by RELEASE_ID_TEMPLATE, {{.Repo}}-{{.ShortSha}}
+	release := h.cd.Release(releaseName("cloud-deploy-jira-0123456"))
+	if release == nil {
+		return fmt.Errorf("release cloud-deploy-jira-0123456 wasn't created for build-1")
+	}
+	if key := release.Annotations[jira.IssueKeyAnnotation]; key != "E2E-1" {
+		return fmt.Errorf("release annotated with issue %q, want E2E-1", key)
+	}
+	if sha := release.Labels[provenance.CommitSha]; sha != "0123456789abcdef" {
+		return fmt.Errorf("release labelled with commit %q, want 0123456789abcdef", sha)
+	}
+	var tags []string
+	for _, a := range release.BuildArtifacts {
+		tags = append(tags, a.Tag)
+	}
+	pinned := []string{appImage + "@" + appDigest, workerImage + "@" + workerDigest}
+	if strings.Join(tags, ",") != strings.Join(pinned, ",") {
+		return fmt.Errorf("release images are %v, want them pinned to %v", tags, pinned)
+	}
+	for _, r := range h.cd.Rollouts() {
+		if id := r.Annotations[provenance.BuildID]; id != "build-1" {
+			return fmt.Errorf("rollout %s annotated with build %q, want build-1", r.Name, id)
+		}
+	}
+	// The last Rollout Succeed event may still be on its way to Jira
+	return waitFor(10*time.Second, func() bool {
+		issue := h.js.Issue("E2E-1")
+		return issue != nil && issue.Status == "Deployed" && len(issue.Comments) > 0
+	})
+}
+
+// redelivery republishes the CreateRelease command and checks it doesn't
+// reach Cloud Deploy twice.
+func (h *harness) redelivery(ctx context.Context) error {
+	var createRelease []byte
+	for _, data := range h.commands.all() {
+		if e, _, err := command.Parse(data); err == nil && e.Type == command.CreateRelease {
+			createRelease = data
+		}
+	}
+	if _, err := h.topics["deploy-commands"].Publish(ctx, &pubsub.Message{Data: createRelease}).Get(ctx); err != nil {
+		return err
+	}
+	before := len(h.commands.all())
+	if err := waitFor(10*time.Second, func() bool { return len(h.commands.all()) > before }); err != nil {
+		return err
+	}
+	// Give cloudDeployInteractions time to (not) act on it
+	time.Sleep(2 * time.Second)
+	n := 0
+	for _, c := range h.cd.Calls() {
+		if c.Method == "CreateRelease" {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("redelivered CreateRelease reached Cloud Deploy, %d calls", n)
+	}
+	return nil
+}
+
+// deadLetter publishes a malformed command and checks it's parked on the
+// dead-letter topic with a reason.
+func (h *harness) deadLetter(ctx context.Context) error {
+	if n := len(h.deadLetters.all()); n != 0 {
+		return fmt.Errorf("%d messages were dead-lettered during the release", n)
+	}
+	if _, err := h.topics["deploy-commands"].Publish(ctx, &pubsub.Message{Data: []byte(`{"type":"Explode"}`)}).Get(ctx); err != nil {
+		return err
+	}
+	if err := waitFor(10*time.Second, func() bool { return len(h.deadLetters.all()) == 1 }); err != nil {
+		return fmt.Errorf("malformed command wasn't dead-lettered: %w", err)
+	}
+	if reason := h.deadLetters.attrs()[0]["reason"]; reason == "" {
+		return fmt.Errorf("dead-lettered command has no reason")
+	}
+	return nil
+}
+
+// failedBuild sends a failed build and checks its issue explains the
+// failure, and that every status went out as a build event.
+func (h *harness) failedBuild(ctx context.Context) error {
+	if err := publishBuild(ctx, h.topics["cloud-builds"], "build-2", "FAILURE", nil); err != nil {
+		return err
+	}
+	err := waitFor(10*time.Second, func() bool {
+		issue := h.js.Issue("E2E-2")
+		return issue != nil && issue.Status == "Failed" && len(issue.Comments) > 0
+	})
+	if err != nil {
+		return fmt.Errorf("issue E2E-2 wasn't failed for build-2: %w", err)
+	}
+	if comment := h.js.Issue("E2E-2").Comments[0]; !strings.Contains(comment, "builds/build-2") {
+		return fmt.Errorf("failure comment %q doesn't link the build log", comment)
+	}
+
+	want := []string{"build-1/WORKING", "build-1/SUCCESS", "build-2/FAILURE"}
+	return waitFor(10*time.Second, func() bool {
+		var got []string
+		for _, a := range h.builds.attrs() {
+			got = append(got, a["buildId"]+"/"+a["status"])
+		}
+		return strings.Join(got, ",") == strings.Join(want, ",")
+	})
+}
+
+// quorum releases a feature branch build, whose prod rollout the approval
+// policy escalates, and votes on it through approvalVote: the author's
+// approval mustn't count and two others must approve it.
+func (h *harness) quorum(ctx context.Context) error {
+	err := publishBuild(ctx, h.topics["cloud-builds"], "build-3", "SUCCESS", map[string]string{
+		"COMMIT_SHA":  "fedcba9876543210",
+		"SHORT_SHA":   "fedcba9",
+		"BRANCH_NAME": "feature",
+		"_AUTHOR":     "alice@example.com",
+	})
+	if err != nil {
+		return err
+	}
+	rollout, err := h.waitRollout("prod rollout of build-3 waiting for approval", func(r *deploypb.Rollout) bool {
+		return r.TargetId == "prod" && strings.Contains(r.Name, "/releases/cloud-deploy-jira-fedcba9/") && r.ApprovalState == deploypb.Rollout_NEEDS_APPROVAL
+	})
+	if err != nil {
+		return err
+	}
+	// Let the policy escalate it before anyone votes
+	time.Sleep(2 * time.Second)
+
+	for _, v := range []struct {
+		email     string
+		approvals int
+		decision  string
+	}{
+		{"alice@example.com", 0, ""},
+		{"bob@example.com", 1, ""},
+		{"carol@example.com", 2, "approve"},
+	} {
+		resp, err := h.vote(ctx, v.email, rollout.Name, true)
+		if err != nil {
+			return err
+		}
+		if resp.Approvals != v.approvals || resp.Decision != v.decision || len(resp.Ignored) != 1 {
+			return fmt.Errorf("vote by %s got %+v, want %d approval(s), decision %q and alice's ignored", v.email, resp, v.approvals, v.decision)
+		}
+	}
+
+	_, err = h.waitRollout("prod rollout of build-3 succeeding after the quorum", func(r *deploypb.Rollout) bool {
+		return r.Name == rollout.Name && r.State == deploypb.Rollout_SUCCEEDED
+	})
+	if err != nil {
+		return err
+	}
+	var issuers []string
+	var approvers []command.Approver
+	for _, data := range h.commands.all() {
+		if e, req, err := command.Parse(data); err == nil && e.Type == command.ApproveRollout && req.(*deploypb.ApproveRolloutRequest).Name == rollout.Name {
+			issuers = append(issuers, e.Issuer)
+			approvers = e.Approvers
+		}
+	}
+	want := "quorum:google:bob@example.com,google:carol@example.com"
+	if len(issuers) != 1 || issuers[0] != want {
+		return fmt.Errorf("ApproveRollout issuers %v, want [%s]", issuers, want)
+	}
+	if len(approvers) != 2 || approvers[0].Email != "bob@example.com" || approvers[1].Email != "carol@example.com" {
+		return fmt.Errorf("ApproveRollout approvers %+v, want bob and carol", approvers)
+	}
+	return h.issueComment("cloud-deploy-jira-fedcba9", "was approved by google:bob@example.com, google:carol@example.com")
+}
+
+const slackSecret = "slack-secret"
+
+// slackApproval releases another feature branch build and clicks the
+// buttons on the Slack message its escalated prod rollout gets: someone who
+// isn't an approver is turned away, two approvers approve it.
+func (h *harness) slackApproval(ctx context.Context) error {
+	err := publishBuild(ctx, h.topics["cloud-builds"], "build-4", "SUCCESS", map[string]string{
+		"COMMIT_SHA":  "abcdef1234567890",
+		"SHORT_SHA":   "abcdef1",
+		"BRANCH_NAME": "feature",
+		"_AUTHOR":     "alice@example.com",
+	})
+	if err != nil {
+		return err
+	}
+	var approve string
+	err = waitFor(*wait, func() bool {
+		for _, m := range h.chat.posted("/slack-app") {
+			if strings.Contains(m, "Approval required: cloud-deploy-jira-abcdef1 to prod") {
+				approve, err = buttonValue(m, "approve")
+				return err == nil
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return fmt.Errorf("no Slack approval request for build-4: %w\nposted: %q", err, h.chat.posted("/slack-app"))
+	}
+
+	for _, click := range []struct {
+		user string
+		want string
+	}{
+		{"UMALLORY", "not an approver for prod"},
+		{"UAPPROVER1", "1 of 2 approvals"},
+		{"UAPPROVER2", "approved by UAPPROVER1 (slack:UAPPROVER1), UAPPROVER2 (slack:UAPPROVER2)"},
+	} {
+		before := len(h.chat.posted("/slack-response"))
+		if err := h.clickSlack(ctx, click.user, "approve", approve, h.chat.URL+"/slack-response"); err != nil {
+			return err
+		}
+		err := waitFor(10*time.Second, func() bool { return len(h.chat.posted("/slack-response")) > before })
+		if err != nil {
+			return fmt.Errorf("no Slack response to %s's click: %w", click.user, err)
+		}
+		if got := h.chat.posted("/slack-response")[before]; !strings.Contains(got, click.want) {
+			return fmt.Errorf("Slack response to %s's click is %s, want %q in it", click.user, got, click.want)
+		}
+	}
+
+	rollout, err := h.waitRollout("prod rollout of build-4 succeeding after the Slack approvals", func(r *deploypb.Rollout) bool {
+		return r.TargetId == "prod" && strings.Contains(r.Name, "/releases/cloud-deploy-jira-abcdef1/") && r.State == deploypb.Rollout_SUCCEEDED
+	})
+	if err != nil {
+		return err
+	}
+	for _, data := range h.commands.all() {
+		if e, req, err := command.Parse(data); err == nil && e.Type == command.ApproveRollout && req.(*deploypb.ApproveRolloutRequest).Name == rollout.Name {
+			if want := "quorum:slack:UAPPROVER1,slack:UAPPROVER2"; e.Issuer != want {
+				return fmt.Errorf("ApproveRollout issued by %s, want %s", e.Issuer, want)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no ApproveRollout command for %s", rollout.Name)
+}
+
+// notifications checks both channels heard about what they're routed, with
+// links to the commit, build log and issue.
+func (h *harness) notifications(ctx context.Context) error {
+	want := map[string][]string{
+		"/slack": {
+			"Release created: cloud-deploy-jira-0123456",
+			"Rollout succeeded: cloud-deploy-jira-0123456 to dev",
+			"Rollout succeeded: cloud-deploy-jira-0123456 to prod",
+			"Approval required: cloud-deploy-jira-fedcba9 to prod",
+			"builds/build-1",
+			"/browse/E2E-1",
+		},
+		"/chat": {
+			"Rollout succeeded: cloud-deploy-jira-0123456 to prod",
+			"Approval required: cloud-deploy-jira-fedcba9 to prod",
+			"Needs 2 approval(s)",
+		},
+	}
+	err := waitFor(10*time.Second, func() bool {
+		for path, texts := range want {
+			all := strings.Join(h.chat.posted(path), "\n")
+			for _, text := range texts {
+				if !strings.Contains(all, text) {
+					return false
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("chat notifications missing: %w\nslack: %q\nchat: %q", err, h.chat.posted("/slack"), h.chat.posted("/chat"))
+	}
+	for _, m := range h.chat.posted("/chat") {
+		if strings.Contains(m, "to dev") || strings.Contains(m, "Release created") {
+			return fmt.Errorf("Google Chat was sent a notification it isn't routed: %s", m)
+		}
+	}
+	return nil
+}
+
+type historyRecord struct {
+	Kind     string `json:"kind"`
+	Release  string `json:"release"`
+	Target   string `json:"target"`
+	Rollout  string `json:"rollout"`
+	State    string `json:"state"`
+	Actor    string `json:"actor"`
+	Decision string `json:"decision"`
+	Command  string `json:"command"`
+	Commit   string `json:"commit"`
+}
+
+// history asks deployHistory what's running on prod, what happened to the
+// first release and how prod was voted on.
+func (h *harness) history(ctx context.Context) error {
+	var current historyRecord
+	err := waitFor(10*time.Second, func() bool {
+		err := getJSON(ctx, h.historyAPI.url+"/current?target=prod", &current)
+		return err == nil && current.Release == "cloud-deploy-jira-abcdef1"
+	})
+	if err != nil {
+		return fmt.Errorf("prod is running %+v, want cloud-deploy-jira-abcdef1: %w", current, err)
+	}
+	if current.Commit != "abcdef1234567890" || current.State != "SUCCEEDED" {
+		return fmt.Errorf("current prod rollout is %+v, want commit abcdef1234567890 SUCCEEDED", current)
+	}
+
+	var release struct {
+		Records []historyRecord `json:"records"`
+	}
+	if err := getJSON(ctx, h.historyAPI.url+"/?"+url.Values{"release": {"cloud-deploy-jira-0123456"}}.Encode(), &release); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, r := range release.Records {
+		switch r.Kind {
+		case "rollout":
+			seen[r.Kind+"/"+r.Target+"/"+r.State] = true
+		case "approval":
+			seen[r.Kind+"/"+r.Actor+"/"+r.Decision] = true
+		case "command":
+			seen[r.Kind+"/"+r.Command] = true
+		default:
+			seen[r.Kind] = true
+		}
+	}
+	for _, want := range []string{
+		"release-created",
+		"command/CreateRelease",
+		"command/ApproveRollout",
+		"rollout/dev/SUCCEEDED",
+		"rollout/prod/SUCCEEDED",
+		"approval/quorum:google:bob@example.com,policy:prod after dev/approve",
+	} {
+		if !seen[want] {
+			return fmt.Errorf("history of cloud-deploy-jira-0123456 has no %s: %+v", want, release.Records)
+		}
+	}
+
+	var votes struct {
+		Records []historyRecord `json:"records"`
+	}
+	if err := getJSON(ctx, h.historyAPI.url+"/?target=prod&kind=vote", &votes); err != nil {
+		return err
+	}
+	// The policy and bob on build-1, alice, bob and carol on build-3, two
+	// approvers on build-4; mallory wasn't allowed to vote
+	if len(votes.Records) != 7 {
+		return fmt.Errorf("got %d votes on prod in the history, want 7: %+v", len(votes.Records), votes.Records)
+	}
+	return nil
+}
+
+type doraMetrics struct {
+	Pipeline          string  `json:"pipeline"`
+	Target            string  `json:"target"`
+	Window            string  `json:"window"`
+	Deployments       int     `json:"deployments"`
+	DeploymentsPerDay float64 `json:"deploymentsPerDay"`
+	LeadTimes         int     `json:"leadTimes"`
+	LeadTimeSeconds   float64 `json:"leadTimeSeconds"`
+	Changes           int     `json:"changes"`
+	ChangeFailures    int     `json:"changeFailures"`
+}
+
+// metrics asks doraMetrics about prod over the last week, then for the same
+// as OpenMetrics. Every build was committed an hour before the run and no
+// rollout failed.
+func (h *harness) metrics(ctx context.Context) error {
+	var got struct {
+		Metrics []doraMetrics `json:"metrics"`
+	}
+	if err := getJSON(ctx, h.doraMetrics.url+"/?target=prod&windows=7d", &got); err != nil {
+		return err
+	}
+	if len(got.Metrics) != 1 {
+		return fmt.Errorf("got metrics %+v, want one pipeline and window for prod", got.Metrics)
+	}
+	m := got.Metrics[0]
+	if m.Pipeline != pipeline || m.Window != "7d" || m.Deployments < 3 || m.LeadTimes != m.Deployments || m.ChangeFailures != 0 {
+		return fmt.Errorf("got prod metrics %+v, want at least 3 deployments with lead times and no failures", m)
+	}
+	if m.LeadTimeSeconds < 3600 || m.LeadTimeSeconds > 3600+600 {
+		return fmt.Errorf("median prod lead time is %vs, want a little over an hour", m.LeadTimeSeconds)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.doraMetrics.url+"/metrics?target=prod&windows=7d", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/openmetrics-text") {
+		return fmt.Errorf("/metrics is %s, want OpenMetrics", resp.Header.Get("Content-Type"))
+	}
+	text := string(body)
+	want := fmt.Sprintf("dora_deployments{pipeline=%q,target=\"prod\",window=\"7d\"} %d\n", pipeline, m.Deployments)
+	if !strings.Contains(text, want) || !strings.HasSuffix(text, "# EOF\n") || strings.Contains(text, "dora_time_to_restore_seconds{") {
+		return fmt.Errorf("/metrics has no %q, or restore times with nothing restored:\n%s", want, text)
+	}
+	return nil
+}
+
+// Releases of these commits fail verification, see failing
+const (
+	badRelease   = "cloud-deploy-jira-baaaad1"
+	worseRelease = "cloud-deploy-jira-baaaad2"
+)
+
+// failing makes the fake fail rollouts of the bad releases.
+func failing(r *deploypb.Rollout) bool {
+	return strings.Contains(r.Name, "/releases/"+badRelease+"/") || strings.Contains(r.Name, "/releases/"+worseRelease+"/")
+}
+
+// rollback releases a build whose dev rollout fails verification: dev is
+// rolled back to the last release that succeeded there, which isn't
+// promoted again, and the issue and chat hear about it. A second failure
+// within the cool-down is left alone.
+func (h *harness) rollback(ctx context.Context) error {
+	err := publishBuild(ctx, h.topics["cloud-builds"], "build-5", "SUCCESS", map[string]string{
+		"COMMIT_SHA": "baaaad1000000000",
+		"SHORT_SHA":  "baaaad1",
+	})
+	if err != nil {
+		return err
+	}
+	rollback, err := h.waitRollout("rollback of dev after "+badRelease+" failed", func(r *deploypb.Rollout) bool {
+		return strings.Contains(r.RollbackOfRollout, "/releases/"+badRelease+"/") && r.State == deploypb.Rollout_SUCCEEDED
+	})
+	if err != nil {
+		return err
+	}
+	if rollback.TargetId != "dev" || !strings.Contains(rollback.Name, "/releases/cloud-deploy-jira-abcdef1/rollouts/rollback-") {
+		return fmt.Errorf("rollback is %s to %s, want a rollout of cloud-deploy-jira-abcdef1 to dev", rollback.Name, rollback.TargetId)
+	}
+	err = waitFor(10*time.Second, func() bool {
+		return strings.Contains(strings.Join(h.chat.posted("/slack"), "\n"), "Rolling back: "+badRelease+" to dev")
+	})
+	if err != nil {
+		return fmt.Errorf("chat didn't hear about the rollback: %w\nposted: %q", err, h.chat.posted("/slack"))
+	}
+	if err := h.issueComment(badRelease, "Rolling dev back to cloud-deploy-jira-abcdef1: the rollout's verify job failed."); err != nil {
+		return err
+	}
+
+	err = publishBuild(ctx, h.topics["cloud-builds"], "build-6", "SUCCESS", map[string]string{
+		"COMMIT_SHA": "baaaad2000000000",
+		"SHORT_SHA":  "baaaad2",
+	})
+	if err != nil {
+		return err
+	}
+	err = waitFor(*wait, func() bool {
+		return strings.Contains(strings.Join(h.chat.posted("/slack"), "\n"), "Not rolling back: "+worseRelease+" to dev")
+	})
+	if err != nil {
+		return fmt.Errorf("chat didn't hear %s wasn't rolled back: %w\nposted: %q", worseRelease, err, h.chat.posted("/slack"))
+	}
+	if err := h.issueComment(worseRelease, "within the 1h0m0s cool-down"); err != nil {
+		return err
+	}
+
+	// The rollback's success was handled long before, so a promotion of
+	// it would have shown by now
+	counts := map[string]int{}
+	for _, c := range h.cd.Calls() {
+		counts[c.Method]++
+	}
+	for _, r := range h.cd.Rollouts() {
+		if r.TargetId == "prod" && strings.Contains(r.Name, "/releases/cloud-deploy-jira-abcdef1/") {
+			counts["prod rollouts of abcdef1"]++
+		}
+	}
+	if counts["RollbackTarget"] != 1 || counts["prod rollouts of abcdef1"] != 1 {
+		return fmt.Errorf("got %d RollbackTarget calls and %d prod rollouts of cloud-deploy-jira-abcdef1, want 1 of each",
+			counts["RollbackTarget"], counts["prod rollouts of abcdef1"])
+	}
+	return nil
+}
+
+var committed = time.Now().Add(-time.Hour)
+
+// publishBuild publishes a notification for build id of commit 0123456 on
+// main, with any substitutions in subs overriding those.
+func publishBuild(ctx context.Context, t *pubsub.Topic, id, status string, subs map[string]string) error {
+	substitutions := map[string]string{
+		"COMMIT_SHA":  "0123456789abcdef",
+		"SHORT_SHA":   "0123456",
+		"BRANCH_NAME": "main",
+		"REPO_NAME":   "cloud_deploy_jira",
+		"_DEPLOY_GCS": "gs://e2e-deploy",
+		// Every commit was an hour before the run, for the lead time
+		"_COMMIT_TIME": strconv.FormatInt(committed.Unix(), 10),
+	}
+	for k, v := range subs {
+		substitutions[k] = v
+	}
+	build := map[string]interface{}{
+		"id":             id,
+		"status":         status,
+		"buildTriggerId": trigger,
+		"logUrl":         "https://console.cloud.google.com/cloud-build/builds/" + id,
+		"substitutions":  substitutions,
+		"artifacts": map[string]interface{}{
+			"images": []string{appImage + ":0123456", workerImage + ":0123456"},
+		},
+		// The worker's digest is missing, so it's resolved in the registry
+		"results": map[string]interface{}{
+			"images": []map[string]string{{"name": appImage + ":0123456", "digest": appDigest}},
+		},
+	}
+	data, err := json.Marshal(build)
+	if err != nil {
+		return err
+	}
+	_, err = t.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx)
+	return err
+}
+
+func releaseName(release string) string {
+	return fmt.Sprintf("projects/%s/locations/%s/deliveryPipelines/%s/releases/%s", project, location, pipeline, release)
+}
+
+// waitRollout waits for a rollout matching match, described by what should
+// it time out.
+func (h *harness) waitRollout(what string, match func(*deploypb.Rollout) bool) (*deploypb.Rollout, error) {
+	var rollout *deploypb.Rollout
+	err := waitFor(*wait, func() bool {
+		for _, r := range h.cd.Rollouts() {
+			if match(r) {
+				rollout = r
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("no %s: %w\ncalls: %s", what, err, methods(h.cd.Calls()))
+	}
+	return rollout, nil
+}
+
+// issueComment waits for the issue of release to get a comment containing
+// want.
+func (h *harness) issueComment(release, want string) error {
+	r := h.cd.Release(releaseName(release))
+	if r == nil {
+		return fmt.Errorf("release %s wasn't created", release)
+	}
+	key := r.Annotations[jira.IssueKeyAnnotation]
+	err := waitFor(10*time.Second, func() bool {
+		issue := h.js.Issue(key)
+		return issue != nil && strings.Contains(strings.Join(issue.Comments, "\n"), want)
+	})
+	if err != nil {
+		var comments []string
+		if issue := h.js.Issue(key); issue != nil {
+			comments = issue.Comments
+		}
+		return fmt.Errorf("issue %s of %s has no comment with %q: %w\ncomments: %q", key, release, want, err, comments)
+	}
+	return nil
+}
+
+type voteResponse struct {
+	Decision  string   `json:"decision"`
+	Approvals int      `json:"approvals"`
+	Ignored   []string `json:"ignored"`
+}
+
+// vote posts to approvalVote as email, with the unsigned identity token
+// Cloud Run would have verified.
+func (h *harness) vote(ctx context.Context, email, rollout string, approve bool) (*voteResponse, error) {
+	claims, _ := json.Marshal(map[string]interface{}{"email": email, "email_verified": true})
+	token := "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+	body, _ := json.Marshal(map[string]interface{}{"rollout": rollout, "approve": approve})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.voter.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vote by %s: %s", email, resp.Status)
+	}
+	var v voteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// buttonValue finds the value of the button with action ID id in a Slack
+// message.
+func buttonValue(message, id string) (string, error) {
+	var msg struct {
+		Blocks []struct {
+			Elements []struct {
+				ActionID string `json:"action_id"`
+				Value    string `json:"value"`
+			} `json:"elements"`
+		} `json:"blocks"`
+	}
+	if err := json.Unmarshal([]byte(message), &msg); err != nil {
+		return "", err
+	}
+	for _, b := range msg.Blocks {
+		for _, e := range b.Elements {
+			if e.ActionID == id {
+				return e.Value, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no %s button", id)
+}
+
+// clickSlack sends chatApproval the block_actions callback Slack would for
+// user clicking a button, signed with the app's signing secret.
+func (h *harness) clickSlack(ctx context.Context, user, action, value, responseURL string) error {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type":         "block_actions",
+		"user":         map[string]string{"id": user, "username": strings.ToLower(user), "name": user},
+		"actions":      []map[string]string{{"action_id": action, "value": value}},
+		"response_url": responseURL,
+	})
+	body := url.Values{"payload": {string(payload)}}.Encode()
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(slackSecret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.approver.url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("click by %s: %s", user, resp.Status)
+	}
+	return nil
+}
+
+func getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func countRollouts(cd *deploytest.Server, state deploypb.Rollout_State) int {
+	n := 0
+	for _, r := range cd.Rollouts() {
+		if r.State == state {
+			n++
+		}
+	}
+	return n
+}
+
+func methods(calls []deploytest.Call) []string {
+	var m []string
+	for _, c := range calls {
+		m = append(m, c.Method)
+	}
+	return m
+}
+
+func waitFor(timeout time.Duration, cond func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return fmt.Errorf("timed out after %v", timeout)
+}
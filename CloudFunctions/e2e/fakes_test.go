@@ -0,0 +1,205 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"cloud.google.com/go/pubsub"
+)
+
+// notifier publishes the Pub/Sub notifications Cloud Deploy sends when the
+// fake's releases and rollouts change.
+type notifier struct {
+	ctx        context.Context
+	operations *pubsub.Topic
+	approvals  *pubsub.Topic
+}
+
+// resourceName splits projects/p/locations/l/deliveryPipelines/d/releases/r
+// (optionally followed by /rollouts/x) into its IDs.
+type resourceName struct {
+	Project, Location, Pipeline, Release, Rollout string
+}
+
+func parseName(name string) resourceName {
+	var n resourceName
+	parts := strings.Split(name, "/")
+	for i := 0; i+1 < len(parts); i += 2 {
+		switch parts[i] {
+		case "projects":
+			n.Project = parts[i+1]
+		case "locations":
+			n.Location = parts[i+1]
+		case "deliveryPipelines":
+			n.Pipeline = parts[i+1]
+		case "releases":
+			n.Release = parts[i+1]
+		case "rollouts":
+			n.Rollout = parts[i+1]
+		}
+	}
+	return n
+}
+
+func (n *notifier) release(r *deploypb.Release) {
+	name := parseName(r.Name)
+	n.publish(n.operations, map[string]string{
+		"Action":             "Succeed",
+		"Resource":           r.Name,
+		"ResourceType":       "Release",
+		"Location":           name.Location,
+		"DeliveryPipelineId": name.Pipeline,
+		"ProjectNumber":      name.Project,
+		"ReleaseId":          name.Release,
+	})
+}
+
+func (n *notifier) rollout(r *deploypb.Rollout) {
+	name := parseName(r.Name)
+	attrs := map[string]string{
+		"Resource":           r.Name,
+		"ResourceType":       "Rollout",
+		"Location":           name.Location,
+		"DeliveryPipelineId": name.Pipeline,
+		"ProjectNumber":      name.Project,
+		"ReleaseId":          name.Release,
+		"RolloutId":          name.Rollout,
+		"TargetId":           r.TargetId,
+	}
+	switch r.State {
+	case deploypb.Rollout_PENDING_APPROVAL:
+		n.publish(n.approvals, map[string]string{
+			"Action":         "Required",
+			"Rollout":        r.Name,
+			"ReleaseId":      name.Release,
+			"RolloutId":      name.Rollout,
+			"TargetId":       r.TargetId,
+			"Location":       name.Location,
+			"ProjectNumber":  name.Project,
+			"manualApproval": "true",
+		})
+		return
+	case deploypb.Rollout_SUCCEEDED:
+		attrs["Action"] = "Succeed"
+	case deploypb.Rollout_FAILED:
+		attrs["Action"] = "Failure"
+	case deploypb.Rollout_CANCELLED:
+		attrs["Action"] = "Cancel"
+	default:
+		return
+	}
+	n.publish(n.operations, attrs)
+}
+
+func (n *notifier) publish(t *pubsub.Topic, attrs map[string]string) {
+	if _, err := t.Publish(n.ctx, &pubsub.Message{Attributes: attrs}).Get(n.ctx); err != nil && n.ctx.Err() == nil {
+		log.Printf("Publishing notification to %s: %v", t.ID(), err)
+	}
+}
+
+// chatServer stands in for Slack and Google Chat incoming webhooks,
+// recording the text of every message posted to /slack and /chat. Slack's
+// users.info, under /slack-api, gives every user <id>@example.com.
+type chatServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	messages map[string][]string
+}
+
+func newChatServer() *chatServer {
+	s := &chatServer{messages: map[string][]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slack-api/users.info" {
+			email := strings.ToLower(r.URL.Query().Get("user")) + "@example.com"
+			_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "user": map[string]any{"profile": map[string]any{"email": email}}})
+			return
+		}
+		// Slack's blocks and Chat's text both end up in the raw body
+		body, _ := io.ReadAll(r.Body)
+		var msg struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(body, &msg); err != nil || msg.Text == "" {
+			http.Error(w, "no text", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.messages[r.URL.Path] = append(s.messages[r.URL.Path], string(body))
+		s.mu.Unlock()
+	}))
+	return s
+}
+
+// routes sends everything to Slack and only prod to Google Chat.
+func (s *chatServer) routes() string {
+	return fmt.Sprintf(`{"routes": [
+		{"name": "all", "slack": "%s/slack"},
+		{"name": "prod", "targets": ["prod"], "googleChat": "%s/chat"}]}`, s.URL, s.URL)
+}
+
+func (s *chatServer) posted(path string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages[path]...)
+}
+
+const (
+	appImage     = "us-docker.pkg.dev/e2e-project/app/app"
+	appDigest    = "sha256:aaaa"
+	workerImage  = "us-docker.pkg.dev/e2e-project/app/worker"
+	workerDigest = "sha256:bbbb"
+)
+
+// newRegistry fakes the Artifact Registry tags.get call for the worker
+// image.
+func newRegistry() *httptest.Server {
+	tag := "projects/e2e-project/locations/us/repositories/app/packages/worker/tags/0123456"
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/"+tag {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"name":    tag,
+			"version": "projects/e2e-project/locations/us/repositories/app/packages/worker/versions/" + workerDigest,
+		})
+	}))
+}
+
+// tap records every message published to a topic.
+type tap struct {
+	mu    sync.Mutex
+	data  [][]byte
+	attrM []map[string]string
+}
+
+func (t *tap) receive(ctx context.Context, sub *pubsub.Subscription) {
+	_ = sub.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+		t.mu.Lock()
+		t.data = append(t.data, m.Data)
+		t.attrM = append(t.attrM, m.Attributes)
+		t.mu.Unlock()
+		m.Ack()
+	})
+}
+
+func (t *tap) all() [][]byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([][]byte(nil), t.data...)
+}
+
+func (t *tap) attrs() []map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]map[string]string(nil), t.attrM...)
+}
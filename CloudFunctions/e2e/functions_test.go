@@ -0,0 +1,153 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"example.com/shared/events"
+)
+
+// function is one Cloud Function served locally by its cmd/main.go.
+type function struct {
+	// Dir is the function's module directory, Target its entry point
+	Dir    string
+	Target string
+	// Env is added to the environment shared by every function
+	Env map[string]string
+	// All serves every function of the module, each at /<name>, so they
+	// share one process and its in-memory stores; see sibling
+	All bool
+
+	url string
+	cmd *exec.Cmd
+}
+
+// start builds the function into binDir and runs it on a free port.
+func (f *function) start(ctx context.Context, binDir string, env []string) error {
+	bin := filepath.Join(binDir, f.Target)
+	build := exec.CommandContext(ctx, "go", "build", "-o", bin, "./cmd")
+	build.Dir = f.Dir
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		return fmt.Errorf("building %s: %w", f.Dir, err)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return err
+	}
+	f.url = fmt.Sprintf("http://localhost:%d", port)
+	f.cmd = exec.CommandContext(ctx, bin)
+	f.cmd.Env = append(os.Environ(), env...)
+	f.cmd.Env = append(f.cmd.Env, fmt.Sprintf("PORT=%d", port))
+	if f.All {
+		f.url += "/" + f.Target
+	} else {
+		f.cmd.Env = append(f.cmd.Env, "FUNCTION_TARGET="+f.Target)
+	}
+	for k, v := range f.Env {
+		f.cmd.Env = append(f.cmd.Env, k+"="+v)
+	}
+	f.cmd.Stdout = os.Stderr
+	f.cmd.Stderr = os.Stderr
+	if err := f.cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s: %w", f.Target, err)
+	}
+
+	// Wait for the framework to listen
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("%s didn't start listening on %d", f.Target, port)
+}
+
+// sibling is another function served by f, which must have been started
+// with All.
+func (f *function) sibling(target string) *function {
+	return &function{Dir: f.Dir, Target: target, url: strings.TrimSuffix(f.url, f.Target) + target}
+}
+
+func (f *function) stop() {
+	if f.cmd != nil && f.cmd.Process != nil {
+		_ = f.cmd.Process.Kill()
+		_ = f.cmd.Wait()
+	}
+}
+
+// deliver pushes every message on sub to the function as the Pub/Sub
+// CloudEvent Eventarc would send, acking it only if the function succeeds,
+// until ctx is done.
+func (f *function) deliver(ctx context.Context, sub *pubsub.Subscription, topic string) {
+	err := sub.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+		if err := f.post(ctx, m, sub.String(), topic); err != nil {
+			log.Printf("%s failed on message %s: %v", f.Target, m.ID, err)
+			// Back off a little so transient failures don't spin
+			time.Sleep(500 * time.Millisecond)
+			m.Nack()
+			return
+		}
+		m.Ack()
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Receiving for %s stopped: %v", f.Target, err)
+	}
+}
+
+func (f *function) post(ctx context.Context, m *pubsub.Message, subscription, topic string) error {
+	body, err := json.Marshal(events.MessagePublishedData{
+		Message: events.PubsubMessage{
+			Data:        m.Data,
+			Attributes:  m.Attributes,
+			MessageID:   m.ID,
+			PublishTime: m.PublishTime,
+		},
+		Subscription: subscription,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("ce-id", m.ID)
+	req.Header.Set("ce-specversion", "1.0")
+	req.Header.Set("ce-type", "google.cloud.pubsub.topic.v1.messagePublished")
+	req.Header.Set("ce-source", "//pubsub.googleapis.com/"+topic)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+func freePort() (int, error) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port, nil
+}
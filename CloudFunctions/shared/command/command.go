@@ -0,0 +1,259 @@
+// Package command defines the envelope every function publishes onto the
+// deploy-commands topic for cloudDeployInteractions to execute, and a
+// publisher for it.
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// SchemaVersion is the envelope version this package produces. Consumers
+// reject envelopes with a version they don't know.
+const SchemaVersion = "v1"
+
+// CorrelationAnnotation is set on the rollouts commands create to the
+// command's correlation ID, so a retry can tell its own rollout apart.
+const CorrelationAnnotation = "command-correlation-id"
+
+// Type says which Cloud Deploy call an Envelope asks for.
+type Type string
+
+const (
+	CreateRelease  Type = "CreateRelease"
+	CreateRollout  Type = "CreateRollout"
+	ApproveRollout Type = "ApproveRollout"
+	// PromoteRelease rolls a release out to a target. Its payload is a
+	// CreateRolloutRequest whose parent is the release; the target and
+	// rollout ID are optional and default to the pipeline's next stage.
+	PromoteRelease  Type = "PromoteRelease"
+	AdvanceRollout  Type = "AdvanceRollout"
+	CancelRollout   Type = "CancelRollout"
+	RetryJob        Type = "RetryJob"
+	RollbackTarget  Type = "RollbackTarget"
+	AbandonRelease  Type = "AbandonRelease"
+	IgnoreJob       Type = "IgnoreJob"
+	TerminateJobRun Type = "TerminateJobRun"
+	// LiftFreeze ends a deployment freeze early. It isn't a Cloud Deploy
+	// call; its payload is a LiftFreezeRequest.
+	LiftFreeze Type = "LiftFreeze"
+)
+
+// Payload is the request a command carries: a Cloud Deploy request message,
+// or one of this package's request types for commands that aren't Cloud
+// Deploy calls.
+type Payload interface{}
+
+// LiftFreezeRequest is the payload of a LiftFreeze command.
+type LiftFreezeRequest struct {
+	// Freeze names the freeze in the freeze config
+	Freeze string `json:"freeze"`
+	Reason string `json:"reason"`
+}
+
+// payloads maps every known Type to a constructor for its payload.
+var payloads = map[Type]func() Payload{
+	CreateRelease:   func() Payload { return &deploypb.CreateReleaseRequest{} },
+	CreateRollout:   func() Payload { return &deploypb.CreateRolloutRequest{} },
+	ApproveRollout:  func() Payload { return &deploypb.ApproveRolloutRequest{} },
+	PromoteRelease:  func() Payload { return &deploypb.CreateRolloutRequest{} },
+	AdvanceRollout:  func() Payload { return &deploypb.AdvanceRolloutRequest{} },
+	CancelRollout:   func() Payload { return &deploypb.CancelRolloutRequest{} },
+	RetryJob:        func() Payload { return &deploypb.RetryJobRequest{} },
+	RollbackTarget:  func() Payload { return &deploypb.RollbackTargetRequest{} },
+	AbandonRelease:  func() Payload { return &deploypb.AbandonReleaseRequest{} },
+	IgnoreJob:       func() Payload { return &deploypb.IgnoreJobRequest{} },
+	TerminateJobRun: func() Payload { return &deploypb.TerminateJobRunRequest{} },
+	LiftFreeze:      func() Payload { return &LiftFreezeRequest{} },
+}
+
+// Envelope is the versioned command message. Payload is the JSON encoding of
+// the request matching Type, protojson for Cloud Deploy requests.
+type Envelope struct {
+	SchemaVersion string `json:"schemaVersion"`
+	Type          Type   `json:"type"`
+	// CorrelationID ties a command to whatever caused it (a build, a Pub/Sub
+	// event...) and is stable across redeliveries of that cause.
+	CorrelationID string `json:"correlationId"`
+	// Issuer names the function or person that sent the command
+	Issuer    string          `json:"issuer"`
+	Timestamp time.Time       `json:"timestamp"`
+	Payload   json.RawMessage `json:"payload"`
+	// Approvers decided an ApproveRollout, e.g. the votes meeting the
+	// target's quorum. Cloud Deploy can't annotate a rollout once it's
+	// created, so they're recorded here and in the history instead.
+	Approvers []Approver `json:"approvers,omitempty"`
+}
+
+// Approver identifies someone, or something like the approval policy, who
+// decided a rollout. ID says where they decided, e.g. "jira:<account ID>"
+// or "google:alice@example.com".
+type Approver struct {
+	ID    string `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// New builds an Envelope for req. The correlation ID defaults to a random
+// UUID; callers with a natural ID for the cause should overwrite it.
+func New(t Type, issuer string, req Payload) (*Envelope, error) {
+	newPayload, ok := payloads[t]
+	if !ok {
+		return nil, fmt.Errorf("unknown command type %q", t)
+	}
+	if want := reflect.TypeOf(newPayload()); reflect.TypeOf(req) != want {
+		return nil, fmt.Errorf("%s command needs a %s payload, got %T", t, want, req)
+	}
+	payload, err := marshalPayload(req)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		SchemaVersion: SchemaVersion,
+		Type:          t,
+		CorrelationID: uuid.NewString(),
+		Issuer:        issuer,
+		Timestamp:     time.Now().UTC(),
+		Payload:       payload,
+	}, nil
+}
+
+// ValidationError explains why a command was rejected.
+type ValidationError struct {
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid command: " + e.Reason
+}
+
+func invalid(format string, a ...interface{}) error {
+	return &ValidationError{Reason: fmt.Sprintf(format, a...)}
+}
+
+// Parse decodes and validates a command published on the topic, returning
+// the envelope and its decoded payload. Any problem with the message is
+// reported as a *ValidationError. Unknown payload fields are dropped so newer
+// producers can talk to older consumers.
+func Parse(data []byte) (*Envelope, Payload, error) {
+	var e Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, nil, invalid("malformed envelope: %v", err)
+	}
+	if e.SchemaVersion != SchemaVersion {
+		return &e, nil, invalid("unsupported schema version %q", e.SchemaVersion)
+	}
+	newPayload, ok := payloads[e.Type]
+	if !ok {
+		return &e, nil, invalid("unknown command type %q", e.Type)
+	}
+	if e.CorrelationID == "" {
+		return &e, nil, invalid("missing correlationId")
+	}
+	if e.Issuer == "" {
+		return &e, nil, invalid("missing issuer")
+	}
+	if e.Timestamp.IsZero() {
+		return &e, nil, invalid("missing timestamp")
+	}
+	if len(e.Payload) == 0 {
+		return &e, nil, invalid("missing payload")
+	}
+	req := newPayload()
+	if err := unmarshalPayload(e.Payload, req); err != nil {
+		return &e, nil, invalid("malformed %s payload: %v", e.Type, err)
+	}
+	if err := validatePayload(e.Type, req); err != nil {
+		return &e, nil, err
+	}
+	return &e, req, nil
+}
+
+func marshalPayload(req Payload) ([]byte, error) {
+	if m, ok := req.(proto.Message); ok {
+		payload, err := protojson.Marshal(m)
+		if err != nil {
+			return nil, fmt.Errorf("protojson.Marshal: %v", err)
+		}
+		return payload, nil
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %v", err)
+	}
+	return payload, nil
+}
+
+// unmarshalPayload decodes data into req, dropping unknown fields whether or
+// not req is a message.
+func unmarshalPayload(data []byte, req Payload) error {
+	if m, ok := req.(proto.Message); ok {
+		return (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, m)
+	}
+	return json.Unmarshal(data, req)
+}
+
+// validatePayload checks the fields Cloud Deploy would otherwise reject
+// later with a less helpful error.
+func validatePayload(t Type, req Payload) error {
+	switch r := req.(type) {
+	case *deploypb.CreateReleaseRequest:
+		if r.GetParent() == "" || r.GetReleaseId() == "" || r.GetRelease() == nil {
+			return invalid("CreateRelease needs parent, releaseId and release")
+		}
+	case *deploypb.CreateRolloutRequest:
+		if t == PromoteRelease {
+			if r.GetParent() == "" {
+				return invalid("PromoteRelease needs parent")
+			}
+			break
+		}
+		if r.GetParent() == "" || r.GetRolloutId() == "" || r.GetRollout().GetTargetId() == "" {
+			return invalid("CreateRollout needs parent, rolloutId and rollout.targetId")
+		}
+	case *deploypb.ApproveRolloutRequest:
+		if r.GetName() == "" {
+			return invalid("ApproveRollout needs name")
+		}
+	case *deploypb.AdvanceRolloutRequest:
+		if r.GetName() == "" || r.GetPhaseId() == "" {
+			return invalid("AdvanceRollout needs name and phaseId")
+		}
+	case *deploypb.CancelRolloutRequest:
+		if r.GetName() == "" {
+			return invalid("CancelRollout needs name")
+		}
+	case *deploypb.RetryJobRequest:
+		if r.GetRollout() == "" || r.GetPhaseId() == "" || r.GetJobId() == "" {
+			return invalid("RetryJob needs rollout, phaseId and jobId")
+		}
+	case *deploypb.RollbackTargetRequest:
+		if r.GetName() == "" || r.GetTargetId() == "" || r.GetRolloutId() == "" {
+			return invalid("RollbackTarget needs name, targetId and rolloutId")
+		}
+	case *deploypb.AbandonReleaseRequest:
+		if r.GetName() == "" {
+			return invalid("AbandonRelease needs name")
+		}
+	case *deploypb.IgnoreJobRequest:
+		if r.GetRollout() == "" || r.GetPhaseId() == "" || r.GetJobId() == "" {
+			return invalid("IgnoreJob needs rollout, phaseId and jobId")
+		}
+	case *deploypb.TerminateJobRunRequest:
+		if r.GetName() == "" {
+			return invalid("TerminateJobRun needs name")
+		}
+	case *LiftFreezeRequest:
+		if r.Freeze == "" || r.Reason == "" {
+			return invalid("LiftFreeze needs freeze and reason")
+		}
+	}
+	return nil
+}
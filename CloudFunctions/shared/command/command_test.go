@@ -0,0 +1,97 @@
+package command_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"example.com/shared/command"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		typ command.Type
+		req command.Payload
+	}{
+		{command.ApproveRollout, &deploypb.ApproveRolloutRequest{Name: "projects/p/locations/l/deliveryPipelines/d/releases/r/rollouts/o", Approved: true}},
+		{command.LiftFreeze, &command.LiftFreezeRequest{Freeze: "christmas", Reason: "hotfix"}},
+	} {
+		t.Run(string(tt.typ), func(t *testing.T) {
+			cmd, err := command.New(tt.typ, "test", tt.req)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			data, err := json.Marshal(cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, got, err := command.Parse(data)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if e.Type != tt.typ || e.Issuer != "test" || e.CorrelationID != cmd.CorrelationID {
+				t.Errorf("Parse envelope = %+v, want %+v", e, cmd)
+			}
+			if m, ok := tt.req.(proto.Message); ok {
+				if !proto.Equal(got.(proto.Message), m) {
+					t.Errorf("Parse payload = %v, want %v", got, m)
+				}
+			} else if !reflect.DeepEqual(got, tt.req) {
+				t.Errorf("Parse payload = %+v, want %+v", got, tt.req)
+			}
+		})
+	}
+}
+
+func TestNewWrongPayload(t *testing.T) {
+	if _, err := command.New(command.LiftFreeze, "test", &deploypb.ApproveRolloutRequest{Name: "r"}); err == nil {
+		t.Error("New of a LiftFreeze with an ApproveRolloutRequest succeeded")
+	}
+	if _, err := command.New(command.ApproveRollout, "test", &command.LiftFreezeRequest{Freeze: "f", Reason: "r"}); err == nil {
+		t.Error("New of an ApproveRollout with a LiftFreezeRequest succeeded")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	envelope := func(typ command.Type, payload string) string {
+		data, _ := json.Marshal(command.Envelope{
+			SchemaVersion: command.SchemaVersion,
+			Type:          typ,
+			CorrelationID: "c",
+			Issuer:        "test",
+			Timestamp:     time.Now(),
+			Payload:       json.RawMessage(payload),
+		})
+		return string(data)
+	}
+	for name, data := range map[string]string{
+		"not JSON":               `{`,
+		"old schema":             `{"schemaVersion": "v0"}`,
+		"unknown type":           envelope("Deploy", `{}`),
+		"no payload":             envelope(command.ApproveRollout, ``),
+		"malformed payload":      envelope(command.ApproveRollout, `{"name": 1}`),
+		"rollout not named":      envelope(command.ApproveRollout, `{"approved": true}`),
+		"freeze not named":       envelope(command.LiftFreeze, `{"reason": "hotfix"}`),
+		"lift without reason":    envelope(command.LiftFreeze, `{"freeze": "christmas"}`),
+		"malformed lift payload": envelope(command.LiftFreeze, `{"freeze": ["christmas"], "reason": "hotfix"}`),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := command.Parse([]byte(data))
+			var invalid *command.ValidationError
+			if !errors.As(err, &invalid) {
+				t.Errorf("Parse = %v, want a ValidationError", err)
+			}
+		})
+	}
+
+	// Newer producers can add fields
+	if _, req, err := command.Parse([]byte(envelope(command.LiftFreeze, `{"freeze": "christmas", "reason": "hotfix", "until": "tomorrow"}`))); err != nil {
+		t.Errorf("Parse with an unknown payload field: %v", err)
+	} else if l := req.(*command.LiftFreezeRequest); l.Freeze != "christmas" {
+		t.Errorf("Parse with an unknown payload field = %+v", l)
+	}
+}
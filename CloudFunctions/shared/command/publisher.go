@@ -0,0 +1,75 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Publisher sends command Envelopes to a Pub/Sub topic.
+type Publisher struct {
+	ProjectID string
+	TopicID   string
+}
+
+// NewPublisher returns a Publisher for the given project and topic ID.
+func NewPublisher(projectID, topicID string) *Publisher {
+	return &Publisher{ProjectID: projectID, TopicID: topicID}
+}
+
+// Publish sends e and blocks until Pub/Sub acknowledges it, returning the
+// server-generated message ID. The envelope header is copied into message
+// attributes so subscriptions can filter on it.
+func (p *Publisher) Publish(ctx context.Context, e *Envelope) (string, error) {
+	// Marshal the Envelope into a JSON byte slice
+	jsonData, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %v", err)
+	}
+	log.Printf("Sending %s command %s to PubSub", e.Type, e.CorrelationID)
+	return p.publish(ctx, &pubsub.Message{
+		Data: jsonData,
+		Attributes: map[string]string{
+			"schemaVersion": e.SchemaVersion,
+			"type":          string(e.Type),
+			"correlationId": e.CorrelationID,
+			"issuer":        e.Issuer,
+		},
+	})
+}
+
+// DeadLetter forwards a rejected command or event, untouched, along with the
+// function that gave up on it and why. Use a Publisher for the dead-letter
+// topic.
+func (p *Publisher) DeadLetter(ctx context.Context, data []byte, function, reason string) (string, error) {
+	log.Printf("Dead-lettering message from %s: %s", function, reason)
+	return p.publish(ctx, &pubsub.Message{
+		Data: data,
+		Attributes: map[string]string{
+			"function": function,
+			"reason":   reason,
+		},
+	})
+}
+
+func (p *Publisher) publish(ctx context.Context, m *pubsub.Message) (string, error) {
+	client, err := pubsub.NewClient(ctx, p.ProjectID)
+	if err != nil {
+		return "", fmt.Errorf("pubsub.NewClient: %w", err)
+	}
+	defer client.Close()
+	t := client.Topic(p.TopicID)
+	defer t.Stop()
+
+	// Block until the result is returned and a server-generated
+	// ID is returned for the published message.
+	id, err := t.Publish(ctx, m).Get(ctx)
+	if err != nil {
+		return "", fmt.Errorf("publishing to %s: %w", p.TopicID, err)
+	}
+	log.Printf("Published a message; msg ID: %v", id)
+	return id, nil
+}
@@ -0,0 +1,130 @@
+// Package configfile loads configuration files that can change without a
+// redeploy, from Cloud Storage or the local filesystem.
+package configfile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+// Read returns the current version of the file at uri (gs://bucket/object
+// or a local path) and, if it differs from known, its contents. Versions
+// are object generations in Cloud Storage and modification times locally.
+func Read(ctx context.Context, uri, known string) (string, []byte, error) {
+	bucket, object, ok := strings.Cut(strings.TrimPrefix(uri, "gs://"), "/")
+	if !strings.HasPrefix(uri, "gs://") {
+		info, err := os.Stat(uri)
+		if err != nil {
+			return "", nil, fmt.Errorf("error reading %s: %w", uri, err)
+		}
+		version := info.ModTime().UTC().Format(time.RFC3339Nano)
+		if version == known {
+			return version, nil, nil
+		}
+		data, err := os.ReadFile(uri)
+		if err != nil {
+			return "", nil, fmt.Errorf("error reading %s: %w", uri, err)
+		}
+		return version, data, nil
+	}
+	if !ok || object == "" {
+		return "", nil, fmt.Errorf("%q must be gs://bucket/object", uri)
+	}
+
+	svc, err := storage.NewService(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating Cloud Storage client: %w", err)
+	}
+	obj, err := svc.Objects.Get(bucket, object).Context(ctx).Do()
+	if err != nil {
+		return "", nil, fmt.Errorf("error getting %s: %w", uri, err)
+	}
+	version := fmt.Sprint(obj.Generation)
+	if version == known {
+		return version, nil, nil
+	}
+	// Pin the generation so the contents match the version we report
+	resp, err := svc.Objects.Get(bucket, object).Generation(obj.Generation).Context(ctx).Download()
+	if err != nil {
+		return "", nil, fmt.Errorf("error downloading %s: %w", uri, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("error downloading %s: %w", uri, err)
+	}
+	return version, data, nil
+}
+
+// Cache keeps a parsed file across invocations of a warm instance, checking
+// for a new version at most every refresh. Once a version has parsed, read
+// errors and versions that don't parse are logged and the last good value
+// is kept, so a bad upload doesn't stop the function. A version that didn't
+// parse isn't downloaded again.
+type Cache[T any] struct {
+	// Name describes the file in logs, e.g. "approval policy"
+	Name  string
+	Parse func([]byte) (T, error)
+
+	mu      sync.Mutex
+	value   T
+	loaded  bool
+	version string
+	checked time.Time
+	// rejected is the latest version, when it didn't parse, and why
+	rejected    string
+	rejectedErr error
+}
+
+// Get returns the parsed contents of the file at uri.
+func (c *Cache[T]) Get(ctx context.Context, uri string, refresh time.Duration) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loaded && time.Since(c.checked) < refresh {
+		return c.value, nil
+	}
+	known := c.version
+	if c.rejected != "" {
+		known = c.rejected
+	}
+	version, data, err := Read(ctx, uri, known)
+	if err != nil {
+		if c.loaded {
+			log.Printf("Error refreshing %s, keeping version %s: %v", c.Name, c.version, err)
+			return c.value, nil
+		}
+		var zero T
+		return zero, err
+	}
+	c.checked = time.Now()
+	if data == nil {
+		if !c.loaded {
+			// Still the version that didn't parse
+			var zero T
+			return zero, c.rejectedErr
+		}
+		return c.value, nil
+	}
+	v, err := c.Parse(data)
+	if err != nil {
+		c.rejected, c.rejectedErr = version, err
+		if c.loaded {
+			log.Printf("Ignoring invalid %s version %s: %v", c.Name, version, err)
+			return c.value, nil
+		}
+		var zero T
+		return zero, err
+	}
+	log.Printf("Loaded %s version %s", c.Name, version)
+	c.value, c.loaded, c.version = v, true, version
+	c.rejected, c.rejectedErr = "", nil
+	return v, nil
+}
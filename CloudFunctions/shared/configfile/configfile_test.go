@@ -0,0 +1,77 @@
+package configfile_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example.com/shared/configfile"
+)
+
+func TestCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	version := time.Date(2026, 6, 2, 9, 0, 0, 0, time.UTC)
+	// write uploads a new version of the file
+	write := func(contents string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		version = version.Add(time.Minute)
+		if err := os.Chtimes(path, version, version); err != nil {
+			t.Fatal(err)
+		}
+	}
+	parsed := 0
+	cache := &configfile.Cache[string]{Name: "test config", Parse: func(data []byte) (string, error) {
+		parsed++
+		if string(data) == "bad" {
+			return "", errors.New("bad config")
+		}
+		return string(data), nil
+	}}
+	ctx := context.Background()
+	get := func() (string, error) { return cache.Get(ctx, path, 0) }
+
+	// Nothing good to fall back on yet
+	write("bad")
+	for i := 0; i < 2; i++ {
+		if _, err := get(); err == nil {
+			t.Fatal("Get of a bad first version succeeded")
+		}
+	}
+	if parsed != 1 {
+		t.Errorf("bad first version parsed %d times, want once", parsed)
+	}
+
+	write("v1")
+	if v, err := get(); err != nil || v != "v1" {
+		t.Fatalf("Get = %q, %v, want v1", v, err)
+	}
+
+	// A bad upload keeps the last good version and is only parsed once
+	write("bad")
+	parsed = 0
+	for i := 0; i < 3; i++ {
+		if v, err := get(); err != nil || v != "v1" {
+			t.Fatalf("Get after a bad upload = %q, %v, want v1", v, err)
+		}
+	}
+	if parsed != 1 {
+		t.Errorf("bad version parsed %d times, want once", parsed)
+	}
+
+	write("v2")
+	if v, err := get(); err != nil || v != "v2" {
+		t.Errorf("Get after fixing it = %q, %v, want v2", v, err)
+	}
+
+	// Within the refresh interval the file isn't looked at
+	write("v3")
+	if v, err := cache.Get(ctx, path, time.Hour); err != nil || v != "v2" {
+		t.Errorf("Get within the refresh = %q, %v, want v2", v, err)
+	}
+}
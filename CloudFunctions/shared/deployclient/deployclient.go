@@ -0,0 +1,30 @@
+// Package deployclient creates Cloud Deploy clients that can be pointed at a
+// local fake, the same way the Pub/Sub client honours PUBSUB_EMULATOR_HOST.
+package deployclient
+
+import (
+	"context"
+	"os"
+
+	deploy "cloud.google.com/go/deploy/apiv1"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// EmulatorHostEnv names the env variable holding the host:port of a fake
+// Cloud Deploy server such as deploytest.Server.
+const EmulatorHostEnv = "CLOUD_DEPLOY_EMULATOR_HOST"
+
+// New returns a Cloud Deploy client, talking to the fake in
+// CLOUD_DEPLOY_EMULATOR_HOST without credentials when it's set.
+func New(ctx context.Context, opts ...option.ClientOption) (*deploy.CloudDeployClient, error) {
+	if host := os.Getenv(EmulatorHostEnv); host != "" {
+		opts = append([]option.ClientOption{
+			option.WithEndpoint(host),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		}, opts...)
+	}
+	return deploy.NewCloudDeployClient(ctx, opts...)
+}
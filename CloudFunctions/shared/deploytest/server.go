@@ -0,0 +1,440 @@
+// Package deploytest provides an in-process fake of the Cloud Deploy gRPC API
+// covering what the deploy functions call: pipelines, releases, rollouts and
+// their approvals, in the spirit of pstest for Pub/Sub.
+//
+// Long running operations complete immediately. Rollouts to targets that
+// don't require approval succeed as soon as they're created, unless FailRollout
+// says they fail; the others wait for ApproveRollout. RollbackTarget creates
+// such a rollout of the target's last successful release.
+package deploytest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+	"cloud.google.com/go/longrunning/autogen/longrunningpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Call is a request the fake received.
+type Call struct {
+	Method  string
+	Request proto.Message
+}
+
+// Server is a fake Cloud Deploy API. Point clients at Addr, e.g. through
+// deployclient.EmulatorHostEnv.
+type Server struct {
+	deploypb.UnimplementedCloudDeployServer
+	longrunningpb.UnimplementedOperationsServer
+
+	Addr string
+
+	// OnRelease and OnRollout, when set, are called in their own goroutine
+	// after a release is created or a rollout changes state, so tests can
+	// publish the notifications Cloud Deploy would.
+	OnRelease func(*deploypb.Release)
+	OnRollout func(*deploypb.Rollout)
+	// FailRollout, when set, picks the rollouts whose verification fails
+	// instead of succeeding
+	FailRollout func(*deploypb.Rollout) bool
+
+	srv *grpc.Server
+
+	mu         sync.Mutex
+	calls      []Call
+	pipelines  map[string]*deploypb.DeliveryPipeline
+	targets    map[string]*deploypb.Target
+	releases   map[string]*deploypb.Release
+	rollouts   map[string]*deploypb.Rollout
+	operations map[string]*longrunningpb.Operation
+}
+
+// NewServer starts a fake on a free localhost port. Callers should Close it
+// when done.
+func NewServer() (*Server, error) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:       lis.Addr().String(),
+		srv:        grpc.NewServer(),
+		pipelines:  map[string]*deploypb.DeliveryPipeline{},
+		targets:    map[string]*deploypb.Target{},
+		releases:   map[string]*deploypb.Release{},
+		rollouts:   map[string]*deploypb.Rollout{},
+		operations: map[string]*longrunningpb.Operation{},
+	}
+	deploypb.RegisterCloudDeployServer(s.srv, s)
+	longrunningpb.RegisterOperationsServer(s.srv, s)
+	go s.srv.Serve(lis)
+	return s, nil
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.srv.Stop()
+}
+
+// AddPipeline stores a delivery pipeline. Name must be set.
+func (s *Server) AddPipeline(p *deploypb.DeliveryPipeline) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pipelines[p.Name] = proto.Clone(p).(*deploypb.DeliveryPipeline)
+}
+
+// AddTarget stores a target. Name must be set; its last segment is the
+// target ID stages and rollouts refer to.
+func (s *Server) AddTarget(t *deploypb.Target) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targets[t.Name] = proto.Clone(t).(*deploypb.Target)
+}
+
+// Calls returns the requests received so far, in order.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// Release returns a copy of a stored release, or nil.
+func (s *Server) Release(name string) *deploypb.Release {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.releases[name]; ok {
+		return proto.Clone(r).(*deploypb.Release)
+	}
+	return nil
+}
+
+// Rollouts returns copies of every stored rollout.
+func (s *Server) Rollouts() []*deploypb.Rollout {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*deploypb.Rollout
+	for _, r := range s.rollouts {
+		out = append(out, proto.Clone(r).(*deploypb.Rollout))
+	}
+	return out
+}
+
+// record must be called with s.mu held.
+func (s *Server) record(method string, req proto.Message) {
+	s.calls = append(s.calls, Call{Method: method, Request: proto.Clone(req)})
+}
+
+func (s *Server) GetDeliveryPipeline(ctx context.Context, req *deploypb.GetDeliveryPipelineRequest) (*deploypb.DeliveryPipeline, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("GetDeliveryPipeline", req)
+	p, ok := s.pipelines[req.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "delivery pipeline %q not found", req.Name)
+	}
+	return proto.Clone(p).(*deploypb.DeliveryPipeline), nil
+}
+
+func (s *Server) GetRelease(ctx context.Context, req *deploypb.GetReleaseRequest) (*deploypb.Release, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("GetRelease", req)
+	r, ok := s.releases[req.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "release %q not found", req.Name)
+	}
+	return proto.Clone(r).(*deploypb.Release), nil
+}
+
+func (s *Server) CreateRelease(ctx context.Context, req *deploypb.CreateReleaseRequest) (*longrunningpb.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("CreateRelease", req)
+	p, ok := s.pipelines[req.Parent]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "delivery pipeline %q not found", req.Parent)
+	}
+	name := req.Parent + "/releases/" + req.ReleaseId
+	if _, exists := s.releases[name]; exists {
+		return nil, status.Errorf(codes.AlreadyExists, "release %q already exists", name)
+	}
+	release := proto.Clone(req.Release).(*deploypb.Release)
+	release.Name = name
+	release.Uid = fmt.Sprintf("uid-%d", len(s.releases)+1)
+	release.CreateTime = timestamppb.Now()
+	release.DeliveryPipelineSnapshot = proto.Clone(p).(*deploypb.DeliveryPipeline)
+	release.RenderState = deploypb.Release_SUCCEEDED
+	s.releases[name] = release
+
+	if s.OnRelease != nil {
+		go s.OnRelease(proto.Clone(release).(*deploypb.Release))
+	}
+	return s.done(name, release)
+}
+
+func (s *Server) AbandonRelease(ctx context.Context, req *deploypb.AbandonReleaseRequest) (*deploypb.AbandonReleaseResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("AbandonRelease", req)
+	r, ok := s.releases[req.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "release %q not found", req.Name)
+	}
+	r.Abandoned = true
+	return &deploypb.AbandonReleaseResponse{}, nil
+}
+
+func (s *Server) ListRollouts(ctx context.Context, req *deploypb.ListRolloutsRequest) (*deploypb.ListRolloutsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("ListRollouts", req)
+	if _, ok := s.releases[req.Parent]; !ok {
+		return nil, status.Errorf(codes.NotFound, "release %q not found", req.Parent)
+	}
+	// Only the filter the functions use is understood
+	var approvalState deploypb.Rollout_ApprovalState
+	if req.Filter != "" {
+		state, ok := strings.CutPrefix(req.Filter, "approval_state=")
+		v, known := deploypb.Rollout_ApprovalState_value[strings.Trim(state, `"`)]
+		if !ok || !known {
+			return nil, status.Errorf(codes.InvalidArgument, "unsupported filter %q", req.Filter)
+		}
+		approvalState = deploypb.Rollout_ApprovalState(v)
+	}
+	resp := &deploypb.ListRolloutsResponse{}
+	for name, r := range s.rollouts {
+		if !strings.HasPrefix(name, req.Parent+"/rollouts/") {
+			continue
+		}
+		if approvalState != deploypb.Rollout_APPROVAL_STATE_UNSPECIFIED && r.ApprovalState != approvalState {
+			continue
+		}
+		resp.Rollouts = append(resp.Rollouts, proto.Clone(r).(*deploypb.Rollout))
+	}
+	return resp, nil
+}
+
+func (s *Server) GetRollout(ctx context.Context, req *deploypb.GetRolloutRequest) (*deploypb.Rollout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("GetRollout", req)
+	r, ok := s.rollouts[req.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "rollout %q not found", req.Name)
+	}
+	return proto.Clone(r).(*deploypb.Rollout), nil
+}
+
+func (s *Server) CreateRollout(ctx context.Context, req *deploypb.CreateRolloutRequest) (*longrunningpb.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("CreateRollout", req)
+	release, ok := s.releases[req.Parent]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "release %q not found", req.Parent)
+	}
+	name := req.Parent + "/rollouts/" + req.RolloutId
+	if _, exists := s.rollouts[name]; exists {
+		return nil, status.Errorf(codes.AlreadyExists, "rollout %q already exists", name)
+	}
+	target := s.target(release, req.GetRollout().GetTargetId())
+	if target == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "target %q is not part of the pipeline", req.GetRollout().GetTargetId())
+	}
+
+	rollout := proto.Clone(req.Rollout).(*deploypb.Rollout)
+	rollout.Name = name
+	s.start(rollout, target)
+	return s.done(name, rollout)
+}
+
+// start stores a new rollout and takes it as far as it goes without
+// approval. Must be called with s.mu held.
+func (s *Server) start(rollout *deploypb.Rollout, target *deploypb.Target) {
+	rollout.Uid = fmt.Sprintf("uid-%d", len(s.rollouts)+1)
+	rollout.CreateTime = timestamppb.Now()
+	if target.RequireApproval {
+		rollout.ApprovalState = deploypb.Rollout_NEEDS_APPROVAL
+		rollout.State = deploypb.Rollout_PENDING_APPROVAL
+	} else {
+		rollout.ApprovalState = deploypb.Rollout_DOES_NOT_NEED_APPROVAL
+		s.finish(rollout)
+	}
+	s.rollouts[rollout.Name] = rollout
+	s.notifyRollout(rollout)
+}
+
+// finish deploys an approved rollout: it succeeds unless FailRollout says
+// its verification fails. Must be called with s.mu held.
+func (s *Server) finish(r *deploypb.Rollout) {
+	r.DeployEndTime = timestamppb.Now()
+	if s.FailRollout == nil || !s.FailRollout(proto.Clone(r).(*deploypb.Rollout)) {
+		r.State = deploypb.Rollout_SUCCEEDED
+		return
+	}
+	r.State = deploypb.Rollout_FAILED
+	r.FailureReason = "verification failed"
+	r.Phases = []*deploypb.Phase{{
+		Id:    "stable",
+		State: deploypb.Phase_FAILED,
+		Jobs: &deploypb.Phase_DeploymentJobs{DeploymentJobs: &deploypb.DeploymentJobs{
+			DeployJob: &deploypb.Job{Id: "deploy", State: deploypb.Job_SUCCEEDED},
+			VerifyJob: &deploypb.Job{Id: "verify", State: deploypb.Job_FAILED},
+		}},
+	}}
+}
+
+// RollbackTarget rolls the target back to ReleaseId, or else to the release
+// of the last rollout that succeeded on it other than the one rolled back.
+func (s *Server) RollbackTarget(ctx context.Context, req *deploypb.RollbackTargetRequest) (*deploypb.RollbackTargetResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("RollbackTarget", req)
+	if _, ok := s.pipelines[req.Name]; !ok {
+		return nil, status.Errorf(codes.NotFound, "delivery pipeline %q not found", req.Name)
+	}
+	var rolledBack, last *deploypb.Rollout
+	for name, r := range s.rollouts {
+		if !strings.HasPrefix(name, req.Name+"/releases/") || r.TargetId != req.TargetId {
+			continue
+		}
+		if name == req.RolloutToRollBack || (req.RolloutToRollBack == "" && (rolledBack == nil || r.CreateTime.AsTime().After(rolledBack.CreateTime.AsTime()))) {
+			rolledBack = r
+		}
+	}
+	if rolledBack == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "nothing to roll back on target %q", req.TargetId)
+	}
+	for name, r := range s.rollouts {
+		if !strings.HasPrefix(name, req.Name+"/releases/") || r.TargetId != req.TargetId || r.State != deploypb.Rollout_SUCCEEDED || r == rolledBack {
+			continue
+		}
+		if req.ReleaseId != "" && !strings.HasPrefix(name, req.Name+"/releases/"+req.ReleaseId+"/") {
+			continue
+		}
+		if last == nil || r.CreateTime.AsTime().After(last.CreateTime.AsTime()) {
+			last = r
+		}
+	}
+	if last == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "no successful rollout to roll target %q back to", req.TargetId)
+	}
+	releaseName, _, _ := strings.Cut(last.Name, "/rollouts/")
+	name := releaseName + "/rollouts/" + req.RolloutId
+	if _, exists := s.rollouts[name]; exists {
+		return nil, status.Errorf(codes.AlreadyExists, "rollout %q already exists", name)
+	}
+	rollout := &deploypb.Rollout{Name: name, TargetId: req.TargetId, RollbackOfRollout: rolledBack.Name}
+	resp := &deploypb.RollbackTargetResponse{RollbackConfig: &deploypb.RollbackTargetConfig{Rollout: rollout}}
+	if req.ValidateOnly {
+		return resp, nil
+	}
+	rolledBack.RolledBackByRollouts = append(rolledBack.RolledBackByRollouts, name)
+	s.start(rollout, s.target(s.releases[releaseName], req.TargetId))
+	return resp, nil
+}
+
+func (s *Server) ApproveRollout(ctx context.Context, req *deploypb.ApproveRolloutRequest) (*deploypb.ApproveRolloutResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("ApproveRollout", req)
+	r, ok := s.rollouts[req.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "rollout %q not found", req.Name)
+	}
+	if r.ApprovalState != deploypb.Rollout_NEEDS_APPROVAL {
+		return nil, status.Errorf(codes.FailedPrecondition, "rollout %q doesn't need approval", req.Name)
+	}
+	if req.Approved {
+		r.ApprovalState = deploypb.Rollout_APPROVED
+		s.finish(r)
+	} else {
+		r.ApprovalState = deploypb.Rollout_REJECTED
+		r.State = deploypb.Rollout_FAILED
+	}
+	s.notifyRollout(r)
+	return &deploypb.ApproveRolloutResponse{}, nil
+}
+
+func (s *Server) CancelRollout(ctx context.Context, req *deploypb.CancelRolloutRequest) (*deploypb.CancelRolloutResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("CancelRollout", req)
+	r, ok := s.rollouts[req.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "rollout %q not found", req.Name)
+	}
+	r.State = deploypb.Rollout_CANCELLED
+	s.notifyRollout(r)
+	return &deploypb.CancelRolloutResponse{}, nil
+}
+
+func (s *Server) GetOperation(ctx context.Context, req *longrunningpb.GetOperationRequest) (*longrunningpb.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op, ok := s.operations[req.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "operation %q not found", req.Name)
+	}
+	return proto.Clone(op).(*longrunningpb.Operation), nil
+}
+
+// target finds the target a release's pipeline deploys to under targetID.
+// Targets nobody added are assumed to exist and not require approval.
+// Must be called with s.mu held.
+func (s *Server) target(release *deploypb.Release, targetID string) *deploypb.Target {
+	for _, stage := range release.GetDeliveryPipelineSnapshot().GetSerialPipeline().GetStages() {
+		if stage.TargetId != targetID {
+			continue
+		}
+		for name, t := range s.targets {
+			if name[strings.LastIndex(name, "/")+1:] == targetID {
+				return t
+			}
+		}
+		return &deploypb.Target{TargetId: targetID}
+	}
+	return nil
+}
+
+// notifyRollout must be called with s.mu held.
+func (s *Server) notifyRollout(r *deploypb.Rollout) {
+	if s.OnRollout != nil {
+		go s.OnRollout(proto.Clone(r).(*deploypb.Rollout))
+	}
+}
+
+// done stores and returns a finished operation for resource.
+// Must be called with s.mu held.
+func (s *Server) done(resource string, response proto.Message) (*longrunningpb.Operation, error) {
+	resp, err := anypb.New(response)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "anypb.New: %v", err)
+	}
+	meta, err := anypb.New(&deploypb.OperationMetadata{
+		CreateTime: timestamppb.Now(),
+		EndTime:    timestamppb.Now(),
+		Target:     resource,
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "anypb.New: %v", err)
+	}
+	op := &longrunningpb.Operation{
+		Name:     fmt.Sprintf("operations/op-%d", len(s.operations)+1),
+		Metadata: meta,
+		Done:     true,
+		Result:   &longrunningpb.Operation_Response{Response: resp},
+	}
+	s.operations[op.Name] = op
+	return proto.Clone(op).(*longrunningpb.Operation), nil
+}
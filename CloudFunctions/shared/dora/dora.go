@@ -0,0 +1,201 @@
+// Package dora computes the four DORA metrics — deployment frequency, lead
+// time for changes, change failure rate and time to restore service — per
+// pipeline and target from the rollouts in the deployment history.
+package dora
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/shared/history"
+)
+
+// Window is a rolling window the metrics are computed over, ending now.
+type Window struct {
+	Name   string
+	Length time.Duration
+}
+
+// ParseWindows parses a comma separated list of windows such as
+// "1d,7d,30d". Days are written "<n>d", anything else as a Go duration.
+func ParseWindows(s string) ([]Window, error) {
+	var windows []Window
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		var length time.Duration
+		if days, ok := strings.CutSuffix(name, "d"); ok {
+			n, err := strconv.Atoi(days)
+			if err != nil {
+				return nil, fmt.Errorf("invalid window %q: %w", name, err)
+			}
+			length = time.Duration(n) * 24 * time.Hour
+		} else {
+			var err error
+			if length, err = time.ParseDuration(name); err != nil {
+				return nil, fmt.Errorf("invalid window %q: %w", name, err)
+			}
+		}
+		if length <= 0 {
+			return nil, fmt.Errorf("invalid window %q: must be positive", name)
+		}
+		windows = append(windows, Window{Name: name, Length: length})
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("no windows given")
+	}
+	return windows, nil
+}
+
+// Longest returns the length of the longest window, which is how far back
+// the history has to be read.
+func Longest(windows []Window) time.Duration {
+	var longest time.Duration
+	for _, w := range windows {
+		longest = max(longest, w.Length)
+	}
+	return longest
+}
+
+// Metrics of one pipeline's target over one window. Durations are medians
+// in seconds, left out when there was nothing to measure.
+type Metrics struct {
+	Pipeline string `json:"pipeline"`
+	Target   string `json:"target"`
+	Window   string `json:"window"`
+
+	// Deployments are rollouts that succeeded, rollbacks aside
+	Deployments       int     `json:"deployments"`
+	DeploymentsPerDay float64 `json:"deploymentsPerDay"`
+	// LeadTimeSeconds is from commit to successful rollout, over the
+	// LeadTimes deployments whose commit time is known
+	LeadTimes       int     `json:"leadTimes"`
+	LeadTimeSeconds float64 `json:"leadTimeSeconds,omitempty"`
+	// Changes are rollouts that finished, ChangeFailures those that failed
+	// or were rolled back
+	Changes           int     `json:"changes"`
+	ChangeFailures    int     `json:"changeFailures"`
+	ChangeFailureRate float64 `json:"changeFailureRate"`
+	// TimeToRestoreSeconds is from a failure to the next rollout to
+	// succeed on the target, over the Restores failures that have been
+	Restores             int     `json:"restores"`
+	TimeToRestoreSeconds float64 `json:"timeToRestoreSeconds,omitempty"`
+}
+
+// Compute returns the metrics of every pipeline and target with rollouts
+// in records, for each window ending at now. records are rollout state
+// records in any order; others are ignored.
+func Compute(records []history.Record, windows []Window, now time.Time) []Metrics {
+	type key struct{ pipeline, target string }
+	groups := map[key][]history.Record{}
+	var keys []key
+	for _, r := range records {
+		if r.Kind != history.RolloutState {
+			continue
+		}
+		k := key{r.Pipeline, r.Target}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], r)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].pipeline != keys[j].pipeline {
+			return keys[i].pipeline < keys[j].pipeline
+		}
+		return keys[i].target < keys[j].target
+	})
+
+	var metrics []Metrics
+	for _, k := range keys {
+		rollouts := groups[k]
+		sort.SliceStable(rollouts, func(i, j int) bool { return rollouts[i].At.Before(rollouts[j].At) })
+		failures := failuresOf(rollouts)
+		for _, w := range windows {
+			m := compute(rollouts, failures, now.Add(-w.Length), now)
+			m.Pipeline, m.Target, m.Window = k.pipeline, k.target, w.Name
+			m.DeploymentsPerDay = float64(m.Deployments) / (w.Length.Hours() / 24)
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// failuresOf returns when each failed change, keyed by rollout, was found
+// to have failed: when its rollout failed, or when a rollback of it
+// started. A rollout that failed and was rolled back counts once.
+func failuresOf(rollouts []history.Record) map[string]time.Time {
+	failures := map[string]time.Time{}
+	for _, r := range rollouts {
+		failed := r.Rollout
+		switch {
+		case r.RollbackOf != "":
+			failed = r.RollbackOf
+		case r.State != history.Failed:
+			continue
+		}
+		if t, ok := failures[failed]; !ok || r.At.Before(t) {
+			failures[failed] = r.At
+		}
+	}
+	return failures
+}
+
+// compute works out the metrics of rollouts, sorted oldest first, in
+// [start, end].
+func compute(rollouts []history.Record, failures map[string]time.Time, start, end time.Time) Metrics {
+	in := func(t time.Time) bool { return !t.Before(start) && !t.After(end) }
+	var m Metrics
+	var leadTimes, restoreTimes []time.Duration
+	for _, r := range rollouts {
+		if !in(r.At) || r.RollbackOf != "" {
+			continue
+		}
+		switch r.State {
+		case history.Succeeded:
+			m.Deployments++
+			m.Changes++
+			if !r.CommitTime.IsZero() && r.At.After(r.CommitTime) {
+				leadTimes = append(leadTimes, r.At.Sub(r.CommitTime))
+			}
+		case history.Failed:
+			m.Changes++
+		}
+	}
+	for _, failedAt := range failures {
+		if !in(failedAt) {
+			continue
+		}
+		m.ChangeFailures++
+		for _, r := range rollouts {
+			if r.State == history.Succeeded && r.At.After(failedAt) && !r.At.After(end) {
+				restoreTimes = append(restoreTimes, r.At.Sub(failedAt))
+				break
+			}
+		}
+	}
+	if m.Changes > 0 {
+		// Rolled back changes may have succeeded before the window
+		m.ChangeFailureRate = min(float64(m.ChangeFailures)/float64(m.Changes), 1)
+	}
+	m.LeadTimes, m.LeadTimeSeconds = len(leadTimes), median(leadTimes).Seconds()
+	m.Restores, m.TimeToRestoreSeconds = len(restoreTimes), median(restoreTimes).Seconds()
+	return m
+}
+
+func median(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	mid := len(durations) / 2
+	if len(durations)%2 == 0 {
+		return (durations[mid-1] + durations[mid]) / 2
+	}
+	return durations[mid]
+}
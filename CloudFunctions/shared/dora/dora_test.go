@@ -0,0 +1,218 @@
+package dora_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"example.com/shared/dora"
+	"example.com/shared/history"
+)
+
+var now = time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+// rollout is a rollout state record of web's prod target, at ago before now.
+func rollout(id, state string, ago time.Duration) history.Record {
+	return history.Record{Kind: history.RolloutState, Pipeline: "web", Target: "prod", Rollout: id, State: state, At: now.Add(-ago)}
+}
+
+// committed sets when r's change was committed, lead before it rolled out.
+func committed(r history.Record, lead time.Duration) history.Record {
+	r.CommitTime = r.At.Add(-lead)
+	return r
+}
+
+func rollbackOf(r history.Record, of string) history.Record {
+	r.RollbackOf = of
+	return r
+}
+
+const day = 24 * time.Hour
+
+// fixture is a month of web's prod target, out of order as the history
+// may return it:
+//   - r1 succeeded 10 days ago, 4h after its commit
+//   - r2 failed 5 days ago and was rolled back, which took 40m
+//   - r3 succeeded 3 days ago, 2h after its commit
+//   - r4 succeeded 2h ago, 1h after its commit, and was rolled back 90m
+//     ago, which took 30m
+var fixture = []history.Record{
+	committed(rollout("r4", history.Succeeded, 2*time.Hour), time.Hour),
+	committed(rollout("r1", history.Succeeded, 10*day), 4*time.Hour),
+	rollbackOf(rollout("rollback-r2", history.Succeeded, 5*day-40*time.Minute), "r2"),
+	rollout("r2", history.Failed, 5*day),
+	rollbackOf(rollout("rollback-r2", history.InProgress, 5*day-10*time.Minute), "r2"),
+	committed(rollout("r3", history.Succeeded, 3*day), 2*time.Hour),
+	rollbackOf(rollout("rollback-r4", history.InProgress, 90*time.Minute), "r4"),
+	rollbackOf(rollout("rollback-r4", history.Succeeded, time.Hour), "r4"),
+	rollout("r4", history.InProgress, 3*time.Hour),
+	// Only rollout states count
+	{Kind: history.Approval, Pipeline: "web", Target: "prod", Rollout: "r4", Decision: "approve", At: now.Add(-4 * time.Hour)},
+	{Kind: history.Rollback, Pipeline: "web", Target: "prod", Rollout: "r4", At: now.Add(-90 * time.Minute)},
+}
+
+func TestCompute(t *testing.T) {
+	windows, err := dora.ParseWindows("1d,7d,30d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := dora.Compute(fixture, windows, now)
+	want := []dora.Metrics{
+		{
+			Pipeline: "web", Target: "prod", Window: "1d",
+			Deployments: 1, DeploymentsPerDay: 1,
+			LeadTimes: 1, LeadTimeSeconds: 3600,
+			// r4's rollback, whichever of its records came first
+			Changes: 1, ChangeFailures: 1, ChangeFailureRate: 1,
+			Restores: 1, TimeToRestoreSeconds: 1800,
+		},
+		{
+			Pipeline: "web", Target: "prod", Window: "7d",
+			Deployments: 2, DeploymentsPerDay: 2.0 / 7,
+			// The median of an even count is the mean of the middle two
+			LeadTimes: 2, LeadTimeSeconds: 5400,
+			// r2 failed and was rolled back, which is one failure
+			Changes: 3, ChangeFailures: 2, ChangeFailureRate: 2.0 / 3,
+			Restores: 2, TimeToRestoreSeconds: 2100,
+		},
+		{
+			Pipeline: "web", Target: "prod", Window: "30d",
+			Deployments: 3, DeploymentsPerDay: 0.1,
+			LeadTimes: 3, LeadTimeSeconds: 7200,
+			Changes: 4, ChangeFailures: 2, ChangeFailureRate: 0.5,
+			Restores: 2, TimeToRestoreSeconds: 2100,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Compute =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestComputeGroups(t *testing.T) {
+	windows := []dora.Window{{Name: "1d", Length: day}}
+	records := []history.Record{
+		{Kind: history.RolloutState, Pipeline: "web", Target: "prod", State: history.Succeeded, At: now.Add(-time.Hour)},
+		{Kind: history.RolloutState, Pipeline: "web", Target: "dev", State: history.Succeeded, At: now.Add(-time.Hour)},
+		{Kind: history.RolloutState, Pipeline: "api", Target: "prod", State: history.Failed, At: now.Add(-time.Hour)},
+		{Kind: history.RolloutState, Pipeline: "web", Target: "dev", State: history.Succeeded, At: now.Add(-2 * time.Hour)},
+	}
+	var got []string
+	for _, m := range dora.Compute(records, windows, now) {
+		got = append(got, m.Pipeline+"/"+m.Target)
+		if m.Pipeline == "web" && m.Target == "dev" && m.Deployments != 2 {
+			t.Errorf("web/dev deployments = %d, want 2", m.Deployments)
+		}
+	}
+	if want := []string{"api/prod", "web/dev", "web/prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("groups %v, want %v", got, want)
+	}
+	if got := dora.Compute(nil, windows, now); len(got) != 0 {
+		t.Errorf("Compute of no records = %+v, want nothing", got)
+	}
+}
+
+func TestComputeWindowBoundaries(t *testing.T) {
+	windows := []dora.Window{{Name: "1h", Length: time.Hour}}
+	for _, tt := range []struct {
+		name string
+		ago  time.Duration
+		want int
+	}{
+		{name: "at the start", ago: time.Hour, want: 1},
+		{name: "before the start", ago: time.Hour + time.Nanosecond},
+		{name: "at the end", want: 1},
+		{name: "after the end", ago: -time.Nanosecond},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := dora.Compute([]history.Record{
+				rollout("r1", history.Succeeded, tt.ago),
+				rollout("r0", history.Failed, tt.ago),
+			}, windows, now)[0]
+			if m.Deployments != tt.want || m.Changes != 2*tt.want || m.ChangeFailures != tt.want {
+				t.Errorf("deployments %d, changes %d, failures %d, want %d, %d and %d",
+					m.Deployments, m.Changes, m.ChangeFailures, tt.want, 2*tt.want, tt.want)
+			}
+		})
+	}
+
+	// Restores after the window don't count, nor do failures before it
+	m := dora.Compute([]history.Record{
+		rollout("r1", history.Failed, 30*time.Minute),
+		rollout("r2", history.Succeeded, -time.Minute),
+		rollout("r0", history.Failed, 2*time.Hour),
+	}, windows, now)[0]
+	if m.ChangeFailures != 1 || m.Restores != 0 || m.TimeToRestoreSeconds != 0 {
+		t.Errorf("failures %d, restores %d in %vs, want 1 not yet restored", m.ChangeFailures, m.Restores, m.TimeToRestoreSeconds)
+	}
+}
+
+func TestComputeRollbacks(t *testing.T) {
+	windows := []dora.Window{{Name: "1d", Length: day}}
+	// r1 and r2 succeeded, r1 before the window, and both were rolled back
+	// in it
+	m := dora.Compute([]history.Record{
+		rollout("r1", history.Succeeded, 2*day),
+		rollout("r2", history.Succeeded, 2*time.Hour),
+		rollbackOf(rollout("rollback-r1", history.InProgress, 90*time.Minute), "r1"),
+		rollbackOf(rollout("rollback-r2", history.InProgress, time.Hour), "r2"),
+		rollbackOf(rollout("rollback-r2", history.Failed, 50*time.Minute), "r2"),
+	}, windows, now)[0]
+	// Rollbacks are neither deployments nor changes, even when they fail
+	if m.Deployments != 1 || m.Changes != 1 {
+		t.Errorf("deployments %d, changes %d, want 1 and 1", m.Deployments, m.Changes)
+	}
+	// Two failures of one change is still a rate of 1
+	if m.ChangeFailures != 2 || m.ChangeFailureRate != 1 {
+		t.Errorf("failures %d at rate %v, want 2 at 1", m.ChangeFailures, m.ChangeFailureRate)
+	}
+	if m.Restores != 0 {
+		t.Errorf("restores %d, want none", m.Restores)
+	}
+
+	// Nothing finished, so there's no rate
+	m = dora.Compute([]history.Record{rollout("r1", history.InProgress, time.Hour)}, windows, now)[0]
+	if m.Changes != 0 || m.ChangeFailureRate != 0 || m.LeadTimes != 0 {
+		t.Errorf("metrics of a rollout in progress = %+v", m)
+	}
+}
+
+func TestComputeLeadTimes(t *testing.T) {
+	windows := []dora.Window{{Name: "1d", Length: day}}
+	records := []history.Record{
+		committed(rollout("r1", history.Succeeded, time.Hour), 3*time.Hour),
+		committed(rollout("r2", history.Succeeded, 2*time.Hour), time.Hour),
+		committed(rollout("r3", history.Succeeded, 3*time.Hour), 2*time.Hour),
+		// Commit times that are unknown or after the rollout aren't measured
+		rollout("r4", history.Succeeded, 4*time.Hour),
+		committed(rollout("r5", history.Succeeded, 5*time.Hour), -time.Minute),
+		// Nor are failures
+		committed(rollout("r6", history.Failed, 6*time.Hour), 10*time.Hour),
+	}
+	m := dora.Compute(records, windows, now)[0]
+	if m.LeadTimes != 3 || m.LeadTimeSeconds != 7200 {
+		t.Errorf("%d lead times, median %vs, want 3 with median 7200s", m.LeadTimes, m.LeadTimeSeconds)
+	}
+}
+
+func TestParseWindows(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []dora.Window
+	}{
+		{in: "1d,7d,30d", want: []dora.Window{{"1d", day}, {"7d", 7 * day}, {"30d", 30 * day}}},
+		{in: " 12h , 90m,", want: []dora.Window{{"12h", 12 * time.Hour}, {"90m", 90 * time.Minute}}},
+	} {
+		got, err := dora.ParseWindows(tt.in)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseWindows(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", " , ", "xd", "0d", "-1d", "-1h", "0s", "week"} {
+		if _, err := dora.ParseWindows(in); err == nil {
+			t.Errorf("ParseWindows(%q) succeeded, want an error", in)
+		}
+	}
+	if got := dora.Longest([]dora.Window{{"1d", day}, {"30d", 30 * day}, {"7d", 7 * day}}); got != 30*day {
+		t.Errorf("Longest = %s, want 720h", got)
+	}
+}
@@ -0,0 +1,57 @@
+package dora
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ContentType of WriteOpenMetrics' output
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// The metric families written, all gauges as they're over rolling windows
+var families = []struct {
+	name, help string
+	value      func(Metrics) (float64, bool)
+}{
+	{"dora_deployments", "Rollouts that succeeded in the window, rollbacks aside.",
+		func(m Metrics) (float64, bool) { return float64(m.Deployments), true }},
+	{"dora_deployment_frequency_per_day", "Deployments per day over the window.",
+		func(m Metrics) (float64, bool) { return m.DeploymentsPerDay, true }},
+	{"dora_lead_time_seconds", "Median time from commit to successful rollout.",
+		func(m Metrics) (float64, bool) { return m.LeadTimeSeconds, m.LeadTimes > 0 }},
+	{"dora_change_failures", "Rollouts that failed or were rolled back in the window.",
+		func(m Metrics) (float64, bool) { return float64(m.ChangeFailures), true }},
+	{"dora_change_failure_ratio", "Change failures over finished rollouts in the window.",
+		func(m Metrics) (float64, bool) { return m.ChangeFailureRate, m.Changes > 0 }},
+	{"dora_time_to_restore_seconds", "Median time from a failure to the next successful rollout.",
+		func(m Metrics) (float64, bool) { return m.TimeToRestoreSeconds, m.Restores > 0 }},
+}
+
+// WriteOpenMetrics writes metrics in the OpenMetrics text format, labelled
+// by pipeline, target and window. Medians with nothing to measure are left
+// out rather than reported as 0.
+func WriteOpenMetrics(w io.Writer, metrics []Metrics) error {
+	b := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(b, "# TYPE %s gauge\n# HELP %s %s\n", f.name, f.name, f.help)
+		for _, m := range metrics {
+			v, ok := f.value(m)
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(b, "%s{pipeline=\"%s\",target=\"%s\",window=\"%s\"} %s\n", f.name,
+				escape(m.Pipeline), escape(m.Target), escape(m.Window), strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	}
+	b.WriteString("# EOF\n")
+	return b.Flush()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
@@ -0,0 +1,62 @@
+package dora_test
+
+import (
+	"bytes"
+	"testing"
+
+	"example.com/shared/dora"
+)
+
+func TestWriteOpenMetrics(t *testing.T) {
+	metrics := []dora.Metrics{
+		{
+			Pipeline: "web", Target: "prod", Window: "7d",
+			Deployments: 2, DeploymentsPerDay: 2.0 / 7,
+			LeadTimes: 2, LeadTimeSeconds: 5400,
+			Changes: 3, ChangeFailures: 2, ChangeFailureRate: 2.0 / 3,
+			Restores: 2, TimeToRestoreSeconds: 2100,
+		},
+		// Label values are escaped, and medians and rates with nothing to
+		// measure left out
+		{Pipeline: `we"b\`, Target: "pr\nod", Window: "1d"},
+	}
+	var b bytes.Buffer
+	if err := dora.WriteOpenMetrics(&b, metrics); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE dora_deployments gauge
+# HELP dora_deployments Rollouts that succeeded in the window, rollbacks aside.
+dora_deployments{pipeline="web",target="prod",window="7d"} 2
+dora_deployments{pipeline="we\"b\\",target="pr\nod",window="1d"} 0
+# TYPE dora_deployment_frequency_per_day gauge
+# HELP dora_deployment_frequency_per_day Deployments per day over the window.
+dora_deployment_frequency_per_day{pipeline="web",target="prod",window="7d"} 0.2857142857142857
+dora_deployment_frequency_per_day{pipeline="we\"b\\",target="pr\nod",window="1d"} 0
+# TYPE dora_lead_time_seconds gauge
+# HELP dora_lead_time_seconds Median time from commit to successful rollout.
+dora_lead_time_seconds{pipeline="web",target="prod",window="7d"} 5400
+# TYPE dora_change_failures gauge
+# HELP dora_change_failures Rollouts that failed or were rolled back in the window.
+dora_change_failures{pipeline="web",target="prod",window="7d"} 2
+dora_change_failures{pipeline="we\"b\\",target="pr\nod",window="1d"} 0
+# TYPE dora_change_failure_ratio gauge
+# HELP dora_change_failure_ratio Change failures over finished rollouts in the window.
+dora_change_failure_ratio{pipeline="web",target="prod",window="7d"} 0.6666666666666666
+# TYPE dora_time_to_restore_seconds gauge
+# HELP dora_time_to_restore_seconds Median time from a failure to the next successful rollout.
+dora_time_to_restore_seconds{pipeline="web",target="prod",window="7d"} 2100
+# EOF
+`
+	if got := b.String(); got != want {
+		t.Errorf("WriteOpenMetrics wrote\n%s\nwant\n%s", got, want)
+	}
+
+	// With no metrics it's still a valid exposition
+	b.Reset()
+	if err := dora.WriteOpenMetrics(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); !bytes.HasSuffix(b.Bytes(), []byte("# EOF\n")) || bytes.Count(b.Bytes(), []byte("# TYPE")) != 6 {
+		t.Errorf("WriteOpenMetrics of nothing wrote\n%s", got)
+	}
+}
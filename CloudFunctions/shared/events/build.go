@@ -0,0 +1,67 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// BuildEvent is published by createRelease for every status change of a
+// routed Cloud Build build, so the deployment timeline starts at build time.
+type BuildEvent struct {
+	BuildID string `json:"buildId"`
+	// Status is the Cloud Build status, e.g. WORKING, SUCCESS or TIMEOUT
+	Status    string    `json:"status"`
+	Trigger   string    `json:"trigger,omitempty"`
+	Repo      string    `json:"repo,omitempty"`
+	Branch    string    `json:"branch,omitempty"`
+	Tag       string    `json:"tag,omitempty"`
+	CommitSha string    `json:"commitSha,omitempty"`
+	LogURL    string    `json:"logUrl,omitempty"`
+	Pipeline  string    `json:"pipeline,omitempty"`
+	IssueKey  string    `json:"issueKey,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Failed reports whether the build ended without producing anything to
+// release.
+func (e *BuildEvent) Failed() bool {
+	switch e.Status {
+	case "FAILURE", "INTERNAL_ERROR", "TIMEOUT", "CANCELLED", "EXPIRED":
+		return true
+	}
+	return false
+}
+
+// PublishBuildEvent sends e to the topic with its build ID and status as
+// attributes so subscriptions can filter on them.
+func PublishBuildEvent(ctx context.Context, projectID, topicID string, e *BuildEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %v", err)
+	}
+	client, err := pubsub.NewClient(ctx, projectID)
+	if err != nil {
+		return fmt.Errorf("pubsub.NewClient: %w", err)
+	}
+	defer client.Close()
+	t := client.Topic(topicID)
+	defer t.Stop()
+
+	id, err := t.Publish(ctx, &pubsub.Message{
+		Data: data,
+		Attributes: map[string]string{
+			"buildId": e.BuildID,
+			"status":  e.Status,
+		},
+	}).Get(ctx)
+	if err != nil {
+		return fmt.Errorf("publishing to %s: %w", topicID, err)
+	}
+	log.Printf("Published %s event for build %s; msg ID: %v", e.Status, e.BuildID, id)
+	return nil
+}
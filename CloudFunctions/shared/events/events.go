@@ -0,0 +1,59 @@
+// Package events decodes the CloudEvents Eventarc delivers to the functions
+// when a message is published to one of their Pub/Sub topics.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// MessagePublishedData is the payload of a
+// google.cloud.pubsub.topic.v1.messagePublished event.
+type MessagePublishedData struct {
+	Message      PubsubMessage `json:"message"`
+	Subscription string        `json:"subscription"`
+}
+
+// PubsubMessage is the Pub/Sub message carried by the event.
+type PubsubMessage struct {
+	// Data is the payload of the message.
+	Data []byte `json:"data"`
+
+	// Attributes are the message attributes. Cloud Deploy notifications put
+	// everything interesting here, see DecodeAttributes.
+	Attributes map[string]string `json:"attributes"`
+
+	// MessageId is the ID of the message generated by the server.
+	MessageID string `json:"messageId"`
+
+	// PublishTime is the time at which the message was published.
+	PublishTime time.Time `json:"publishTime"`
+
+	// OrderingKey is the ordering key of the message.
+	OrderingKey string `json:"orderingKey"`
+}
+
+// Decode parses the Pub/Sub message out of a CloudEvent.
+func Decode(e event.Event) (*MessagePublishedData, error) {
+	var msg MessagePublishedData
+	if err := e.DataAs(&msg); err != nil {
+		return nil, fmt.Errorf("event.DataAs: %w", err)
+	}
+	return &msg, nil
+}
+
+// DecodeAttributes copies the message attributes into v, a struct whose json
+// tags name the attributes.
+func (m *PubsubMessage) DecodeAttributes(v interface{}) error {
+	b, err := json.Marshal(m.Attributes)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("decoding attributes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Package failure sorts the errors the functions run into into ones worth
+// retrying and ones that will never succeed, and routes each accordingly:
+// transient errors go back to Pub/Sub for redelivery, permanent ones are
+// logged and parked on a dead-letter topic so the event is acknowledged.
+package failure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"example.com/shared/command"
+	"example.com/shared/jira"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Class says what should happen to the event an error came from.
+type Class int
+
+const (
+	// Transient errors are returned so Pub/Sub redelivers the event.
+	Transient Class = iota
+	// Permanent errors are dead-lettered and the event acknowledged.
+	Permanent
+)
+
+func (c Class) String() string {
+	if c == Permanent {
+		return "permanent"
+	}
+	return "transient"
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// NewPermanent marks err as permanent whatever its cause, e.g. for messages
+// that can't be decoded.
+func NewPermanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Classify works out whether err is worth retrying. gRPC status codes and
+// Jira HTTP statuses are recognised anywhere in the wrap chain; anything else
+// is assumed to be transient, since it's usually the network.
+func Classify(err error) Class {
+	var perm *permanentError
+	if errors.As(err, &perm) {
+		return Permanent
+	}
+	var invalid *command.ValidationError
+	if errors.As(err, &invalid) {
+		return Permanent
+	}
+	var apiErr *jira.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500 {
+			return Transient
+		}
+		return Permanent
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return Transient
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.InvalidArgument, codes.NotFound, codes.PermissionDenied,
+			codes.AlreadyExists, codes.FailedPrecondition, codes.OutOfRange,
+			codes.Unimplemented, codes.Unauthenticated:
+			return Permanent
+		}
+	}
+	return Transient
+}
+
+// logger writes JSON lines Cloud Logging turns into structured entries.
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		switch a.Key {
+		case slog.LevelKey:
+			a.Key = "severity"
+		case slog.MessageKey:
+			a.Key = "message"
+		}
+		return a
+	},
+}))
+
+// Handler decides what a Pub/Sub triggered function returns when it fails.
+type Handler struct {
+	// Function names the function in logs and dead-lettered messages
+	Function string
+	// DeadLetter receives the original data of permanently failed events
+	DeadLetter *command.Publisher
+}
+
+// NewHandler returns a Handler dead-lettering to topicID.
+func NewHandler(function, projectID, topicID string) *Handler {
+	return &Handler{Function: function, DeadLetter: command.NewPublisher(projectID, topicID)}
+}
+
+// Handle logs err and returns what the function should return for the event
+// carrying data: err itself if it's transient, nil once a permanent failure
+// has been dead-lettered. attrs are added to the log entry.
+func (h *Handler) Handle(ctx context.Context, data []byte, err error, attrs ...any) error {
+	if err == nil {
+		return nil
+	}
+	class := Classify(err)
+	args := append([]any{"function", h.Function, "class", class.String(), "error", err.Error()}, attrs...)
+	if s, ok := status.FromError(err); ok && s.Code() != codes.Unknown {
+		args = append(args, "code", s.Code().String())
+	}
+	if class == Transient {
+		logger.WarnContext(ctx, "Transient failure, retrying", args...)
+		return err
+	}
+	logger.ErrorContext(ctx, "Permanent failure, dead-lettering", args...)
+	if _, dlErr := h.DeadLetter.DeadLetter(ctx, data, h.Function, err.Error()); dlErr != nil {
+		// Retry so the event isn't lost
+		return fmt.Errorf("failed to dead-letter after %v: %w", err, dlErr)
+	}
+	return nil
+}
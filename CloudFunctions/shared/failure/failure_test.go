@@ -0,0 +1,117 @@
+package failure_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"example.com/shared/command"
+	"example.com/shared/failure"
+	"example.com/shared/jira"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassify(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		err  error
+		want failure.Class
+	}{
+		{"unknown error", errors.New("connection reset"), failure.Transient},
+		{"permanent", failure.NewPermanent(errors.New("bad message")), failure.Permanent},
+		{"wrapped permanent", fmt.Errorf("handling: %w", failure.NewPermanent(errors.New("bad message"))), failure.Permanent},
+		{"permanent whatever the cause", failure.NewPermanent(status.Error(codes.Unavailable, "down")), failure.Permanent},
+		{"validation error", fmt.Errorf("parsing: %w", &command.ValidationError{Reason: "missing issuer"}), failure.Permanent},
+		{"jira bad request", &jira.APIError{StatusCode: http.StatusBadRequest}, failure.Permanent},
+		{"jira not found", fmt.Errorf("commenting: %w", &jira.APIError{StatusCode: http.StatusNotFound}), failure.Permanent},
+		{"jira rate limit", &jira.APIError{StatusCode: http.StatusTooManyRequests}, failure.Transient},
+		{"jira server error", &jira.APIError{StatusCode: http.StatusInternalServerError}, failure.Transient},
+		{"jira unavailable", &jira.APIError{StatusCode: http.StatusServiceUnavailable}, failure.Transient},
+		{"deadline", fmt.Errorf("calling: %w", context.DeadlineExceeded), failure.Transient},
+		{"canceled", context.Canceled, failure.Transient},
+		{"grpc invalid argument", status.Error(codes.InvalidArgument, "bad"), failure.Permanent},
+		{"grpc not found", fmt.Errorf("getting release: %w", status.Error(codes.NotFound, "gone")), failure.Permanent},
+		{"grpc permission denied", status.Error(codes.PermissionDenied, "no"), failure.Permanent},
+		{"grpc already exists", status.Error(codes.AlreadyExists, "there"), failure.Permanent},
+		{"grpc failed precondition", status.Error(codes.FailedPrecondition, "index"), failure.Permanent},
+		{"grpc out of range", status.Error(codes.OutOfRange, "range"), failure.Permanent},
+		{"grpc unimplemented", status.Error(codes.Unimplemented, "no such method"), failure.Permanent},
+		{"grpc unauthenticated", status.Error(codes.Unauthenticated, "who"), failure.Permanent},
+		{"grpc unavailable", status.Error(codes.Unavailable, "down"), failure.Transient},
+		{"grpc resource exhausted", status.Error(codes.ResourceExhausted, "quota"), failure.Transient},
+		{"grpc aborted", status.Error(codes.Aborted, "contention"), failure.Transient},
+		{"grpc internal", status.Error(codes.Internal, "oops"), failure.Transient},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := failure.Classify(tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+
+	if err := failure.NewPermanent(nil); err != nil {
+		t.Errorf("NewPermanent(nil) = %v, want nil", err)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	t.Setenv("PUBSUB_EMULATOR_HOST", srv.Addr)
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.CreateTopic(ctx, "dead-letters"); err != nil {
+		t.Fatal(err)
+	}
+
+	transient := errors.New("connection reset")
+	for _, tt := range []struct {
+		name string
+		// topic is the dead-letter topic, only "dead-letters" exists
+		topic          string
+		err            error
+		wantErr        error
+		wantRetry      bool
+		wantDeadLetter bool
+	}{
+		{name: "success", topic: "dead-letters"},
+		{name: "transient is returned for redelivery", topic: "dead-letters", err: transient, wantErr: transient, wantRetry: true},
+		{name: "permanent is dead-lettered and acked", topic: "dead-letters", err: failure.NewPermanent(errors.New("bad message")), wantDeadLetter: true},
+		{name: "permanent is retried when dead-lettering fails", topic: "missing", err: failure.NewPermanent(errors.New("bad message")), wantRetry: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.ClearMessages()
+			h := failure.NewHandler("fn", "p", tt.topic)
+			got := h.Handle(ctx, []byte("data"), tt.err, "messageId", "1")
+			if tt.wantErr != nil && !errors.Is(got, tt.wantErr) {
+				t.Errorf("Handle = %v, want %v", got, tt.wantErr)
+			}
+			if (got != nil) != tt.wantRetry {
+				t.Errorf("Handle = %v, want an error: %v", got, tt.wantRetry)
+			}
+			msgs := srv.Messages()
+			if !tt.wantDeadLetter {
+				if len(msgs) != 0 {
+					t.Errorf("dead-lettered %d message(s), want none", len(msgs))
+				}
+				return
+			}
+			if len(msgs) != 1 {
+				t.Fatalf("dead-lettered %d message(s), want 1", len(msgs))
+			}
+			m := msgs[0]
+			if string(m.Data) != "data" || m.Attributes["function"] != "fn" || m.Attributes["reason"] != "bad message" {
+				t.Errorf("dead letter = %q %v, want the original data from fn with the reason", m.Data, m.Attributes)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+// Package freeze implements deployment freezes: a change calendar of
+// recurring and one-off windows during which automation mustn't promote or
+// approve rollouts, freezes lifted early by hand, and the actions deferred
+// until a freeze ends.
+package freeze
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"example.com/shared/failure"
+	// Function runtimes don't promise a zoneinfo database
+	_ "time/tzdata"
+)
+
+// Mode is what happens to an action that runs into a freeze.
+type Mode string
+
+const (
+	// Defer holds the action until the freeze ends or is lifted
+	Defer Mode = "defer"
+	// Reject refuses the action, e.g. rejects the rollout
+	Reject Mode = "reject"
+)
+
+// maxDuration bounds recurring freezes, which are found by walking back
+// minute by minute from now.
+const maxDuration = 31 * 24 * time.Hour
+
+// Calendar lists the freezes, e.g.
+//
+//	{
+//	  "freezes": [
+//	    {"name": "weekend", "cron": "0 18 * * FRI", "duration": "62h",
+//	     "timeZone": "Europe/London", "targets": ["prod*"],
+//	     "reason": "No production changes over the weekend"},
+//	    {"name": "black-friday", "start": "2026-11-27T00:00:00Z", "end": "2026-11-30T08:00:00Z",
+//	     "reason": "Peak trading", "mode": "reject"}
+//	  ]
+//	}
+type Calendar struct {
+	Freezes []Freeze `json:"freezes"`
+}
+
+// Freeze is either recurring, starting whenever Cron fires and lasting
+// Duration, or a one-off from Start to End.
+type Freeze struct {
+	// Name identifies the freeze, e.g. to lift it
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+	// Targets the freeze applies to, as path.Match patterns; all when empty
+	Targets []string `json:"targets,omitempty"`
+	// Mode is defer when empty
+	Mode Mode `json:"mode,omitempty"`
+
+	Cron     string `json:"cron,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	// TimeZone Cron is read in, an IANA name, UTC when empty
+	TimeZone string `json:"timeZone,omitempty"`
+
+	Start time.Time `json:"start,omitempty"`
+	End   time.Time `json:"end,omitempty"`
+}
+
+// Occurrence is one period a Freeze is in force.
+type Occurrence struct {
+	Freeze Freeze
+	Start  time.Time
+	End    time.Time
+}
+
+// Parse decodes and validates a calendar.
+func Parse(data []byte) (*Calendar, error) {
+	var cal Calendar
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// A misspelt field would otherwise silently never freeze anything
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cal); err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("error parsing freeze calendar: %w", err))
+	}
+	if err := cal.Validate(); err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("invalid freeze calendar: %w", err))
+	}
+	return &cal, nil
+}
+
+// Validate checks every freeze can be evaluated, so a bad calendar is
+// refused when it's loaded rather than when a rollout needs it.
+func (cal *Calendar) Validate() error {
+	names := map[string]bool{}
+	for i, f := range cal.Freezes {
+		if f.Name == "" {
+			return fmt.Errorf("freeze %d has no name", i)
+		}
+		if names[f.Name] {
+			return fmt.Errorf("freeze %q is defined twice", f.Name)
+		}
+		names[f.Name] = true
+		if f.Mode != "" && f.Mode != Defer && f.Mode != Reject {
+			return fmt.Errorf("freeze %q: mode must be defer or reject, got %q", f.Name, f.Mode)
+		}
+		for _, pattern := range f.Targets {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("freeze %q: bad pattern %q: %w", f.Name, pattern, err)
+			}
+		}
+		if _, _, err := f.recurrence(); err != nil {
+			return fmt.Errorf("freeze %q: %w", f.Name, err)
+		}
+		if f.Cron == "" && (f.Start.IsZero() || !f.End.After(f.Start)) {
+			return fmt.Errorf("freeze %q needs a cron and duration, or a start before its end", f.Name)
+		}
+	}
+	return nil
+}
+
+// Active returns the occurrences of freezes applying to target that are in
+// force at now. It doesn't do any I/O, so it knows nothing of lifts; see
+// Check for that.
+func (cal *Calendar) Active(target string, now time.Time) []Occurrence {
+	var active []Occurrence
+	for _, f := range cal.Freezes {
+		if !f.appliesTo(target) {
+			continue
+		}
+		if o, ok := f.occurrenceAt(now); ok {
+			active = append(active, o)
+		}
+	}
+	return active
+}
+
+// ModeOrDefault returns the freeze's Mode, defer when unset.
+func (f Freeze) ModeOrDefault() Mode {
+	if f.Mode == "" {
+		return Defer
+	}
+	return f.Mode
+}
+
+func (f Freeze) appliesTo(target string) bool {
+	if len(f.Targets) == 0 {
+		return true
+	}
+	for _, pattern := range f.Targets {
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Freeze) occurrenceAt(now time.Time) (Occurrence, bool) {
+	if f.Cron == "" {
+		if !now.Before(f.Start) && now.Before(f.End) {
+			return Occurrence{Freeze: f, Start: f.Start, End: f.End}, true
+		}
+		return Occurrence{}, false
+	}
+	s, d, err := f.recurrence()
+	if err != nil {
+		// Validate refuses these
+		return Occurrence{}, false
+	}
+	loc := time.UTC
+	if f.TimeZone != "" {
+		loc, _ = time.LoadLocation(f.TimeZone)
+	}
+	start, ok := s.lastFire(now.In(loc), d)
+	if !ok {
+		return Occurrence{}, false
+	}
+	return Occurrence{Freeze: f, Start: start, End: start.Add(d)}, true
+}
+
+// recurrence parses a recurring freeze's schedule and duration. One-off
+// freezes return a nil schedule.
+func (f Freeze) recurrence() (*schedule, time.Duration, error) {
+	if f.Cron == "" {
+		if f.Duration != "" || f.TimeZone != "" {
+			return nil, 0, fmt.Errorf("duration and timeZone only apply with cron")
+		}
+		return nil, 0, nil
+	}
+	if !f.Start.IsZero() || !f.End.IsZero() {
+		return nil, 0, fmt.Errorf("start and end don't apply with cron")
+	}
+	s, err := parseCron(f.Cron)
+	if err != nil {
+		return nil, 0, err
+	}
+	d, err := time.ParseDuration(f.Duration)
+	if err != nil {
+		return nil, 0, fmt.Errorf("duration: %w", err)
+	}
+	if d <= 0 || d > maxDuration {
+		return nil, 0, fmt.Errorf("duration must be positive and at most %s", maxDuration)
+	}
+	if f.TimeZone != "" {
+		if _, err := time.LoadLocation(f.TimeZone); err != nil {
+			return nil, 0, fmt.Errorf("time zone: %w", err)
+		}
+	}
+	return s, d, nil
+}
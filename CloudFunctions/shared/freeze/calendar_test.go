@@ -0,0 +1,203 @@
+package freeze
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/shared/failure"
+)
+
+const testCalendar = `{"freezes": [
+	{"name": "weekend", "cron": "0 18 * * FRI", "duration": "62h", "timeZone": "Europe/London",
+	 "targets": ["prod*"], "reason": "No production changes over the weekend"},
+	{"name": "black-friday", "start": "2026-11-27T00:00:00Z", "end": "2026-11-30T08:00:00Z",
+	 "reason": "Peak trading", "mode": "reject"},
+	{"name": "nightly", "cron": "0 2 * * *", "duration": "1h", "targets": ["staging", "prod-eu"],
+	 "reason": "Backups"}
+]}`
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		freeze string
+		// in the error, none when empty
+		wantErr string
+	}{
+		{name: "recurring", freeze: `{"name": "f", "cron": "0 18 * * FRI", "duration": "62h", "timeZone": "Europe/London"}`},
+		{name: "one-off", freeze: `{"name": "f", "start": "2026-11-27T00:00:00Z", "end": "2026-11-30T08:00:00Z", "mode": "reject"}`},
+		{name: "longest duration", freeze: `{"name": "f", "cron": "0 0 1 * *", "duration": "744h"}`},
+		{name: "no name", freeze: `{"cron": "0 18 * * FRI", "duration": "1h"}`, wantErr: "has no name"},
+		{name: "unknown mode", freeze: `{"name": "f", "cron": "0 18 * * FRI", "duration": "1h", "mode": "halt"}`, wantErr: "mode must be"},
+		{name: "bad pattern", freeze: `{"name": "f", "cron": "0 18 * * FRI", "duration": "1h", "targets": ["prod["]}`, wantErr: "bad pattern"},
+		{name: "bad cron", freeze: `{"name": "f", "cron": "0 18 * FRI", "duration": "1h"}`, wantErr: "5 fields"},
+		{name: "no duration", freeze: `{"name": "f", "cron": "0 18 * * FRI"}`, wantErr: "duration"},
+		{name: "negative duration", freeze: `{"name": "f", "cron": "0 18 * * FRI", "duration": "-1h"}`, wantErr: "positive"},
+		{name: "duration too long", freeze: `{"name": "f", "cron": "0 18 * * FRI", "duration": "745h"}`, wantErr: "at most"},
+		{name: "unknown time zone", freeze: `{"name": "f", "cron": "0 18 * * FRI", "duration": "1h", "timeZone": "Mars/Olympus"}`, wantErr: "time zone"},
+		{name: "cron and start", freeze: `{"name": "f", "cron": "0 18 * * FRI", "duration": "1h", "start": "2026-11-27T00:00:00Z"}`, wantErr: "don't apply with cron"},
+		{name: "duration without cron", freeze: `{"name": "f", "start": "2026-11-27T00:00:00Z", "end": "2026-11-30T08:00:00Z", "duration": "1h"}`, wantErr: "only apply with cron"},
+		{name: "time zone without cron", freeze: `{"name": "f", "start": "2026-11-27T00:00:00Z", "end": "2026-11-30T08:00:00Z", "timeZone": "UTC"}`, wantErr: "only apply with cron"},
+		{name: "end before start", freeze: `{"name": "f", "start": "2026-11-30T00:00:00Z", "end": "2026-11-27T00:00:00Z"}`, wantErr: "start before its end"},
+		{name: "empty one-off", freeze: `{"name": "f", "start": "2026-11-27T00:00:00Z", "end": "2026-11-27T00:00:00Z"}`, wantErr: "start before its end"},
+		{name: "no end", freeze: `{"name": "f", "start": "2026-11-27T00:00:00Z"}`, wantErr: "start before its end"},
+		{name: "neither", freeze: `{"name": "f"}`, wantErr: "needs a cron"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(`{"freezes": [` + tt.freeze + `]}`))
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Parse = %v, want an error about %q", err, tt.wantErr)
+			}
+			if err != nil && failure.Classify(err) != failure.Permanent {
+				t.Errorf("Parse error %v isn't permanent", err)
+			}
+		})
+	}
+
+	duplicate := `{"freezes": [{"name": "f", "cron": "0 18 * * FRI", "duration": "1h"}, {"name": "f", "cron": "0 9 * * MON", "duration": "1h"}]}`
+	if _, err := Parse([]byte(duplicate)); err == nil || !strings.Contains(err.Error(), "defined twice") {
+		t.Errorf("Parse of a freeze defined twice = %v, want an error", err)
+	}
+	// A misspelt field would otherwise never freeze anything
+	if _, err := Parse([]byte(`{"freezes": [{"name": "f", "crons": "0 18 * * FRI", "duration": "1h"}]}`)); err == nil {
+		t.Error("Parse of an unknown field succeeded, want an error")
+	}
+	if cal, err := Parse([]byte(`{}`)); err != nil || len(cal.Freezes) != 0 {
+		t.Errorf("Parse of no freezes = %+v, %v", cal, err)
+	}
+}
+
+func TestActive(t *testing.T) {
+	cal, err := Parse([]byte(testCalendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	london, _ := time.LoadLocation("Europe/London")
+	utc := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		target string
+		now    time.Time
+		// names of the freezes in force
+		want []string
+		// start of the first occurrence, in UTC
+		start time.Time
+	}{
+		{name: "weekday", target: "prod", now: utc(10, 14, 12, 0)},
+		// 18:00 in London is 17:00 UTC in summer time
+		{name: "weekend starts at local time", target: "prod", now: utc(10, 16, 17, 0), want: []string{"weekend"}, start: utc(10, 16, 17, 0)},
+		{name: "before the weekend", target: "prod", now: utc(10, 16, 16, 59)},
+		{name: "weekend", target: "prod-us", now: utc(10, 18, 12, 0), want: []string{"weekend"}, start: utc(10, 16, 17, 0)},
+		{name: "weekend is over", target: "prod", now: utc(10, 19, 7, 0)},
+		{name: "weekend given local time", target: "prod", now: utc(10, 18, 12, 0).In(london), want: []string{"weekend"}, start: utc(10, 16, 17, 0)},
+		{name: "target not frozen", target: "staging", now: utc(10, 18, 12, 0)},
+		{name: "freeze for every target", target: "staging", now: utc(11, 28, 12, 0), want: []string{"black-friday"}, start: utc(11, 27, 0, 0)},
+		{name: "one-off starts", target: "dev", now: utc(11, 27, 0, 0), want: []string{"black-friday"}, start: utc(11, 27, 0, 0)},
+		{name: "one-off ended", target: "dev", now: utc(11, 30, 8, 0)},
+		{name: "overlapping freezes", target: "prod-eu", now: utc(11, 28, 2, 30), want: []string{"weekend", "black-friday", "nightly"}, start: utc(11, 27, 18, 0)},
+		{name: "exact target", target: "staging", now: utc(10, 14, 2, 59), want: []string{"nightly"}, start: utc(10, 14, 2, 0)},
+		{name: "pattern isn't a prefix", target: "staging-2", now: utc(10, 14, 2, 30)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			active := cal.Active(tt.target, tt.now)
+			var got []string
+			for _, o := range active {
+				got = append(got, o.Freeze.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Active(%s, %s) = %v, want %v", tt.target, tt.now.Format(time.RFC3339), got, tt.want)
+			}
+			if len(active) == 0 {
+				return
+			}
+			o := active[0]
+			if !o.Start.Equal(tt.start) || !o.End.After(tt.now) {
+				t.Errorf("occurrence %s to %s, want it from %s and still in force", o.Start, o.End, tt.start)
+			}
+		})
+	}
+
+	o := cal.Active("prod", utc(10, 18, 12, 0))[0]
+	if want := utc(10, 19, 7, 0); !o.End.Equal(want) {
+		t.Errorf("weekend ends %s, want %s", o.End, want)
+	}
+	if o.Freeze.ModeOrDefault() != Defer || cal.Active("dev", utc(11, 28, 0, 0))[0].Freeze.ModeOrDefault() != Reject {
+		t.Error("weekend should defer and black-friday reject")
+	}
+	if got := (&Calendar{}).Active("prod", utc(10, 18, 12, 0)); len(got) != 0 {
+		t.Errorf("empty calendar has %v in force", got)
+	}
+}
+
+func TestLifted(t *testing.T) {
+	start := time.Date(2026, 10, 16, 17, 0, 0, 0, time.UTC)
+	o := Occurrence{Freeze: Freeze{Name: "weekend"}, Start: start, End: start.Add(62 * time.Hour)}
+	for _, tt := range []struct {
+		name  string
+		lifts []Lift
+		want  bool
+	}{
+		{name: "no lifts"},
+		{name: "during", lifts: []Lift{{Freeze: "weekend", At: start.Add(time.Hour)}}, want: true},
+		{name: "as it started", lifts: []Lift{{Freeze: "weekend", At: start}}, want: true},
+		{name: "before", lifts: []Lift{{Freeze: "weekend", At: start.Add(-time.Minute)}}},
+		// A lift only ends the occurrence it was made in, not next weekend
+		{name: "previous occurrence", lifts: []Lift{{Freeze: "weekend", At: start.Add(-6 * 24 * time.Hour)}}},
+		{name: "as it ended", lifts: []Lift{{Freeze: "weekend", At: o.End}}},
+		{name: "another freeze", lifts: []Lift{{Freeze: "black-friday", At: start.Add(time.Hour)}}},
+		{name: "one of several", lifts: []Lift{
+			{Freeze: "weekend", At: start.Add(-time.Hour)},
+			{Freeze: "weekend", At: start.Add(2 * time.Hour)},
+		}, want: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Lifted(o, tt.lifts); got != tt.want {
+				t.Errorf("Lifted = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{Inline: testCalendar, Store: "memory"}
+	weekend := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	o, err := cfg.Check(ctx, "prod", weekend)
+	if err != nil || o == nil || o.Freeze.Name != "weekend" {
+		t.Fatalf("Check = %+v, %v, want the weekend", o, err)
+	}
+	if o, err := cfg.Check(ctx, "staging", weekend); err != nil || o != nil {
+		t.Errorf("Check of a target not frozen = %+v, %v", o, err)
+	}
+
+	// Once lifted the weekend doesn't hold anything, until the next one
+	if err := memStore.Lift(ctx, Lift{Freeze: "weekend", By: "alice@example.com", At: weekend}); err != nil {
+		t.Fatal(err)
+	}
+	if o, err := cfg.Check(ctx, "prod", weekend.Add(time.Hour)); err != nil || o != nil {
+		t.Errorf("Check once lifted = %+v, %v", o, err)
+	}
+	if o, err := cfg.Check(ctx, "prod", weekend.Add(7*24*time.Hour)); err != nil || o == nil {
+		t.Errorf("Check next weekend = %+v, %v, want the weekend", o, err)
+	}
+	// Other freezes still hold
+	if o, err := cfg.Check(ctx, "prod-eu", time.Date(2026, 10, 20, 2, 30, 0, 0, time.UTC)); err != nil || o == nil || o.Freeze.Name != "nightly" {
+		t.Errorf("Check of nightly = %+v, %v", o, err)
+	}
+
+	if _, err := (Config{Inline: `{"freezes": [{"name": "f"}]}`}).Check(ctx, "prod", weekend); failure.Classify(err) != failure.Permanent {
+		t.Errorf("Check with a bad calendar = %v, want a permanent error", err)
+	}
+	if o, err := (Config{Store: "nowhere"}).Check(ctx, "prod", weekend); err != nil || o != nil {
+		t.Errorf("Check with no calendar = %+v, %v, want nothing frozen without opening the store", o, err)
+	}
+}
@@ -0,0 +1,82 @@
+package freeze
+
+import (
+	"context"
+	"time"
+
+	"example.com/shared/configfile"
+)
+
+// Config says where a function finds the freeze calendar and the store of
+// lifts and deferrals. Functions fill it from their own environment.
+type Config struct {
+	// Inline calendar JSON, takes precedence over URI
+	Inline string
+	// URI is gs://bucket/object or a local file, re-read at most every
+	// Refresh so calendar changes apply without a redeploy
+	URI     string
+	Refresh time.Duration
+
+	// Store is "firestore" or "memory"
+	Store      string
+	ProjectID  string
+	Database   string
+	Collection string
+}
+
+var calendarCache = &configfile.Cache[*Calendar]{Name: "freeze calendar", Parse: Parse}
+
+// Calendar returns the configured calendar, an empty one when there's none.
+func (c Config) Calendar(ctx context.Context) (*Calendar, error) {
+	if c.Inline != "" {
+		return Parse([]byte(c.Inline))
+	}
+	if c.URI == "" {
+		return &Calendar{}, nil
+	}
+	return calendarCache.Get(ctx, c.URI, c.Refresh)
+}
+
+// OpenStore returns the configured Store.
+func (c Config) OpenStore(ctx context.Context) (Store, error) {
+	return NewStore(ctx, c.Store, c.ProjectID, c.Database, c.Collection)
+}
+
+// Check returns the occurrence of a freeze holding target at now, or nil
+// when none does. Occurrences lifted early don't count. The store is only
+// opened when the calendar has a freeze in force.
+func (c Config) Check(ctx context.Context, target string, now time.Time) (*Occurrence, error) {
+	cal, err := c.Calendar(ctx)
+	if err != nil {
+		return nil, err
+	}
+	active := cal.Active(target, now)
+	if len(active) == 0 {
+		return nil, nil
+	}
+	store, err := c.OpenStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	for _, o := range active {
+		lifts, err := store.Lifts(ctx, o.Freeze.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !Lifted(o, lifts) {
+			return &o, nil
+		}
+	}
+	return nil, nil
+}
+
+// Lifted says whether any of lifts was recorded while o was in force.
+func Lifted(o Occurrence, lifts []Lift) bool {
+	for _, l := range lifts {
+		if l.Freeze == o.Freeze.Name && !l.At.Before(o.Start) && l.At.Before(o.End) {
+			return true
+		}
+	}
+	return false
+}
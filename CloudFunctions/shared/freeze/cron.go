@@ -0,0 +1,136 @@
+package freeze
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is a parsed five field cron expression:
+// minute hour day-of-month month day-of-week.
+type schedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	// Like cron, when both day fields are restricted either may match
+	domAny, dowAny bool
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dayNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// parseCron parses expressions such as "0 18 * * FRI" or "*/30 9-17 1,15 * *".
+func parseCron(expr string) (*schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q must have 5 fields, got %d", expr, len(fields))
+	}
+	var s schedule
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("cron %q minute: %w", expr, err)
+	}
+	if s.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("cron %q hour: %w", expr, err)
+	}
+	if s.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("cron %q day of month: %w", expr, err)
+	}
+	if s.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("cron %q month: %w", expr, err)
+	}
+	// 7 is Sunday too
+	if s.dow, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("cron %q day of week: %w", expr, err)
+	}
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return &s, nil
+}
+
+// parseField parses a comma separated list of *, n, a-b, each optionally
+// followed by /step.
+func parseField(field string, lo, hi int, names map[string]int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("bad step %q", stepStr)
+			}
+			step = n
+		}
+		start, end := lo, hi
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if start, err = parseValue(from, lo, hi, names); err != nil {
+				return nil, err
+			}
+			end = start
+			if isRange {
+				if end, err = parseValue(to, lo, hi, names); err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				// "5/15" means from 5 to the end in steps of 15
+				end = hi
+			}
+			if end < start {
+				return nil, fmt.Errorf("bad range %q", rng)
+			}
+		}
+		for v := start; v <= end; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func parseValue(s string, lo, hi int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < lo || v > hi {
+		return 0, fmt.Errorf("%q is not between %d and %d", s, lo, hi)
+	}
+	return v, nil
+}
+
+// matches says whether the schedule fires at t's minute, in t's location.
+func (s *schedule) matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+	dom, dow := s.dom[t.Day()], s.dow[int(t.Weekday())]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// lastFire returns the latest time at or before t, and after t-within, the
+// schedule fired at.
+func (s *schedule) lastFire(t time.Time, within time.Duration) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	for d := time.Duration(0); d < within; d += time.Minute {
+		if m := t.Add(-d); s.matches(m) {
+			return m, true
+		}
+	}
+	return time.Time{}, false
+}
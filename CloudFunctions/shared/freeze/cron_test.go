@@ -0,0 +1,183 @@
+package freeze
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// values returns the members of a parsed field in order.
+func values(set map[int]bool) []int {
+	var out []int
+	for v, ok := range set {
+		if ok {
+			out = append(out, v)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+func TestParseCron(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		// field is the index of the field checked: minute, hour, day of
+		// month, month or day of week
+		field int
+		want  []int
+	}{
+		{expr: "0 18 * * FRI", field: 4, want: []int{5}},
+		{expr: "0 18 * * fri", field: 4, want: []int{5}},
+		{expr: "0 0 * * MON-WED", field: 4, want: []int{1, 2, 3}},
+		{expr: "0 0 * * mon,Thu", field: 4, want: []int{1, 4}},
+		{expr: "0 0 * * 7", field: 4, want: []int{0, 7}},
+		{expr: "0 0 * * 5-7", field: 4, want: []int{0, 5, 6, 7}},
+		{expr: "0 0 * * SUN", field: 4, want: []int{0}},
+		{expr: "0 0 * JAN-MAR *", field: 3, want: []int{1, 2, 3}},
+		{expr: "0 0 * nov,Dec *", field: 3, want: []int{11, 12}},
+		{expr: "0 0 1,15 * *", field: 2, want: []int{1, 15}},
+		{expr: "0 0 */10 * *", field: 2, want: []int{1, 11, 21, 31}},
+		{expr: "*/15 * * * *", field: 0, want: []int{0, 15, 30, 45}},
+		{expr: "5/20 * * * *", field: 0, want: []int{5, 25, 45}},
+		{expr: "0 1-10/3 * * *", field: 1, want: []int{1, 4, 7, 10}},
+		{expr: "0 9-11,22 * * *", field: 1, want: []int{9, 10, 11, 22}},
+		{expr: "  30   6  * * *  ", field: 0, want: []int{30}},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron: %v", err)
+			}
+			fields := []map[int]bool{s.minute, s.hour, s.dom, s.month, s.dow}
+			if got := values(fields[tt.field]); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("field %d = %v, want %v", tt.field, got, tt.want)
+			}
+		})
+	}
+
+	for _, expr := range []string{
+		"",
+		"0 18 * *",
+		"0 18 * * FRI *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * FRI *",
+		"* * * * JAN",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"10-5 * * * *",
+		"1-x * * * *",
+		"a * * * *",
+		"1,,2 * * * *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	// 2026-10-16 is a Friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+	for _, tt := range []struct {
+		name string
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{name: "fires", expr: "0 18 * * FRI", t: at(16, 18, 0), want: true},
+		{name: "seconds don't matter", expr: "0 18 * * FRI", t: at(16, 18, 0).Add(59 * time.Second), want: true},
+		{name: "next minute", expr: "0 18 * * FRI", t: at(16, 18, 1)},
+		{name: "other hour", expr: "0 18 * * FRI", t: at(16, 17, 0)},
+		{name: "other day", expr: "0 18 * * FRI", t: at(15, 18, 0)},
+		{name: "7 is Sunday", expr: "0 0 * * 7", t: at(18, 0, 0), want: true},
+		{name: "0 is Sunday", expr: "0 0 * * 0", t: at(18, 0, 0), want: true},
+		{name: "7 isn't Saturday", expr: "0 0 * * 7", t: at(17, 0, 0)},
+		{name: "step", expr: "*/20 9-17 * * *", t: at(16, 9, 40), want: true},
+		{name: "off step", expr: "*/20 9-17 * * *", t: at(16, 9, 30)},
+		{name: "month", expr: "0 0 * OCT *", t: at(1, 0, 0), want: true},
+		{name: "other month", expr: "0 0 * NOV *", t: at(1, 0, 0)},
+		// When both day fields are restricted either will do, like cron
+		{name: "day of month or week: day of month", expr: "0 0 1 * MON", t: at(1, 0, 0), want: true},
+		{name: "day of month or week: day of week", expr: "0 0 1 * MON", t: at(5, 0, 0), want: true},
+		{name: "day of month or week: neither", expr: "0 0 1 * MON", t: at(6, 0, 0)},
+		// Otherwise the restricted one decides
+		{name: "day of month only", expr: "0 0 1 * *", t: at(5, 0, 0)},
+		{name: "day of week only", expr: "0 0 * * MON", t: at(1, 0, 0)},
+		{name: "day of month restricted by step", expr: "0 0 */2 * MON", t: at(5, 0, 0), want: true},
+		{name: "every minute", expr: "* * * * *", t: at(17, 13, 37), want: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.matches(tt.t); got != tt.want {
+				t.Errorf("%q matches %s = %t, want %t", tt.expr, tt.t.Format(time.RFC1123), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLastFire(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		expr   string
+		t      time.Time
+		within time.Duration
+		// zero when the schedule didn't fire within
+		want time.Time
+	}{
+		{name: "at the fire", expr: "0 18 * * FRI", t: utc(10, 16, 18, 0), within: 62 * time.Hour, want: utc(10, 16, 18, 0)},
+		{name: "truncated to the minute", expr: "0 18 * * FRI", t: utc(10, 16, 18, 0).Add(30 * time.Second), within: 62 * time.Hour, want: utc(10, 16, 18, 0)},
+		{name: "just before", expr: "0 18 * * FRI", t: utc(10, 16, 17, 59), within: 62 * time.Hour},
+		{name: "days later", expr: "0 18 * * FRI", t: utc(10, 18, 10, 0), within: 62 * time.Hour, want: utc(10, 16, 18, 0)},
+		{name: "last minute of the window", expr: "0 18 * * FRI", t: utc(10, 19, 7, 59), within: 62 * time.Hour, want: utc(10, 16, 18, 0)},
+		{name: "window over", expr: "0 18 * * FRI", t: utc(10, 19, 8, 0), within: 62 * time.Hour},
+		{name: "latest of several", expr: "0 */6 * * *", t: utc(10, 17, 5, 59), within: 24 * time.Hour, want: utc(10, 17, 0, 0)},
+		{name: "across midnight", expr: "0 22 * * *", t: utc(10, 17, 1, 30), within: 8 * time.Hour, want: utc(10, 16, 22, 0)},
+		{name: "across months", expr: "0 12 31 * *", t: utc(11, 1, 6, 0), within: 24 * time.Hour, want: utc(10, 31, 12, 0)},
+		{name: "across the new year", expr: "0 20 31 DEC *", t: time.Date(2027, 1, 1, 2, 0, 0, 0, time.UTC), within: 8 * time.Hour, want: utc(12, 31, 20, 0)},
+		// 18:00 in London is 17:00 UTC in summer time
+		{name: "in summer time", expr: "0 18 * * FRI", t: utc(10, 16, 17, 30).In(london), within: 62 * time.Hour, want: utc(10, 16, 17, 0)},
+		{name: "UTC hour before the local fire", expr: "0 18 * * FRI", t: utc(10, 16, 17, 59).In(london), within: time.Hour, want: utc(10, 16, 17, 0)},
+		{name: "in winter time", expr: "0 18 * * FRI", t: utc(11, 6, 18, 30).In(london), within: 62 * time.Hour, want: utc(11, 6, 18, 0)},
+		// Summer time ends on Sunday 2026-10-25; the window is still 62 hours
+		{name: "across the end of summer time", expr: "0 18 * * FRI", t: utc(10, 26, 6, 59).In(london), within: 62 * time.Hour, want: utc(10, 23, 17, 0)},
+		{name: "after the end of summer time", expr: "0 18 * * FRI", t: utc(10, 26, 7, 0).In(london), within: 62 * time.Hour},
+		// Saturday midnight in New York is 04:00 UTC, not UTC midnight
+		{name: "local day boundary", expr: "0 0 * * SAT", t: utc(10, 17, 5, 0).In(newYork), within: 2 * time.Hour, want: utc(10, 17, 4, 0)},
+		{name: "UTC midnight isn't local midnight", expr: "0 0 * * SAT", t: utc(10, 17, 1, 0).In(newYork), within: 2 * time.Hour},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := s.lastFire(tt.t, tt.within)
+			if ok != !tt.want.IsZero() || !got.Equal(tt.want) {
+				t.Errorf("lastFire(%s, %s) = %s, %t, want %s", tt.t.Format(time.RFC3339), tt.within, got, ok, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package freeze
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Document kinds sharing the collection
+const (
+	liftKind     = "lift"
+	deferralKind = "deferral"
+)
+
+// FirestoreStore keeps lifts and deferrals as documents in one collection,
+// told apart by their kind field.
+type FirestoreStore struct {
+	client     *firestore.Client
+	collection string
+}
+
+func newFirestoreStore(ctx context.Context, projectID, database, collection string) (*FirestoreStore, error) {
+	client, err := firestore.NewClientWithDatabase(ctx, projectID, database)
+	if err != nil {
+		return nil, fmt.Errorf("firestore.NewClient: %w", err)
+	}
+	return &FirestoreStore{client: client, collection: collection}, nil
+}
+
+type liftDoc struct {
+	Kind string `firestore:"kind"`
+	Lift
+}
+
+type deferralDoc struct {
+	Kind string `firestore:"kind"`
+	Deferral
+}
+
+func (s *FirestoreStore) Lift(ctx context.Context, l Lift) error {
+	_, err := s.client.Collection(s.collection).Doc("lift-"+uuid.NewString()).Create(ctx, liftDoc{Kind: liftKind, Lift: l})
+	if err != nil {
+		return fmt.Errorf("recording lift of %s: %w", l.Freeze, err)
+	}
+	return nil
+}
+
+func (s *FirestoreStore) Lifts(ctx context.Context, freeze string) ([]Lift, error) {
+	it := s.client.Collection(s.collection).
+		Where("kind", "==", liftKind).
+		Where("freeze", "==", freeze).
+		Documents(ctx)
+	defer it.Stop()
+	var lifts []Lift
+	for {
+		snap, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing lifts of %s: %w", freeze, err)
+		}
+		var doc liftDoc
+		if err := snap.DataTo(&doc); err != nil {
+			return nil, fmt.Errorf("reading lift %s: %w", snap.Ref.ID, err)
+		}
+		lifts = append(lifts, doc.Lift)
+	}
+	return lifts, nil
+}
+
+func (s *FirestoreStore) Defer(ctx context.Context, key string, d Deferral) (bool, error) {
+	d.Pending = true
+	_, err := s.client.Collection(s.collection).Doc("deferral-"+key).Create(ctx, deferralDoc{Kind: deferralKind, Deferral: d})
+	if status.Code(err) == codes.AlreadyExists {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("recording deferral %s: %w", key, err)
+	}
+	return true, nil
+}
+
+func (s *FirestoreStore) Pending(ctx context.Context) (map[string]Deferral, error) {
+	it := s.client.Collection(s.collection).
+		Where("kind", "==", deferralKind).
+		Where("pending", "==", true).
+		Documents(ctx)
+	defer it.Stop()
+	pending := map[string]Deferral{}
+	for {
+		snap, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing deferrals: %w", err)
+		}
+		var doc deferralDoc
+		if err := snap.DataTo(&doc); err != nil {
+			return nil, fmt.Errorf("reading deferral %s: %w", snap.Ref.ID, err)
+		}
+		pending[snap.Ref.ID[len("deferral-"):]] = doc.Deferral
+	}
+	return pending, nil
+}
+
+func (s *FirestoreStore) Resumed(ctx context.Context, key string) error {
+	_, err := s.client.Collection(s.collection).Doc("deferral-"+key).Update(ctx, []firestore.Update{
+		{Path: "pending", Value: false},
+		{Path: "resumed", Value: time.Now().UTC()},
+	})
+	if err != nil {
+		return fmt.Errorf("recording resumption of %s: %w", key, err)
+	}
+	return nil
+}
+
+// Close releases the Firestore client.
+func (s *FirestoreStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,147 @@
+package freeze
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Lift ends the occurrence of a freeze in force when it was recorded.
+type Lift struct {
+	Freeze string    `firestore:"freeze"`
+	Reason string    `firestore:"reason"`
+	By     string    `firestore:"by"`
+	At     time.Time `firestore:"at"`
+}
+
+// Deferral is an action held back by a freeze. Command is the command
+// envelope to publish once Target isn't frozen any more.
+type Deferral struct {
+	Freeze   string    `firestore:"freeze"`
+	Target   string    `firestore:"target"`
+	Action   string    `firestore:"action"`
+	Command  []byte    `firestore:"command"`
+	IssueKey string    `firestore:"issueKey,omitempty"`
+	Until    time.Time `firestore:"until"`
+	Created  time.Time `firestore:"created"`
+	Pending  bool      `firestore:"pending"`
+	Resumed  time.Time `firestore:"resumed,omitempty"`
+}
+
+// Store keeps lifts and deferrals, which have to outlive function instances.
+type Store interface {
+	// Lift records a lift.
+	Lift(ctx context.Context, l Lift) error
+	// Lifts returns the lifts of a freeze.
+	Lifts(ctx context.Context, freeze string) ([]Lift, error)
+	// Defer records d under key unless something already is, and reports
+	// whether it did, so redelivered events are only deferred once.
+	Defer(ctx context.Context, key string, d Deferral) (bool, error)
+	// Pending returns the deferrals not yet resumed, by key.
+	Pending(ctx context.Context) (map[string]Deferral, error)
+	// Resumed marks a deferral as done.
+	Resumed(ctx context.Context, key string) error
+	Close() error
+}
+
+// DeferralKey identifies an action across redeliveries of the event that
+// caused it. It's hashed because the parts may contain characters store
+// keys can't.
+func DeferralKey(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		fmt.Fprintf(h, "%s/", p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NewStore returns the Store selected by kind, "firestore" or "memory".
+func NewStore(ctx context.Context, kind, projectID, database, collection string) (Store, error) {
+	switch kind {
+	case "firestore":
+		return newFirestoreStore(ctx, projectID, database, collection)
+	case "memory":
+		return memStore, nil
+	default:
+		return nil, fmt.Errorf("unknown freeze store %q", kind)
+	}
+}
+
+// memStore lives as long as the function instance, which is enough for
+// tests and local runs but not for production.
+var memStore = NewMemoryStore()
+
+// MemoryStore is an in-process Store.
+type MemoryStore struct {
+	mu        sync.Mutex
+	lifts     []Lift
+	deferrals map[string]Deferral
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{deferrals: map[string]Deferral{}}
+}
+
+func (s *MemoryStore) Lift(ctx context.Context, l Lift) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lifts = append(s.lifts, l)
+	return nil
+}
+
+func (s *MemoryStore) Lifts(ctx context.Context, freeze string) ([]Lift, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var lifts []Lift
+	for _, l := range s.lifts {
+		if l.Freeze == freeze {
+			lifts = append(lifts, l)
+		}
+	}
+	sort.Slice(lifts, func(i, j int) bool { return lifts[i].At.Before(lifts[j].At) })
+	return lifts, nil
+}
+
+func (s *MemoryStore) Defer(ctx context.Context, key string, d Deferral) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.deferrals[key]; ok {
+		return false, nil
+	}
+	d.Pending = true
+	s.deferrals[key] = d
+	return true, nil
+}
+
+func (s *MemoryStore) Pending(ctx context.Context) (map[string]Deferral, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := map[string]Deferral{}
+	for k, d := range s.deferrals {
+		if d.Pending {
+			pending[k] = d
+		}
+	}
+	return pending, nil
+}
+
+func (s *MemoryStore) Resumed(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deferrals[key]
+	if !ok {
+		return fmt.Errorf("no deferral %s", key)
+	}
+	d.Pending = false
+	d.Resumed = time.Now().UTC()
+	s.deferrals[key] = d
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,84 @@
+package history
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FirestoreStore keeps one document per record. Queries filter on
+// equality and order by time, so each combination of filters used needs a
+// composite index (see functions.tf).
+type FirestoreStore struct {
+	client     *firestore.Client
+	collection string
+}
+
+func newFirestoreStore(ctx context.Context, projectID, database, collection string) (*FirestoreStore, error) {
+	client, err := firestore.NewClientWithDatabase(ctx, projectID, database)
+	if err != nil {
+		return nil, fmt.Errorf("firestore.NewClient: %w", err)
+	}
+	return &FirestoreStore{client: client, collection: collection}, nil
+}
+
+func (s *FirestoreStore) Append(ctx context.Context, r Record) error {
+	if r.ID == "" {
+		r.ID = uuid.NewString()
+	}
+	_, err := s.client.Collection(s.collection).Doc(r.ID).Create(ctx, r)
+	if status.Code(err) == codes.AlreadyExists {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("recording %s history: %w", r.Kind, err)
+	}
+	return nil
+}
+
+func (s *FirestoreStore) Query(ctx context.Context, f Filter) ([]Record, error) {
+	q := s.client.Collection(s.collection).Query
+	for field, value := range map[string]string{
+		"pipeline": f.Pipeline,
+		"release":  f.Release,
+		"target":   f.Target,
+		"kind":     string(f.Kind),
+		"state":    f.State,
+	} {
+		if value != "" {
+			q = q.Where(field, "==", value)
+		}
+	}
+	if !f.Since.IsZero() {
+		q = q.Where("at", ">=", f.Since)
+	}
+	it := q.OrderBy("at", firestore.Desc).Limit(f.limit()).Documents(ctx)
+	defer it.Stop()
+	var records []Record
+	for {
+		snap, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("querying history: %w", err)
+		}
+		var r Record
+		if err := snap.DataTo(&r); err != nil {
+			return nil, fmt.Errorf("reading history record %s: %w", snap.Ref.ID, err)
+		}
+		r.ID = snap.Ref.ID
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// Close releases the Firestore client.
+func (s *FirestoreStore) Close() error {
+	return s.client.Close()
+}
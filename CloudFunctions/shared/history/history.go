@@ -0,0 +1,177 @@
+// Package history records what happened to releases and rollouts, so
+// it can be queried after the functions that did it have moved on.
+package history
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kind of record
+type Kind string
+
+const (
+	// ReleaseCreated is recorded when Cloud Deploy accepted a release
+	ReleaseCreated Kind = "release-created"
+	// RolloutState is recorded on every state change of a rollout
+	RolloutState Kind = "rollout"
+	// Approval is a decision on a rollout waiting for approval
+	Approval Kind = "approval"
+	// Vote is one approver's vote on such a rollout
+	Vote Kind = "vote"
+	// Command is the outcome of a command cloudDeployInteractions ran
+	Command Kind = "command"
+	// Rollback is an automatic rollback of a failed rollout, which is the
+	// Rollout of the record
+	Rollback Kind = "rollback"
+)
+
+// Rollout states recorded in RolloutState records
+const (
+	InProgress = "IN_PROGRESS"
+	Succeeded  = "SUCCEEDED"
+	Failed     = "FAILED"
+	Cancelled  = "CANCELLED"
+)
+
+// Record is one thing that happened. Resource IDs are short Cloud Deploy
+// IDs; fields that don't apply to the kind are empty.
+type Record struct {
+	// ID makes appending idempotent, so redelivered events are recorded
+	// once, see Key
+	ID   string    `firestore:"-" json:"id"`
+	Kind Kind      `firestore:"kind" json:"kind"`
+	At   time.Time `firestore:"at" json:"at"`
+
+	Pipeline string `firestore:"pipeline,omitempty" json:"pipeline,omitempty"`
+	Release  string `firestore:"release,omitempty" json:"release,omitempty"`
+	Target   string `firestore:"target,omitempty" json:"target,omitempty"`
+	Rollout  string `firestore:"rollout,omitempty" json:"rollout,omitempty"`
+
+	// State is the rollout's state for RolloutState records
+	State string `firestore:"state,omitempty" json:"state,omitempty"`
+	// Actor did it: a command's issuer, an approver or the policy rule
+	Actor string `firestore:"actor,omitempty" json:"actor,omitempty"`
+	// Approvers are the IDs of whoever decided an approval, see
+	// command.Approver
+	Approvers []string `firestore:"approvers,omitempty" json:"approvers,omitempty"`
+	// Decision is "approve", "reject" or "escalate" for approvals and votes
+	Decision string `firestore:"decision,omitempty" json:"decision,omitempty"`
+	// Command is the command type of Command records, Error why it failed
+	Command string `firestore:"command,omitempty" json:"command,omitempty"`
+	Error   string `firestore:"error,omitempty" json:"error,omitempty"`
+	Commit  string `firestore:"commit,omitempty" json:"commit,omitempty"`
+	Detail  string `firestore:"detail,omitempty" json:"detail,omitempty"`
+	// CommitTime is when the rollout's change was committed, see
+	// provenance.CommitTime
+	CommitTime time.Time `firestore:"commitTime,omitempty" json:"commitTime,omitempty"`
+	// RollbackOf is the rollout a rollback rollout rolls back
+	RollbackOf string `firestore:"rollbackOf,omitempty" json:"rollbackOf,omitempty"`
+}
+
+// SetResource fills the pipeline, release, target and rollout IDs found in
+// a Cloud Deploy resource name, e.g.
+// projects/p/locations/l/deliveryPipelines/d/releases/r/rollouts/x.
+func (r *Record) SetResource(name string) {
+	parts := strings.Split(name, "/")
+	for i := 0; i+1 < len(parts); i += 2 {
+		switch parts[i] {
+		case "deliveryPipelines":
+			r.Pipeline = parts[i+1]
+		case "releases":
+			r.Release = parts[i+1]
+		case "rollouts":
+			r.Rollout = parts[i+1]
+		case "targets":
+			r.Target = parts[i+1]
+		}
+	}
+}
+
+// Key identifies a record across redeliveries of the message that caused
+// it. It's hashed because the parts may contain characters store keys
+// can't.
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		fmt.Fprintf(h, "%s/", p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Filter selects records; empty fields match everything. Query returns the
+// newest first, at most Limit of them (DefaultLimit when 0). main.tf indexes
+// every combination of the fields FirestoreStore filters on by equality, so a
+// new one needs adding to its history_filters too.
+type Filter struct {
+	Pipeline string
+	Release  string
+	Target   string
+	Kind     Kind
+	State    string
+	// Since leaves out records from before it, when set
+	Since time.Time
+	Limit int
+}
+
+// DefaultLimit caps queries that don't set a limit
+const DefaultLimit = 100
+
+func (f Filter) limit() int {
+	if f.Limit <= 0 {
+		return DefaultLimit
+	}
+	return f.Limit
+}
+
+func (f Filter) matches(r Record) bool {
+	return (f.Pipeline == "" || f.Pipeline == r.Pipeline) &&
+		(f.Release == "" || f.Release == r.Release) &&
+		(f.Target == "" || f.Target == r.Target) &&
+		(f.Kind == "" || f.Kind == r.Kind) &&
+		(f.State == "" || f.State == r.State) &&
+		!r.At.Before(f.Since)
+}
+
+// Store keeps the history.
+type Store interface {
+	// Append records r, unless a record with its ID already is.
+	Append(ctx context.Context, r Record) error
+	// Query returns the records matching f, newest first.
+	Query(ctx context.Context, f Filter) ([]Record, error)
+	Close() error
+}
+
+// Current returns the last rollout to succeed on target, which is what's
+// running there, or nil if none has. pipeline narrows it down when targets
+// are shared between pipelines.
+func Current(ctx context.Context, s Store, pipeline, target string) (*Record, error) {
+	records, err := s.Query(ctx, Filter{Pipeline: pipeline, Target: target, Kind: RolloutState, State: Succeeded, Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return &records[0], nil
+}
+
+// newestFirst sorts and filters records for the in-process stores.
+func newestFirst(records []Record, f Filter) []Record {
+	var matched []Record
+	for _, r := range records {
+		if f.matches(r) {
+			matched = append(matched, r)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].At.After(matched[j].At) })
+	if len(matched) > f.limit() {
+		matched = matched[:f.limit()]
+	}
+	return matched
+}
@@ -0,0 +1,205 @@
+package history_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/shared/history"
+)
+
+// grid is one record for every combination of two values of each filtered
+// field, a minute apart.
+func grid() []history.Record {
+	at := time.Date(2026, 6, 2, 9, 0, 0, 0, time.UTC)
+	var records []history.Record
+	for _, pipeline := range []string{"web", "api"} {
+		for _, release := range []string{"r1", "r2"} {
+			for _, target := range []string{"dev", "prod"} {
+				for _, kind := range []history.Kind{history.RolloutState, history.Approval} {
+					for _, state := range []string{history.Succeeded, history.Failed} {
+						records = append(records, history.Record{
+							ID:   fmt.Sprint(len(records)),
+							At:   at.Add(time.Duration(len(records)) * time.Minute),
+							Kind: kind, Pipeline: pipeline, Release: release, Target: target, State: state,
+						})
+					}
+				}
+			}
+		}
+	}
+	return records
+}
+
+// stores returns an empty store of each in-process kind.
+func stores(t *testing.T) map[string]history.Store {
+	return map[string]history.Store{
+		"memory": history.NewMemoryStore(),
+		"file":   &history.FileStore{Path: filepath.Join(t.TempDir(), "history.jsonl")},
+	}
+}
+
+func TestQueryFilters(t *testing.T) {
+	ctx := context.Background()
+	want := history.Record{Pipeline: "web", Release: "r1", Target: "dev", Kind: history.RolloutState, State: history.Succeeded}
+	fields := []string{"pipeline", "release", "target", "kind", "state"}
+	for name, s := range stores(t) {
+		for _, r := range grid() {
+			if err := s.Append(ctx, r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Every combination main.tf indexes
+		for used := 1; used < 1<<len(fields); used++ {
+			var f history.Filter
+			var names []string
+			for i, field := range fields {
+				if used&(1<<i) == 0 {
+					continue
+				}
+				names = append(names, field)
+				switch field {
+				case "pipeline":
+					f.Pipeline = want.Pipeline
+				case "release":
+					f.Release = want.Release
+				case "target":
+					f.Target = want.Target
+				case "kind":
+					f.Kind = want.Kind
+				case "state":
+					f.State = want.State
+				}
+			}
+			t.Run(name+"/"+strings.Join(names, "_"), func(t *testing.T) {
+				got, err := s.Query(ctx, f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Each field used halves the grid
+				if n := 32 >> len(names); len(got) != n {
+					t.Fatalf("got %d records, want %d", len(got), n)
+				}
+				for i, r := range got {
+					if (f.Pipeline != "" && r.Pipeline != f.Pipeline) || (f.Release != "" && r.Release != f.Release) ||
+						(f.Target != "" && r.Target != f.Target) || (f.Kind != "" && r.Kind != f.Kind) || (f.State != "" && r.State != f.State) {
+						t.Errorf("record %+v doesn't match %+v", r, f)
+					}
+					if i > 0 && !r.At.Before(got[i-1].At) {
+						t.Errorf("record %d at %s isn't older than the one before, at %s", i, r.At, got[i-1].At)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestQueryNewestFirst(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2026, 6, 2, 9, 0, 0, 0, time.UTC)
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Appended out of order
+			for _, minutes := range []int{2, 0, 3, 1} {
+				r := history.Record{Kind: history.RolloutState, Release: fmt.Sprintf("r%d", minutes), At: at.Add(time.Duration(minutes) * time.Minute)}
+				if err := s.Append(ctx, r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, tt := range []struct {
+				filter history.Filter
+				want   []string
+			}{
+				{history.Filter{}, []string{"r3", "r2", "r1", "r0"}},
+				{history.Filter{Limit: 2}, []string{"r3", "r2"}},
+				{history.Filter{Since: at.Add(time.Minute)}, []string{"r3", "r2", "r1"}},
+				{history.Filter{Since: at.Add(time.Minute), Limit: 1}, []string{"r3"}},
+			} {
+				got, err := s.Query(ctx, tt.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var releases []string
+				for _, r := range got {
+					releases = append(releases, r.Release)
+				}
+				if !reflect.DeepEqual(releases, tt.want) {
+					t.Errorf("Query(%+v) = %v, want %v", tt.filter, releases, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryDefaultLimit(t *testing.T) {
+	ctx := context.Background()
+	s := history.NewMemoryStore()
+	for i := 0; i < history.DefaultLimit+5; i++ {
+		if err := s.Append(ctx, history.Record{Kind: history.Vote}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := s.Query(ctx, history.Filter{}); len(got) != history.DefaultLimit {
+		t.Errorf("got %d records, want the default limit of %d", len(got), history.DefaultLimit)
+	}
+}
+
+func TestAppendIdempotent(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			r := history.Record{ID: history.Key("rollout", "m1"), Kind: history.RolloutState, State: history.Succeeded}
+			for range 2 {
+				if err := s.Append(ctx, r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Records without an ID get their own
+			for range 2 {
+				if err := s.Append(ctx, history.Record{Kind: history.Vote}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got, _ := s.Query(ctx, history.Filter{}); len(got) != 3 {
+				t.Errorf("got %d records, want 3", len(got))
+			}
+		})
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	ctx := context.Background()
+	s := history.NewMemoryStore()
+	for _, r := range grid() {
+		if err := s.Append(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := history.Current(ctx, s, "web", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Pipeline != "web" || got.Target != "prod" || got.Release != "r2" || got.Kind != history.RolloutState || got.State != history.Succeeded {
+		t.Errorf("Current = %+v, want web's last successful prod rollout, of r2", got)
+	}
+	if got, err := history.Current(ctx, s, "web", "staging"); got != nil || err != nil {
+		t.Errorf("Current of a target never deployed to = %+v, %v, want nil", got, err)
+	}
+}
+
+func TestSetResource(t *testing.T) {
+	var r history.Record
+	r.SetResource("projects/p/locations/l/deliveryPipelines/web/releases/r1/rollouts/r1-to-prod-0001")
+	if r.Pipeline != "web" || r.Release != "r1" || r.Rollout != "r1-to-prod-0001" || r.Target != "" {
+		t.Errorf("SetResource of a rollout = %+v", r)
+	}
+	r = history.Record{}
+	r.SetResource("projects/p/locations/l/targets/prod")
+	if r.Target != "prod" || r.Pipeline != "" {
+		t.Errorf("SetResource of a target = %+v", r)
+	}
+}
@@ -0,0 +1,155 @@
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// NewStore returns the Store selected by kind: "firestore", "memory" or
+// "file:<path>".
+func NewStore(ctx context.Context, kind, projectID, database, collection string) (Store, error) {
+	if path, ok := strings.CutPrefix(kind, "file:"); ok {
+		return &FileStore{Path: path}, nil
+	}
+	switch kind {
+	case "firestore":
+		return newFirestoreStore(ctx, projectID, database, collection)
+	case "memory":
+		return memStore, nil
+	default:
+		return nil, fmt.Errorf("unknown history store %q", kind)
+	}
+}
+
+// memStore lives as long as the function instance, which is enough for
+// tests and local runs but not for production.
+var memStore = NewMemoryStore()
+
+// MemoryStore is an in-process Store.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records []Record
+	ids     map[string]bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{ids: map[string]bool{}}
+}
+
+func (s *MemoryStore) Append(ctx context.Context, r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.ID == "" {
+		r.ID = uuid.NewString()
+	}
+	if s.ids[r.ID] {
+		return nil
+	}
+	s.ids[r.ID] = true
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *MemoryStore) Query(ctx context.Context, f Filter) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return newestFirst(s.records, f), nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// FileStore appends records to a local JSON lines file, so functions
+// running side by side on one machine share a history without a database.
+// Appends of a line are atomic, duplicates are dropped when reading.
+type FileStore struct {
+	Path string
+}
+
+func (s *FileStore) Append(ctx context.Context, r Record) error {
+	if r.ID == "" {
+		r.ID = uuid.NewString()
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening history: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("appending to history: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Query(ctx context.Context, f Filter) ([]Record, error) {
+	file, err := os.Open(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening history: %w", err)
+	}
+	defer file.Close()
+	var records []Record
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("reading history: %w", err)
+		}
+		if seen[r.ID] {
+			continue
+		}
+		seen[r.ID] = true
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	return newestFirst(records, f), nil
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
+
+// Config says where a function keeps the history. Functions fill it from
+// their own environment.
+type Config struct {
+	// Store is "firestore", "memory" or "file:<path>"
+	Store      string
+	ProjectID  string
+	Database   string
+	Collection string
+}
+
+// Open returns the configured Store.
+func (c Config) Open(ctx context.Context) (Store, error) {
+	return NewStore(ctx, c.Store, c.ProjectID, c.Database, c.Collection)
+}
+
+// Append records r in the configured Store. Callers log the error rather
+// than fail: the history mustn't hold up a deployment.
+func (c Config) Append(ctx context.Context, r Record) error {
+	s, err := c.Open(ctx)
+	if err != nil {
+		return fmt.Errorf("error creating history store: %w", err)
+	}
+	defer s.Close()
+	return s.Append(ctx, r)
+}
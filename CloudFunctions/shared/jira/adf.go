@@ -0,0 +1,40 @@
+package jira
+
+import "strings"
+
+// Document is an Atlassian Document Format node. The v3 API only accepts
+// rich text fields (description, comment bodies) in this format.
+type Document struct {
+	Type    string     `json:"type"`
+	Version int        `json:"version,omitempty"`
+	Text    string     `json:"text,omitempty"`
+	Content []Document `json:"content,omitempty"`
+}
+
+// Text converts plain text into an ADF document with one paragraph per line.
+func Text(s string) Document {
+	doc := Document{Type: "doc", Version: 1}
+	for _, line := range strings.Split(s, "\n") {
+		p := Document{Type: "paragraph"}
+		if line != "" {
+			p.Content = []Document{{Type: "text", Text: line}}
+		}
+		doc.Content = append(doc.Content, p)
+	}
+	return doc
+}
+
+// PlainText flattens an ADF document back into text, one line per block.
+func (d Document) PlainText() string {
+	if d.Type == "text" {
+		return d.Text
+	}
+	var lines []string
+	for _, c := range d.Content {
+		lines = append(lines, c.PlainText())
+	}
+	if d.Type == "paragraph" {
+		return strings.Join(lines, "")
+	}
+	return strings.Join(lines, "\n")
+}
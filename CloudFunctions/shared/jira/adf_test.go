@@ -0,0 +1,65 @@
+package jira_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"example.com/shared/jira"
+)
+
+func TestText(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "one line",
+			text: "Deployed",
+			want: `{"type":"doc","version":1,"content":[{"type":"paragraph","content":[{"type":"text","text":"Deployed"}]}]}`,
+		},
+		{
+			name: "blank lines are empty paragraphs",
+			text: "Commit: 0123456\n\nBranch: main",
+			want: `{"type":"doc","version":1,"content":[` +
+				`{"type":"paragraph","content":[{"type":"text","text":"Commit: 0123456"}]},` +
+				`{"type":"paragraph"},` +
+				`{"type":"paragraph","content":[{"type":"text","text":"Branch: main"}]}]}`,
+		},
+		{
+			name: "empty",
+			text: "",
+			want: `{"type":"doc","version":1,"content":[{"type":"paragraph"}]}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(jira.Text(tt.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Text(%q) =\n%s\nwant\n%s", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	for _, text := range []string{"Deployed", "Commit: 0123456\n\nBranch: main", ""} {
+		if got := jira.Text(text).PlainText(); got != text {
+			t.Errorf("Text(%q).PlainText() = %q", text, got)
+		}
+	}
+
+	// Paragraphs with several text nodes, as Jira's editor writes them
+	var doc jira.Document
+	err := json.Unmarshal([]byte(`{"type":"doc","version":1,"content":[
+		{"type":"paragraph","content":[{"type":"text","text":"Approved "},{"type":"text","text":"by alice"}]},
+		{"type":"paragraph","content":[{"type":"text","text":"LGTM"}]}]}`), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := doc.PlainText(), "Approved by alice\nLGTM"; got != want {
+		t.Errorf("PlainText() = %q, want %q", got, want)
+	}
+}
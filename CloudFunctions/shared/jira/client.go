@@ -0,0 +1,258 @@
+// Package jira is a small client for the parts of the Jira Cloud REST v3 API
+// that the deploy functions need: creating issues, commenting on them and
+// moving them through their workflow.
+package jira
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client talks to a single Jira Cloud site. BaseURL is the site root
+// (e.g. https://example.atlassian.net) so it can be pointed at a local
+// stand-in such as jiratest.Server.
+type Client struct {
+	BaseURL  string
+	Email    string
+	APIToken string
+	// HTTPClient defaults to http.DefaultClient when nil
+	HTTPClient *http.Client
+}
+
+// NewClient returns a Client for the given site using basic auth with an
+// Atlassian account email and API token.
+func NewClient(baseURL, email, apiToken string) *Client {
+	return &Client{
+		BaseURL:  strings.TrimRight(baseURL, "/"),
+		Email:    email,
+		APIToken: apiToken,
+	}
+}
+
+// Issue is the subset of a Jira issue returned by the API that we care about.
+type Issue struct {
+	ID     string                 `json:"id"`
+	Key    string                 `json:"key"`
+	Self   string                 `json:"self"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// IssueRequest describes an issue to create. Fields holds any additional
+// field values (custom fields, labels, components...) keyed by Jira field ID
+// and is merged over the standard fields.
+type IssueRequest struct {
+	ProjectKey  string
+	IssueType   string
+	Summary     string
+	Description string
+	Fields      map[string]interface{}
+}
+
+// APIError is returned when Jira answers with a non 2xx status.
+type APIError struct {
+	StatusCode    int
+	ErrorMessages []string          `json:"errorMessages"`
+	Errors        map[string]string `json:"errors"`
+}
+
+func (e *APIError) Error() string {
+	var parts []string
+	parts = append(parts, e.ErrorMessages...)
+	for k, v := range e.Errors {
+		parts = append(parts, fmt.Sprintf("%s: %s", k, v))
+	}
+	return fmt.Sprintf("jira returned %d: %s", e.StatusCode, strings.Join(parts, "; "))
+}
+
+// CreateIssue creates a new issue and returns its ID and key.
+func (c *Client) CreateIssue(ctx context.Context, r IssueRequest) (*Issue, error) {
+	fields := map[string]interface{}{
+		"project":   map[string]string{"key": r.ProjectKey},
+		"issuetype": map[string]string{"name": r.IssueType},
+		"summary":   r.Summary,
+	}
+	if r.Description != "" {
+		fields["description"] = Text(r.Description)
+	}
+	for k, v := range r.Fields {
+		fields[k] = v
+	}
+	var issue Issue
+	if err := c.do(ctx, http.MethodPost, "/rest/api/3/issue", map[string]interface{}{"fields": fields}, &issue); err != nil {
+		return nil, fmt.Errorf("creating issue: %w", err)
+	}
+	return &issue, nil
+}
+
+// GetIssue fetches an issue by key or ID.
+func (c *Client) GetIssue(ctx context.Context, key string) (*Issue, error) {
+	var issue Issue
+	if err := c.do(ctx, http.MethodGet, "/rest/api/3/issue/"+url.PathEscape(key), nil, &issue); err != nil {
+		return nil, fmt.Errorf("getting issue %s: %w", key, err)
+	}
+	return &issue, nil
+}
+
+// SearchIssues returns the first page of issues matching jql, with their
+// summary, status and labels.
+func (c *Client) SearchIssues(ctx context.Context, jql string) ([]Issue, error) {
+	body := map[string]interface{}{
+		"jql":        jql,
+		"fields":     []string{"summary", "status", "labels"},
+		"maxResults": 50,
+	}
+	var resp struct {
+		Issues []Issue `json:"issues"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/rest/api/3/search/jql", body, &resp); err != nil {
+		return nil, fmt.Errorf("searching %q: %w", jql, err)
+	}
+	return resp.Issues, nil
+}
+
+// AddComment adds a plain text comment to the issue.
+func (c *Client) AddComment(ctx context.Context, key, text string) error {
+	path := fmt.Sprintf("/rest/api/3/issue/%s/comment", url.PathEscape(key))
+	if err := c.do(ctx, http.MethodPost, path, map[string]interface{}{"body": Text(text)}, nil); err != nil {
+		return fmt.Errorf("commenting on %s: %w", key, err)
+	}
+	return nil
+}
+
+// Transition is a workflow transition available on an issue.
+type Transition struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	To   struct {
+		Name string `json:"name"`
+	} `json:"to"`
+}
+
+// Transitions lists the transitions currently available on the issue.
+func (c *Client) Transitions(ctx context.Context, key string) ([]Transition, error) {
+	var resp struct {
+		Transitions []Transition `json:"transitions"`
+	}
+	path := fmt.Sprintf("/rest/api/3/issue/%s/transitions", url.PathEscape(key))
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, fmt.Errorf("listing transitions on %s: %w", key, err)
+	}
+	return resp.Transitions, nil
+}
+
+// DoTransition performs the transition with the given ID.
+func (c *Client) DoTransition(ctx context.Context, key, transitionID string) error {
+	path := fmt.Sprintf("/rest/api/3/issue/%s/transitions", url.PathEscape(key))
+	body := map[string]interface{}{"transition": map[string]string{"id": transitionID}}
+	if err := c.do(ctx, http.MethodPost, path, body, nil); err != nil {
+		return fmt.Errorf("transitioning %s: %w", key, err)
+	}
+	return nil
+}
+
+// ErrNoTransition is returned by TransitionTo when the workflow offers no
+// matching transition from the issue's current status.
+var ErrNoTransition = errors.New("no matching transition")
+
+// TransitionTo moves the issue using the first available transition whose
+// name or target status matches status (case-insensitively). Workflows name
+// transitions freely, so matching on either keeps configuration simple.
+func (c *Client) TransitionTo(ctx context.Context, key, status string) error {
+	transitions, err := c.Transitions(ctx, key)
+	if err != nil {
+		return err
+	}
+	for _, t := range transitions {
+		if strings.EqualFold(t.To.Name, status) || strings.EqualFold(t.Name, status) {
+			return c.DoTransition(ctx, key, t.ID)
+		}
+	}
+	return fmt.Errorf("moving %s to %q: %w", key, status, ErrNoTransition)
+}
+
+// User is the subset of a Jira user returned by the API that we care
+// about. EmailAddress is empty when the user's profile hides it from the
+// API's account.
+type User struct {
+	AccountID    string `json:"accountId"`
+	DisplayName  string `json:"displayName"`
+	EmailAddress string `json:"emailAddress,omitempty"`
+}
+
+// GetUser fetches a user by account ID.
+func (c *Client) GetUser(ctx context.Context, accountID string) (*User, error) {
+	var user User
+	if err := c.do(ctx, http.MethodGet, "/rest/api/3/user?accountId="+url.QueryEscape(accountID), nil, &user); err != nil {
+		return nil, fmt.Errorf("getting user %s: %w", accountID, err)
+	}
+	return &user, nil
+}
+
+// SetProperty stores an arbitrary JSON value as an entity property on the issue.
+func (c *Client) SetProperty(ctx context.Context, key, property string, value interface{}) error {
+	path := fmt.Sprintf("/rest/api/3/issue/%s/properties/%s", url.PathEscape(key), url.PathEscape(property))
+	if err := c.do(ctx, http.MethodPut, path, value, nil); err != nil {
+		return fmt.Errorf("setting property %s on %s: %w", property, key, err)
+	}
+	return nil
+}
+
+// GetProperty reads an entity property from the issue into v.
+func (c *Client) GetProperty(ctx context.Context, key, property string, v interface{}) error {
+	path := fmt.Sprintf("/rest/api/3/issue/%s/properties/%s", url.PathEscape(key), url.PathEscape(property))
+	var resp struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return fmt.Errorf("getting property %s on %s: %w", property, key, err)
+	}
+	return json.Unmarshal(resp.Value, v)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("json.Marshal: %v", err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, r)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.Email, c.APIToken)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		// Jira usually sends a JSON error collection, but don't fail if it didn't
+		_ = json.NewDecoder(resp.Body).Decode(apiErr)
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,251 @@
+package jira_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"example.com/shared/jira"
+	"example.com/shared/jira/jiratest"
+)
+
+func newClient(t *testing.T) (*jira.Client, *jiratest.Server) {
+	t.Helper()
+	s := jiratest.NewServer()
+	t.Cleanup(s.Close)
+	return jira.NewClient(s.URL+"/", "e2e@example.com", "token"), s
+}
+
+func TestCreateIssue(t *testing.T) {
+	c, s := newClient(t)
+	issue, err := c.CreateIssue(context.Background(), jira.IssueRequest{
+		ProjectKey:  "DEP",
+		IssueType:   "Task",
+		Summary:     "Release 0123456",
+		Description: "Commit: 0123456\nBranch: main",
+		Fields:      map[string]interface{}{"labels": []string{"deploy"}},
+	})
+	if err != nil {
+		t.Fatalf("CreateIssue: %v", err)
+	}
+	if issue.Key != "DEP-1" || issue.ID == "" {
+		t.Fatalf("CreateIssue = %+v, want DEP-1 with an ID", issue)
+	}
+	stored := s.Issue("DEP-1")
+	if stored == nil {
+		t.Fatal("issue wasn't stored")
+	}
+	if got := stored.Fields["summary"]; got != "Release 0123456" {
+		t.Errorf("summary = %v", got)
+	}
+	if got := stored.Fields["labels"]; !reflect.DeepEqual(got, []interface{}{"deploy"}) {
+		t.Errorf("labels = %v, want [deploy]", got)
+	}
+	if got := stored.Fields["issuetype"]; !reflect.DeepEqual(got, map[string]interface{}{"name": "Task"}) {
+		t.Errorf("issuetype = %v", got)
+	}
+
+	got, err := c.GetIssue(context.Background(), "DEP-1")
+	if err != nil {
+		t.Fatalf("GetIssue: %v", err)
+	}
+	if got.Key != "DEP-1" || got.Fields["status"].(map[string]interface{})["name"] != "To Do" {
+		t.Errorf("GetIssue = %+v", got)
+	}
+}
+
+func TestSearchIssues(t *testing.T) {
+	c, _ := newClient(t)
+	ctx := context.Background()
+	for _, build := range []string{"build-1", "build-2"} {
+		_, err := c.CreateIssue(ctx, jira.IssueRequest{
+			ProjectKey: "DEP",
+			IssueType:  "Task",
+			Summary:    "Build " + build,
+			Fields:     map[string]interface{}{"labels": []string{jira.BuildLabel(build)}},
+		})
+		if err != nil {
+			t.Fatalf("CreateIssue: %v", err)
+		}
+	}
+	issues, err := c.SearchIssues(ctx, jira.BuildLabelJQL("build-2"))
+	if err != nil {
+		t.Fatalf("SearchIssues: %v", err)
+	}
+	if len(issues) != 1 || issues[0].Key != "DEP-2" {
+		t.Fatalf("SearchIssues = %+v, want DEP-2 only", issues)
+	}
+	if issues[0].Fields["summary"] != "Build build-2" {
+		t.Errorf("summary = %v", issues[0].Fields["summary"])
+	}
+
+	issues, err = c.SearchIssues(ctx, jira.BuildLabelJQL("build-3"))
+	if err != nil || len(issues) != 0 {
+		t.Errorf("SearchIssues for an unknown build = %+v, %v, want none", issues, err)
+	}
+}
+
+func TestTransitionTo(t *testing.T) {
+	c, s := newClient(t)
+	ctx := context.Background()
+	if _, err := c.CreateIssue(ctx, jira.IssueRequest{ProjectKey: "DEP", IssueType: "Task", Summary: "Release"}); err != nil {
+		t.Fatalf("CreateIssue: %v", err)
+	}
+
+	// Matching is on the transition or status name, case-insensitively
+	if err := c.TransitionTo(ctx, "DEP-1", "in progress"); err != nil {
+		t.Fatalf("TransitionTo: %v", err)
+	}
+	if got := s.Issue("DEP-1").Status; got != "In Progress" {
+		t.Errorf("status = %q, want In Progress", got)
+	}
+
+	err := c.TransitionTo(ctx, "DEP-1", "Shipped")
+	if !errors.Is(err, jira.ErrNoTransition) {
+		t.Errorf("TransitionTo an unknown status = %v, want ErrNoTransition", err)
+	}
+	// The current status isn't offered as a transition
+	if err := c.TransitionTo(ctx, "DEP-1", "In Progress"); !errors.Is(err, jira.ErrNoTransition) {
+		t.Errorf("TransitionTo the current status = %v, want ErrNoTransition", err)
+	}
+}
+
+func TestProperties(t *testing.T) {
+	c, _ := newClient(t)
+	ctx := context.Background()
+	if _, err := c.CreateIssue(ctx, jira.IssueRequest{ProjectKey: "DEP", IssueType: "Task", Summary: "Release"}); err != nil {
+		t.Fatalf("CreateIssue: %v", err)
+	}
+	link := jira.ReleaseLink{Release: "projects/p/locations/l/deliveryPipelines/d/releases/r"}
+	if err := c.SetProperty(ctx, "DEP-1", jira.ReleaseProperty, link); err != nil {
+		t.Fatalf("SetProperty: %v", err)
+	}
+	var got jira.ReleaseLink
+	if err := c.GetProperty(ctx, "DEP-1", jira.ReleaseProperty, &got); err != nil {
+		t.Fatalf("GetProperty: %v", err)
+	}
+	if got != link {
+		t.Errorf("GetProperty = %+v, want %+v", got, link)
+	}
+
+	// Overwriting keeps the latest value
+	link.Release += "-2"
+	if err := c.SetProperty(ctx, "DEP-1", jira.ReleaseProperty, link); err != nil {
+		t.Fatalf("SetProperty again: %v", err)
+	}
+	if err := c.GetProperty(ctx, "DEP-1", jira.ReleaseProperty, &got); err != nil || got != link {
+		t.Errorf("GetProperty after overwrite = %+v, %v, want %+v", got, err, link)
+	}
+
+	var apiErr *jira.APIError
+	err := c.GetProperty(ctx, "DEP-1", "missing", &got)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("GetProperty of a missing property = %v, want a 404 APIError", err)
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	c, s := newClient(t)
+	ctx := context.Background()
+	carol := jira.User{AccountID: "5b10ac8d82e05b22cc7d4ef5", DisplayName: "Carol", EmailAddress: "carol@example.com"}
+	s.AddUser(carol)
+	if got, err := c.GetUser(ctx, carol.AccountID); err != nil || *got != carol {
+		t.Errorf("GetUser = %+v, %v, want %+v", got, err, carol)
+	}
+
+	var apiErr *jira.APIError
+	_, err := c.GetUser(ctx, "nobody")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("GetUser of a missing user = %v, want a 404 APIError", err)
+	}
+}
+
+func TestAddComment(t *testing.T) {
+	c, s := newClient(t)
+	ctx := context.Background()
+	if _, err := c.CreateIssue(ctx, jira.IssueRequest{ProjectKey: "DEP", IssueType: "Task", Summary: "Release"}); err != nil {
+		t.Fatalf("CreateIssue: %v", err)
+	}
+	if err := c.AddComment(ctx, "DEP-1", "Rolled out to dev.\nPromoting to prod."); err != nil {
+		t.Fatalf("AddComment: %v", err)
+	}
+	want := []string{"Rolled out to dev.\nPromoting to prod."}
+	if got := s.Issue("DEP-1").Comments; !reflect.DeepEqual(got, want) {
+		t.Errorf("comments = %q, want %q", got, want)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		status   int
+		body     string
+		wantText []string
+	}{
+		{
+			name:     "error collection",
+			status:   http.StatusBadRequest,
+			body:     `{"errorMessages": ["Field is invalid."], "errors": {"summary": "You must specify a summary."}}`,
+			wantText: []string{"jira returned 400", "Field is invalid.", "summary: You must specify a summary."},
+		},
+		{
+			name:     "not json",
+			status:   http.StatusBadGateway,
+			body:     "<html>bad gateway</html>",
+			wantText: []string{"jira returned 502"},
+		},
+		{
+			name:     "unauthorized",
+			status:   http.StatusUnauthorized,
+			body:     `{"errorMessages": ["Client must be authenticated to access this resource."]}`,
+			wantText: []string{"jira returned 401", "must be authenticated"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer s.Close()
+			_, err := jira.NewClient(s.URL, "e", "t").GetIssue(context.Background(), "DEP-1")
+			var apiErr *jira.APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("GetIssue = %v, want an APIError", err)
+			}
+			if apiErr.StatusCode != tt.status {
+				t.Errorf("StatusCode = %d, want %d", apiErr.StatusCode, tt.status)
+			}
+			for _, want := range tt.wantText {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't contain %q", err, want)
+				}
+			}
+		})
+	}
+
+	// Missing issues come back from the fake as 404s
+	c, _ := newClient(t)
+	var apiErr *jira.APIError
+	if err := c.AddComment(context.Background(), "DEP-9", "hello"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("AddComment on a missing issue = %v, want a 404 APIError", err)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	var user, pass string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ = r.BasicAuth()
+		w.Write([]byte(`{"id": "1", "key": "DEP-1"}`))
+	}))
+	defer s.Close()
+	if _, err := jira.NewClient(s.URL, "bot@example.com", "secret").GetIssue(context.Background(), "DEP-1"); err != nil {
+		t.Fatalf("GetIssue: %v", err)
+	}
+	if user != "bot@example.com" || pass != "secret" {
+		t.Errorf("basic auth = %q:%q", user, pass)
+	}
+}
@@ -0,0 +1,306 @@
+// Package jiratest provides an in-memory stand-in for the Jira Cloud REST v3
+// endpoints used by package jira, in the spirit of net/http/httptest.
+package jiratest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"example.com/shared/jira"
+)
+
+// Server is a fake Jira site. Point a jira.Client at Server.URL.
+type Server struct {
+	*httptest.Server
+
+	// Statuses is the fake workflow: every issue can transition to any of
+	// these from any other. Set it before issues are transitioned.
+	Statuses []string
+
+	mu     sync.Mutex
+	next   int
+	issues map[string]*Issue
+	users  map[string]jira.User
+}
+
+// DefaultStatuses is the workflow NewServer starts with.
+var DefaultStatuses = []string{"To Do", "In Progress", "Approved", "Rejected", "Deployed", "Failed"}
+
+// Issue is the state the fake keeps for every created issue.
+type Issue struct {
+	ID         string
+	Key        string
+	Fields     map[string]interface{}
+	Properties map[string]json.RawMessage
+	Status     string
+	// Comments are flattened to plain text
+	Comments []string
+}
+
+// NewServer starts a fake Jira site. Issues are created in whatever project
+// key is sent and numbered from 1. Callers should Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		Statuses: DefaultStatuses,
+		issues:   map[string]*Issue{},
+		users:    map[string]jira.User{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /rest/api/3/issue", s.createIssue)
+	mux.HandleFunc("GET /rest/api/3/issue/{key}", s.getIssue)
+	mux.HandleFunc("POST /rest/api/3/search/jql", s.search)
+	mux.HandleFunc("POST /rest/api/3/issue/{key}/comment", s.addComment)
+	mux.HandleFunc("GET /rest/api/3/issue/{key}/transitions", s.listTransitions)
+	mux.HandleFunc("POST /rest/api/3/issue/{key}/transitions", s.doTransition)
+	mux.HandleFunc("PUT /rest/api/3/issue/{key}/properties/{property}", s.setProperty)
+	mux.HandleFunc("GET /rest/api/3/issue/{key}/properties/{property}", s.getProperty)
+	mux.HandleFunc("GET /rest/api/3/user", s.getUser)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Issue returns a copy of the stored issue, or nil if it doesn't exist.
+func (s *Server) Issue(key string) *Issue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.issues[key]
+	if !ok {
+		return nil
+	}
+	cp := *i
+	cp.Properties = make(map[string]json.RawMessage, len(i.Properties))
+	for k, v := range i.Properties {
+		cp.Properties[k] = v
+	}
+	cp.Comments = append([]string(nil), i.Comments...)
+	return &cp
+}
+
+func (s *Server) createIssue(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Fields map[string]interface{} `json:"fields"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	project, _ := req.Fields["project"].(map[string]interface{})
+	projectKey, _ := project["key"].(string)
+	if projectKey == "" {
+		writeError(w, http.StatusBadRequest, "project is required")
+		return
+	}
+	if summary, _ := req.Fields["summary"].(string); summary == "" {
+		writeError(w, http.StatusBadRequest, "summary is required")
+		return
+	}
+
+	s.mu.Lock()
+	s.next++
+	issue := &Issue{
+		ID:         fmt.Sprint(10000 + s.next),
+		Key:        fmt.Sprintf("%s-%d", projectKey, s.next),
+		Fields:     req.Fields,
+		Properties: map[string]json.RawMessage{},
+		Status:     "To Do",
+	}
+	s.issues[issue.Key] = issue
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"id":   issue.ID,
+		"key":  issue.Key,
+		"self": fmt.Sprintf("%s/rest/api/3/issue/%s", s.URL, issue.ID),
+	})
+}
+
+func (s *Server) getIssue(w http.ResponseWriter, r *http.Request) {
+	issue := s.Issue(r.PathValue("key"))
+	if issue == nil {
+		writeError(w, http.StatusNotFound, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	fields := map[string]interface{}{}
+	for k, v := range issue.Fields {
+		fields[k] = v
+	}
+	fields["status"] = map[string]string{"name": issue.Status}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     issue.ID,
+		"key":    issue.Key,
+		"fields": fields,
+	})
+}
+
+// search only understands `labels = "x"`, which is all package jira's
+// callers send.
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		JQL string `json:"jql"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var label string
+	if _, err := fmt.Sscanf(req.JQL, "labels = %q", &label); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported JQL %q", req.JQL))
+		return
+	}
+
+	s.mu.Lock()
+	var issues []map[string]interface{}
+	for _, issue := range s.issues {
+		labels, _ := issue.Fields["labels"].([]interface{})
+		for _, l := range labels {
+			if l == label {
+				issues = append(issues, map[string]interface{}{
+					"id":  issue.ID,
+					"key": issue.Key,
+					"fields": map[string]interface{}{
+						"summary": issue.Fields["summary"],
+						"labels":  labels,
+						"status":  map[string]string{"name": issue.Status},
+					},
+				})
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"issues": issues})
+}
+
+func (s *Server) addComment(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Body jira.Document `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.issues[r.PathValue("key")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	issue.Comments = append(issue.Comments, req.Body.PlainText())
+	writeJSON(w, http.StatusCreated, map[string]string{"id": fmt.Sprint(len(issue.Comments))})
+}
+
+func (s *Server) listTransitions(w http.ResponseWriter, r *http.Request) {
+	issue := s.Issue(r.PathValue("key"))
+	if issue == nil {
+		writeError(w, http.StatusNotFound, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	var transitions []map[string]interface{}
+	for i, status := range s.Statuses {
+		if status == issue.Status {
+			continue
+		}
+		transitions = append(transitions, map[string]interface{}{
+			"id":   fmt.Sprint(i + 1),
+			"name": status,
+			"to":   map[string]string{"name": status},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"transitions": transitions})
+}
+
+func (s *Server) doTransition(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Transition struct {
+			ID string `json:"id"`
+		} `json:"transition"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.issues[r.PathValue("key")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	var id int
+	if _, err := fmt.Sscan(req.Transition.ID, &id); err != nil || id < 1 || id > len(s.Statuses) || s.Statuses[id-1] == issue.Status {
+		writeError(w, http.StatusBadRequest, "Transition id '"+req.Transition.ID+"' is not valid for this issue.")
+		return
+	}
+	issue.Status = s.Statuses[id-1]
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) setProperty(w http.ResponseWriter, r *http.Request) {
+	var value json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&value); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.issues[r.PathValue("key")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	status := http.StatusOK
+	if _, exists := issue.Properties[r.PathValue("property")]; !exists {
+		status = http.StatusCreated
+	}
+	issue.Properties[r.PathValue("property")] = value
+	w.WriteHeader(status)
+}
+
+func (s *Server) getProperty(w http.ResponseWriter, r *http.Request) {
+	issue := s.Issue(r.PathValue("key"))
+	if issue == nil {
+		writeError(w, http.StatusNotFound, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	value, ok := issue.Properties[r.PathValue("property")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "The property was not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":   r.PathValue("property"),
+		"value": value,
+	})
+}
+
+// AddUser adds a user the site returns by account ID.
+func (s *Server) AddUser(u jira.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.AccountID] = u
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	u, ok := s.users[r.URL.Query().Get("accountId")]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "The user with the specified accountId does not exist.")
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]interface{}{"errorMessages": []string{msg}})
+}
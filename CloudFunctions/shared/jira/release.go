@@ -0,0 +1,41 @@
+package jira
+
+import (
+	"fmt"
+	"strings"
+)
+
+// These are the conventions the deploy functions use to link a Jira issue and
+// the Cloud Deploy release it tracks in both directions.
+const (
+	// IssueKeyAnnotation is set on every release to the key of its issue.
+	IssueKeyAnnotation = "jira-issue-key"
+	// ReleaseProperty is the issue entity property holding a ReleaseLink.
+	ReleaseProperty = "clouddeploy-release"
+	// BuildLabelPrefix followed by the Cloud Build build ID labels the
+	// issue tracking that build, see BuildLabel.
+	BuildLabelPrefix = "cloud-build-"
+)
+
+// BuildLabel is the label of the issue tracking the build, which is how
+// later notifications for the same build find it again.
+func BuildLabel(buildID string) string {
+	return BuildLabelPrefix + buildID
+}
+
+// BuildLabelJQL finds the issue tracking the build.
+func BuildLabelJQL(buildID string) string {
+	return fmt.Sprintf("labels = %q", BuildLabel(buildID))
+}
+
+// ReleaseLink is stored on the issue so webhooks only carrying the issue
+// can find their way back to the release.
+type ReleaseLink struct {
+	// Release is the full resource name of the release
+	Release string `json:"release"`
+}
+
+// IssueURL is where people see the issue on the site at baseURL.
+func IssueURL(baseURL, key string) string {
+	return strings.TrimRight(baseURL, "/") + "/browse/" + key
+}
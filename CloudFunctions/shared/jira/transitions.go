@@ -0,0 +1,28 @@
+package jira
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseTransitions parses the comma-separated "Event=Status" pairs the
+// functions are configured with, e.g. "WORKING=In Progress,FAILURE=Failed"
+// or "Rollout.Succeed=Deployed". Spaces around names are ignored.
+func ParseTransitions(s string) (map[string]string, error) {
+	transitions := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected Name=Status, got %q", pair)
+		}
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if from == "" || to == "" {
+			return nil, fmt.Errorf("expected Name=Status, got %q", pair)
+		}
+		transitions[from] = to
+	}
+	return transitions, nil
+}
@@ -0,0 +1,34 @@
+package jira_test
+
+import (
+	"reflect"
+	"testing"
+
+	"example.com/shared/jira"
+)
+
+func TestParseTransitions(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", in: "", want: map[string]string{}},
+		{name: "pairs", in: "WORKING=In Progress,FAILURE=Failed", want: map[string]string{"WORKING": "In Progress", "FAILURE": "Failed"}},
+		{name: "spaces and trailing comma", in: " Rollout.Succeed = Deployed , ", want: map[string]string{"Rollout.Succeed": "Deployed"}},
+		{name: "no equals", in: "WORKING", wantErr: true},
+		{name: "no status", in: "WORKING=", wantErr: true},
+		{name: "no name", in: "=Failed", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jira.ParseTransitions(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTransitions(%q) error = %v, want an error: %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTransitions(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
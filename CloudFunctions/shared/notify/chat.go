@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// GoogleChat posts to a Google Chat space's incoming webhook.
+type GoogleChat struct {
+	WebhookURL string
+}
+
+func (g GoogleChat) Notify(ctx context.Context, e Event) error {
+	return Post(ctx, g.WebhookURL, map[string]string{"text": GoogleChatText(e)})
+}
+
+// GoogleChatText formats e in Google Chat's text markup.
+func GoogleChatText(e Event) string {
+	lines := []string{"*" + e.Title() + "*"}
+	if e.Repo != "" || e.Branch != "" {
+		lines = append(lines, fmt.Sprintf("%s@%s", e.Repo, e.Branch))
+	}
+	if e.Detail != "" {
+		lines = append(lines, e.Detail)
+	}
+	var links []string
+	for _, l := range e.Links() {
+		if l.URL == "" {
+			links = append(links, l.Text)
+			continue
+		}
+		links = append(links, fmt.Sprintf("<%s|%s>", l.URL, l.Text))
+	}
+	if len(links) > 0 {
+		lines = append(lines, strings.Join(links, " · "))
+	}
+	return strings.Join(lines, "\n")
+}
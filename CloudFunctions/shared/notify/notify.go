@@ -0,0 +1,173 @@
+// Package notify tells chat channels about releases, rollouts and approvals
+// through incoming webhooks.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"example.com/shared/provenance"
+)
+
+// Kind is what happened.
+type Kind string
+
+const (
+	ReleaseCreated   Kind = "release-created"
+	RolloutStarted   Kind = "rollout-started"
+	RolloutSucceeded Kind = "rollout-succeeded"
+	RolloutFailed    Kind = "rollout-failed"
+	ApprovalRequired Kind = "approval-required"
+	RollbackStarted  Kind = "rollback-started"
+	RollbackSkipped  Kind = "rollback-skipped"
+)
+
+var kinds = map[Kind]string{
+	ReleaseCreated:   "Release created",
+	RolloutStarted:   "Rollout started",
+	RolloutSucceeded: "Rollout succeeded",
+	RolloutFailed:    "Rollout failed",
+	ApprovalRequired: "Approval required",
+	RollbackStarted:  "Rolling back",
+	RollbackSkipped:  "Not rolling back",
+}
+
+// Event is a notification. IDs are short Cloud Deploy IDs, not full
+// resource names; whatever isn't known is left empty and left out of the
+// message.
+type Event struct {
+	Kind     Kind
+	Project  string
+	Location string
+	Pipeline string
+	Release  string
+	Target   string
+	Rollout  string
+
+	Repo      string
+	Branch    string
+	Commit    string
+	SourceURL string
+	BuildLog  string
+
+	// IssueKey and IssueURL point at the release's Jira issue
+	IssueKey string
+	IssueURL string
+	// Detail is a free text line, e.g. why a rollout failed
+	Detail string
+}
+
+// AddProvenance fills the commit and build fields from a release's or
+// rollout's provenance annotations.
+func (e *Event) AddProvenance(annotations map[string]string) {
+	e.Repo = annotations[provenance.Repo]
+	e.Branch = annotations[provenance.Branch]
+	e.Commit = annotations[provenance.CommitSha]
+	e.SourceURL = annotations[provenance.SourceURL]
+	e.BuildLog = annotations[provenance.LogURL]
+}
+
+// Title is a one line summary, e.g. "Rollout failed: cloud-deploy-jira-0123456 to prod".
+func (e Event) Title() string {
+	title := kinds[e.Kind]
+	if title == "" {
+		title = string(e.Kind)
+	}
+	title += ": " + e.Release
+	if e.Target != "" {
+		title += " to " + e.Target
+	}
+	return title
+}
+
+// Link is a titled URL.
+type Link struct {
+	Text string
+	URL  string
+}
+
+// Links returns the Cloud Deploy console pages, commit, build log and Jira
+// issue of the event, in that order, skipping the ones that aren't known.
+func (e Event) Links() []Link {
+	var links []Link
+	if e.Project != "" && e.Location != "" && e.Pipeline != "" && e.Release != "" {
+		release := fmt.Sprintf("https://console.cloud.google.com/deploy/delivery-pipelines/%s/%s/releases/%s",
+			e.Location, e.Pipeline, e.Release)
+		project := "?project=" + url.QueryEscape(e.Project)
+		links = append(links, Link{"Release " + e.Release, release + project})
+		if e.Rollout != "" {
+			links = append(links, Link{"Rollout " + e.Rollout, release + "/rollouts/" + e.Rollout + project})
+		}
+	}
+	if e.Commit != "" {
+		short := e.Commit
+		if len(short) > 7 {
+			short = short[:7]
+		}
+		links = append(links, Link{"Commit " + short, commitURL(e.SourceURL, e.Commit)})
+	}
+	if e.BuildLog != "" {
+		links = append(links, Link{"Build log", e.BuildLog})
+	}
+	if e.IssueKey != "" {
+		links = append(links, Link{e.IssueKey, e.IssueURL})
+	}
+	return links
+}
+
+// commitURL links a commit on the web UI of GitHub, GitLab and Bitbucket
+// style hosts, or returns "" when the source URL isn't one.
+func commitURL(source, sha string) string {
+	if !strings.HasPrefix(source, "https://") {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(source, "/"), ".git") + "/commit/" + sha
+}
+
+// Button is put on a message by notifiers that support them. Value comes
+// back in the platform's callback when someone clicks it.
+type Button struct {
+	ID    string
+	Text  string
+	Value string
+	// Style is "primary", "danger" or empty
+	Style string
+}
+
+// Notifier delivers events somewhere people see them.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Post posts body as JSON to a webhook, e.g. an incoming webhook or the
+// response URL of a button click.
+func Post(ctx context.Context, webhook string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"example.com/shared/configfile"
+	"example.com/shared/failure"
+)
+
+// Routes says which channels hear about which events:
+//
+//	{"routes": [
+//	  {"name": "prod", "targets": ["prod*"], "slack": "$SLACK_PROD_WEBHOOK"},
+//	  {"name": "everything", "events": ["release-created", "rollout-failed"], "googleChat": "https://chat.googleapis.com/..."}
+//	]}
+//
+// Every matching route is notified.
+type Routes struct {
+	Routes []Route `json:"routes"`
+}
+
+// Route sends the events it matches to its webhooks. Webhook URLs starting
+// with $ name an environment variable holding the URL, so the secret part
+// can stay out of the routing file.
+type Route struct {
+	Name string `json:"name"`
+	// Kinds of event, all when empty
+	Events []Kind `json:"events,omitempty"`
+	// Pipeline and target IDs as path.Match patterns, all when empty. A
+	// route with targets only gets events that have one, so not
+	// release-created.
+	Pipelines []string `json:"pipelines,omitempty"`
+	Targets   []string `json:"targets,omitempty"`
+
+	Slack      string `json:"slack,omitempty"`
+	GoogleChat string `json:"googleChat,omitempty"`
+}
+
+// ParseRoutes decodes and validates a routing file.
+func ParseRoutes(data []byte) (*Routes, error) {
+	var r Routes
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&r); err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("error parsing notification routes: %w", err))
+	}
+	if err := r.Validate(); err != nil {
+		return nil, failure.NewPermanent(fmt.Errorf("invalid notification routes: %w", err))
+	}
+	return &r, nil
+}
+
+// Validate checks every route has somewhere to send to and only uses
+// known events and valid patterns.
+func (r *Routes) Validate() error {
+	for i, route := range r.Routes {
+		name := route.Name
+		if name == "" {
+			name = fmt.Sprint(i)
+		}
+		if route.Slack == "" && route.GoogleChat == "" {
+			return fmt.Errorf("route %s has no slack or googleChat webhook", name)
+		}
+		for _, k := range route.Events {
+			if _, ok := kinds[k]; !ok {
+				return fmt.Errorf("route %s: unknown event %q", name, k)
+			}
+		}
+		for _, pattern := range append(route.Pipelines, route.Targets...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("route %s: bad pattern %q: %w", name, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Matches says whether the route wants e.
+func (route Route) Matches(e Event) bool {
+	if len(route.Events) > 0 && !contains(route.Events, e.Kind) {
+		return false
+	}
+	if len(route.Pipelines) > 0 && !MatchAny(route.Pipelines, e.Pipeline) {
+		return false
+	}
+	if len(route.Targets) > 0 && (e.Target == "" || !MatchAny(route.Targets, e.Target)) {
+		return false
+	}
+	return true
+}
+
+// Notifiers returns the route's adapters, leaving out webhooks whose
+// environment variable isn't set.
+func (route Route) Notifiers() []Notifier {
+	var n []Notifier
+	if url := webhookURL(route.Slack); url != "" {
+		n = append(n, Slack{WebhookURL: url})
+	}
+	if url := webhookURL(route.GoogleChat); url != "" {
+		n = append(n, GoogleChat{WebhookURL: url})
+	}
+	return n
+}
+
+func webhookURL(s string) string {
+	if name, ok := strings.CutPrefix(s, "$"); ok {
+		return os.Getenv(name)
+	}
+	return s
+}
+
+func contains(kinds []Kind, k Kind) bool {
+	for _, kind := range kinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchAny says whether s matches any of the path.Match patterns.
+func MatchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Config says where a function finds its notification routes. Functions
+// load it from their environment with env.Set.
+type Config struct {
+	// Inline routes JSON, takes precedence over URI
+	Inline string `env:"NOTIFY_ROUTES"`
+	// URI is gs://bucket/object or a local file, re-read at most every
+	// Refresh
+	URI     string        `env:"NOTIFY_ROUTES_URI"`
+	Refresh time.Duration `env:"NOTIFY_ROUTES_REFRESH" default:"1m"`
+}
+
+var routesCache = &configfile.Cache[*Routes]{Name: "notification routes", Parse: ParseRoutes}
+
+// Routes returns the configured routes, none when there are none.
+func (c Config) Routes(ctx context.Context) (*Routes, error) {
+	if c.Inline != "" {
+		return ParseRoutes([]byte(c.Inline))
+	}
+	if c.URI == "" {
+		return &Routes{}, nil
+	}
+	return routesCache.Get(ctx, c.URI, c.Refresh)
+}
+
+// Send notifies every route matching e. It tries them all, returning what
+// went wrong with any.
+func (c Config) Send(ctx context.Context, e Event) error {
+	routes, err := c.Routes(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, route := range routes.Routes {
+		if !route.Matches(e) {
+			continue
+		}
+		for _, n := range route.Notifiers() {
+			if err := n.Notify(ctx, e); err != nil {
+				errs = append(errs, fmt.Errorf("route %s: %w", route.Name, err))
+				continue
+			}
+			log.Printf("Notified route %s of %s", route.Name, e.Title())
+		}
+	}
+	return errors.Join(errs...)
+}
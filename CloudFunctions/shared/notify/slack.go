@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Slack posts to a Slack incoming webhook. Buttons only work when the
+// webhook belongs to a Slack app with interactivity turned on.
+type Slack struct {
+	WebhookURL string
+	Buttons    []Button
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func (s Slack) Notify(ctx context.Context, e Event) error {
+	var lines []string
+	if e.Repo != "" || e.Branch != "" {
+		lines = append(lines, fmt.Sprintf("%s@%s", slackEscaper.Replace(e.Repo), slackEscaper.Replace(e.Branch)))
+	}
+	if e.Detail != "" {
+		lines = append(lines, slackEscaper.Replace(e.Detail))
+	}
+	var links []string
+	for _, l := range e.Links() {
+		if l.URL == "" {
+			links = append(links, slackEscaper.Replace(l.Text))
+			continue
+		}
+		links = append(links, fmt.Sprintf("<%s|%s>", l.URL, slackEscaper.Replace(l.Text)))
+	}
+	if len(links) > 0 {
+		lines = append(lines, strings.Join(links, " · "))
+	}
+
+	title := slackEscaper.Replace(e.Title())
+	blocks := []map[string]interface{}{
+		{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": "*" + title + "*"}},
+	}
+	if len(lines) > 0 {
+		blocks = append(blocks, map[string]interface{}{
+			"type":     "context",
+			"elements": []map[string]string{{"type": "mrkdwn", "text": strings.Join(lines, "\n")}},
+		})
+	}
+	if len(s.Buttons) > 0 {
+		var elements []map[string]interface{}
+		for _, b := range s.Buttons {
+			button := map[string]interface{}{
+				"type":      "button",
+				"action_id": b.ID,
+				"text":      map[string]string{"type": "plain_text", "text": b.Text},
+				"value":     b.Value,
+			}
+			if b.Style != "" {
+				button["style"] = b.Style
+			}
+			elements = append(elements, button)
+		}
+		blocks = append(blocks, map[string]interface{}{"type": "actions", "elements": elements})
+	}
+	// text is the fallback shown in notifications
+	return Post(ctx, s.WebhookURL, map[string]interface{}{"text": title, "blocks": blocks})
+}
@@ -0,0 +1,135 @@
+// Package once keeps the side effects of Pub/Sub triggered functions, such
+// as Jira comments and chat posts, from repeating when an event is
+// redelivered: each is claimed under a key derived from the message ID
+// before it's done.
+package once
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Retention is how long claims are kept. Pub/Sub stops redelivering long
+// before; the Firestore TTL policy on expireAt deletes them after.
+const Retention = 7 * 24 * time.Hour
+
+// Store remembers claimed keys.
+type Store interface {
+	// Claim records key and reports whether it wasn't already.
+	Claim(ctx context.Context, key string) (bool, error)
+	Close() error
+}
+
+// Key identifies a side effect of a message. It's hashed because message
+// IDs and effect names may contain characters store keys can't.
+func Key(effect, messageID string) string {
+	sum := sha256.Sum256([]byte(effect + "/" + messageID))
+	return hex.EncodeToString(sum[:])
+}
+
+// Config says where claims are kept. Functions fill it from their own
+// environment.
+type Config struct {
+	// Store is "firestore" or "memory"
+	Store      string
+	ProjectID  string
+	Database   string
+	Collection string
+}
+
+// Do runs fn unless the effect was already done for messageID. The claim
+// is made first, so an effect that fails or is cut short isn't repeated
+// either: these effects keep people informed, and a missing one is better
+// than a flood.
+func (c Config) Do(ctx context.Context, effect, messageID string, fn func() error) error {
+	s, err := c.open(ctx)
+	if err != nil {
+		return fmt.Errorf("error creating once store: %w", err)
+	}
+	defer s.Close()
+	first, err := s.Claim(ctx, Key(effect, messageID))
+	if err != nil {
+		return fmt.Errorf("error claiming %s of message %s: %w", effect, messageID, err)
+	}
+	if !first {
+		return nil
+	}
+	return fn()
+}
+
+func (c Config) open(ctx context.Context) (Store, error) {
+	switch c.Store {
+	case "firestore":
+		client, err := firestore.NewClientWithDatabase(ctx, c.ProjectID, c.Database)
+		if err != nil {
+			return nil, fmt.Errorf("firestore.NewClient: %w", err)
+		}
+		return &FirestoreStore{client: client, collection: c.Collection}, nil
+	case "memory":
+		return memStore, nil
+	default:
+		return nil, fmt.Errorf("unknown once store %q", c.Store)
+	}
+}
+
+// memStore lives as long as the function instance, which is enough for
+// tests and local runs but not for production.
+var memStore = NewMemoryStore()
+
+// MemoryStore is an in-process Store.
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: map[string]bool{}}
+}
+
+func (s *MemoryStore) Claim(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys[key] {
+		return false, nil
+	}
+	s.keys[key] = true
+	return true, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// FirestoreStore keeps one document per claim.
+type FirestoreStore struct {
+	client     *firestore.Client
+	collection string
+}
+
+func (s *FirestoreStore) Claim(ctx context.Context, key string) (bool, error) {
+	now := time.Now().UTC()
+	_, err := s.client.Collection(s.collection).Doc(key).Create(ctx, map[string]interface{}{
+		"claimed":  now,
+		"expireAt": now.Add(Retention),
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Close releases the Firestore client.
+func (s *FirestoreStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,57 @@
+package once
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDo(t *testing.T) {
+	ctx := context.Background()
+	c := Config{Store: "memory"}
+	runs := map[string]int{}
+	for _, call := range []struct{ effect, messageID string }{
+		{"jira", "m1"},
+		{"jira", "m1"}, // redelivered
+		{"notify", "m1"},
+		{"jira", "m2"},
+	} {
+		err := c.Do(ctx, call.effect, call.messageID, func() error {
+			runs[call.effect+"/"+call.messageID]++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Do(%s, %s): %v", call.effect, call.messageID, err)
+		}
+	}
+	want := map[string]int{"jira/m1": 1, "notify/m1": 1, "jira/m2": 1}
+	for k, n := range want {
+		if runs[k] != n {
+			t.Errorf("%s ran %d times, want %d", k, runs[k], n)
+		}
+	}
+}
+
+func TestDoFailureIsNotRepeated(t *testing.T) {
+	ctx := context.Background()
+	c := Config{Store: "memory"}
+	boom := errors.New("boom")
+	runs := 0
+	fn := func() error { runs++; return boom }
+	if err := c.Do(ctx, "jira", "failing", fn); !errors.Is(err, boom) {
+		t.Errorf("Do = %v, want %v", err, boom)
+	}
+	if err := c.Do(ctx, "jira", "failing", fn); err != nil || runs != 1 {
+		t.Errorf("second Do = %v after %d runs, want nil after 1", err, runs)
+	}
+}
+
+func TestUnknownStore(t *testing.T) {
+	err := Config{Store: "sqlite"}.Do(context.Background(), "jira", "m1", func() error {
+		t.Error("ran without a store")
+		return nil
+	})
+	if err == nil {
+		t.Error("Do with an unknown store succeeded")
+	}
+}
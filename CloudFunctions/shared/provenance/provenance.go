@@ -0,0 +1,77 @@
+// Package provenance holds the keys linking releases and rollouts back to
+// the Cloud Build build, commit and log they came from.
+package provenance
+
+import (
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/deploy/apiv1/deploypb"
+)
+
+// Annotation keys set on every release, and copied onto its rollouts. The
+// ones in labelKeys are also set as labels so they can be filtered on.
+const (
+	BuildID   = "cloud-build-id"
+	CommitSha = "commit-sha"
+	Branch    = "branch"
+	Tag       = "tag"
+	Repo      = "repo"
+	Trigger   = "trigger"
+	LogURL    = "build-log-url"
+	SourceURL = "source-url"
+	// Author is whoever the build was for, e.g. so they can't approve
+	// their own release
+	Author = "author"
+	// CommitTime is when the change was committed (RFC 3339), or failing
+	// that when its build was created, for lead time metrics
+	CommitTime = "commit-time"
+)
+
+var labelKeys = []string{BuildID, CommitSha, Branch, Tag, Repo, Trigger}
+
+// Labels returns the label counterparts of the provenance annotations.
+func Labels(annotations map[string]string) map[string]string {
+	labels := map[string]string{}
+	for _, k := range labelKeys {
+		if v := LabelValue(annotations[k]); v != "" {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// LabelValue makes s a valid label value: lowercase letters, digits, _ and
+// -, at most 63 characters, so "feature/ABC" becomes "feature-abc".
+func LabelValue(s string) string {
+	v := invalidLabelChars.ReplaceAllString(strings.ToLower(s), "-")
+	if len(v) > 63 {
+		v = v[:63]
+	}
+	return strings.Trim(v, "-_")
+}
+
+// Inherit copies the release's provenance labels and annotations onto the
+// rollout, keeping any the rollout already sets.
+func Inherit(rollout *deploypb.Rollout, release *deploypb.Release) {
+	for _, k := range []string{BuildID, CommitSha, Branch, Tag, Repo, Trigger, LogURL, SourceURL, Author, CommitTime} {
+		if v, ok := release.GetAnnotations()[k]; ok {
+			if rollout.Annotations == nil {
+				rollout.Annotations = map[string]string{}
+			}
+			if _, set := rollout.Annotations[k]; !set {
+				rollout.Annotations[k] = v
+			}
+		}
+		if v, ok := release.GetLabels()[k]; ok {
+			if rollout.Labels == nil {
+				rollout.Labels = map[string]string{}
+			}
+			if _, set := rollout.Labels[k]; !set {
+				rollout.Labels[k] = v
+			}
+		}
+	}
+}
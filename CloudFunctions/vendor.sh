@@ -0,0 +1,19 @@
+#!/usr/bin/env bash
+# Functions that use the shared module pull it in through a local replace
+# directive, which doesn't survive being zipped up on its own. Vendoring copies
+# the shared code (and everything else) into each function before terraform
+# packages it, so run this before `terraform apply`.
+set -euo pipefail
+
+cd "$(dirname "$0")"
+for dir in */; do
+  dir=${dir%/}
+  # shared is what gets vendored and e2e is never deployed
+  if [[ "$dir" == "shared" || "$dir" == "e2e" || ! -f "$dir/go.mod" ]]; then
+    continue
+  fi
+  if grep -q "example.com/shared" "$dir/go.mod"; then
+    echo "Vendoring $dir"
+    (cd "$dir" && go mod vendor)
+  fi
+done
@@ -12,6 +12,20 @@ resource "google_project_iam_member" "cloud_deploy_releaser" {
   member  = "serviceAccount:${data.google_compute_default_service_account.default.email}"
 }
 
+# Lets the functions record processed commands, freeze lifts and deferrals in Firestore
+resource "google_project_iam_member" "datastore_user" {
+  project = var.project_id
+  role    = "roles/datastore.user"
+  member  = "serviceAccount:${data.google_compute_default_service_account.default.email}"
+}
+
+# Lets createRelease resolve image tags to digests when a build didn't report one
+resource "google_project_iam_member" "artifactregistry_reader" {
+  project = var.project_id
+  role    = "roles/artifactregistry.reader"
+  member  = "serviceAccount:${data.google_compute_default_service_account.default.email}"
+}
+
 # Grant "Service Account User" role to the default Compute Engine service account on the Cloud Build service account
 # Required for Cloud Functions to handle releases (Maybe? Probably isn't needed)
 resource "google_service_account_iam_binding" "allow_compute_sa_to_act_as" {
@@ -4,6 +4,8 @@ resource "google_storage_bucket" "function_bucket" {
   uniform_bucket_level_access = true 
 }
 
+# Functions depending on CloudFunctions/shared must be vendored first
+# by running CloudFunctions/vendor.sh
 data "archive_file" "createRelease" {
   type = "zip"
   output_path = "/tmp/function-createRelease.zip"
@@ -28,6 +30,49 @@ data "archive_file" "cloudDeployApprovals" {
   source_dir = "CloudFunctions/cloudDeployApprovals/"
 }
 
+# Read by cloudDeployApprovals on every refresh, so edits apply without a redeploy
+resource "google_storage_bucket_object" "approval_policy" {
+  name = "approval-policy.json"
+  bucket = google_storage_bucket.function_bucket.name
+  source = "approval-policy.json"
+}
+
+# Votes needed per target, read by the approval functions on every refresh
+resource "google_storage_bucket_object" "approval_quorum" {
+  name = "approval-quorum.json"
+  bucket = google_storage_bucket.function_bucket.name
+  source = "approval-quorum.json"
+}
+
+# Which chat channels hear about what, read by deployTrigger,
+# cloudDeployOperations and cloudDeployApprovals on every refresh
+resource "google_storage_bucket_object" "notify_routes" {
+  name = "notify-routes.json"
+  bucket = google_storage_bucket.function_bucket.name
+  source = "notify-routes.json"
+}
+
+# Which targets cloudDeployOperations rolls back when a rollout fails, read
+# on every refresh
+resource "google_storage_bucket_object" "rollback_policy" {
+  name = "rollback-policy.json"
+  bucket = google_storage_bucket.function_bucket.name
+  source = "rollback-policy.json"
+}
+
+# Read by cloudDeployApprovals and cloudDeployOperations on every refresh
+resource "google_storage_bucket_object" "freeze_calendar" {
+  name = "freeze-calendar.json"
+  bucket = google_storage_bucket.function_bucket.name
+  source = "freeze-calendar.json"
+}
+
+resource "google_storage_bucket_iam_member" "approval_policy_reader" {
+  bucket = google_storage_bucket.function_bucket.name
+  role   = "roles/storage.objectViewer"
+  member = "serviceAccount:${data.google_compute_default_service_account.default.email}"
+}
+
 resource "google_storage_bucket_object" "createRelease" {
   name = "function-create-release.zip"
   bucket = google_storage_bucket.function_bucket.name
@@ -76,9 +121,19 @@ resource "google_cloudfunctions2_function" "create-release" {
     environment_variables = {
       PROJECTID = "${var.project_id}"
       LOCATION = "${var.region}"
+      DEADLETTERTOPICID = google_pubsub_topic.dead_letter.name
       PIPELINE = "${google_clouddeploy_delivery_pipeline.primary.name}"
       TRIGGER = "${google_cloudbuild_trigger.build-cloudrun-deploy.trigger_id}"
       SENDTOPICID = "${google_pubsub_topic.deploy-commands.name}"
+      BUILDEVENTSTOPICID = google_pubsub_topic.build_events.name
+      FIRESTORE_DATABASE = google_firestore_database.commands.name
+      JIRA_URL = var.jira_url
+      JIRA_EMAIL = var.jira_email
+      JIRA_API_TOKEN = var.jira_api_token
+      JIRA_PROJECT = var.jira_project
+      NOTIFY_ROUTES_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.notify_routes.name}"
+      SLACK_WEBHOOK_URL = var.slack_webhook_url
+      GOOGLE_CHAT_WEBHOOK_URL = var.google_chat_webhook_url
     }
   }
 
@@ -117,6 +172,8 @@ resource "google_cloudfunctions2_function" "cloudDeployInteractions" {
     environment_variables = {
       PROJECTID = "${var.project_id}"
       LOCATION = "${var.region}"
+      DEADLETTERTOPICID = google_pubsub_topic.dead_letter.name
+      FIRESTORE_DATABASE = google_firestore_database.commands.name
     }
   }
 
@@ -153,7 +210,17 @@ resource "google_cloudfunctions2_function" "cloudDeployOperations" {
     environment_variables = {
       PROJECTID = "${var.project_id}"
       LOCATION = "${var.region}"
+      DEADLETTERTOPICID = google_pubsub_topic.dead_letter.name
       SENDTOPICID = google_pubsub_topic.deploy-commands.name
+      JIRA_URL = var.jira_url
+      JIRA_EMAIL = var.jira_email
+      JIRA_API_TOKEN = var.jira_api_token
+      FREEZE_CALENDAR_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.freeze_calendar.name}"
+      FIRESTORE_DATABASE = google_firestore_database.commands.name
+      NOTIFY_ROUTES_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.notify_routes.name}"
+      SLACK_WEBHOOK_URL = var.slack_webhook_url
+      GOOGLE_CHAT_WEBHOOK_URL = var.google_chat_webhook_url
+      ROLLBACK_POLICY_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.rollback_policy.name}"
     }
   }
 
@@ -165,6 +232,51 @@ resource "google_cloudfunctions2_function" "cloudDeployOperations" {
   }
 }
 
+# Sends the promotions and approvals deferred by a freeze once it ends or is
+# lifted. Shares its source with cloudDeployOperations.
+resource "google_cloudfunctions2_function" "resumeDeferred" {
+  name    = "resume-deferred"
+  project = var.project_id
+  location = var.region
+
+  build_config {
+    entry_point = "resumeDeferred"
+    runtime     = "go122" # Or your preferred runtime
+    source {
+      storage_source {
+        bucket = google_storage_bucket.function_bucket.name
+        object = google_storage_bucket_object.cloudDeployOperations.name
+      }
+    }
+  }
+
+  service_config {
+    all_traffic_on_latest_revision = true
+    available_memory               = "256M" # Adjust as needed
+    ingress_settings               = "ALLOW_INTERNAL_ONLY"
+    timeout_seconds                = 60 # Adjust as needed
+    environment_variables = {
+      PROJECTID = "${var.project_id}"
+      LOCATION = "${var.region}"
+      DEADLETTERTOPICID = google_pubsub_topic.dead_letter.name
+      SENDTOPICID = google_pubsub_topic.deploy-commands.name
+      JIRA_URL = var.jira_url
+      JIRA_EMAIL = var.jira_email
+      JIRA_API_TOKEN = var.jira_api_token
+      FREEZE_CALENDAR_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.freeze_calendar.name}"
+      FIRESTORE_DATABASE = google_firestore_database.commands.name
+    }
+  }
+
+  event_trigger {
+    event_type = "google.cloud.pubsub.topic.v1.messagePublished"
+    # A failed run is simply picked up by the next tick
+    retry_policy = "RETRY_POLICY_DO_NOT_RETRY"
+    trigger_region = var.region
+    pubsub_topic = google_pubsub_topic.freeze_ticks.id
+  }
+}
+
 # Create a Cloud Function to interact with Cloud Deploy Approvals
 resource "google_cloudfunctions2_function" "cloudDeployApprovals" {
   name    = "cloud-deploy-approvals"
@@ -190,7 +302,23 @@ resource "google_cloudfunctions2_function" "cloudDeployApprovals" {
     environment_variables = {
       PROJECTID = "${var.project_id}"
       LOCATION = "${var.region}"
+      DEADLETTERTOPICID = google_pubsub_topic.dead_letter.name
       SENDTOPICID = google_pubsub_topic.deploy-commands.name
+      JIRA_URL = var.jira_url
+      JIRA_EMAIL = var.jira_email
+      JIRA_API_TOKEN = var.jira_api_token
+      JIRA_WEBHOOK_SECRET = var.jira_webhook_secret
+      APPROVAL_POLICY_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.approval_policy.name}"
+      APPROVAL_QUORUM_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.approval_quorum.name}"
+      FREEZE_CALENDAR_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.freeze_calendar.name}"
+      FREEZE_CALENDAR_REFRESH = "1m"
+      FREEZE_STORE = "firestore"
+      FIRESTORE_DATABASE = google_firestore_database.commands.name
+      NOTIFY_ROUTES_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.notify_routes.name}"
+      SLACK_WEBHOOK_URL = var.slack_webhook_url
+      GOOGLE_CHAT_WEBHOOK_URL = var.google_chat_webhook_url
+      SLACK_APPROVAL_WEBHOOK = var.slack_approval_webhook
+      GOOGLE_CHAT_APPROVAL_SPACE = var.google_chat_approval_space
     }
   }
 
@@ -200,4 +328,253 @@ resource "google_cloudfunctions2_function" "cloudDeployApprovals" {
     trigger_region = var.region
     pubsub_topic = google_pubsub_topic.deploy_approvals.id
   }
-}
\ No newline at end of file
+}
+
+# HTTP function receiving Jira webhooks to approve/reject rollouts.
+# Shares its source with cloudDeployApprovals.
+resource "google_cloudfunctions2_function" "jiraWebhook" {
+  name    = "jira-webhook"
+  project = var.project_id
+  location = var.region
+
+  build_config {
+    entry_point = "jiraWebhook"
+    runtime     = "go122" # Or your preferred runtime
+    source {
+      storage_source {
+        bucket = google_storage_bucket.function_bucket.name
+        object = google_storage_bucket_object.cloudDeployApprovals.name
+      }
+    }
+  }
+
+  service_config {
+    all_traffic_on_latest_revision = true
+    available_memory               = "256M" # Adjust as needed
+    ingress_settings               = "ALLOW_ALL"
+    timeout_seconds                = 60 # Adjust as needed
+    environment_variables = {
+      PROJECTID = "${var.project_id}"
+      LOCATION = "${var.region}"
+      DEADLETTERTOPICID = google_pubsub_topic.dead_letter.name
+      SENDTOPICID = google_pubsub_topic.deploy-commands.name
+      JIRA_URL = var.jira_url
+      JIRA_EMAIL = var.jira_email
+      JIRA_API_TOKEN = var.jira_api_token
+      JIRA_WEBHOOK_SECRET = var.jira_webhook_secret
+      APPROVAL_QUORUM_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.approval_quorum.name}"
+      # Votes that decide a rollout are held to the freeze calendar too, so
+      # every function casting them reads the same one
+      FREEZE_CALENDAR_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.freeze_calendar.name}"
+      FREEZE_CALENDAR_REFRESH = "1m"
+      FREEZE_STORE = "firestore"
+      FIRESTORE_DATABASE = google_firestore_database.commands.name
+    }
+  }
+}
+
+# HTTP function taking approval votes from people. Only callers with the
+# invoker role get through, so the vote is cast as the caller's identity.
+# Shares its source with cloudDeployApprovals.
+resource "google_cloudfunctions2_function" "approvalVote" {
+  name    = "approval-vote"
+  project = var.project_id
+  location = var.region
+
+  build_config {
+    entry_point = "approvalVote"
+    runtime     = "go122" # Or your preferred runtime
+    source {
+      storage_source {
+        bucket = google_storage_bucket.function_bucket.name
+        object = google_storage_bucket_object.cloudDeployApprovals.name
+      }
+    }
+  }
+
+  service_config {
+    all_traffic_on_latest_revision = true
+    available_memory               = "256M" # Adjust as needed
+    ingress_settings               = "ALLOW_ALL"
+    timeout_seconds                = 60 # Adjust as needed
+    environment_variables = {
+      PROJECTID = "${var.project_id}"
+      LOCATION = "${var.region}"
+      DEADLETTERTOPICID = google_pubsub_topic.dead_letter.name
+      SENDTOPICID = google_pubsub_topic.deploy-commands.name
+      JIRA_URL = var.jira_url
+      JIRA_EMAIL = var.jira_email
+      JIRA_API_TOKEN = var.jira_api_token
+      JIRA_WEBHOOK_SECRET = var.jira_webhook_secret
+      APPROVAL_QUORUM_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.approval_quorum.name}"
+      FREEZE_CALENDAR_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.freeze_calendar.name}"
+      FREEZE_CALENDAR_REFRESH = "1m"
+      FREEZE_STORE = "firestore"
+      FIRESTORE_DATABASE = google_firestore_database.commands.name
+    }
+  }
+}
+
+resource "google_cloud_run_service_iam_member" "approval_vote_invoker" {
+  for_each = toset(var.approvers)
+  project  = var.project_id
+  location = var.region
+  service  = google_cloudfunctions2_function.approvalVote.name
+  role     = "roles/run.invoker"
+  member   = each.key
+}
+
+# Jira can't authenticate to Cloud Run, requests are verified by signature instead
+resource "google_cloud_run_service_iam_member" "jira_webhook_invoker" {
+  project  = var.project_id
+  location = var.region
+  service  = google_cloudfunctions2_function.jiraWebhook.name
+  role     = "roles/run.invoker"
+  member   = "allUsers"
+}
+# HTTP function receiving Approve/Reject button clicks from the Slack app
+# and the Google Chat app. Shares its source with cloudDeployApprovals.
+resource "google_cloudfunctions2_function" "chatApproval" {
+  name    = "chat-approval"
+  project = var.project_id
+  location = var.region
+
+  build_config {
+    entry_point = "chatApproval"
+    runtime     = "go122" # Or your preferred runtime
+    source {
+      storage_source {
+        bucket = google_storage_bucket.function_bucket.name
+        object = google_storage_bucket_object.cloudDeployApprovals.name
+      }
+    }
+  }
+
+  service_config {
+    all_traffic_on_latest_revision = true
+    available_memory               = "256M" # Adjust as needed
+    ingress_settings               = "ALLOW_ALL"
+    timeout_seconds                = 60 # Adjust as needed
+    environment_variables = {
+      PROJECTID = "${var.project_id}"
+      LOCATION = "${var.region}"
+      DEADLETTERTOPICID = google_pubsub_topic.dead_letter.name
+      SENDTOPICID = google_pubsub_topic.deploy-commands.name
+      JIRA_URL = var.jira_url
+      JIRA_EMAIL = var.jira_email
+      JIRA_API_TOKEN = var.jira_api_token
+      JIRA_WEBHOOK_SECRET = var.jira_webhook_secret
+      APPROVAL_QUORUM_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.approval_quorum.name}"
+      FREEZE_CALENDAR_URI = "gs://${google_storage_bucket.function_bucket.name}/${google_storage_bucket_object.freeze_calendar.name}"
+      FREEZE_CALENDAR_REFRESH = "1m"
+      FREEZE_STORE = "firestore"
+      FIRESTORE_DATABASE = google_firestore_database.commands.name
+      SLACK_SIGNING_SECRET = var.slack_signing_secret
+      SLACK_BOT_TOKEN = var.slack_bot_token
+      # The function's own URL, which the Chat app's HTTP endpoint is set to
+      GOOGLE_CHAT_AUDIENCE = "https://${var.region}-${var.project_id}.cloudfunctions.net/chat-approval"
+    }
+  }
+}
+
+# Slack and Google Chat can't authenticate to Cloud Run, requests are
+# verified by signature and token instead
+resource "google_cloud_run_service_iam_member" "chat_approval_invoker" {
+  project  = var.project_id
+  location = var.region
+  service  = google_cloudfunctions2_function.chatApproval.name
+  role     = "roles/run.invoker"
+  member   = "allUsers"
+}
+
+# HTTP function answering queries on the deployment history the other
+# functions record. Shares its source with cloudDeployOperations.
+resource "google_cloudfunctions2_function" "deployHistory" {
+  name    = "deploy-history"
+  project = var.project_id
+  location = var.region
+
+  build_config {
+    entry_point = "deployHistory"
+    runtime     = "go122" # Or your preferred runtime
+    source {
+      storage_source {
+        bucket = google_storage_bucket.function_bucket.name
+        object = google_storage_bucket_object.cloudDeployOperations.name
+      }
+    }
+  }
+
+  service_config {
+    all_traffic_on_latest_revision = true
+    available_memory               = "256M" # Adjust as needed
+    ingress_settings               = "ALLOW_ALL"
+    timeout_seconds                = 60 # Adjust as needed
+    environment_variables = {
+      PROJECTID = "${var.project_id}"
+      LOCATION = "${var.region}"
+      DEADLETTERTOPICID = google_pubsub_topic.dead_letter.name
+      SENDTOPICID = google_pubsub_topic.deploy-commands.name
+      JIRA_URL = var.jira_url
+      JIRA_EMAIL = var.jira_email
+      JIRA_API_TOKEN = var.jira_api_token
+      FIRESTORE_DATABASE = google_firestore_database.commands.name
+    }
+  }
+}
+
+resource "google_cloud_run_service_iam_member" "deploy_history_invoker" {
+  for_each = toset(var.history_readers)
+  project  = var.project_id
+  location = var.region
+  service  = google_cloudfunctions2_function.deployHistory.name
+  role     = "roles/run.invoker"
+  member   = each.key
+}
+
+# HTTP function serving DORA metrics computed from the deployment history,
+# as JSON or, on /metrics, OpenMetrics. Shares its source with
+# cloudDeployOperations.
+resource "google_cloudfunctions2_function" "doraMetrics" {
+  name    = "dora-metrics"
+  project = var.project_id
+  location = var.region
+
+  build_config {
+    entry_point = "doraMetrics"
+    runtime     = "go122" # Or your preferred runtime
+    source {
+      storage_source {
+        bucket = google_storage_bucket.function_bucket.name
+        object = google_storage_bucket_object.cloudDeployOperations.name
+      }
+    }
+  }
+
+  service_config {
+    all_traffic_on_latest_revision = true
+    available_memory               = "256M" # Adjust as needed
+    ingress_settings               = "ALLOW_ALL"
+    timeout_seconds                = 60 # Adjust as needed
+    environment_variables = {
+      PROJECTID = "${var.project_id}"
+      LOCATION = "${var.region}"
+      DEADLETTERTOPICID = google_pubsub_topic.dead_letter.name
+      SENDTOPICID = google_pubsub_topic.deploy-commands.name
+      JIRA_URL = var.jira_url
+      JIRA_EMAIL = var.jira_email
+      JIRA_API_TOKEN = var.jira_api_token
+      FIRESTORE_DATABASE = google_firestore_database.commands.name
+      DORA_WINDOWS = var.dora_windows
+    }
+  }
+}
+
+resource "google_cloud_run_service_iam_member" "dora_metrics_invoker" {
+  for_each = toset(concat(var.history_readers, var.metrics_readers))
+  project  = var.project_id
+  location = var.region
+  service  = google_cloudfunctions2_function.doraMetrics.name
+  role     = "roles/run.invoker"
+  member   = each.key
+}
@@ -14,7 +14,10 @@ variable "gcp_service_list" {
   default = [
     "pubsub.googleapis.com",
     "clouddeploy.googleapis.com",
-    "cloudbuild.googleapis.com"
+    "cloudbuild.googleapis.com",
+    "firestore.googleapis.com",
+    "cloudscheduler.googleapis.com",
+    "chat.googleapis.com"
   ]
 }
 
@@ -45,6 +48,104 @@ resource "google_pubsub_subscription" "deploy_commands_subscription" {
   project = var.project_id
 }
 
+# Commands and events the functions give up on land here with "function"
+# and "reason" attributes
+resource "google_pubsub_topic" "dead_letter" {
+  name = "deploy-dead-letter"
+  project = var.project_id
+}
+
+# Create a Pub/Sub subscription for deploy-dead-letter topic
+resource "google_pubsub_subscription" "dead_letter_subscription" {
+  name  = "deploy-dead-letter-subscription"
+  topic = google_pubsub_topic.dead_letter.id
+  project = var.project_id
+}
+
+# Ticks resumeDeferred, which sends actions held by a freeze once it's over
+resource "google_pubsub_topic" "freeze_ticks" {
+  name = "deploy-freeze-ticks"
+  project = var.project_id
+}
+
+resource "google_cloud_scheduler_job" "freeze_ticks" {
+  name     = "deploy-freeze-ticks"
+  project  = var.project_id
+  region   = var.region
+  schedule = "*/5 * * * *"
+
+  pubsub_target {
+    topic_name = google_pubsub_topic.freeze_ticks.id
+    data       = base64encode("tick")
+  }
+
+  depends_on = [ google_project_service.project ]
+}
+
+# Records which deploy commands have been processed so redeliveries are
+# skipped, and the freezes lifted and actions deferred by freezes
+resource "google_firestore_database" "commands" {
+  project     = var.project_id
+  name        = "(default)"
+  location_id = var.region
+  type        = "FIRESTORE_NATIVE"
+
+  depends_on = [ google_project_service.project ]
+}
+
+# Side effects already done per event (Jira comments, chat posts), claimed
+# so redeliveries don't repeat them. Claims carry their expiry.
+resource "google_firestore_field" "once_ttl" {
+  project    = var.project_id
+  database   = google_firestore_database.commands.name
+  collection = "deploy-once"
+  field      = "expireAt"
+
+  ttl_config {}
+}
+
+# The Jira issue opened for each build, claimed so notifications arriving
+# together don't open one each. Claims carry their expiry.
+resource "google_firestore_field" "build_issues_ttl" {
+  project    = var.project_id
+  database   = google_firestore_database.commands.name
+  collection = "deploy-build-issues"
+  field      = "expireAt"
+
+  ttl_config {}
+}
+
+# Composite indexes for the deployment history queries: deployHistory and
+# the DORA metrics filter on any combination of these fields, by equality,
+# and read the newest first, so each combination gets an index
+locals {
+  history_filters = ["pipeline", "release", "target", "kind", "state"]
+  history_indexes = {
+    for used in setproduct([true, false], [true, false], [true, false], [true, false], [true, false]) :
+    join("_", [for i, f in local.history_filters : f if used[i]]) => [for i, f in local.history_filters : f if used[i]]
+    if anytrue(used)
+  }
+}
+
+resource "google_firestore_index" "history" {
+  for_each   = local.history_indexes
+  project    = var.project_id
+  database   = google_firestore_database.commands.name
+  collection = "deploy-history"
+
+  dynamic "fields" {
+    for_each = each.value
+    content {
+      field_path = fields.value
+      order      = "ASCENDING"
+    }
+  }
+  fields {
+    field_path = "at"
+    order      = "DESCENDING"
+  }
+}
+
 # Create a Pub/Sub topic to receive Cloud Deploy Operations Notifications
 resource "google_pubsub_topic" "deploy_operations" {
   name = "clouddeploy-operations"
@@ -70,6 +171,12 @@ resource "google_pubsub_subscription" "deploy_approvals_subscription" {
   project = var.project_id
 }
 
+# Every status change of a routed build, as published by createRelease
+resource "google_pubsub_topic" "build_events" {
+  name = "deploy-build-events"
+  project = var.project_id
+}
+
 # Create a Pub/Sub topic to receive Cloud Build Notifications
 resource "google_pubsub_topic" "build_notifications" {
   name = "cloud-builds"
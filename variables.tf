@@ -16,4 +16,95 @@ variable "github_owner" {
 variable "github_repo" {
   type = string
   description = "Github Repo"
-}
\ No newline at end of file
+}
+variable "jira_url" {
+  type = string
+  description = "Jira Cloud site URL (e.g. https://example.atlassian.net)"
+}
+
+variable "jira_email" {
+  type = string
+  description = "Email of the Jira account used by the functions"
+}
+
+variable "jira_api_token" {
+  type = string
+  description = "API token for the Jira account"
+  sensitive = true
+}
+
+variable "jira_project" {
+  type = string
+  description = "Jira project key release issues are created in"
+}
+
+variable "jira_webhook_secret" {
+  type = string
+  description = "Secret configured on the Jira webhook, used to verify X-Hub-Signature"
+  sensitive = true
+}
+
+variable "approvers" {
+  type = list(string)
+  description = "Members allowed to vote on rollouts through the approval-vote function (e.g. user:alice@example.com, group:releases@example.com)"
+  default = []
+}
+
+variable "slack_webhook_url" {
+  type = string
+  description = "Slack incoming webhook notify-routes.json refers to as $SLACK_WEBHOOK_URL, unset to not notify Slack"
+  default = ""
+  sensitive = true
+}
+
+variable "google_chat_webhook_url" {
+  type = string
+  description = "Google Chat space webhook notify-routes.json refers to as $GOOGLE_CHAT_WEBHOOK_URL, unset to not notify Google Chat"
+  default = ""
+  sensitive = true
+}
+
+variable "slack_approval_webhook" {
+  type = string
+  description = "Incoming webhook of the Slack app escalated rollouts are posted to with Approve/Reject buttons, unset to not ask in Slack. The app's interactivity request URL must be the chat-approval function."
+  default = ""
+  sensitive = true
+}
+
+variable "slack_signing_secret" {
+  type = string
+  description = "Signing secret of that Slack app, used to verify button clicks"
+  default = ""
+  sensitive = true
+}
+
+variable "slack_bot_token" {
+  type = string
+  description = "Bot token of that Slack app with the users:read.email scope, used to check who clicked against the release's author. Without it Slack approvals are refused under forbidSelfApproval."
+  default = ""
+  sensitive = true
+}
+
+variable "google_chat_approval_space" {
+  type = string
+  description = "Google Chat space (spaces/...) escalated rollouts are posted to as the Chat app, unset to not ask in Google Chat. The app's HTTP endpoint must be the chat-approval function."
+  default = ""
+}
+
+variable "history_readers" {
+  type = list(string)
+  description = "Members allowed to query the deploy-history function (e.g. group:releases@example.com)"
+  default = []
+}
+
+variable "metrics_readers" {
+  type = list(string)
+  description = "Members allowed to read the dora-metrics function besides history_readers (e.g. a Prometheus scraper's service account)"
+  default = []
+}
+
+variable "dora_windows" {
+  type = string
+  description = "Rolling windows the DORA metrics cover unless asked otherwise"
+  default = "1d,7d,30d"
+}